@@ -2,12 +2,14 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/chzyer/readline"                              // Import the readline library
 	toycalc_core "github.com/vladimirck/toycalc/toycalc-core" // Import your toycalc core package
@@ -16,23 +18,347 @@ import (
 
 
 // processExpression encapsulates the calculation and printing logic.
-func processExpression(expressionString string) {
+// Statements are evaluated by engine, so assignments like 'x = 3+4i' persist between lines.
+func processExpression(expressionString string, engine *toycalc_core.Engine) {
 	if strings.TrimSpace(expressionString) == "" {
 		return // Do nothing for empty input in REPL
 	}
-	resultStr, err := toycalc_core.CalculateExpression(expressionString)
+	resultStr, err := engine.Evaluate(expressionString)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		printError(expressionString, err)
 		return
 	}
 	fmt.Println(resultStr)
 }
 
+// printError prints err to stderr. When the error points at part of the input, the input is
+// echoed with a marker underneath, followed by any suggested names, e.g.
+//
+//	  sine(1)
+//	  ^~~~
+//	Did you mean 'sin' or 'sinh'?
+func printError(input string, err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	var calcErr *toycalc_core.CalculationError
+	if !errors.As(err, &calcErr) {
+		return
+	}
+	if calcErr.HasSpan() && calcErr.Start <= len(input) {
+		end := min(calcErr.End, len(input))
+		padding := strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return '\t' // Keep tabs so the marker lines up with the echoed input
+			}
+			return ' '
+		}, input[:calcErr.Start])
+		marker := "^" + strings.Repeat("~", max(utf8.RuneCountInString(input[calcErr.Start:end])-1, 0))
+		fmt.Fprintf(os.Stderr, "  %s\n  %s%s\n", input, padding, marker)
+	}
+	if len(calcErr.Suggestions) > 0 {
+		fmt.Fprintf(os.Stderr, "Did you mean %s?\n", quotedAlternatives(calcErr.Suggestions))
+	}
+}
+
+// quotedAlternatives renders names as "'a'", "'a' or 'b'" or "'a', 'b' or 'c'".
+func quotedAlternatives(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + name + "'"
+	}
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
+
+// handleSetCommand applies a 'set' command (the words after 'set', lowercased) to engine.
+func handleSetCommand(args []string, engine *toycalc_core.Engine) {
+	if len(args) == 0 {
+		fmt.Println("Usage: set <format|precision|angle|exact|locale|base|word|tolerance> <options>")
+		fmt.Println("Example: set format fixed 4")
+		fmt.Println("         set precision 6")
+		fmt.Println("         set precision bits 256")
+		fmt.Println("         set angle deg")
+		fmt.Println("         set exact on")
+		fmt.Println("         set locale es")
+		fmt.Println("         set base 16")
+		fmt.Println("         set word 32 unsigned")
+		fmt.Println("         set tolerance 1e-12")
+		return
+	}
+	switch args[0] {
+	case "format":
+		if len(args) < 2 {
+			fmt.Println("Usage: set format <auto|fixed N|sci N|eng [N]|si [N]|frac [maxDenominator]|polar [deg|rad]>")
+			fmt.Println("Example: set format fixed 4")
+			fmt.Println("         set format sci 6")
+			fmt.Println("         set format si 3")
+			fmt.Println("         set format frac 1000")
+			fmt.Println("         set format polar deg")
+			fmt.Println("         set format auto")
+			return
+		}
+		mode := args[1]
+		precision := engine.Settings().Precision // Keep current precision if not specified for auto, eng, si and frac
+		if mode == "frac" && len(args) >= 3 {
+			maxDenominator, err := strconv.ParseInt(args[2], 10, 64)
+			if err != nil {
+				fmt.Println("Error: The maximum denominator must be a positive integer.")
+				return
+			}
+			if err := engine.SetMaxDenominator(maxDenominator); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+		}
+		if mode == "polar" {
+			unit := "" // Follow the angle mode unless a unit is given
+			if len(args) >= 3 {
+				unit = args[2]
+			}
+			if err := engine.SetPolarUnit(toycalc_core.AngleMode(unit)); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				return
+			}
+		}
+		if mode == "fixed" || mode == "sci" || ((mode == "eng" || mode == "si") && len(args) >= 3) {
+			if len(args) < 3 {
+				fmt.Printf("Usage: set format %s <N> (where N is number of digits)\n", mode)
+				return
+			}
+			p, err := strconv.Atoi(args[2])
+			if err != nil {
+				fmt.Printf("Error: Precision N must be a non-negative integer (e.g., 0-%d).\n", toycalc_core.MaxDisplayPrecision)
+				return
+			}
+			precision = p
+		}
+		if err := engine.SetFormat(mode, precision); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		settings := engine.Settings()
+		fmt.Printf("Output format set to: %s", settings.Format)
+		switch settings.Format {
+		case "auto":
+		case "frac":
+			fmt.Printf(", denominators up to %d", settings.MaxDenominator)
+		case "polar":
+			if settings.PolarUnit != "" {
+				fmt.Printf(", angles in %s", settings.PolarUnit)
+			}
+		default:
+			fmt.Printf(", %d digits precision", settings.Precision)
+		}
+		fmt.Println()
+
+	case "precision":
+		if len(args) < 2 {
+			fmt.Printf("Usage: set precision <N> (where N is number of digits, e.g., 0-%d)\n", toycalc_core.MaxDisplayPrecision)
+			fmt.Printf("       set precision sig <N> (N significant digits, e.g., 1-%d)\n", toycalc_core.MaxDisplayPrecision)
+			fmt.Printf("       set precision bits <N|off> (high-precision mode, N is %d-%d)\n", toycalc_core.MinPrecisionBits, toycalc_core.MaxPrecisionBits)
+			return
+		}
+		if args[1] == "bits" {
+			setPrecisionBits(args[2:], engine)
+			return
+		}
+		if args[1] == "sig" {
+			setSignificantDigits(args[2:], engine)
+			return
+		}
+		p, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Printf("Error: Precision N must be a non-negative integer (e.g., 0-%d).\n", toycalc_core.MaxDisplayPrecision)
+			return
+		}
+		if err := engine.SetPrecision(p); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Display precision set to: %d digits (affects 'fixed', 'sci', and pre-rounding for 'auto' mode)\n", p)
+
+	case "angle":
+		if len(args) < 2 {
+			fmt.Println("Usage: set angle <deg|rad>")
+			return
+		}
+		if err := engine.SetAngleMode(toycalc_core.AngleMode(args[1])); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Angle mode set to: %s\n", args[1])
+
+	case "exact":
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			fmt.Println("Usage: set exact <on|off>")
+			return
+		}
+		engine.SetExact(args[1] == "on")
+		fmt.Printf("Exact mode set to: %s\n", args[1])
+
+	case "locale":
+		if len(args) < 2 {
+			fmt.Println("Usage: set locale <en|es|de|off>")
+			return
+		}
+		locale := toycalc_core.Locale(args[1])
+		if args[1] == "off" {
+			locale = toycalc_core.LocalePlain
+		}
+		if err := engine.SetLocale(locale); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Locale set to: %s\n", args[1])
+
+	case "base":
+		if len(args) < 2 {
+			fmt.Println("Usage: set base <2|8|10|16>")
+			return
+		}
+		base, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("Error: The base must be 2, 8, 10 or 16.")
+			return
+		}
+		if err := engine.SetBase(base); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Output base set to: %d (results that are not integers are shown in decimal)\n", base)
+
+	case "word":
+		setWord(args[1:], engine)
+
+	case "tolerance":
+		if len(args) < 2 {
+			fmt.Println("Usage: set tolerance <t>   (0 <= t < 1; '==' holds when |a-b| <= t * max(1, |a|, |b|))")
+			return
+		}
+		tolerance, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			fmt.Println("Error: The tolerance must be a number, like 1e-12.")
+			return
+		}
+		if err := engine.SetTolerance(tolerance); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		fmt.Printf("Comparison tolerance set to: %g\n", tolerance)
+
+	default:
+		fmt.Printf("Error: Unknown option for 'set': '%s'. Try 'set format ...', 'set precision ...', 'set angle ...', 'set exact ...', 'set locale ...', 'set base ...', 'set word ...' or 'set tolerance ...'.\n", args[0])
+	}
+}
+
+// setWord applies 'set word <8|16|32|64|off> [signed|unsigned]'.
+func setWord(args []string, engine *toycalc_core.Engine) {
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "signed" && args[1] != "unsigned") {
+		fmt.Println("Usage: set word <8|16|32|64|off> [signed|unsigned]")
+		return
+	}
+	size := 0
+	if args[0] != "off" {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error: The word size must be 'off', 8, 16, 32 or 64.")
+			return
+		}
+		size = n
+	}
+	unsigned := len(args) == 2 && args[1] == "unsigned"
+	if err := engine.SetWord(size, unsigned); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if size == 0 {
+		fmt.Println("Programmer mode off")
+		return
+	}
+	signedness := "signed"
+	if unsigned {
+		signedness = "unsigned"
+	}
+	fmt.Printf("Programmer mode set to: %d-bit %s integers\n", size, signedness)
+}
+
+// setSignificantDigits applies 'set precision sig <N>'.
+func setSignificantDigits(args []string, engine *toycalc_core.Engine) {
+	if len(args) < 1 {
+		fmt.Printf("Usage: set precision sig <N> (where N is number of significant digits, e.g., 1-%d)\n", toycalc_core.MaxDisplayPrecision)
+		return
+	}
+	digits, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Printf("Error: Significant digits N must be a positive integer (e.g., 1-%d).\n", toycalc_core.MaxDisplayPrecision)
+		return
+	}
+	if err := engine.SetSignificantDigits(digits); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Display precision set to: %d significant digits (affects 'auto' mode; 'set precision N' returns to decimal places)\n", digits)
+}
+
+// setPrecisionBits applies 'set precision bits <N|off>'.
+func setPrecisionBits(args []string, engine *toycalc_core.Engine) {
+	if len(args) < 1 {
+		fmt.Printf("Usage: set precision bits <N|off> (where N is %d-%d)\n", toycalc_core.MinPrecisionBits, toycalc_core.MaxPrecisionBits)
+		return
+	}
+	var bits uint
+	if args[0] != "off" {
+		n, err := strconv.ParseUint(args[0], 10, 0)
+		if err != nil {
+			fmt.Printf("Error: Bits N must be 'off' or an integer between %d and %d.\n", toycalc_core.MinPrecisionBits, toycalc_core.MaxPrecisionBits)
+			return
+		}
+		bits = uint(n)
+	}
+	if err := engine.SetPrecisionBits(bits); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if bits == 0 {
+		fmt.Println("High-precision mode off (complex128 arithmetic)")
+		return
+	}
+	fmt.Printf("High-precision mode set to: %d bits\n", bits)
+}
+
+// identifierCompleter completes the identifier under the cursor with the names of built-in
+// functions and constants plus the variables and functions defined in engine.
+type identifierCompleter struct {
+	engine *toycalc_core.Engine
+}
+
+// Do implements readline.AutoCompleter.
+func (c identifierCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	start := pos
+	for start > 0 && isIdentifierRune(line[start-1]) {
+		start--
+	}
+	prefix := string(line[start:pos])
+	if prefix == "" {
+		return nil, 0
+	}
+	var candidates [][]rune
+	for _, name := range c.engine.Completions(prefix) {
+		candidates = append(candidates, []rune(name[len(prefix):]))
+	}
+	return candidates, len([]rune(prefix))
+}
+
+func isIdentifierRune(ch rune) bool {
+	return ch == '_' || ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z') || ('0' <= ch && ch <= '9')
+}
+
 // startInteractiveMode starts the REPL for toycalc using the readline library.
 func startInteractiveMode() {
 	fmt.Println("ToyCalc Interactive Mode (v0.3 Stage 3)") // Updated version
 	fmt.Println("Type 'exit', 'quit', or 'help' for assistance.")
-	fmt.Println("Use 'set format [auto|fixed N|sci N]' to change output format.")
+	fmt.Println("Use 'set format [auto|fixed N|sci N|eng|si|frac|polar]' to change output format, 'set angle [deg|rad]' for angle units.")
 	fmt.Println("Use arrow keys for history and line editing.")
 
 	var historyFile string
@@ -44,10 +370,11 @@ func startInteractiveMode() {
 		historyFile = filepath.Join(homeDir, ".toycalc_history")
 	}
 
+	engine := toycalc_core.NewEngine()
 	rl, err := readline.NewEx(&readline.Config{
 		Prompt:              ">>> ",
 		HistoryFile:         historyFile,
-		AutoComplete:        nil,
+		AutoComplete:        identifierCompleter{engine: engine},
 		InterruptPrompt:     "^C",
 		EOFPrompt:           "exit",
 		HistorySearchFold:   true,
@@ -57,7 +384,7 @@ func startInteractiveMode() {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error initializing advanced readline: %v\n", err)
 		fmt.Println("Falling back to basic interactive mode.")
-		startBasicInteractiveMode()
+		startBasicInteractiveMode(engine)
 		return
 	}
 	defer rl.Close()
@@ -97,72 +424,16 @@ func startInteractiveMode() {
 			}
 			toycalc_core.DisplayHelp(topic)
 		} else if parts[0] == "set" {
-			if len(parts) == 1 {
-				fmt.Println("Usage: set <format|precision> <options>")
-				fmt.Println("Example: set format fixed 4")
-				fmt.Println("         set precision 6")
-				continue
-			}
-			switch parts[1] {
-			case "format":
-				if len(parts) < 3 {
-					fmt.Println("Usage: set format <auto|fixed N|sci N>")
-					fmt.Println("Example: set format fixed 4")
-					fmt.Println("         set format sci 6")
-					fmt.Println("         set format auto")
-					continue
-				}
-				mode := parts[2]
-				precision := toycalc_core.OutputDisplayPrecision // Keep current precision if not specified for auto
-				if mode == "fixed" || mode == "sci" {
-					if len(parts) < 4 {
-						fmt.Printf("Usage: set format %s <N> (where N is number of digits)\n", mode)
-						continue
-					}
-					p, err := strconv.Atoi(parts[3])
-					if err != nil || p < 0 || p > 20 { // Set a reasonable max precision
-						fmt.Println("Error: Precision N must be a non-negative integer (e.g., 0-20).")
-						continue
-					}
-					precision = p
-				} else if mode != "auto" {
-					fmt.Printf("Error: Unknown format mode '%s'. Use 'auto', 'fixed N', or 'sci N'.\n", mode)
-					continue
-				}
-
-				toycalc_core.OutputFormatMode = mode
-				toycalc_core.OutputDisplayPrecision = precision
-				fmt.Printf("Output format set to: %s", toycalc_core.OutputFormatMode)
-				if toycalc_core.OutputFormatMode != "auto" {
-					fmt.Printf(", %d digits precision", toycalc_core.OutputDisplayPrecision)
-				}
-				fmt.Println()
-
-			case "precision":
-				if len(parts) < 3 {
-					fmt.Println("Usage: set precision <N> (where N is number of digits, e.g., 0-20)")
-					continue
-				}
-				p, err := strconv.Atoi(parts[2])
-				if err != nil || p < 0 || p > 20 { // Max precision
-					fmt.Println("Error: Precision N must be a non-negative integer (e.g., 0-20).")
-					continue
-				}
-				toycalc_core.OutputDisplayPrecision = p
-				fmt.Printf("Display precision set to: %d digits (affects 'fixed', 'sci', and pre-rounding for 'auto' mode)\n", toycalc_core.OutputDisplayPrecision)
-			default:
-				fmt.Printf("Error: Unknown option for 'set': '%s'. Try 'set format ...' or 'set precision ...'.\n", parts[1])
-
-			}
+			handleSetCommand(parts[1:], engine)
 		} else {
 			// Process as mathematical expression
-			processExpression(input) // Use the original 'input' not 'lowerInput'
+			processExpression(input, engine) // Use the original 'input' not 'lowerInput'
 		}
 	}
 }
 
 // startBasicInteractiveMode is your original interactive mode as a fallback
-func startBasicInteractiveMode() {
+func startBasicInteractiveMode(engine *toycalc_core.Engine) {
 	fmt.Println("ToyCalc Interactive Mode (v0.1 Stage 1 - Basic)")
 	fmt.Println("Type 'exit' or 'quit' to leave, or 'help' for assistance.")
 	reader := NewStdinReader() // Custom function to create bufio.Reader if you want to keep it
@@ -194,7 +465,7 @@ func startBasicInteractiveMode() {
 			}
 			toycalc_core.DisplayHelp(topic)
 		} else {
-			processExpression(input)
+			processExpression(input, engine)
 		}
 	}
 }
@@ -209,6 +480,26 @@ func NewStdinReader() *bufio.Reader {
 	return bufio.NewReader(os.Stdin)
 }
 
+// runScript evaluates a script file (or standard input when path is "-") one statement
+// per line, printing each result. Variables assigned on one line are visible on the next.
+func runScript(path string) error {
+	var script []byte
+	var err error
+	if path == "-" {
+		script, err = io.ReadAll(os.Stdin)
+	} else {
+		script, err = os.ReadFile(filepath.Clean(path))
+	}
+	if err != nil {
+		return err
+	}
+	results, err := toycalc_core.NewEngine().EvaluateScript(string(script))
+	for _, result := range results {
+		fmt.Println(result)
+	}
+	return err
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		startInteractiveMode()
@@ -220,11 +511,20 @@ func main() {
 				topic = strings.Join(os.Args[2:], " ")
 			}
 			toycalc_core.DisplayHelp(topic)
+		} else if firstArg == "-f" {
+			if len(os.Args) != 3 {
+				fmt.Fprintln(os.Stderr, "Usage: toycalc -f <script file | ->")
+				os.Exit(2)
+			}
+			if err := runScript(os.Args[2]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
 		} else {
 			expressionString := strings.Join(os.Args[1:], " ")
-			resultStr, err := toycalc_core.CalculateExpression(expressionString)
+			resultStr, err := toycalc_core.NewEngine().Evaluate(expressionString)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				printError(expressionString, err)
 				os.Exit(1)
 			}
 			fmt.Println(resultStr)
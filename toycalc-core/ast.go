@@ -0,0 +1,161 @@
+// ast.go
+package toycalc_core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Span is a range of byte offsets in the input: Start is inclusive, End exclusive.
+type Span struct {
+	Start int
+	End   int
+}
+
+// Node is a node of the expression tree built by ParseAST.
+type Node interface {
+	// Span returns the part of the input the node was parsed from.
+	Span() Span
+	// String renders the node as an infix expression, with parentheses only where needed.
+	String() string
+	// appendRPN appends the node's tokens in Reverse Polish Notation to rpn.
+	appendRPN(rpn []Token) []Token
+}
+
+// NumberNode is a numeric literal such as 3.14 or 1e-3.
+type NumberNode struct {
+	Token Token
+}
+
+// IdentNode is a reference to a constant or variable, such as pi or x.
+type IdentNode struct {
+	Token Token
+}
+
+// UnaryNode is an operator applied to one operand: a prefix one, such as -x, or a postfix
+// one, such as 30° or 5!.
+type UnaryNode struct {
+	Op      Token // UNARY_MINUS, TILDE, NOT, DEGREE, FACTORIAL or DOUBLE_FACTORIAL
+	Operand Node
+}
+
+// BinaryNode is an infix operator applied to two operands. Implicit is set for
+// multiplications the user did not write, as in 2x or (1+i)(1-i).
+type BinaryNode struct {
+	Op       Token
+	Left     Node
+	Right    Node
+	Implicit bool
+}
+
+// CallNode is a call to a built-in or user function. Its span runs from the function name
+// to the closing parenthesis.
+type CallNode struct {
+	Name Token
+	Args []Node
+	span Span
+}
+
+func tokenSpan(token Token) Span {
+	return Span{Start: token.Position, End: token.Position + len(token.Literal)}
+}
+
+func (n *NumberNode) Span() Span { return tokenSpan(n.Token) }
+func (n *IdentNode) Span() Span  { return tokenSpan(n.Token) }
+func (n *UnaryNode) Span() Span {
+	if n.Postfix() {
+		return Span{Start: n.Operand.Span().Start, End: n.Op.Position + len(n.Op.Literal)}
+	}
+	return Span{Start: n.Op.Position, End: n.Operand.Span().End}
+}
+func (n *BinaryNode) Span() Span { return Span{Start: n.Left.Span().Start, End: n.Right.Span().End} }
+func (n *CallNode) Span() Span   { return n.span }
+
+func (n *NumberNode) String() string { return n.Token.Literal }
+func (n *IdentNode) String() string  { return n.Token.Literal }
+
+// Postfix reports whether the operator follows its operand.
+func (n *UnaryNode) Postfix() bool {
+	return n.Op.Type == DEGREE || n.Op.Type == FACTORIAL || n.Op.Type == DOUBLE_FACTORIAL
+}
+
+func (n *UnaryNode) String() string {
+	operand := parenthesize(n.Operand, nodePrecedence(n), false)
+	if n.Postfix() {
+		if strings.HasSuffix(operand, "!") && strings.HasPrefix(n.Op.Literal, "!") {
+			return operand + " " + n.Op.Literal // (5!)! is not 5!!
+		}
+		return operand + n.Op.Literal
+	}
+	if n.Op.Type == NOT {
+		return "not " + operand
+	}
+	return n.Op.Literal + operand
+}
+
+func (n *BinaryNode) String() string {
+	precedence := nodePrecedence(n)
+	rightAssociative := n.Op.Type == CARET
+	left := parenthesize(n.Left, precedence, rightAssociative)
+	right := parenthesize(n.Right, precedence, !rightAssociative)
+	if n.Implicit && strings.HasPrefix(right, "(") {
+		return left + right // 2(x + 1) reads as written; 2 * x avoids ambiguities like "2e"
+	}
+	return fmt.Sprintf("%s %s %s", left, n.Op.Literal, right)
+}
+
+func (n *CallNode) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return fmt.Sprintf("%s(%s)", n.Name.Literal, strings.Join(args, ", "))
+}
+
+// nodePrecedence returns how tightly a node binds, using the parser's operator precedences.
+// Leaves and calls never need parentheses.
+func nodePrecedence(node Node) int {
+	switch n := node.(type) {
+	case *UnaryNode:
+		return operatorPrecedence[n.Op.Type]
+	case *BinaryNode:
+		return operatorPrecedence[n.Op.Type]
+	}
+	return maxPrecedence
+}
+
+// parenthesize renders child as an operand of an operator with the given precedence.
+// onTightSide is true for the side on which an equal precedence must be parenthesized
+// (the right side of a left-associative operator, the left side of a right-associative one).
+func parenthesize(child Node, precedence int, onTightSide bool) string {
+	childPrecedence := nodePrecedence(child)
+	if childPrecedence < precedence || (childPrecedence == precedence && onTightSide) {
+		return "(" + child.String() + ")"
+	}
+	return child.String()
+}
+
+func (n *NumberNode) appendRPN(rpn []Token) []Token { return append(rpn, n.Token) }
+func (n *IdentNode) appendRPN(rpn []Token) []Token  { return append(rpn, n.Token) }
+
+func (n *UnaryNode) appendRPN(rpn []Token) []Token {
+	return append(n.Operand.appendRPN(rpn), n.Op)
+}
+
+func (n *BinaryNode) appendRPN(rpn []Token) []Token {
+	return append(n.Right.appendRPN(n.Left.appendRPN(rpn)), n.Op)
+}
+
+func (n *CallNode) appendRPN(rpn []Token) []Token {
+	for _, arg := range n.Args {
+		rpn = arg.appendRPN(rpn)
+	}
+	name := n.Name
+	name.Arity = len(n.Args)
+	return append(rpn, name)
+}
+
+// ToRPN flattens a tree into the Reverse Polish Notation queue expected by EvaluateRPN.
+func ToRPN(node Node) []Token {
+	return node.appendRPN(nil)
+}
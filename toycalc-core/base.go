@@ -0,0 +1,147 @@
+// base.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strings"
+	"unicode"
+)
+
+// basePrefixes maps the letter after the leading 0 of a based literal (0x1F, 0o17, 0b1010)
+// to its base.
+var basePrefixes = map[rune]int{'x': 16, 'o': 8, 'b': 2}
+
+// baseOutputPrefixes are the prefixes results are written with in each output base.
+var baseOutputPrefixes = map[int]string{2: "0b", 8: "0o", 16: "0x"}
+
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// readBasedNumber reads a literal like 0x1F, 0o17 or 0b1010 if the lexer is at one; hex
+// literals may have a fractional part, as in 0x1.8. It returns false, without consuming
+// anything, unless the 0 is followed by a prefix and a digit, so 0b still reads as 0*b.
+// Binary and octal literals take any decimal digits, so that 0b102 is reported as an
+// invalid number instead of being read as 0b10 * 2.
+func (l *Lexer) readBasedNumber() (string, bool) {
+	if l.ch != '0' {
+		return "", false
+	}
+	base := basePrefixes[unicode.ToLower(l.peekChar())]
+	if base == 0 {
+		return "", false
+	}
+	saved := *l
+	position := l.position
+	l.readChar() // consume '0'
+	l.readChar() // consume the prefix
+	isBaseDigit := isDigit
+	if base == 16 {
+		isBaseDigit = isHexDigit
+	}
+	if !isBaseDigit(l.ch) {
+		*l = saved
+		return "", false
+	}
+	hasFraction := false
+	for isBaseDigit(l.ch) || (base == 16 && !hasFraction && (l.ch == '.' || l.ch == ',' && l.decimalComma) && isHexDigit(l.peekChar())) {
+		if !isBaseDigit(l.ch) {
+			hasFraction = true
+		}
+		l.readChar()
+	}
+	return l.input[position:l.position], true
+}
+
+// basedNumberText converts a based literal to the decimal text numberText returns, exactly:
+// a hex fraction has a finite decimal expansion. It returns false for other literals or
+// if the digits are not valid in the literal's base.
+func basedNumberText(literal string) (string, bool) {
+	if len(literal) < 3 || literal[0] != '0' {
+		return "", false
+	}
+	base := basePrefixes[unicode.ToLower(rune(literal[1]))]
+	if base == 0 {
+		return "", false
+	}
+	digits := strings.Replace(literal[2:], ",", ".", 1)
+	whole, fraction, _ := strings.Cut(digits, ".")
+	mantissa, ok := new(big.Int).SetString(whole+fraction, base)
+	if !ok {
+		return "", false
+	}
+	if fraction == "" {
+		return mantissa.String(), true
+	}
+	denominator := new(big.Int).Exp(big.NewInt(int64(base)), big.NewInt(int64(len(fraction))), nil)
+	// Each hex digit after the point adds 4 binary places and so at most 4 decimal places.
+	return new(big.Rat).SetFrac(mantissa, denominator).FloatString(4 * len(fraction)), true
+}
+
+func validateBase(base int) error {
+	if _, found := baseOutputPrefixes[base]; !found && base != 10 {
+		return NewCalculationError(fmt.Sprintf("unknown base %d; use 2, 8, 10 or 16", base))
+	}
+	return nil
+}
+
+// formatComplexInBase renders c in settings.Base if both parts are integers after rounding
+// to the display precision. It returns false, so that c is shown in decimal, in base 10 or
+// if a part is not an integer or not finite.
+func formatComplexInBase(c complex128, settings Settings) (string, bool) {
+	if settings.Base == 0 || settings.Base == 10 || cmplx.IsNaN(c) || cmplx.IsInf(c) {
+		return "", false
+	}
+	reInt, realIsInt := displayedInteger(real(c), settings.Precision)
+	imInt, imagIsInt := displayedInteger(imag(c), settings.Precision)
+	if !realIsInt || !imagIsInt {
+		return "", false
+	}
+	return formatIntegersInBase(reInt, imInt, settings.Base), true
+}
+
+// displayedInteger returns the integer x shows as after rounding to the given number of
+// decimal places, if any. Integers, which every float64 of magnitude 2^53 or more is, are
+// converted exactly, as scaling them for the rounding would lose their low bits.
+func displayedInteger(x float64, places int) (*big.Int, bool) {
+	if x != math.Trunc(x) {
+		x = roundToDecimalPlaces(x, places)
+		if !isEffectivelyInteger(x, Epsilon) {
+			return nil, false
+		}
+		x = math.Round(x)
+	}
+	integer, _ := big.NewFloat(x).Int(nil)
+	return integer, true
+}
+
+// formatIntegersInBase renders the Gaussian integer re + im*i in base 2, 8 or 16 with the
+// base's prefix, as in "0x1F - 0xA i". An imaginary part ending in a letter is followed by
+// a space so that the digits and the i stay apart.
+func formatIntegersInBase(re, im *big.Int, base int) string {
+	text := func(x *big.Int) string {
+		digits := new(big.Int).Abs(x).Text(base)
+		if base == 16 {
+			digits = strings.ToUpper(digits)
+		}
+		return baseOutputPrefixes[base] + digits
+	}
+	realStr := text(re)
+	if re.Sign() < 0 {
+		realStr = "-" + realStr
+	}
+	if im.Sign() == 0 {
+		if re.Sign() == 0 {
+			return "0"
+		}
+		return realStr
+	}
+	imagMagStr := text(im)
+	if last := imagMagStr[len(imagMagStr)-1]; last < '0' || last > '9' {
+		imagMagStr += " "
+	}
+	return joinComplex(realStr, imagMagStr, re.Sign() == 0, im.Sign() < 0, im.IsInt64() && (im.Int64() == 1 || im.Int64() == -1))
+}
@@ -0,0 +1,426 @@
+// bessel.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// reciprocalGammaCoefficients are the c(k) of 1/Γ(z) = Σ c(k) z^k, k = 1..26 (Abramowitz &
+// Stegun 6.1.34), so that 1/Γ(1+x) = Σ c(k) x^(k-1).
+var reciprocalGammaCoefficients = [...]float64{
+	1.0, 0.5772156649015329, -0.6558780715202538, -0.0420026350340952,
+	0.1665386113822915, -0.0421977345555443, -0.0096219715278770, 0.0072189432466630,
+	-0.0011651675918591, -0.0002152416741149, 0.0001280502823882, -0.0000201348547807,
+	-0.0000012504934821, 0.0000011330272320, -0.0000002056338417, 0.0000000061160950,
+	0.0000000050020075, -0.0000000011812746, 0.0000000001043427, 0.0000000000077823,
+	-0.0000000000036968, 0.0000000000005100, -0.0000000000000206, -0.0000000000000054,
+	0.0000000000000014, 0.0000000000000001,
+}
+
+// temmeGammas returns (1/Γ(1-μ) - 1/Γ(1+μ)) / 2μ and (1/Γ(1-μ) + 1/Γ(1+μ)) / 2 for
+// |μ| <= 1/2, from the even and odd coefficients of 1/Γ so that neither loses digits as μ
+// tends to 0.
+func temmeGammas(mu float64) (gam1, gam2 float64) {
+	power := 1.0
+	for k := 0; k+1 < len(reciprocalGammaCoefficients); k += 2 {
+		gam2 += reciprocalGammaCoefficients[k] * power
+		gam1 -= reciprocalGammaCoefficients[k+1] * power
+		power *= mu * mu
+	}
+	return gam1, gam2
+}
+
+// sinCosPi returns sin(πx) and cos(πx), exact at the multiples of 1/2 so that integer orders
+// of the Bessel functions cancel exactly in the reflection formulas.
+func sinCosPi(x float64) (float64, float64) {
+	switch x = math.Mod(x, 2); x {
+	case 0:
+		return 0, 1
+	case 0.5, -1.5:
+		return 1, 0
+	case 1, -1:
+		return 0, -1
+	case 1.5, -0.5:
+		return -1, 0
+	}
+	return math.Sincos(math.Pi * x)
+}
+
+// phasePi returns exp(iπx).
+func phasePi(x float64) complex128 {
+	sin, cos := sinCosPi(x)
+	return complex(cos, sin)
+}
+
+// besselIK returns the modified Bessel functions I_ν(z) e^-z and K_ν(z) e^z for ν >= 0 and
+// Re(z) >= 0, z != 0, scaled so that neither overflows. For large orders K_ν(z) e^z overflows
+// all the same, so it is returned as k and an exponent σ with K_ν(z) e^z = k e^σ. It is the
+// method of Numerical Recipes' bessik in complex arithmetic: K at the order μ = ν - round(ν)
+// from Temme's series for |z| < 2 or Steed's continued fraction CF2 otherwise, K_ν by upward
+// recurrence, which is stable for K, and I_ν from the continued fraction CF1 for I'_ν/I_ν,
+// downward recurrence to μ and the Wronskian. Unlike bessik, the Wronskian is not divided by
+// I_μ, which has zeros off the real axis.
+func besselIK(nu float64, z complex128, budget *Budget) (complex128, complex128, float64, error) {
+	const epsilon = 1e-16
+	const tiny = 1e-300
+	nl := int(nu + 0.5)
+	mu := nu - float64(nl)
+	xi := 1 / z
+	xi2 := 2 * xi
+
+	// CF1: h = I'_ν/I_ν. It needs about |z| iterations to converge.
+	h := complex(nu, 0) * xi
+	if cmplx.Abs(h) < tiny {
+		h = tiny
+	}
+	b := xi2 * complex(nu, 0)
+	d, c := complex(0, 0), h
+	for i := 1; i <= maxSpecialIterations+2*int(cmplx.Abs(z)); i++ {
+		if err := budget.Spend(1); err != nil {
+			return 0, 0, 0, err
+		}
+		b += xi2
+		d = 1 / (b + d)
+		c = b + 1/c
+		delta := c * d
+		h *= delta
+		if cmplx.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	// Downward recurrence from an arbitrary I_ν, rescaled when it grows large; only the ratio
+	// of the values at ν and μ matters.
+	il := complex(tiny, 0)
+	ipl := h * il
+	il1 := il
+	fact := complex(nu, 0) * xi
+	if err := budget.Spend(nl); err != nil {
+		return 0, 0, 0, err
+	}
+	for l := nl; l >= 1; l-- {
+		next := fact*il + ipl
+		fact -= xi
+		ipl = fact*next + il
+		il = next
+		if cmplx.Abs(il) > 1e250 {
+			il, ipl, il1 = il*1e-250, ipl*1e-250, il1*1e-250
+		}
+	}
+
+	var kmu, k1 complex128
+	if cmplx.Abs(z) < 2 {
+		x2 := z / 2
+		piMu := math.Pi * mu
+		fact := 1.0
+		if math.Abs(piMu) >= epsilon {
+			fact = piMu / math.Sin(piMu)
+		}
+		d := -cmplx.Log(x2)
+		e := complex(mu, 0) * d
+		fact2 := complex(1, 0)
+		if cmplx.Abs(e) >= epsilon {
+			fact2 = cmplx.Sinh(e) / e
+		}
+		gam1, gam2 := temmeGammas(mu)
+		gamPlus, gamMinus := gam2-mu*gam1, gam2+mu*gam1 // 1/Γ(1+μ), 1/Γ(1-μ)
+		ff := complex(fact, 0) * (complex(gam1, 0)*cmplx.Cosh(e) + complex(gam2, 0)*fact2*d)
+		sum := ff
+		e = cmplx.Exp(e)
+		p := e / complex(2*gamPlus, 0)
+		q := 1 / (2 * e * complex(gamMinus, 0))
+		c := complex(1, 0)
+		d = x2 * x2
+		sum1 := p
+		for i := 1; i <= maxSpecialIterations; i++ {
+			if err := budget.Spend(1); err != nil {
+				return 0, 0, 0, err
+			}
+			fi := float64(i)
+			ff = (complex(fi, 0)*ff + p + q) / complex(fi*fi-mu*mu, 0)
+			c *= d / complex(fi, 0)
+			p /= complex(fi-mu, 0)
+			q /= complex(fi+mu, 0)
+			delta := c * ff
+			sum += delta
+			sum1 += c * (p - complex(fi, 0)*ff)
+			if cmplx.Abs(delta) < cmplx.Abs(sum)*epsilon {
+				break
+			}
+		}
+		scale := cmplx.Exp(z)
+		kmu, k1 = sum*scale, sum1*xi2*scale
+	} else {
+		b := 2 * (1 + z)
+		d := 1 / b
+		h := d
+		deltaH := d
+		q1, q2 := complex(0, 0), complex(1, 0)
+		a1 := 0.25 - mu*mu
+		q, c := complex(a1, 0), complex(a1, 0)
+		a := -a1
+		s := 1 + q*deltaH
+		for i := 2; i <= maxSpecialIterations; i++ {
+			if err := budget.Spend(1); err != nil {
+				return 0, 0, 0, err
+			}
+			a -= float64(2 * (i - 1))
+			c = -complex(a, 0) * c / complex(float64(i), 0)
+			qNew := (q1 - b*q2) / complex(a, 0)
+			q1, q2 = q2, qNew
+			q += c * qNew
+			b += 2
+			d = 1 / (b + complex(a, 0)*d)
+			deltaH *= b*d - 1
+			h += deltaH
+			deltaS := q * deltaH
+			s += deltaS
+			if cmplx.Abs(deltaS/s) < epsilon {
+				break
+			}
+		}
+		h *= complex(a1, 0)
+		kmu = cmplx.Sqrt(math.Pi/(2*z)) / s
+		k1 = kmu * (complex(mu+0.5, 0) + z - h) * xi
+	}
+	kmuPrime := complex(mu, 0)*xi*kmu - k1
+	i := xi * il1 / (ipl*kmu - il*kmuPrime)
+	sigma := 0.0
+	for l := 1; l <= nl; l++ {
+		factor := complex(mu+float64(l), 0) * xi2
+		if cmplx.Abs(k1)*cmplx.Abs(factor) > 1e250 { // Rescale before the next value overflows
+			scale := cmplx.Abs(k1)
+			kmu, k1 = kmu/complex(scale, 0), k1/complex(scale, 0)
+			sigma += math.Log(scale)
+		}
+		kmu, k1 = k1, factor*k1+kmu
+	}
+	return i, kmu, sigma, nil
+}
+
+// modifiedBessel returns I_ν(z) and K_ν(z) for real ν and z != 0, continuing besselIK to
+// negative orders with I_-ν = I_ν + (2/π) sin(νπ) K_ν and K_-ν = K_ν, and to the left
+// half-plane with I_ν(w e^(mπi)) = e^(mνπi) I_ν(w) and K_ν(w e^(mπi)) = e^(-mνπi) K_ν(w) -
+// mπi I_ν(w), m = ±1.
+func modifiedBessel(nu float64, z complex128, budget *Budget) (complex128, complex128, error) {
+	if nu < 0 {
+		i, k, err := modifiedBessel(-nu, z, budget)
+		sin, _ := sinCosPi(-nu)
+		return i + times(complex(2/math.Pi*sin, 0), k), k, err
+	}
+	if real(z) < 0 {
+		m := 1.0
+		if imag(z) < 0 {
+			m = -1
+		}
+		i, k, sigma, err := besselIK(nu, -z, budget)
+		phase := phasePi(m * nu)
+		return expTimes(-z, phase*i), expTimes(z+complex(sigma, 0), k/phase) - expTimes(-z, complex(0, m*math.Pi)*i), err
+	}
+	i, k, sigma, err := besselIK(nu, z, budget)
+	i, k = expTimes(z, i), expTimes(-z+complex(sigma, 0), k)
+	if imag(z) == 0 { // Real on the positive axis, also where they overflow
+		i, k = complex(real(i), 0), complex(real(k), 0)
+	}
+	return i, k, err
+}
+
+// expTimes returns w e^s. Where e^s overflows, the parts of the result that are 0 stay 0
+// instead of becoming the NaN of Inf*0.
+func expTimes(s, w complex128) complex128 {
+	if real(s) < 700 || w == 0 {
+		return cmplx.Exp(s) * w
+	}
+	l := s + cmplx.Log(w)
+	magnitude := math.Exp(real(l))
+	sin, cos := math.Sincos(imag(l))
+	part := func(x float64) float64 {
+		if math.Abs(x) < 1e-15 {
+			return 0
+		}
+		return magnitude * x
+	}
+	return complex(part(cos), part(sin))
+}
+
+// times returns a b, taking the product of an infinite part and a zero part as 0 rather
+// than NaN: multiplied by the exact zeros of sinCosPi and phasePi, the Bessel functions of
+// large order, which overflow, must vanish rather than become NaN.
+func times(a, b complex128) complex128 {
+	product := func(x, y float64) float64 {
+		if x == 0 || y == 0 {
+			return 0
+		}
+		return x * y
+	}
+	return complex(product(real(a), real(b))-product(imag(a), imag(b)), product(real(a), imag(b))+product(imag(a), real(b)))
+}
+
+// besselJY returns the Bessel functions J_ν(z) and Y_ν(z) for real ν and z != 0. In the right
+// half-plane they come from I and K at ∓iz (DLMF 10.27.6 and 10.27.11); negative orders and
+// the left half-plane use the reflection formulas of DLMF 10.4.6 and 10.11.
+func besselJY(nu float64, z complex128, budget *Budget) (complex128, complex128, error) {
+	if nu < 0 {
+		j, y, err := besselJY(-nu, z, budget)
+		sin, cos := sinCosPi(-nu)
+		return times(complex(cos, 0), j) - times(complex(sin, 0), y), times(complex(sin, 0), j) + times(complex(cos, 0), y), err
+	}
+	if real(z) < 0 {
+		m := 1.0
+		if imag(z) < 0 {
+			m = -1
+		}
+		j, y, err := besselJY(nu, -z, budget)
+		_, cos := sinCosPi(nu)
+		phase := phasePi(m * nu)
+		return phase * j, times(y, cmplx.Conj(phase)) + complex(0, 2*m*cos)*j, err
+	}
+	// m = 1 for Im(z) >= 0, evaluating at -iz; m = -1 below the real axis, at iz.
+	m := 1.0
+	if imag(z) < 0 {
+		m = -1
+	}
+	u := complex(0, -m) * z
+	i, k, sigma, err := besselIK(nu, u, budget)
+	j := expTimes(u, phasePi(m*nu/2)*i)
+	y := expTimes(u, phasePi(m*(nu+1)/2)*i) - expTimes(-u+complex(sigma, 0), complex(2/math.Pi, 0)*phasePi(-m*nu/2)*k)
+	if imag(z) == 0 { // Real on the positive axis, also where they overflow
+		j, y = complex(real(j), 0), complex(real(y), 0)
+	}
+	return j, y, err
+}
+
+// besselOrder checks the order of a Bessel function: a real number.
+func besselOrder(n complex128) (float64, error) {
+	if imag(n) != 0 || math.IsNaN(real(n)) || math.IsInf(real(n), 0) {
+		return 0, fmt.Errorf("the order must be a real number, got %s", formatComplexOutput(n))
+	}
+	return real(n), nil
+}
+
+// besselAtZero returns J_ν(0) and I_ν(0): 1 for ν = 0 and 0 for the other integers and ν > 0.
+// The other orders, like Y and K at every order, are singular at 0.
+func besselAtZero(nu float64) (complex128, error) {
+	switch {
+	case nu == 0:
+		return 1, nil
+	case nu > 0 || nu == math.Trunc(nu):
+		return 0, nil
+	}
+	return complex(math.NaN(), math.NaN()), errBesselSingular
+}
+
+var errBesselSingular = fmt.Errorf("undefined at z = 0")
+
+// besselFunction builds the BudgetedImpl of besselj, bessely, besseli or besselk: pick selects
+// one of the pair computed by compute, and realOnNegativeAxis says whether the function is
+// real on the negative real axis for integer orders, as J and I are. On the real axis the
+// rounding residue of the imaginary part is dropped where the result is real.
+func besselFunction(compute func(float64, complex128, *Budget) (complex128, complex128, error), second, realOnNegativeAxis bool) BudgetedImpl {
+	return func(budget *Budget, args []complex128) (complex128, error) {
+		nu, err := besselOrder(args[0])
+		if err != nil {
+			return complex(math.NaN(), math.NaN()), err
+		}
+		z := args[1]
+		if z == 0 {
+			if second {
+				return complex(math.NaN(), math.NaN()), errBesselSingular
+			}
+			return besselAtZero(nu)
+		}
+		first, other, err := compute(nu, z, budget)
+		if err != nil {
+			return 0, err
+		}
+		result := first
+		if second {
+			result = other
+		}
+		if imag(z) == 0 && (real(z) > 0 || realOnNegativeAxis && nu == math.Trunc(nu)) {
+			result = complex(real(result), 0)
+		}
+		return result, nil
+	}
+}
+
+// airyZero holds Ai(0) and -Ai'(0).
+var airyZero = [2]float64{0.355028053887817239, 0.258819403792806798}
+
+// complexAiry returns the Airy function Ai(z), or Bi(z) if bi is set. Near 0 it sums the
+// Maclaurin series. Elsewhere Ai comes from K_1/3 for |ph z| <= 2π/3 and from J_±1/3 at -z
+// beyond; Bi comes from I_±1/3 for |ph z| <= π/3, where it grows, and from
+// Bi(z) = e^(πi/6) Ai(z e^(2πi/3)) + e^(-πi/6) Ai(z e^(-2πi/3)) beyond (DLMF 9.2.10, 9.6).
+func complexAiry(z complex128, bi bool, budget *Budget) (complex128, error) {
+	var result complex128
+	var err error
+	phase := math.Abs(cmplx.Phase(z))
+	switch {
+	case cmplx.Abs(z) <= 1:
+		result, err = airySeries(z, bi, budget)
+	case bi && phase <= math.Pi/3:
+		// Bi(z) = √(z/3) (I_-1/3(ζ) + I_1/3(ζ)), with I_-1/3 = I_1/3 + (√3/π) K_1/3. The
+		// factor e^ζ of the scaled I and K is applied last, so that overflow gives Inf.
+		zeta := 2.0 / 3 * z * cmplx.Sqrt(z)
+		var i, k complex128
+		i, k, _, err = besselIK(1.0/3, zeta, budget) // Orders below 1/2 are never rescaled
+		result = expTimes(zeta, cmplx.Sqrt(z/3)*(2*i+complex(math.Sqrt(3)/math.Pi, 0)*k*cmplx.Exp(-2*zeta)))
+	case bi:
+		var plus, minus complex128
+		rotation := phasePi(2.0 / 3)
+		if plus, err = complexAiry(z*rotation, false, budget); err != nil {
+			return 0, err
+		}
+		if minus, err = complexAiry(z*cmplx.Conj(rotation), false, budget); err != nil {
+			return 0, err
+		}
+		result = phasePi(1.0/6)*plus + phasePi(-1.0/6)*minus
+	case phase <= 2*math.Pi/3:
+		// Ai(z) = √(z/3) K_1/3(ζ) / π.
+		zeta := 2.0 / 3 * z * cmplx.Sqrt(z)
+		var k complex128
+		_, k, err = modifiedBessel(1.0/3, zeta, budget)
+		result = cmplx.Sqrt(z/3) * k / math.Pi
+	default:
+		// Ai(-w) = √w/3 (J_1/3(ζ) + J_-1/3(ζ)), with J_-1/3 = cos(π/3) J_1/3 - sin(π/3) Y_1/3.
+		w := -z
+		zeta := 2.0 / 3 * w * cmplx.Sqrt(w)
+		var j, y complex128
+		j, y, err = besselJY(1.0/3, zeta, budget)
+		sin, cos := sinCosPi(1.0 / 3)
+		result = cmplx.Sqrt(w) / 3 * (j + complex(cos, 0)*j - complex(sin, 0)*y)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if imag(z) == 0 {
+		result = complex(real(result), 0)
+	}
+	return result, nil
+}
+
+// airySeries sums Ai(z) = Ai(0) f(z) + Ai'(0) g(z) and Bi(z) = √3 (Ai(0) f(z) - Ai'(0) g(z)),
+// with f = Σ 3^k (1/3)_k z^(3k) / (3k)! and g = Σ 3^k (2/3)_k z^(3k+1) / (3k+1)!.
+func airySeries(z complex128, bi bool, budget *Budget) (complex128, error) {
+	z3 := z * z * z
+	fTerm, gTerm := complex(1, 0), z
+	f, g := fTerm, gTerm
+	for k := 0; k < maxSpecialIterations; k++ {
+		if err := budget.Spend(1); err != nil {
+			return 0, err
+		}
+		fk := float64(3 * k)
+		fTerm *= z3 / complex((fk+2)*(fk+3), 0)
+		gTerm *= z3 / complex((fk+3)*(fk+4), 0)
+		f += fTerm
+		g += gTerm
+		if cmplx.Abs(fTerm)+cmplx.Abs(gTerm) <= 1e-17*(cmplx.Abs(f)+cmplx.Abs(g)) {
+			break
+		}
+	}
+	if bi {
+		return complex(math.Sqrt(3), 0) * (complex(airyZero[0], 0)*f + complex(airyZero[1], 0)*g), nil
+	}
+	return complex(airyZero[0], 0)*f - complex(airyZero[1], 0)*g, nil
+}
@@ -0,0 +1,692 @@
+// bigmath.go
+package toycalc_core
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"sync"
+)
+
+// bigComplex is a complex number with big.Float components, the value type of
+// high-precision mode.
+type bigComplex struct {
+	re, im *big.Float
+}
+
+// bigMath computes elementary functions of big.Float and bigComplex values. Each function
+// takes the working precision in bits. Series loops are charged to budget (which may be nil);
+// the first error is kept in err, after which loops stop early and results are meaningless.
+type bigMath struct {
+	budget *Budget
+	err    error
+}
+
+// Errors reported by the functions of high-precision mode. errDivisionByZero is also used
+// by exact mode.
+var (
+	errDivisionByZero   = errors.New("division by zero")
+	errBigLogOfZero     = errors.New("logarithm of zero")
+	errBigArgumentRange = errors.New("argument too large for high-precision mode")
+	errBigUndefined     = errors.New("result is undefined (infinite or not a number)")
+)
+
+// fail records err unless an earlier error is already recorded.
+func (m *bigMath) fail(err error) {
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+// spend charges one series term to the budget and reports whether to go on.
+func (m *bigMath) spend() bool {
+	if m.err == nil {
+		m.err = m.budget.Spend(1)
+	}
+	return m.err == nil
+}
+
+func newFloat(prec uint) *big.Float {
+	return new(big.Float).SetPrec(prec)
+}
+
+func intFloat(n int64, prec uint) *big.Float {
+	return newFloat(prec).SetInt64(n)
+}
+
+func add(a, b *big.Float, prec uint) *big.Float { return newFloat(prec).Add(a, b) }
+func sub(a, b *big.Float, prec uint) *big.Float { return newFloat(prec).Sub(a, b) }
+func mul(a, b *big.Float, prec uint) *big.Float { return newFloat(prec).Mul(a, b) }
+func quo(a, b *big.Float, prec uint) *big.Float { return newFloat(prec).Quo(a, b) }
+func neg(a *big.Float, prec uint) *big.Float    { return newFloat(prec).Neg(a) }
+
+// negligible reports whether term is too small to change a sum at precision prec.
+func negligible(term *big.Float, prec uint) bool {
+	return term.Sign() == 0 || term.MantExp(nil) < -int(prec)
+}
+
+// constantCache keeps the constants computed so far by precision.
+type constantCache struct {
+	mu     sync.Mutex
+	values map[uint]*big.Float
+}
+
+func (c *constantCache) get(prec uint, compute func(prec uint) *big.Float) *big.Float {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = map[uint]*big.Float{}
+	}
+	value, found := c.values[prec]
+	if !found {
+		value = compute(prec)
+		c.values[prec] = value
+	}
+	return value
+}
+
+var piCache, ln2Cache constantCache
+
+// bigPi returns π to prec bits, computed with the Gauss-Legendre algorithm.
+func bigPi(prec uint) *big.Float {
+	return piCache.get(prec, func(prec uint) *big.Float {
+		wp := prec + 32
+		a := intFloat(1, wp)
+		b := newFloat(wp).Sqrt(newFloat(wp).SetFloat64(0.5))
+		t := newFloat(wp).SetFloat64(0.25)
+		p := intFloat(1, wp)
+		// Each iteration doubles the number of correct digits.
+		for iteration := uint(1); iteration < 2*wp; iteration *= 2 {
+			next := add(a, b, wp)
+			next.SetMantExp(next, -1)
+			diff := sub(a, next, wp)
+			t.Sub(t, mul(p, mul(diff, diff, wp), wp))
+			b = newFloat(wp).Sqrt(mul(a, b, wp))
+			a = next
+			p.SetMantExp(p, 1)
+		}
+		sum := add(a, b, wp)
+		pi := quo(mul(sum, sum, wp), mul(t, intFloat(4, wp), wp), wp)
+		return pi.SetPrec(prec)
+	})
+}
+
+// bigLn2 returns log(2) to prec bits, as 2*atanh(1/3) = 2 * sum 1/((2k+1) 3^(2k+1)).
+func bigLn2(prec uint) *big.Float {
+	return ln2Cache.get(prec, func(prec uint) *big.Float {
+		wp := prec + 32
+		x := quo(intFloat(1, wp), intFloat(3, wp), wp)
+		x2 := mul(x, x, wp)
+		term := newFloat(wp).Set(x)
+		sum := newFloat(wp).Set(x)
+		for k := int64(1); ; k++ {
+			term.Mul(term, x2)
+			t := quo(term, intFloat(2*k+1, wp), wp)
+			if negligible(t, wp) {
+				break
+			}
+			sum.Add(sum, t)
+		}
+		return sum.SetMantExp(sum, 1).SetPrec(prec)
+	})
+}
+
+// exp returns e^x for real x.
+func (m *bigMath) exp(x *big.Float, prec uint) *big.Float {
+	switch {
+	case x.Sign() == 0:
+		return intFloat(1, prec)
+	case x.IsInf():
+		if x.Sign() > 0 {
+			return newFloat(prec).SetInf(false)
+		}
+		return newFloat(prec)
+	}
+	// Exponents beyond this range overflow or underflow big.Float's exponent.
+	if xf, _ := x.Float64(); math.Abs(xf) > 1e9 {
+		if x.Sign() > 0 {
+			return newFloat(prec).SetInf(false)
+		}
+		return newFloat(prec)
+	}
+	// Write x = n*log(2) + r with |r| <= log(2)/2, so that e^x = 2^n * e^r. r is halved
+	// halvings more times to speed up the series, and the sum squared as often afterwards.
+	const halvings = 8
+	wp := prec + halvings + 64
+	ln2 := bigLn2(wp)
+	nf := quo(x, ln2, wp)
+	nInt, _ := nf.Int(nil)
+	frac := sub(nf, newFloat(wp).SetInt(nInt), wp)
+	if frac.Cmp(big.NewFloat(0.5)) > 0 {
+		nInt.Add(nInt, big.NewInt(1))
+	} else if frac.Cmp(big.NewFloat(-0.5)) < 0 {
+		nInt.Sub(nInt, big.NewInt(1))
+	}
+	r := sub(x, mul(newFloat(wp).SetInt(nInt), ln2, wp), wp)
+	r.SetMantExp(r, -halvings)
+
+	sum := intFloat(1, wp)
+	term := intFloat(1, wp)
+	for k := int64(1); m.spend(); k++ {
+		term = quo(mul(term, r, wp), intFloat(k, wp), wp)
+		if negligible(term, wp) {
+			break
+		}
+		sum.Add(sum, term)
+	}
+	for range halvings {
+		sum.Mul(sum, sum)
+	}
+	return sum.SetMantExp(sum, int(nInt.Int64())).SetPrec(prec)
+}
+
+// log returns the natural logarithm of a real x > 0.
+func (m *bigMath) log(x *big.Float, prec uint) *big.Float {
+	if x.Sign() <= 0 {
+		m.fail(errBigLogOfZero)
+		return newFloat(prec)
+	}
+	if x.IsInf() {
+		return newFloat(prec).SetInf(false)
+	}
+	// Write x = mant * 2^e with mant in [0.5, 1), so log(x) = log(mant) + e*log(2). Taking
+	// the 16th root of mant brings it close to 1, where the atanh series converges quickly:
+	// log(y) = 2*atanh((y-1)/(y+1)).
+	const roots = 4
+	wp := prec + roots + 64
+	mant := newFloat(wp)
+	e := x.MantExp(mant)
+	for range roots {
+		mant.Sqrt(mant)
+	}
+	one := intFloat(1, wp)
+	z := quo(sub(mant, one, wp), add(mant, one, wp), wp)
+	z2 := mul(z, z, wp)
+	sum := newFloat(wp).Set(z)
+	term := newFloat(wp).Set(z)
+	for k := int64(1); m.spend(); k++ {
+		term.Mul(term, z2)
+		t := quo(term, intFloat(2*k+1, wp), wp)
+		if negligible(t, wp) {
+			break
+		}
+		sum.Add(sum, t)
+	}
+	sum.SetMantExp(sum, roots+1)
+	sum.Add(sum, mul(intFloat(int64(e), wp), bigLn2(wp), wp))
+	return sum.SetPrec(prec)
+}
+
+// sinCos returns sin(x) and cos(x) for real x.
+func (m *bigMath) sinCos(x *big.Float, prec uint) (sin, cos *big.Float) {
+	if x.Sign() == 0 {
+		return newFloat(prec), intFloat(1, prec)
+	}
+	if x.IsInf() {
+		m.fail(errBigUndefined)
+		return newFloat(prec), newFloat(prec)
+	}
+	// Reducing x modulo π/2 needs as many extra bits as x has integer bits.
+	exponent := x.MantExp(nil)
+	if exponent > 1<<20 {
+		m.fail(errBigArgumentRange)
+		return newFloat(prec), newFloat(prec)
+	}
+	wp := prec + uint(max(exponent, 0)) + 64
+	halfPi := bigPi(wp)
+	halfPi = newFloat(wp).SetMantExp(halfPi, -1)
+	kf := quo(x, halfPi, wp)
+	k, _ := kf.Int(nil)
+	frac := sub(kf, newFloat(wp).SetInt(k), wp)
+	if frac.Cmp(big.NewFloat(0.5)) > 0 {
+		k.Add(k, big.NewInt(1))
+	} else if frac.Cmp(big.NewFloat(-0.5)) < 0 {
+		k.Sub(k, big.NewInt(1))
+	}
+	r := sub(x, mul(newFloat(wp).SetInt(k), halfPi, wp), wp)
+	if k.Sign() != 0 && r.Sign() != 0 && r.MantExp(nil) < exponent-int(x.Prec())+2 {
+		// x is a multiple of π/2 to within its own precision, like pi rounded to x's bits:
+		// what is left of it is rounding, so sin(pi) is 0 rather than pi - x.
+		r.SetInt64(0)
+	}
+	quadrant := new(big.Int).Mod(k, big.NewInt(4)).Int64()
+
+	r2 := mul(r, r, wp)
+	sin = newFloat(wp).Set(r)
+	cos = intFloat(1, wp)
+	sinTerm := newFloat(wp).Set(r)
+	cosTerm := intFloat(1, wp)
+	for n := int64(1); m.spend(); n++ {
+		cosTerm = neg(quo(mul(cosTerm, r2, wp), intFloat((2*n-1)*(2*n), wp), wp), wp)
+		sinTerm = neg(quo(mul(sinTerm, r2, wp), intFloat((2*n)*(2*n+1), wp), wp), wp)
+		if negligible(cosTerm, wp) && negligible(sinTerm, wp) {
+			break
+		}
+		cos.Add(cos, cosTerm)
+		sin.Add(sin, sinTerm)
+	}
+	switch quadrant {
+	case 1:
+		sin, cos = cos, neg(sin, wp)
+	case 2:
+		sin, cos = neg(sin, wp), neg(cos, wp)
+	case 3:
+		sin, cos = neg(cos, wp), sin
+	}
+	return sin.SetPrec(prec), cos.SetPrec(prec)
+}
+
+// sinhCosh returns sinh(x) and cosh(x) for real x.
+func (m *bigMath) sinhCosh(x *big.Float, prec uint) (sinh, cosh *big.Float) {
+	if x.Sign() == 0 {
+		return newFloat(prec), intFloat(1, prec)
+	}
+	// e^x - e^-x cancels for small x; carry as many extra bits as are lost.
+	wp := prec + uint(max(-x.MantExp(nil), 0)) + 16
+	ex := m.exp(x, wp)
+	inv := quo(intFloat(1, wp), ex, wp)
+	sinh = sub(ex, inv, wp)
+	cosh = add(ex, inv, wp)
+	sinh.SetMantExp(sinh, -1)
+	cosh.SetMantExp(cosh, -1)
+	return sinh.SetPrec(prec), cosh.SetPrec(prec)
+}
+
+// atan returns the arctangent of real x.
+func (m *bigMath) atan(x *big.Float, prec uint) *big.Float {
+	if x.Sign() == 0 {
+		return newFloat(prec)
+	}
+	wp := prec + 64
+	if x.IsInf() {
+		halfPi := newFloat(wp).SetMantExp(bigPi(wp), -1)
+		if x.Sign() < 0 {
+			halfPi.Neg(halfPi)
+		}
+		return halfPi.SetPrec(prec)
+	}
+	negative := x.Sign() < 0
+	y := newFloat(wp).Abs(x)
+	inverted := y.Cmp(intFloat(1, wp)) > 0
+	if inverted {
+		y = quo(intFloat(1, wp), y, wp)
+	}
+	// atan(y) = 2*atan(y / (1 + sqrt(1 + y^2))); two halvings leave |y| <= tan(π/16).
+	const halvings = 2
+	one := intFloat(1, wp)
+	for range halvings {
+		root := newFloat(wp).Sqrt(add(one, mul(y, y, wp), wp))
+		y = quo(y, add(one, root, wp), wp)
+	}
+	y2 := mul(y, y, wp)
+	sum := newFloat(wp).Set(y)
+	term := newFloat(wp).Set(y)
+	for k := int64(1); m.spend(); k++ {
+		term = neg(mul(term, y2, wp), wp)
+		t := quo(term, intFloat(2*k+1, wp), wp)
+		if negligible(t, wp) {
+			break
+		}
+		sum.Add(sum, t)
+	}
+	sum.SetMantExp(sum, halvings)
+	if inverted {
+		sum = sub(newFloat(wp).SetMantExp(bigPi(wp), -1), sum, wp)
+	}
+	if negative {
+		sum.Neg(sum)
+	}
+	return sum.SetPrec(prec)
+}
+
+// atan2 returns the angle of the point (x, y) in (-π, π], like math.Atan2.
+func (m *bigMath) atan2(y, x *big.Float, prec uint) *big.Float {
+	wp := prec + 16
+	switch {
+	case x.Sign() > 0:
+		return m.atan(quo(y, x, wp), prec)
+	case x.Sign() < 0:
+		angle := m.atan(quo(y, x, wp), wp)
+		if y.Signbit() {
+			return sub(angle, bigPi(wp), prec)
+		}
+		return add(angle, bigPi(wp), prec)
+	case y.Sign() > 0:
+		return newFloat(prec).SetMantExp(bigPi(prec), -1)
+	case y.Sign() < 0:
+		return neg(newFloat(prec).SetMantExp(bigPi(prec), -1), prec)
+	}
+	return newFloat(prec)
+}
+
+// Complex arithmetic.
+
+func newBigComplex(re, im *big.Float) bigComplex {
+	return bigComplex{re: re, im: im}
+}
+
+func bigReal(x *big.Float, prec uint) bigComplex {
+	return bigComplex{re: x, im: newFloat(prec)}
+}
+
+func (z bigComplex) isReal() bool { return z.im.Sign() == 0 }
+func (z bigComplex) isZero() bool { return z.re.Sign() == 0 && z.im.Sign() == 0 }
+func (z bigComplex) isInf() bool  { return z.re.IsInf() || z.im.IsInf() }
+
+// round returns z rounded to prec bits.
+func (z bigComplex) round(prec uint) bigComplex {
+	return bigComplex{re: newFloat(prec).Set(z.re), im: newFloat(prec).Set(z.im)}
+}
+
+func cadd(a, b bigComplex, prec uint) bigComplex {
+	return bigComplex{add(a.re, b.re, prec), add(a.im, b.im, prec)}
+}
+
+func csub(a, b bigComplex, prec uint) bigComplex {
+	return bigComplex{sub(a.re, b.re, prec), sub(a.im, b.im, prec)}
+}
+
+func cmul(a, b bigComplex, prec uint) bigComplex {
+	if a.isReal() && b.isReal() {
+		return bigReal(mul(a.re, b.re, prec), prec)
+	}
+	re := sub(mul(a.re, b.re, prec), mul(a.im, b.im, prec), prec)
+	im := add(mul(a.re, b.im, prec), mul(a.im, b.re, prec), prec)
+	return bigComplex{re, im}
+}
+
+// cquo returns a/b; dividing by zero records errDivisionByZero.
+func (m *bigMath) cquo(a, b bigComplex, prec uint) bigComplex {
+	if b.isZero() {
+		m.fail(errDivisionByZero)
+		return bigReal(newFloat(prec), prec)
+	}
+	if b.isReal() {
+		return bigComplex{quo(a.re, b.re, prec), quo(a.im, b.re, prec)}
+	}
+	denominator := add(mul(b.re, b.re, prec), mul(b.im, b.im, prec), prec)
+	re := add(mul(a.re, b.re, prec), mul(a.im, b.im, prec), prec)
+	im := sub(mul(a.im, b.re, prec), mul(a.re, b.im, prec), prec)
+	return bigComplex{quo(re, denominator, prec), quo(im, denominator, prec)}
+}
+
+func cneg(z bigComplex, prec uint) bigComplex {
+	return bigComplex{neg(z.re, prec), neg(z.im, prec)}
+}
+
+// cscale multiplies z by the real number x.
+func cscale(z bigComplex, x *big.Float, prec uint) bigComplex {
+	return bigComplex{mul(z.re, x, prec), mul(z.im, x, prec)}
+}
+
+func cabs(z bigComplex, prec uint) *big.Float {
+	if z.isReal() {
+		return newFloat(prec).Abs(z.re)
+	}
+	return newFloat(prec).Sqrt(add(mul(z.re, z.re, prec+8), mul(z.im, z.im, prec+8), prec+8))
+}
+
+// csqrt returns the principal square root. Like the unary minus of the default mode, a zero
+// imaginary part counts as +0, so sqrt(-4) is 2i.
+func csqrt(z bigComplex, prec uint) bigComplex {
+	if z.isZero() {
+		return bigReal(newFloat(prec), prec)
+	}
+	if z.isReal() {
+		if z.re.Sign() > 0 {
+			return bigReal(newFloat(prec).Sqrt(z.re), prec)
+		}
+		return bigComplex{newFloat(prec), newFloat(prec).Sqrt(neg(z.re, prec))}
+	}
+	wp := prec + 16
+	// t = sqrt((|z| + |re|) / 2) avoids cancellation; the other part is im / (2t).
+	t := add(cabs(z, wp), newFloat(wp).Abs(z.re), wp)
+	t.SetMantExp(t, -1)
+	t.Sqrt(t)
+	other := quo(z.im, t, wp)
+	other.SetMantExp(other, -1)
+	if z.re.Sign() >= 0 {
+		return bigComplex{t.SetPrec(prec), other.SetPrec(prec)}
+	}
+	other.Abs(other)
+	if z.im.Sign() < 0 {
+		t.Neg(t)
+	}
+	return bigComplex{other.SetPrec(prec), t.SetPrec(prec)}
+}
+
+func (m *bigMath) cexp(z bigComplex, prec uint) bigComplex {
+	if z.isReal() {
+		return bigReal(m.exp(z.re, prec), prec)
+	}
+	wp := prec + 16
+	magnitude := m.exp(z.re, wp)
+	sin, cos := m.sinCos(z.im, wp)
+	return bigComplex{mul(magnitude, cos, prec), mul(magnitude, sin, prec)}
+}
+
+// clog returns the principal logarithm; its imaginary part is in (-π, π].
+func (m *bigMath) clog(z bigComplex, prec uint) bigComplex {
+	if z.isZero() {
+		m.fail(errBigLogOfZero)
+		return bigReal(newFloat(prec), prec)
+	}
+	if z.isReal() && z.re.Sign() > 0 {
+		return bigReal(m.log(z.re, prec), prec)
+	}
+	wp := prec + 16
+	squared := add(mul(z.re, z.re, wp), mul(z.im, z.im, wp), wp)
+	re := m.log(squared, wp)
+	re.SetMantExp(re, -1)
+	im := z.im
+	if z.isReal() {
+		im = newFloat(wp) // log(-x) has the imaginary part +π
+	}
+	return bigComplex{re.SetPrec(prec), m.atan2(im, z.re, prec)}
+}
+
+// cpow returns the principal value of z^w. Integer powers are computed by repeated squaring.
+func (m *bigMath) cpow(z, w bigComplex, prec uint) bigComplex {
+	wp := prec + 32
+	if w.isReal() && w.re.IsInt() && !w.re.IsInf() {
+		if n, accuracy := w.re.Int64(); accuracy == big.Exact && n > -(1<<20) && n < 1<<20 {
+			return m.cpowInt(z, n, prec)
+		}
+	}
+	if z.isZero() {
+		if w.re.Sign() > 0 {
+			return bigReal(newFloat(prec), prec)
+		}
+		m.fail(errDivisionByZero)
+		return bigReal(newFloat(prec), prec)
+	}
+	return m.cexp(cmul(w, m.clog(z, wp), wp), prec)
+}
+
+func (m *bigMath) cpowInt(z bigComplex, n int64, prec uint) bigComplex {
+	wp := prec + 64
+	result := bigReal(intFloat(1, wp), wp)
+	base := z.round(wp)
+	for e := max(n, -n); e > 0 && m.spend(); e >>= 1 {
+		if e&1 == 1 {
+			result = cmul(result, base, wp)
+		}
+		base = cmul(base, base, wp)
+	}
+	if n < 0 {
+		result = m.cquo(bigReal(intFloat(1, wp), wp), result, wp)
+	}
+	return result.round(prec)
+}
+
+func (m *bigMath) csin(z bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	sin, cos := m.sinCos(z.re, wp)
+	if z.isReal() {
+		return bigReal(sin.SetPrec(prec), prec)
+	}
+	sinh, cosh := m.sinhCosh(z.im, wp)
+	return bigComplex{mul(sin, cosh, prec), mul(cos, sinh, prec)}
+}
+
+func (m *bigMath) ccos(z bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	sin, cos := m.sinCos(z.re, wp)
+	if z.isReal() {
+		return bigReal(cos.SetPrec(prec), prec)
+	}
+	sinh, cosh := m.sinhCosh(z.im, wp)
+	return bigComplex{mul(cos, cosh, prec), neg(mul(sin, sinh, prec), prec)}
+}
+
+func (m *bigMath) ctan(z bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	return m.cquo(m.csin(z, wp), m.ccos(z, wp), prec)
+}
+
+func (m *bigMath) csinh(z bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	sinh, cosh := m.sinhCosh(z.re, wp)
+	if z.isReal() {
+		return bigReal(sinh.SetPrec(prec), prec)
+	}
+	sin, cos := m.sinCos(z.im, wp)
+	return bigComplex{mul(sinh, cos, prec), mul(cosh, sin, prec)}
+}
+
+func (m *bigMath) ccosh(z bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	sinh, cosh := m.sinhCosh(z.re, wp)
+	if z.isReal() {
+		return bigReal(cosh.SetPrec(prec), prec)
+	}
+	sin, cos := m.sinCos(z.im, wp)
+	return bigComplex{mul(cosh, cos, prec), mul(sinh, sin, prec)}
+}
+
+func (m *bigMath) ctanh(z bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	return m.cquo(m.csinh(z, wp), m.ccosh(z, wp), prec)
+}
+
+// bigI returns the imaginary unit.
+func bigI(prec uint) bigComplex {
+	return bigComplex{newFloat(prec), intFloat(1, prec)}
+}
+
+func bigOne(prec uint) bigComplex {
+	return bigReal(intFloat(1, prec), prec)
+}
+
+// realInUnitInterval reports whether z is real with |z| <= 1.
+func realInUnitInterval(z bigComplex) bool {
+	return z.isReal() && z.re.Cmp(big.NewFloat(1)) <= 0 && z.re.Cmp(big.NewFloat(-1)) >= 0
+}
+
+// casin returns the principal arcsine, -i*log(i*z + sqrt(1 - z^2)).
+func (m *bigMath) casin(z bigComplex, prec uint) bigComplex {
+	wp := prec + 32
+	if realInUnitInterval(z) {
+		root := newFloat(wp).Sqrt(sub(intFloat(1, wp), mul(z.re, z.re, wp), wp))
+		return bigReal(m.atan2(z.re, root, prec), prec)
+	}
+	if z.isReal() {
+		// On the branch cuts, take the value from above the real axis like cmplx.Asin:
+		// ±π/2 + i*acosh(|x|).
+		abs := newFloat(wp).Abs(z.re)
+		acosh := m.log(add(abs, newFloat(wp).Sqrt(sub(mul(abs, abs, wp), intFloat(1, wp), wp)), wp), prec)
+		halfPi := newFloat(prec).SetMantExp(bigPi(prec), -1)
+		if z.re.Sign() < 0 {
+			halfPi.Neg(halfPi)
+		}
+		return bigComplex{halfPi, acosh}
+	}
+	i := bigI(wp)
+	inner := cadd(cmul(i, z, wp), csqrt(csub(bigOne(wp), cmul(z, z, wp), wp), wp), wp)
+	return cmul(cneg(i, wp), m.clog(inner, wp), wp).round(prec)
+}
+
+// cacos returns the principal arccosine, π/2 - asin(z).
+func (m *bigMath) cacos(z bigComplex, prec uint) bigComplex {
+	wp := prec + 32
+	if realInUnitInterval(z) {
+		root := newFloat(wp).Sqrt(sub(intFloat(1, wp), mul(z.re, z.re, wp), wp))
+		return bigReal(m.atan2(root, z.re, prec), prec)
+	}
+	halfPi := bigReal(newFloat(wp).SetMantExp(bigPi(wp), -1), wp)
+	return csub(halfPi, m.casin(z, wp), prec)
+}
+
+// catan returns the principal arctangent, (i/2) * (log(1 - i*z) - log(1 + i*z)).
+func (m *bigMath) catan(z bigComplex, prec uint) bigComplex {
+	if z.isReal() {
+		return bigReal(m.atan(z.re, prec), prec)
+	}
+	wp := prec + 32
+	iz := cmul(bigI(wp), z, wp)
+	difference := csub(m.clog(csub(bigOne(wp), iz, wp), wp), m.clog(cadd(bigOne(wp), iz, wp), wp), wp)
+	result := cmul(bigI(wp), difference, wp)
+	return cscale(result, newFloat(wp).SetFloat64(0.5), prec)
+}
+
+// casinh returns the principal inverse hyperbolic sine, log(z + sqrt(z^2 + 1)).
+func (m *bigMath) casinh(z bigComplex, prec uint) bigComplex {
+	wp := prec + 32
+	if z.isReal() && z.re.Sign() < 0 {
+		return cneg(m.casinh(cneg(z, wp), wp), prec) // Avoids cancellation for negative x
+	}
+	return m.clog(cadd(z, csqrt(cadd(cmul(z, z, wp), bigOne(wp), wp), wp), wp), prec)
+}
+
+// cacosh returns the principal inverse hyperbolic cosine, log(z + sqrt(z + 1)*sqrt(z - 1)).
+func (m *bigMath) cacosh(z bigComplex, prec uint) bigComplex {
+	wp := prec + 32
+	roots := cmul(csqrt(cadd(z, bigOne(wp), wp), wp), csqrt(csub(z, bigOne(wp), wp), wp), wp)
+	return m.clog(cadd(z, roots, wp), prec)
+}
+
+// catanh returns the principal inverse hyperbolic tangent, (log(1 + z) - log(1 - z)) / 2.
+func (m *bigMath) catanh(z bigComplex, prec uint) bigComplex {
+	wp := prec + 32
+	if z.isReal() && !realInUnitInterval(z) {
+		// On the branch cuts, take the value from above the real axis like cmplx.Atanh:
+		// atanh(1/x) + i*π/2.
+		inverse := bigReal(quo(intFloat(1, wp), z.re, wp), wp)
+		return bigComplex{m.catanh(inverse, prec).re, newFloat(prec).SetMantExp(bigPi(prec), -1)}
+	}
+	difference := csub(m.clog(cadd(bigOne(wp), z, wp), wp), m.clog(csub(bigOne(wp), z, wp), wp), wp)
+	return cscale(difference, newFloat(wp).SetFloat64(0.5), prec)
+}
+
+// roundHalfAway rounds x to the nearest integer, halfway cases away from zero like math.Round.
+func roundHalfAway(x *big.Float, prec uint) *big.Float {
+	half := newFloat(prec + 1).SetFloat64(0.5)
+	if x.Sign() < 0 {
+		half.Neg(half)
+	}
+	return truncate(add(x, half, x.Prec()+1), prec)
+}
+
+func truncate(x *big.Float, prec uint) *big.Float {
+	if x.IsInf() || x.IsInt() {
+		return newFloat(prec).Set(x)
+	}
+	n, _ := x.Int(nil)
+	return newFloat(prec).SetInt(n)
+}
+
+func floor(x *big.Float, prec uint) *big.Float {
+	t := truncate(x, prec)
+	if x.Sign() < 0 && t.Cmp(x) != 0 {
+		t.Sub(t, intFloat(1, prec))
+	}
+	return t
+}
+
+func ceil(x *big.Float, prec uint) *big.Float {
+	t := truncate(x, prec)
+	if x.Sign() > 0 && t.Cmp(x) != 0 {
+		t.Add(t, intFloat(1, prec))
+	}
+	return t
+}
@@ -0,0 +1,821 @@
+// builtins.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// Categories used to group the built-in functions in 'help functions'.
+const (
+	CategoryCore              = "Core"
+	CategoryLogExp            = "Log/Exp"
+	CategoryPowerRoot         = "Power/Root"
+	CategoryTrig              = "Trigonometric"
+	CategoryInverseTrig       = "Inverse Trig"
+	CategoryHyperbolic        = "Hyperbolic"
+	CategoryInverseHyperbolic = "Inverse Hyperbolic"
+	CategoryAngle             = "Angle Conversion"
+	CategoryRounding          = "Rounding/Truncation"
+	CategorySelection         = "Selection"
+	CategoryGamma             = "Gamma"
+	CategorySpecial           = "Special Functions"
+	CategoryBessel            = "Bessel/Airy"
+	CategoryNumberTheory      = "Number Theory"
+	CategoryVector            = "Vectors"
+)
+
+// categoryOrder is the order in which 'help functions' lists the built-in categories.
+// Categories introduced by RegisterFunction are listed after these, alphabetically.
+var categoryOrder = []string{
+	CategoryCore, CategoryLogExp, CategoryPowerRoot, CategoryTrig, CategoryInverseTrig,
+	CategoryHyperbolic, CategoryInverseHyperbolic, CategoryAngle, CategoryRounding, CategorySelection,
+	CategoryGamma, CategorySpecial, CategoryBessel, CategoryNumberTheory, CategoryVector,
+}
+
+// builtinConstants are registered in every new Registry.
+var builtinConstants = []ConstantDef{
+	{
+		Name:  "i",
+		Value: complex(0, 1),
+		exact: true,
+		Help: "Constant: i\n" +
+			"  The imaginary unit, evaluated as complex(0, 1).\n" +
+			"  Must be used with multiplication operator if scaling, e.g., '5*i'.\n" +
+			"    Example: i*i              (Result: -1)\n" +
+			"    Example: 2+3*i            (Result: 2+3i)\n" +
+			"    Example: exp(i*" + fmt.Sprintf("%g", math.Pi/2) + ")    (Result: i)",
+	},
+	{
+		Name:     "pi",
+		Value:    complex(math.Pi, 0),
+		bigValue: bigPi,
+		Help: "Constant: pi\n" +
+			"  Represents the mathematical constant π (Pi), the ratio of a circle's circumference to its diameter.\n" +
+			"  Value: " + fmt.Sprintf("%.10f...", math.Pi) + "\n" + // Show some precision
+			"    Example: sin(pi/2)    (Result: 1)\n" +
+			"    Example: 2*pi         (Result: " + fmt.Sprintf("%g", 2*math.Pi) + ")",
+	},
+	{
+		Name:     "e",
+		Value:    complex(math.E, 0),
+		bigValue: bigE,
+		Help: "Constant: e\n" +
+			"  Represents Euler's number, the base of the natural logarithm.\n" +
+			"  Value: " + fmt.Sprintf("%.10f...", math.E) + "\n" +
+			"    Example: log(e)         (Result: 1)\n" +
+			"    Example: e^2            (Result: " + fmt.Sprintf("%g", math.E*math.E) + ")",
+	},
+	{
+		Name:    "true",
+		Value:   1,
+		exact:   true,
+		boolean: true,
+		Help: "Constant: true\n" +
+			"  The result of a comparison that holds. It counts as 1 in arithmetic.\n" +
+			"    Example: isprime(7) == true   (Result: true)\n" +
+			"    Example: true + 1             (Result: 2)",
+	},
+	{
+		Name:    "false",
+		Value:   0,
+		exact:   true,
+		boolean: true,
+		Help: "Constant: false\n" +
+			"  The result of a comparison that fails. It counts as 0 in arithmetic.\n" +
+			"    Example: 1 > 2 or false      (Result: false)",
+	},
+}
+
+// builtinFunctions are registered in every new Registry.
+var builtinFunctions = []FunctionDef{
+	{
+		Name: "real", Signature: "real(x)", Arity: 1, Category: CategoryCore,
+		Impl:      unary(func(x complex128) complex128 { return complex(real(x), 0) }),
+		bigImpl:   bigPure(func(z bigComplex, prec uint) bigComplex { return bigReal(z.re, prec) }),
+		exactImpl: exactUnary(func(x gaussianRational) (gaussianRational, bool) { return gaussianRational{x.re, new(big.Rat)}, true }),
+		Help: "Function: real(x)\n" +
+			"  Returns the real part of the complex number x, as a complex number with a zero imaginary part.\n" +
+			"    Example: real(3+4*i)    (Result: 3)\n" +
+			"    Example: real(5)        (Result: 5)\n" +
+			"    Example: real(2*i)      (Result: 0)",
+	},
+	{
+		Name: "imag", Signature: "imag(x)", Arity: 1, Category: CategoryCore,
+		Impl:      unary(func(x complex128) complex128 { return complex(imag(x), 0) }),
+		bigImpl:   bigPure(func(z bigComplex, prec uint) bigComplex { return bigReal(z.im, prec) }),
+		exactImpl: exactUnary(func(x gaussianRational) (gaussianRational, bool) { return gaussianRational{x.im, new(big.Rat)}, true }),
+		Help: "Function: imag(x)\n" +
+			"  Returns the imaginary part of the complex number x, as a complex number with a zero imaginary part.\n" +
+			"  Note: This returns the coefficient of 'i'. For the complex number 'i' itself, use the constant 'i'.\n" +
+			"    Example: imag(3+4*i)    (Result: 4)\n" +
+			"    Example: imag(5)        (Result: 0)\n" +
+			"    Example: imag(2*i)      (Result: 2)",
+	},
+	{
+		Name: "abs", Signature: "abs(x)", Arity: 1, Category: CategoryCore,
+		Impl:      unary(func(x complex128) complex128 { return complex(cmplx.Abs(x), 0) }),
+		bigImpl:   bigPure(func(z bigComplex, prec uint) bigComplex { return bigReal(cabs(z, prec), prec) }),
+		exactImpl: exactUnary(exactAbs),
+		Help: "Function: abs(x)\n" +
+			"  Calculates the absolute value (or modulus/magnitude) of the complex number x.\n" +
+			"  This is a non-negative real number, returned as complex(abs_value, 0).\n" +
+			"    Example: abs(3+4*i)    (Result: 5)\n" +
+			"    Example: abs(-5)       (Result: 5)\n" +
+			"    Example: abs(i)        (Result: 1)",
+	},
+	{
+		Name: "phase", Signature: "phase(x)", Arity: 1, Category: CategoryCore, Angles: AngleResult,
+		Impl:    unary(func(x complex128) complex128 { return complex(cmplx.Phase(x), 0) }),
+		bigImpl: bigUnary(func(m *bigMath, z bigComplex, prec uint) bigComplex { return bigReal(m.atan2(z.im, z.re, prec), prec) }),
+		Help: "Function: phase(x)\n" +
+			"  Calculates the argument (or phase/angle) of the complex number x.\n" +
+			"  The result is in radians, in the interval (-π, π].\n" +
+			"  Returned as complex(angle_value, 0).\n" +
+			"    Example: phase(1+i)    (Result: " + fmt.Sprintf("%g", math.Pi/4) + ")\n" +
+			"    Example: phase(-1)     (Result: " + fmt.Sprintf("%g", math.Pi) + ")\n" +
+			"    Example: phase(i)      (Result: " + fmt.Sprintf("%g", math.Pi/2) + ")\n" +
+			"    Example: phase(0)      (Result: 0)",
+	},
+	{
+		Name: "conj", Signature: "conj(x)", Arity: 1, Category: CategoryCore,
+		Impl:    unary(cmplx.Conj),
+		bigImpl: bigPure(func(z bigComplex, prec uint) bigComplex { return bigComplex{z.re, neg(z.im, prec)} }),
+		exactImpl: exactUnary(func(x gaussianRational) (gaussianRational, bool) {
+			return gaussianRational{x.re, new(big.Rat).Neg(x.im)}, true
+		}),
+		Help: "Function: conj(x)\n" +
+			"  Calculates the complex conjugate of x.\n" +
+			"  If x = a+bi, conj(x) = a-bi.\n" +
+			"    Example: conj(3+4*i)    (Result: 3-4i)\n" +
+			"    Example: conj(5)        (Result: 5)\n" +
+			"    Example: conj(2*i)      (Result: -2i)",
+	},
+	{
+		Name: "polar", Signature: "polar(r, theta)", Arity: 2, Category: CategoryCore, Angles: AngleArgument,
+		Impl:    binary(func(r, theta complex128) complex128 { return r * cmplx.Exp(complex(0, 1)*theta) }),
+		bigImpl: bigBinary((*bigMath).cpolar),
+		Help: "Function: polar(r, theta)\n" +
+			"  Builds the complex number with magnitude r and angle theta (radians): r*exp(i*theta).\n" +
+			"    Example: polar(2, pi/2)   (Result: 2i)\n" +
+			"    Example: polar(1, pi)     (Result: -1)",
+	},
+	{
+		Name: "exp", Signature: "exp(x)", Arity: 1, Category: CategoryLogExp,
+		Impl:    unary(cmplx.Exp),
+		bigImpl: bigUnary((*bigMath).cexp),
+		Help: "Function: exp(x)\n" +
+			"  Calculates the exponential function e^x, where e is Euler's number, for the complex number x.\n" +
+			"    Example: exp(0)             (Result: 1)\n" +
+			"    Example: exp(1)             (Result: " + fmt.Sprintf("%g", math.E) + ")\n" +
+			"    Example: exp(log(5))        (Result: 5)\n" +
+			"    Example: exp(i * " + fmt.Sprintf("%g", math.Pi) + ") (Result: -1, Euler's Identity)",
+	},
+	{
+		Name: "log", Signature: "log(x)", Arity: 1, Category: CategoryLogExp,
+		Impl:    unary(cmplx.Log),
+		bigImpl: bigUnary((*bigMath).clog),
+		Help: "Function: log(x)\n" +
+			"  Calculates the natural logarithm (base e) of the complex number x.\n" +
+			"  Returns the principal value. The imaginary part of the result is in (-π, π].\n" +
+			"    Example: log(exp(2))      (Result: 2)\n" +
+			"    Example: log(-1)           (Result: " + fmt.Sprintf("%gi", math.Pi) + ")\n" + // Corrected output
+			"    Example: log(i)            (Result: " + fmt.Sprintf("%gi", math.Pi/2) + ")\n" + // Corrected output
+			"  log(0) results in " + fmt.Sprintf("%v", cmplx.Log(0)) + ".", // Show actual Inf/NaN output
+	},
+	{
+		Name: "log10", Signature: "log10(x)", Arity: 1, Category: CategoryLogExp,
+		Impl: unary(cmplx.Log10),
+		bigImpl: bigUnary(func(m *bigMath, z bigComplex, prec uint) bigComplex {
+			return m.clogBase(z, bigReal(intFloat(10, prec), prec), prec)
+		}),
+		Help: "Function: log10(x)\n" +
+			"  Calculates the base-10 logarithm of the complex number x.\n" +
+			"  Returns the principal value.\n" +
+			"    Example: log10(100)     (Result: 2)\n" +
+			"    Example: log10(1)       (Result: 0)",
+	},
+	{
+		Name: "log2", Signature: "log2(x)", Arity: 1, Category: CategoryLogExp,
+		Impl: unary(func(x complex128) complex128 { return cmplx.Log(x) / cmplx.Log(complex(2, 0)) }),
+		bigImpl: bigUnary(func(m *bigMath, z bigComplex, prec uint) bigComplex {
+			return m.clogBase(z, bigReal(intFloat(2, prec), prec), prec)
+		}),
+		Help: "Function: log2(x)\n" +
+			"  Calculates the base-2 logarithm of the complex number x.\n" +
+			"  Returns the principal value.\n" +
+			"    Example: log2(8)        (Result: 3)\n" +
+			"    Example: log2(1)        (Result: 0)",
+	},
+	{
+		Name: "logb", Signature: "logb(x, base)", Arity: 2, Category: CategoryLogExp,
+		Impl:    binary(func(x, base complex128) complex128 { return cmplx.Log(x) / cmplx.Log(base) }),
+		bigImpl: bigBinary((*bigMath).clogBase),
+		Help: "Function: logb(x, base)\n" +
+			"  Calculates the logarithm of x in the given base, log(x)/log(base) (principal values).\n" +
+			"    Example: logb(81, 3)      (Result: 4)\n" +
+			"    Example: logb(8, 2)       (Result: 3)",
+	},
+	{
+		Name: "sqrt", Signature: "sqrt(x)", Arity: 1, Category: CategoryPowerRoot,
+		Impl:      unary(cmplx.Sqrt),
+		bigImpl:   bigPure(csqrt),
+		exactImpl: exactUnary(exactSqrt),
+		Help: "Function: sqrt(x)\n" +
+			"  Calculates the principal value of the square root of the complex number x.\n" +
+			"  Equivalent to x^0.5.\n" +
+			"    Example: sqrt(4)        (Result: 2)\n" +
+			"    Example: sqrt(-1)       (Result: i)\n" + // Output format will show 'i'
+			"    Example: sqrt(2i)       (Result: 1+1i)", // sqrt(2i) = 1+i
+	},
+	{
+		Name: "root", Signature: "root(x, n)", Arity: 2, Category: CategoryPowerRoot,
+		Impl:    binary(nthRoot),
+		bigImpl: bigBinary((*bigMath).cnthRoot),
+		Help: "Function: root(x, n)\n" +
+			"  Calculates the n-th root of x.\n" +
+			"  If x is a negative real number and n an odd integer, the real root is returned;\n" +
+			"  otherwise the result is the principal value x^(1/n).\n" +
+			"    Example: root(27, 3)      (Result: 3)\n" +
+			"    Example: root(-8, 3)      (Result: -2)\n" +
+			"    Example: root(-4, 2)      (Result: 2i)",
+	},
+	{
+		Name: "hypot", Signature: "hypot(a, b)", Arity: 2, Category: CategoryPowerRoot,
+		Impl:    binary(complexHypot),
+		bigImpl: bigBinary(func(_ *bigMath, a, b bigComplex, prec uint) bigComplex { return chypot(a, b, prec) }),
+		Help: "Function: hypot(a, b)\n" +
+			"  Calculates sqrt(a^2 + b^2) without overflowing for large real arguments.\n" +
+			"    Example: hypot(3, 4)      (Result: 5)",
+	},
+	{
+		Name: "sin", Signature: "sin(x)", Arity: 1, Category: CategoryTrig, Angles: AngleArgument,
+		Impl:    unary(cmplx.Sin),
+		bigImpl: bigUnary((*bigMath).csin),
+		Help: "Function: sin(x)\n" +
+			"  Calculates the trigonometric sine of the complex number x.\n" +
+			"  x is assumed to be in radians.\n" +
+			"    Example: sin(0)         (Result: 0)\n" +
+			"    Example: sin(" + fmt.Sprintf("%g", math.Pi/2) + ") (Result: 1)\n" +
+			"    Example: sin(i)         (Result: " + fmt.Sprintf("%gi", math.Sinh(1)) + ") (since sin(ix) = i*sinh(x))",
+	},
+	{
+		Name: "cos", Signature: "cos(x)", Arity: 1, Category: CategoryTrig, Angles: AngleArgument,
+		Impl:    unary(cmplx.Cos),
+		bigImpl: bigUnary((*bigMath).ccos),
+		Help: "Function: cos(x)\n" +
+			"  Calculates the trigonometric cosine of the complex number x.\n" +
+			"  x is assumed to be in radians.\n" +
+			"    Example: cos(0)         (Result: 1)\n" +
+			"    Example: cos(" + fmt.Sprintf("%g", math.Pi) + ")   (Result: -1)\n" +
+			"    Example: cos(i)         (Result: " + fmt.Sprintf("%g", math.Cosh(1)) + ") (since cos(ix) = cosh(x))",
+	},
+	{
+		Name: "tan", Signature: "tan(x)", Arity: 1, Category: CategoryTrig, Angles: AngleArgument,
+		Impl:    unary(cmplx.Tan),
+		bigImpl: bigUnary((*bigMath).ctan),
+		Help: "Function: tan(x)\n" +
+			"  Calculates the trigonometric tangent of the complex number x (sin(x)/cos(x)).\n" +
+			"  x is assumed to be in radians.\n" +
+			"  Result may be Inf or NaN if cos(x) is zero (e.g., at pi/2, 3pi/2).\n" +
+			"    Example: tan(0)         (Result: 0)\n" +
+			"    Example: tan(" + fmt.Sprintf("%g", math.Pi/4) + ") (Result: 1)",
+	},
+	{
+		Name: "asin", Signature: "asin(x)", Arity: 1, Category: CategoryInverseTrig, Angles: AngleResult,
+		Impl:    unary(cmplx.Asin),
+		bigImpl: bigUnary((*bigMath).casin),
+		Help: "Function: asin(x)\n" +
+			"  Calculates the principal value of the inverse trigonometric sine (arcsine) of x.\n" +
+			"    Example: asin(0)        (Result: 0)\n" +
+			"    Example: asin(1)        (Result: " + fmt.Sprintf("%g", math.Pi/2) + ")",
+	},
+	{
+		Name: "acos", Signature: "acos(x)", Arity: 1, Category: CategoryInverseTrig, Angles: AngleResult,
+		Impl:    unary(cmplx.Acos),
+		bigImpl: bigUnary((*bigMath).cacos),
+		Help: "Function: acos(x)\n" +
+			"  Calculates the principal value of the inverse trigonometric cosine (arccosine) of x.\n" +
+			"    Example: acos(1)        (Result: 0)\n" +
+			"    Example: acos(0)        (Result: " + fmt.Sprintf("%g", math.Pi/2) + ")",
+	},
+	{
+		Name: "atan", Signature: "atan(x)", Arity: 1, Category: CategoryInverseTrig, Angles: AngleResult,
+		Impl:    unary(cmplx.Atan),
+		bigImpl: bigUnary((*bigMath).catan),
+		Help: "Function: atan(x)\n" +
+			"  Calculates the principal value of the inverse trigonometric tangent (arctangent) of x.\n" +
+			"    Example: atan(0)        (Result: 0)\n" +
+			"    Example: atan(1)        (Result: " + fmt.Sprintf("%g", math.Pi/4) + ")",
+	},
+	{
+		Name: "atan2", Signature: "atan2(y, x)", Arity: 2, Category: CategoryInverseTrig, Angles: AngleResult,
+		Impl:    binary(complexAtan2),
+		bigImpl: bigBinary((*bigMath).catan2),
+		Help: "Function: atan2(y, x)\n" +
+			"  Calculates the angle of the point (x, y) in radians, in the interval (-π, π].\n" +
+			"  Unlike atan(y/x), the signs of both arguments select the correct quadrant.\n" +
+			"  For complex arguments the result is -i*log((x + i*y) / sqrt(x^2 + y^2)).\n" +
+			"    Example: atan2(1, 1)      (Result: " + fmt.Sprintf("%g", math.Pi/4) + ")\n" +
+			"    Example: atan2(1, -1)     (Result: " + fmt.Sprintf("%g", 3*math.Pi/4) + ")",
+	},
+	{
+		Name: "sinh", Signature: "sinh(x)", Arity: 1, Category: CategoryHyperbolic,
+		Impl:    unary(cmplx.Sinh),
+		bigImpl: bigUnary((*bigMath).csinh),
+		Help: "Function: sinh(x)\n" +
+			"  Calculates the hyperbolic sine of the complex number x.\n" +
+			"    Example: sinh(0)        (Result: 0)\n" +
+			"    Example: sin(i*(" + fmt.Sprintf("%g", math.Pi/2) + ")) (Result: i)", // sin(i*x) = i*sinh(x) so sinh(x) = -i*sin(ix)
+	},
+	{
+		Name: "cosh", Signature: "cosh(x)", Arity: 1, Category: CategoryHyperbolic,
+		Impl:    unary(cmplx.Cosh),
+		bigImpl: bigUnary((*bigMath).ccosh),
+		Help: "Function: cosh(x)\n" +
+			"  Calculates the hyperbolic cosine of the complex number x.\n" +
+			"    Example: cosh(0)        (Result: 1)\n" +
+			"    Example: cos(i)         (Result: " + fmt.Sprintf("%g", math.Cosh(1)) + ")", // cos(ix) = cosh(x)
+	},
+	{
+		Name: "tanh", Signature: "tanh(x)", Arity: 1, Category: CategoryHyperbolic,
+		Impl:    unary(cmplx.Tanh),
+		bigImpl: bigUnary((*bigMath).ctanh),
+		Help: "Function: tanh(x)\n" +
+			"  Calculates the hyperbolic tangent of the complex number x (sinh(x)/cosh(x)).\n" +
+			"    Example: tanh(0)        (Result: 0)",
+	},
+	{
+		Name: "asinh", Signature: "asinh(x)", Arity: 1, Category: CategoryInverseHyperbolic,
+		Impl:    unary(cmplx.Asinh),
+		bigImpl: bigUnary((*bigMath).casinh),
+		Help:    "Function: asinh(x)\n  Calculates the principal value of the inverse hyperbolic sine of x.\n    Example: asinh(0) (Result: 0)",
+	},
+	{
+		Name: "acosh", Signature: "acosh(x)", Arity: 1, Category: CategoryInverseHyperbolic,
+		Impl:    unary(cmplx.Acosh),
+		bigImpl: bigUnary((*bigMath).cacosh),
+		Help:    "Function: acosh(x)\n  Calculates the principal value of the inverse hyperbolic cosine of x.\n    Example: acosh(1) (Result: 0)",
+	},
+	{
+		Name: "atanh", Signature: "atanh(x)", Arity: 1, Category: CategoryInverseHyperbolic,
+		Impl:    unary(cmplx.Atanh),
+		bigImpl: bigUnary((*bigMath).catanh),
+		Help:    "Function: atanh(x)\n  Calculates the principal value of the inverse hyperbolic tangent of x.\n    Example: atanh(0) (Result: 0)",
+	},
+	{
+		Name: "degtorad", Signature: "degToRad(x)", Arity: 1, Category: CategoryAngle,
+		Impl:    unary(func(x complex128) complex128 { return x * complex(math.Pi/180.0, 0.0) }),
+		bigImpl: bigScale(radiansPerDegree),
+		Help: "Function: degToRad(x)\n" +
+			"  Converts the complex number x from degrees to radians.\n" +
+			"  The entire complex number (both real and imaginary parts) is scaled by π/180.\n" +
+			"    Example: degToRad(180)          (Result: " + fmt.Sprintf("%g", math.Pi) + ")\n" +
+			"    Example: degToRad(90+180*i)  (Result: " + fmt.Sprintf("%g+%gi", math.Pi/2, math.Pi) + ")",
+	},
+	{
+		Name: "radtodeg", Signature: "radToDeg(x)", Arity: 1, Category: CategoryAngle,
+		Impl:    unary(func(x complex128) complex128 { return x * complex(180.0/math.Pi, 0.0) }),
+		bigImpl: bigScale(degreesPerRadian),
+		Help: "Function: radToDeg(x)\n" +
+			"  Converts the complex number x from radians to degrees.\n" +
+			"  The entire complex number (both real and imaginary parts) is scaled by 180/π.\n" +
+			"    Example: radToDeg(pi)           (Result: 180)\n" +
+			"    Example: radToDeg(pi/2 + i)     (Result: " + fmt.Sprintf("%g+%gi", 90.0, 180.0/math.Pi) + ")",
+	},
+	{
+		Name: "floor", Signature: "floor(x)", Arity: 1, Category: CategoryRounding,
+		Impl:      componentwise(math.Floor),
+		bigImpl:   bigComponentwise(floor),
+		exactImpl: exactComponentwise(ratFloor),
+		Help: "Function: floor(x)\n" +
+			"  Computes the floor of the complex number x component-wise.\n" +
+			"  Result: complex(math.Floor(real(x)), math.Floor(imag(x)))\n" +
+			"    Example: floor(3.7+2.3*i)   (Result: 3+2i)\n" +
+			"    Example: floor(-3.7-2.3*i)  (Result: -4-3i)",
+	},
+	{
+		Name: "ceil", Signature: "ceil(x)", Arity: 1, Category: CategoryRounding,
+		Impl:      componentwise(math.Ceil),
+		bigImpl:   bigComponentwise(ceil),
+		exactImpl: exactComponentwise(ratCeil),
+		Help: "Function: ceil(x)\n" +
+			"  Computes the ceiling of the complex number x component-wise.\n" +
+			"  Result: complex(math.Ceil(real(x)), math.Ceil(imag(x)))\n" +
+			"    Example: ceil(3.2+2.8*i)    (Result: 4+3i)\n" +
+			"    Example: ceil(-3.2-2.8*i)   (Result: -3-2i)",
+	},
+	{
+		Name: "round", Signature: "round(x)", Arity: 1, Category: CategoryRounding,
+		Impl:      componentwise(math.Round),
+		bigImpl:   bigComponentwise(roundHalfAway),
+		exactImpl: exactComponentwise(ratRound),
+		Help: "Function: round(x)\n" +
+			"  Rounds the complex number x to the nearest integer component-wise.\n" +
+			"  Uses Go's math.Round (rounds half to even).\n" +
+			"  Result: complex(math.Round(real(x)), math.Round(imag(x)))\n" +
+			"    Example: round(3.5+2.5*i)   (Result: 4+2i)\n" +
+			"    Example: round(3.7+2.3*i)   (Result: 4+2i)",
+	},
+	{
+		Name: "trunc", Signature: "trunc(x)", Arity: 1, Category: CategoryRounding,
+		Impl:      componentwise(math.Trunc),
+		bigImpl:   bigComponentwise(truncate),
+		exactImpl: exactComponentwise(ratTrunc),
+		Help: "Function: trunc(x)\n" +
+			"  Truncates the complex number x towards zero component-wise.\n" +
+			"  Result: complex(math.Trunc(real(x)), math.Trunc(imag(x)))\n" +
+			"    Example: trunc(3.7+2.3*i)   (Result: 3+2i)\n" +
+			"    Example: trunc(-3.7-2.3*i)  (Result: -3-2i)",
+	},
+	{
+		Name: "min", Signature: "min(x1, x2, ...)", Arity: Variadic, Category: CategorySelection,
+		Impl: func(args []complex128) (complex128, error) {
+			return extremeByRealPart(args, func(a, b float64) bool { return a < b }), nil
+		},
+		bigImpl:   bigExtremeByRealPart(-1),
+		exactImpl: exactExtremeByRealPart(-1),
+		Help: "Function: min(x1, x2, ...)\n" +
+			"  Returns the argument with the smallest real part. Imaginary parts are not compared.\n" +
+			"    Example: min(3, -1, 2)    (Result: -1)\n" +
+			"    Example: min(2+i, 5)      (Result: 2 + i)",
+	},
+	{
+		Name: "max", Signature: "max(x1, x2, ...)", Arity: Variadic, Category: CategorySelection,
+		Impl: func(args []complex128) (complex128, error) {
+			return extremeByRealPart(args, func(a, b float64) bool { return a > b }), nil
+		},
+		bigImpl:   bigExtremeByRealPart(1),
+		exactImpl: exactExtremeByRealPart(1),
+		Help: "Function: max(x1, x2, ...)\n" +
+			"  Returns the argument with the largest real part. Imaginary parts are not compared.\n" +
+			"    Example: max(3, -1, 2)    (Result: 3)\n" +
+			"    Example: max(2+i, 1-i)    (Result: 2 + i)",
+	},
+	{
+		// The evaluator evaluates only the chosen branch (see lazyOperations); Impl is not
+		// reached for calls it can split into arguments.
+		Name: "if", Signature: "if(cond, a, b)", Arity: 3, Category: CategorySelection,
+		Impl: func(args []complex128) (complex128, error) {
+			if args[0] != 0 {
+				return args[1], nil
+			}
+			return args[2], nil
+		},
+		Help: "Function: if(cond, a, b)\n" +
+			"  Returns a if cond is true (any number but 0) and b otherwise. Only the chosen branch is\n" +
+			"  evaluated, so user functions can call themselves in it.\n" +
+			"    Example: if(2 > 1, 10, 20)     (Result: 10)\n" +
+			"    Example: f(n) = if(n <= 1, 1, n*f(n-1)), then f(5)   (Result: 120)",
+	},
+	{
+		Name: "gamma", Signature: "gamma(z)", Arity: 1, Category: CategoryGamma,
+		Impl:      withoutPoles(complexGamma),
+		exactImpl: exactUnary(exactGamma),
+		Help: "Function: gamma(z)\n" +
+			"  Calculates the gamma function, which extends the factorial: gamma(n) = (n-1)! for positive\n" +
+			"  integers. Complex arguments use the Lanczos approximation, with the reflection formula\n" +
+			"  gamma(z) gamma(1-z) = pi/sin(pi z) for Re(z) < 0.5. 0 and the negative integers are poles.\n" +
+			"    Example: gamma(5)         (Result: 24)\n" +
+			"    Example: gamma(0.5)       (Result: 1.772453851, sqrt(pi))\n" +
+			"    Example: gamma(1+i)       (Result: 0.498015668 - 0.154949828i)",
+	},
+	{
+		Name: "lgamma", Signature: "lgamma(z)", Arity: 1, Category: CategoryGamma,
+		Impl: withoutPoles(complexLogGamma),
+		Help: "Function: lgamma(z)\n" +
+			"  Calculates the principal branch of the log-gamma function, computed with the Stirling series.\n" +
+			"  It agrees with log(gamma(x)) for x > 0 and is analytic except on the negative real axis, so\n" +
+			"  its imaginary part is not limited to (-pi, pi] like that of log(gamma(z)). Useful when gamma\n" +
+			"  overflows.\n" +
+			"    Example: lgamma(100)      (Result: 359.13420537, log(99!))\n" +
+			"    Example: lgamma(-0.5)     (Result: 1.265512123 - 3.141592654i)",
+	},
+	{
+		Name: "digamma", Signature: "digamma(z)", Arity: 1, Category: CategoryGamma,
+		Impl: withoutPoles(complexDigamma),
+		Help: "Function: digamma(z)\n" +
+			"  Calculates the digamma function psi(z), the derivative of lgamma(z).\n" +
+			"    Example: digamma(1)       (Result: -0.577215665, minus the Euler-Mascheroni constant)\n" +
+			"    Example: digamma(i)       (Result: 0.094650321 + 2.076674047i)",
+	},
+	{
+		Name: "beta", Signature: "beta(a, b)", Arity: 2, Category: CategoryGamma,
+		Impl: func(args []complex128) (complex128, error) {
+			return complexBeta(args[0], args[1])
+		},
+		exactImpl: exactBeta,
+		Help: "Function: beta(a, b)\n" +
+			"  Calculates the beta function gamma(a) gamma(b) / gamma(a+b), through lgamma so that large\n" +
+			"  arguments do not overflow.\n" +
+			"    Example: beta(2, 3)       (Result: 0.083333333, 1/12)\n" +
+			"    Example: beta(0.5, 0.5)   (Result: 3.141592654)\n" +
+			"    Example: beta(1+i, 2)     (Result: 0.1 - 0.3i)",
+	},
+	{
+		Name: "erf", Signature: "erf(z)", Arity: 1, Category: CategorySpecial,
+		Budgeted: iterative(complexErf),
+		Help: "Function: erf(z)\n" +
+			"  Calculates the error function 2/sqrt(pi) times the integral of exp(-t^2) from 0 to z.\n" +
+			"  Complex arguments use its Maclaurin series or, for |Re(z)| >= 1.5, a continued fraction.\n" +
+			"    Example: erf(1)           (Result: 0.842700793)\n" +
+			"    Example: erf(1+i)         (Result: 1.316151282 + 0.190453469i)",
+	},
+	{
+		Name: "erfc", Signature: "erfc(z)", Arity: 1, Category: CategorySpecial,
+		Budgeted: iterative(complexErfc),
+		Help: "Function: erfc(z)\n" +
+			"  Calculates the complementary error function 1 - erf(z), without losing the digits of small\n" +
+			"  results to that subtraction.\n" +
+			"    Example: erfc(1)          (Result: 0.157299207)\n" +
+			"    Example: erfc(2)          (Result: 0.004677735)",
+	},
+	{
+		Name: "erfi", Signature: "erfi(z)", Arity: 1, Category: CategorySpecial,
+		Budgeted: iterative(complexErfi),
+		Help: "Function: erfi(z)\n" +
+			"  Calculates the imaginary error function -i erf(i z), real for real z.\n" +
+			"    Example: erfi(1)          (Result: 1.650425759)\n" +
+			"    Example: erfi(i)          (Result: 0.842700793i)",
+	},
+	{
+		Name: "lambertw", Signature: "lambertw(z [, k])", Arity: 2, Optional: 1, Category: CategorySpecial,
+		Budgeted: lambertW,
+		Help: "Function: lambertw(z [, k])\n" +
+			"  Calculates branch k of the Lambert W function, the solutions w of w*exp(w) = z. k is an\n" +
+			"  integer, 0 if left out: branch 0 is real for z >= -1/e and branch -1 for -1/e <= z < 0.\n" +
+			"  Computed with Halley's method.\n" +
+			"    Example: lambertw(1)         (Result: 0.56714329, the omega constant)\n" +
+			"    Example: lambertw(-0.1, -1)  (Result: -3.577152064)\n" +
+			"    Example: lambertw(1, 1)      (Result: -1.53391332 + 4.375185153i)",
+	},
+	{
+		Name: "zeta", Signature: "zeta(s)", Arity: 1, Category: CategorySpecial,
+		Budgeted: iterative(complexZeta),
+		Help: "Function: zeta(s)\n" +
+			"  Calculates the Riemann zeta function, the sum of 1/n^s for Re(s) > 1, continued to the\n" +
+			"  whole complex plane except the pole at s = 1. It uses Euler-Maclaurin summation for\n" +
+			"  Re(s) >= 0.5 and the functional equation for Re(s) < 0.5.\n" +
+			"    Example: zeta(2)          (Result: 1.644934067, pi^2/6)\n" +
+			"    Example: zeta(-1)         (Result: -0.083333333, -1/12)\n" +
+			"    Example: zeta(i)          (Result: 0.003300224 - 0.418155449i)",
+	},
+	{
+		Name: "besselj", Signature: "besselj(n, z)", Arity: 2, Category: CategoryBessel,
+		Budgeted: besselFunction(besselJY, false, true),
+		Help: "Function: besselj(n, z)\n" +
+			"  Calculates the Bessel function of the first kind J_n(z) for a real order n, integer or not,\n" +
+			"  and a complex z. It is computed from the modified Bessel functions at -iz or iz.\n" +
+			"    Example: besselj(0, 1)       (Result: 0.765197687)\n" +
+			"    Example: besselj(0.5, pi/2)  (Result: 0.636619772, 2/pi)\n" +
+			"    Example: besselj(1, 2+i)     (Result: 0.790623393 - 0.079932694i)",
+	},
+	{
+		Name: "bessely", Signature: "bessely(n, z)", Arity: 2, Category: CategoryBessel,
+		Budgeted: besselFunction(besselJY, true, false),
+		Help: "Function: bessely(n, z)\n" +
+			"  Calculates the Bessel function of the second kind Y_n(z) for a real order n and a complex z.\n" +
+			"  It is singular at z = 0 and, like log, has a branch cut along the negative real axis.\n" +
+			"    Example: bessely(0, 1)       (Result: 0.088256964)\n" +
+			"    Example: bessely(1, 10)      (Result: 0.249015424)",
+	},
+	{
+		Name: "besseli", Signature: "besseli(n, z)", Arity: 2, Category: CategoryBessel,
+		Budgeted: besselFunction(modifiedBessel, false, true),
+		Help: "Function: besseli(n, z)\n" +
+			"  Calculates the modified Bessel function of the first kind I_n(z) for a real order n and a\n" +
+			"  complex z, with Temme's series and continued fractions.\n" +
+			"    Example: besseli(0, 1)       (Result: 1.266065878)\n" +
+			"    Example: besseli(1, 10)      (Result: 2670.988303701)",
+	},
+	{
+		Name: "besselk", Signature: "besselk(n, z)", Arity: 2, Category: CategoryBessel,
+		Budgeted: besselFunction(modifiedBessel, true, false),
+		Help: "Function: besselk(n, z)\n" +
+			"  Calculates the modified Bessel function of the second kind K_n(z) for a real order n and a\n" +
+			"  complex z. It is singular at z = 0 and decays like exp(-z) for large Re(z).\n" +
+			"    Example: besselk(0, 1)       (Result: 0.421024438)\n" +
+			"    Example: besselk(0.5, 1)     (Result: 0.461068504, sqrt(pi/2)/e)",
+	},
+	{
+		Name: "airyai", Signature: "airyai(z)", Arity: 1, Category: CategoryBessel,
+		Budgeted: iterative(func(z complex128, budget *Budget) (complex128, error) { return complexAiry(z, false, budget) }),
+		Help: "Function: airyai(z)\n" +
+			"  Calculates the Airy function Ai(z), the solution of y'' = z y that decays for large positive z.\n" +
+			"  It uses the Maclaurin series for |z| <= 1 and Bessel functions of order 1/3 beyond.\n" +
+			"    Example: airyai(0)           (Result: 0.355028054)\n" +
+			"    Example: airyai(-10)         (Result: 0.040241238)\n" +
+			"    Example: airyai(i)           (Result: 0.331493305 - 0.317449859i)",
+	},
+	{
+		Name: "airybi", Signature: "airybi(z)", Arity: 1, Category: CategoryBessel,
+		Budgeted: iterative(func(z complex128, budget *Budget) (complex128, error) { return complexAiry(z, true, budget) }),
+		Help: "Function: airybi(z)\n" +
+			"  Calculates the Airy function Bi(z), the solution of y'' = z y that grows for large positive z.\n" +
+			"    Example: airybi(1)           (Result: 1.207423595)\n" +
+			"    Example: airybi(-10)         (Result: -0.31467983)",
+	},
+	{
+		Name: "gcd", Signature: "gcd(a, b, ...)", Arity: Variadic, Category: CategoryNumberTheory,
+		integerImpl: foldIntegers(gaussianGCD),
+		Help: "Function: gcd(a, b, ...)\n" +
+			"  Calculates the greatest common divisor of integers, or of Gaussian integers when any argument\n" +
+			"  is complex. Gaussian results are the associate with a positive real and non-negative imaginary\n" +
+			"  part.\n" +
+			"    Example: gcd(12, 18, 8)      (Result: 2)\n" +
+			"    Example: gcd(5, 3+i)         (Result: 1 + 2i)",
+	},
+	{
+		Name: "lcm", Signature: "lcm(a, b, ...)", Arity: Variadic, Category: CategoryNumberTheory,
+		integerImpl: foldIntegers(gaussianLCM),
+		Help: "Function: lcm(a, b, ...)\n" +
+			"  Calculates the least common multiple of integers or Gaussian integers, normalized like gcd.\n" +
+			"    Example: lcm(4, 6, 10)       (Result: 60)\n" +
+			"    Example: lcm(1+i, 1-i)       (Result: 1 + i)",
+	},
+	{
+		Name: "egcd", Signature: "egcd(a, b)", Arity: 2, Category: CategoryNumberTheory,
+		integerImpl: egcdValue,
+		describe:    egcdText,
+		Help: "Function: egcd(a, b)\n" +
+			"  Extended Euclidean algorithm: its value is gcd(a, b), and when the call is the whole\n" +
+			"  expression it shows the coefficients s and t with gcd(a, b) = s*a + t*b.\n" +
+			"    Example: egcd(240, 46)       (Result: 2 = (-9)*240 + 47*46)\n" +
+			"    Example: egcd(3+i, 2)        (Result: 1+i = (-1)*(3+i) + (2+i)*2)",
+	},
+	{
+		Name: "isprime", Signature: "isprime(n)", Arity: 1, Category: CategoryNumberTheory,
+		integerImpl: isPrimeValue,
+		predicate:   true,
+		Help: "Function: isprime(n)\n" +
+			"  Returns true if n is prime and false otherwise. Complex n are tested among the Gaussian\n" +
+			"  integers, where 3i is prime but 1+3i = (1+i)(2+i) is not. The test is exact below 2^64 and a\n" +
+			"  Baillie-PSW probable prime test above.\n" +
+			"    Example: isprime(97)         (Result: true)\n" +
+			"    Example: isprime(2+i)        (Result: true)\n" +
+			"    Example: isprime(1+3i)       (Result: false)",
+	},
+	{
+		Name: "factor", Signature: "factor(n)", Arity: 1, Category: CategoryNumberTheory,
+		integerImpl: factorValue,
+		describe:    factorText,
+		Help: "Function: factor(n)\n" +
+			"  Shows the prime factorization of n when the call is the whole expression; its value is n\n" +
+			"  itself. Integers divisible by 2 or by a prime 1 mod 4 are also shown factored among the\n" +
+			"  Gaussian integers, where those primes split; complex n are factored there only. Gaussian\n" +
+			"  factorizations are up to a unit shown first.\n" +
+			"    Example: factor(360)         (Result: 2^3 * 3^2 * 5 = i(1+i)^6(3)^2(2+i)(2-i))\n" +
+			"    Example: factor(5)           (Result: 5 = (2+i)(2-i))\n" +
+			"    Example: factor(63)          (Result: 3^2 * 7)\n" +
+			"    Example: factor(3+i)         (Result: (1+i)(2-i))",
+	},
+	{
+		Name: "powmod", Signature: "powmod(a, b, m)", Arity: 3, Category: CategoryNumberTheory,
+		integerImpl: powmod,
+		Help: "Function: powmod(a, b, m)\n" +
+			"  Calculates a^b modulo m without computing a^b. For integers the result is in [0, |m|); for\n" +
+			"  Gaussian integers it is the remainder of the '%' operator. Negative exponents use the inverse\n" +
+			"  of a modulo m.\n" +
+			"    Example: powmod(2, 100, 1000000007)    (Result: 976371285)\n" +
+			"    Example: powmod(3, -1, 7)              (Result: 5)\n" +
+			"    Example: powmod(2+i, 10, 3)            (Result: i)",
+	},
+	{
+		Name: "totient", Signature: "totient(n)", Arity: 1, Category: CategoryNumberTheory,
+		integerImpl: totient,
+		Help: "Function: totient(n)\n" +
+			"  Calculates Euler's totient, the number of units modulo n. For complex n it counts the units\n" +
+			"  modulo n among the Gaussian integers.\n" +
+			"    Example: totient(36)         (Result: 12)\n" +
+			"    Example: totient(2+i)        (Result: 4)",
+	},
+	{
+		Name: "vec", Signature: "vec(x1, x2, ...)", Arity: Variadic, Category: CategoryVector,
+		vector: vectorMake,
+		Help: "Function: vec(x1, x2, ...)\n" +
+			"  Makes a vector of the arguments; vectors among them are replaced by their elements.\n" +
+			"  +, -, *, / and the other arithmetic operators apply element by element, pairing the\n" +
+			"  elements of vectors of the same length or a number with every element. Functions of one\n" +
+			"  argument apply to each element, and functions like min or max take the elements as\n" +
+			"  arguments. The number-theory functions do not take vectors.\n" +
+			"    Example: vec(1, 2, 3+i)                 (Result: vec(1, 2, 3 + i))\n" +
+			"    Example: vec(1, 2) + vec(10, 20)        (Result: vec(11, 22))\n" +
+			"    Example: 2 * vec(1, 2)                  (Result: vec(2, 4))\n" +
+			"    Example: sqrt(vec(4, -9))               (Result: vec(2, 3i))",
+	},
+	{
+		Name: "sum", Signature: "sum(x1, x2, ...)", Arity: Variadic, Category: CategoryVector,
+		vector: vectorSum,
+		Help: "Function: sum(x1, x2, ...)\n" +
+			"  Adds up the arguments, and the elements of the vectors among them.\n" +
+			"    Example: sum(vec(1, 2, 3))     (Result: 6)\n" +
+			"    Example: sum(1, 2, 3)          (Result: 6)",
+	},
+	{
+		Name: "prod", Signature: "prod(x1, x2, ...)", Arity: Variadic, Category: CategoryVector,
+		vector: vectorProduct,
+		Help: "Function: prod(x1, x2, ...)\n" +
+			"  Multiplies the arguments, and the elements of the vectors among them.\n" +
+			"    Example: prod(vec(1, 2, 3, 4)) (Result: 24)",
+	},
+	{
+		Name: "len", Signature: "len(v)", Arity: 1, Category: CategoryVector,
+		vector: vectorLength,
+		Help: "Function: len(v)\n" +
+			"  Returns the number of elements of the vector v; a number has 1.\n" +
+			"    Example: len(vec(1, 2, 3))     (Result: 3)",
+	},
+	{
+		Name: "dot", Signature: "dot(a, b)", Arity: 2, Category: CategoryVector,
+		vector: vectorDot,
+		Help: "Function: dot(a, b)\n" +
+			"  Calculates the dot product conj(a1)*b1 + conj(a2)*b2 + ... of two vectors of the same\n" +
+			"  length. The elements of a are conjugated, so dot(v, v) is norm(v)^2 for complex v too.\n" +
+			"    Example: dot(vec(1, 2, 3), vec(4, 5, 6))   (Result: 32)\n" +
+			"    Example: dot(vec(i, 1), vec(i, 1))         (Result: 2)",
+	},
+	{
+		Name: "cross", Signature: "cross(a, b)", Arity: 2, Category: CategoryVector,
+		vector: vectorCross,
+		Help: "Function: cross(a, b)\n" +
+			"  Calculates the cross product of two vectors of 3 elements.\n" +
+			"    Example: cross(vec(1, 0, 0), vec(0, 1, 0)) (Result: vec(0, 0, 1))",
+	},
+	{
+		Name: "norm", Signature: "norm(v)", Arity: 1, Category: CategoryVector,
+		vector: vectorNorm,
+		Help: "Function: norm(v)\n" +
+			"  Calculates the Euclidean length sqrt(|v1|^2 + |v2|^2 + ...) of the vector v.\n" +
+			"    Example: norm(vec(3, 4))       (Result: 5)\n" +
+			"    Example: norm(vec(1, i))       (Result: 1.414213562)",
+	},
+	{
+		Name: "at", Signature: "at(v, k)", Arity: 2, Category: CategoryVector,
+		vector: vectorAt,
+		Help: "Function: at(v, k)\n" +
+			"  Returns the k-th element of the vector v, counting from 1. Brackets group expressions,\n" +
+			"  so v[2] is v*2 rather than an element.\n" +
+			"    Example: at(vec(10, 20, 30), 2)   (Result: 20)",
+	},
+}
+
+// unary adapts a one-argument function to a FunctionImpl.
+func unary(f func(complex128) complex128) FunctionImpl {
+	return func(args []complex128) (complex128, error) {
+		return f(args[0]), nil
+	}
+}
+
+// binary adapts a two-argument function to a FunctionImpl.
+func binary(f func(complex128, complex128) complex128) FunctionImpl {
+	return func(args []complex128) (complex128, error) {
+		return f(args[0], args[1]), nil
+	}
+}
+
+// componentwise applies a real function to the real and imaginary parts separately.
+func componentwise(f func(float64) float64) FunctionImpl {
+	return unary(func(x complex128) complex128 {
+		return complex(f(real(x)), f(imag(x)))
+	})
+}
+
+// complexAtan2 returns the angle of the point (x, y). For real arguments this is math.Atan2;
+// otherwise it is continued analytically as -i*log((x + i*y) / sqrt(x^2 + y^2)).
+func complexAtan2(y, x complex128) complex128 {
+	if imag(y) == 0 && imag(x) == 0 {
+		return complex(math.Atan2(real(y), real(x)), 0)
+	}
+	return -complex(0, 1) * cmplx.Log((x+complex(0, 1)*y)/cmplx.Sqrt(x*x+y*y))
+}
+
+// nthRoot returns the n-th root of x. When x is real and n is an odd integer the real root
+// is returned (root(-8, 3) = -2); otherwise the principal value x^(1/n).
+func nthRoot(x, n complex128) complex128 {
+	if imag(x) == 0 && imag(n) == 0 && real(x) < 0 && isEffectivelyInteger(real(n), Epsilon) && math.Mod(math.Round(real(n)), 2) != 0 {
+		return complex(-math.Pow(-real(x), 1/real(n)), 0)
+	}
+	return cmplx.Pow(x, 1/n)
+}
+
+// complexHypot returns sqrt(a^2 + b^2), avoiding overflow for real arguments.
+func complexHypot(a, b complex128) complex128 {
+	if imag(a) == 0 && imag(b) == 0 {
+		return complex(math.Hypot(real(a), real(b)), 0)
+	}
+	return cmplx.Sqrt(a*a + b*b)
+}
+
+// extremeByRealPart returns the argument whose real part wins the comparison better
+// (the first one on ties). The imaginary parts are carried along but not compared.
+func extremeByRealPart(args []complex128, better func(a, b float64) bool) complex128 {
+	extreme := args[0]
+	for _, arg := range args[1:] {
+		if better(real(arg), real(extreme)) {
+			extreme = arg
+		}
+	}
+	return extreme
+}
@@ -1,9 +1,12 @@
 // core.go
 package toycalc_core
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
-// Epsilon constant for floating-point comparisons
+// Epsilon constant for floating-point comparisons; the default tolerance of '=='
 const Epsilon = 1e-10
 
 // TokenType identifies the type of a token
@@ -20,14 +23,34 @@ const (
 	IDENT  TokenType = "IDENT"  // For function names like log, exp
 
 	// Operators
-	PLUS        TokenType = "+"
-	MINUS       TokenType = "-"
-	ASTERISK    TokenType = "*"
-	SLASH       TokenType = "/"
-	PERCENT     TokenType = "%"           // Modulo
-	CARET       TokenType = "^"           // Power
-	UNARY_MINUS TokenType = "UNARY_MINUS" // Or UMINUS
-	UNARY_PLUS  TokenType = "UNARY_PLUS"  // Or UMINUS
+	PLUS             TokenType = "+"
+	MINUS            TokenType = "-"
+	ASTERISK         TokenType = "*"
+	SLASH            TokenType = "/"
+	PERCENT          TokenType = "%"           // Modulo
+	CARET            TokenType = "^"           // Power
+	UNARY_MINUS      TokenType = "UNARY_MINUS" // Or UMINUS
+	UNARY_PLUS       TokenType = "UNARY_PLUS"  // Or UMINUS
+	ANGLE            TokenType = "∠"           // Phasor: r∠θ is r*exp(i*θ); also written 'angle'
+	DEGREE           TokenType = "°"           // Postfix: converts an angle in degrees to the angle mode's unit
+	SLASH_SLASH      TokenType = "//"          // Floor division; truncating integer division in programmer mode
+	AMPERSAND        TokenType = "&"           // Bitwise and
+	PIPE             TokenType = "|"           // Bitwise or
+	XOR              TokenType = "xor"         // Bitwise exclusive or
+	TILDE            TokenType = "~"           // Prefix: bitwise not
+	SHIFT_LEFT       TokenType = "<<"
+	SHIFT_RIGHT      TokenType = ">>" // Arithmetic shift: the sign is kept
+	FACTORIAL        TokenType = "!"  // Postfix: x! is gamma(x+1)
+	DOUBLE_FACTORIAL TokenType = "!!" // Postfix: n!! is n(n-2)(n-4)...
+	EQUAL            TokenType = "==" // Equal within the tolerance of the settings
+	NOT_EQUAL        TokenType = "!="
+	LESS             TokenType = "<" // Orderings compare real values only
+	LESS_EQUAL       TokenType = "<="
+	GREATER          TokenType = ">"
+	GREATER_EQUAL    TokenType = ">="
+	AND              TokenType = "and" // Logical and; the right operand is evaluated only if needed
+	OR               TokenType = "or"  // Logical or; likewise
+	NOT              TokenType = "not" // Prefix: logical not
 
 	// Delimiters
 	LPAREN   TokenType = "(" // Left Parenthesis
@@ -37,6 +60,9 @@ const (
 	LBRACE   TokenType = "{" // Left Brace
 	RBRACE   TokenType = "}" // Right Brace
 	COMMA    TokenType = "," // For function arguments (though not heavily used in Stage 1 funcs)
+
+	// Statements
+	ASSIGN TokenType = "=" // Binds a name: x = 3+4i
 )
 
 // Token represents a lexical unit
@@ -44,17 +70,89 @@ type Token struct {
 	Type     TokenType
 	Literal  string // The literal value of the token
 	Position int    // for detailed error reporting
+	Arity    int    // For function tokens in RPN output: number of arguments passed in the call
 }
 
-// CalculationError (as defined in Stage 0)
+// ErrorKind classifies a CalculationError.
+type ErrorKind string
+
+const (
+	KindLex    ErrorKind = "lex"    // The input contains text that cannot be tokenized
+	KindParse  ErrorKind = "parse"  // The tokens do not form a valid expression or statement
+	KindDomain ErrorKind = "domain" // An operation is undefined for its operands, e.g. modulo by zero
+	KindArity  ErrorKind = "arity"  // A function was called with the wrong number of arguments
+	KindLimit  ErrorKind = "limit"  // A resource limit or the call depth was exceeded
+)
+
+// Sentinel errors for errors.Is, one per ErrorKind (ErrLimitExceeded covers KindLimit):
+// errors.Is(err, ErrParse) reports whether err is a parse error.
+var (
+	ErrLex    = errors.New("lex error")
+	ErrParse  = errors.New("parse error")
+	ErrDomain = errors.New("domain error")
+	ErrArity  = errors.New("arity error")
+)
+
+var kindSentinels = map[ErrorKind]error{
+	KindLex:    ErrLex,
+	KindParse:  ErrParse,
+	KindDomain: ErrDomain,
+	KindArity:  ErrArity,
+	KindLimit:  ErrLimitExceeded,
+}
+
+// CalculationError (as defined in Stage 0), extended with where and what went wrong.
+// Start and End are byte offsets into the input (End exclusive); they are only meaningful
+// when HasSpan reports true.
 type CalculationError struct {
 	Message string
+	Kind    ErrorKind // Empty for errors not tied to an expression, e.g. invalid settings
+	Start   int
+	End     int
+	Literal string // The offending text, if any
+
+	// Suggestions lists known names the user may have meant, for unknown identifiers.
+	Suggestions []string
 }
 
 func (e *CalculationError) Error() string {
 	return fmt.Sprintf("Calculation error: %s", e.Message)
 }
 
+// HasSpan reports whether the error points at a part of the input.
+func (e *CalculationError) HasSpan() bool {
+	return e.End > e.Start && e.Start >= 0
+}
+
+// Is makes errors.Is(err, ErrParse) and friends match errors of the corresponding kind.
+func (e *CalculationError) Is(target error) bool {
+	sentinel, found := kindSentinels[e.Kind]
+	return found && sentinel == target
+}
+
 func NewCalculationError(message string) error {
 	return &CalculationError{Message: message}
 }
+
+// newKindError is a CalculationError of the given kind that does not point into the input.
+func newKindError(kind ErrorKind, message string) error {
+	return &CalculationError{Message: message, Kind: kind}
+}
+
+// newTokenError is a CalculationError of the given kind pointing at token. Tokens without
+// text (the end of the input) get a one-character span so that they can still be marked.
+func newTokenError(kind ErrorKind, token Token, message string) error {
+	end := token.Position + len(token.Literal)
+	if end == token.Position {
+		end++
+	}
+	return &CalculationError{Message: message, Kind: kind, Start: token.Position, End: end, Literal: token.Literal}
+}
+
+// newUnknownIdentifierError is a parse error pointing at token, with the names in env that
+// it may be a misspelling of.
+func newUnknownIdentifierError(token Token, message string, env *Environment) error {
+	err := newTokenError(KindParse, token, message).(*CalculationError)
+	err.Suggestions = Suggestions(token.Literal, env)
+	return err
+}
@@ -0,0 +1,353 @@
+// engine.go
+package toycalc_core
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// MaxDisplayPrecision is the largest number of decimal places accepted by SetFormat and
+// SetPrecision, except in high-precision mode, which accepts as many as its bits carry.
+const MaxDisplayPrecision = 20
+
+// AngleMode selects the unit trigonometric functions work in.
+type AngleMode string
+
+const (
+	AngleRadians AngleMode = "rad" // Default: sin(pi/2) = 1
+	AngleDegrees AngleMode = "deg" // sin(90) = 1, asin(1) = 90
+)
+
+// Settings controls how an Engine evaluates and displays results.
+type Settings struct {
+	Format         string    // Output format: "auto", "fixed", "sci", "eng", "si", "frac" or "polar"
+	Precision      int       // Decimal places for 'fixed' and 'sci', for rounding before display in 'auto', and the tolerance of 'frac'
+	AngleMode      AngleMode // Unit of the angles taken and returned by trigonometric functions
+	MaxDenominator int64     // Largest denominator shown by 'frac'
+	PolarUnit      AngleMode // Unit of the angles shown by 'polar'; empty follows AngleMode
+	Locale         Locale    // How numbers are written in expressions and results
+	Base           int       // Base of integral results: 2, 8, 10 or 16
+	Tolerance      float64   // '==' holds when |a-b| <= Tolerance * max(1, |a|, |b|); Epsilon by default
+
+	// Significant makes Precision a number of significant digits in 'auto' format: each
+	// part is rounded relative to its own magnitude, so 1.23e-12 is not shown as 0.
+	Significant bool
+
+	// Bits selects high-precision mode: when not 0, numbers are complex values with
+	// big.Float components of this many bits instead of complex128.
+	Bits uint
+
+	// Exact selects exact mode: numbers are Gaussian rationals, and results that cannot be
+	// computed exactly are approximated and marked with ApproximateMarker. Exact mode and
+	// high-precision mode exclude each other.
+	Exact bool
+
+	// Word selects programmer mode: when not 0, numbers are integers of this many bits
+	// (8, 16, 32 or 64) that wrap around, two's complement unless Unsigned is set.
+	// Programmer mode excludes exact and high-precision mode.
+	Word     int
+	Unsigned bool
+}
+
+// DefaultSettings returns the settings a new Engine starts with.
+func DefaultSettings() Settings {
+	return Settings{Format: "auto", Precision: 9, AngleMode: AngleRadians, MaxDenominator: DefaultMaxDenominator, Base: 10, Tolerance: Epsilon}
+}
+
+// Engine evaluates expressions with its own settings, variables, user functions and
+// registered functions, so several engines can be used side by side (for example one per
+// console session or per web request) without affecting each other.
+// An Engine is safe for concurrent use.
+type Engine struct {
+	mu       sync.Mutex
+	settings Settings
+	limits   Limits
+	env      *Environment
+}
+
+// NewEngine returns an engine with default settings and limits and no variables. It starts
+// with the functions and constants of the default registry; functions registered on the
+// engine afterwards are only visible to it.
+func NewEngine() *Engine {
+	return &Engine{settings: DefaultSettings(), limits: DefaultLimits(), env: newEnvironment(defaultRegistry.Clone())}
+}
+
+// defaultEngine backs CalculateExpression. Its settings and limits are never changed.
+var defaultEngine = &Engine{settings: DefaultSettings(), limits: DefaultLimits(), env: NewEnvironment()}
+
+// calculation captures the engine's settings and limits for one request. e.mu must be held.
+func (e *Engine) calculation(ctx context.Context) calculation {
+	return calculation{ctx: ctx, settings: e.settings, limits: e.limits}
+}
+
+// Evaluate evaluates a statement: an expression, an assignment like `x = 3+4i` or a
+// function definition like `f(x) = x^2`. Assignments and definitions are kept by the engine.
+func (e *Engine) Evaluate(statement string) (string, error) {
+	return e.EvaluateContext(context.Background(), statement)
+}
+
+// EvaluateContext is like Evaluate, but stops with ctx's error once ctx is cancelled.
+func (e *Engine) EvaluateContext(ctx context.Context, statement string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calculation(ctx).calculateStatement(statement, e.env)
+}
+
+// EvaluateExpression evaluates an expression using the engine's variables and functions.
+// Unlike Evaluate, it rejects assignments, so it never changes the engine.
+func (e *Engine) EvaluateExpression(expression string) (string, error) {
+	return e.EvaluateExpressionContext(context.Background(), expression)
+}
+
+// EvaluateExpressionContext is like EvaluateExpression, but stops with ctx's error once
+// ctx is cancelled.
+func (e *Engine) EvaluateExpressionContext(ctx context.Context, expression string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calculation(ctx).calculateExpression(expression, e.env)
+}
+
+// EvaluateScript evaluates a script line by line, like CalculateScript, keeping the
+// variables and functions it defines.
+func (e *Engine) EvaluateScript(script string) ([]string, error) {
+	return e.EvaluateScriptContext(context.Background(), script)
+}
+
+// EvaluateScriptContext is like EvaluateScript, but stops with ctx's error once ctx is cancelled.
+func (e *Engine) EvaluateScriptContext(ctx context.Context, script string) ([]string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calculation(ctx).calculateScript(script, e.env)
+}
+
+// Format formats c with the engine's output settings.
+func (e *Engine) Format(c complex128) string {
+	settings := e.Settings()
+	return localizeNumbers(formatComplex(c, settings), settings.Locale)
+}
+
+// Settings returns a copy of the engine's current settings.
+func (e *Engine) Settings() Settings {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.settings
+}
+
+// SetFormat sets the output format ("auto", "fixed", "sci", "eng", "si", "frac" or "polar")
+// and the display precision. 'fixed' and 'sci' count decimal places, so they turn
+// significant-digit mode off.
+func (e *Engine) SetFormat(format string, precision int) error {
+	switch format {
+	case "auto", "fixed", "sci", "eng", "si", "frac", "polar":
+	default:
+		return NewCalculationError(fmt.Sprintf("unknown format mode '%s'; use 'auto', 'fixed N', 'sci N', 'eng', 'si', 'frac' or 'polar'", format))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := validatePrecision(precision, e.settings.Bits); err != nil {
+		return err
+	}
+	e.settings.Format = format
+	e.settings.Precision = precision
+	if format == "fixed" || format == "sci" {
+		e.settings.Significant = false
+	}
+	return nil
+}
+
+// SetPrecision sets the number of decimal places used for display, turning
+// significant-digit mode off.
+func (e *Engine) SetPrecision(precision int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := validatePrecision(precision, e.settings.Bits); err != nil {
+		return err
+	}
+	e.settings.Precision = precision
+	e.settings.Significant = false
+	return nil
+}
+
+// SetSignificantDigits turns significant-digit mode on: 'auto' output rounds each part to
+// the given number of significant digits instead of decimal places.
+func (e *Engine) SetSignificantDigits(digits int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if limit := displayPrecisionLimit(e.settings.Bits); digits < 1 || digits > limit {
+		return NewCalculationError(fmt.Sprintf("significant digits must be an integer between 1 and %d, got %d", limit, digits))
+	}
+	e.settings.Precision = digits
+	e.settings.Significant = true
+	return nil
+}
+
+// SetMaxDenominator sets the largest denominator shown by the 'frac' format.
+func (e *Engine) SetMaxDenominator(maxDenominator int64) error {
+	if maxDenominator < 1 {
+		return NewCalculationError(fmt.Sprintf("maximum denominator must be a positive integer, got %d", maxDenominator))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.MaxDenominator = maxDenominator
+	return nil
+}
+
+// SetPolarUnit sets the unit of the angles shown by the 'polar' format: AngleDegrees,
+// AngleRadians, or "" to follow the angle mode.
+func (e *Engine) SetPolarUnit(unit AngleMode) error {
+	if unit != "" && unit != AngleRadians && unit != AngleDegrees {
+		return NewCalculationError(fmt.Sprintf("unknown angle unit '%s'; use 'rad' or 'deg'", unit))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.PolarUnit = unit
+	return nil
+}
+
+// SetPrecisionBits turns high-precision mode on with the given number of bits (between
+// MinPrecisionBits and MaxPrecisionBits), or off with 0. Turning it off reduces a display
+// precision above MaxDisplayPrecision to MaxDisplayPrecision; turning it on turns exact
+// mode off.
+func (e *Engine) SetPrecisionBits(bits uint) error {
+	if bits != 0 && (bits < MinPrecisionBits || bits > MaxPrecisionBits) {
+		return NewCalculationError(fmt.Sprintf("precision bits must be 0 (off) or between %d and %d, got %d", MinPrecisionBits, MaxPrecisionBits, bits))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.Bits = bits
+	e.settings.Precision = min(e.settings.Precision, displayPrecisionLimit(bits))
+	if bits != 0 {
+		e.settings.Exact = false
+		e.settings.Word = 0
+	}
+	return nil
+}
+
+// SetExact turns exact mode on or off. Turning it on turns high-precision mode and
+// programmer mode off.
+func (e *Engine) SetExact(exact bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.Exact = exact
+	if exact {
+		e.settings.Bits = 0
+		e.settings.Word = 0
+		e.settings.Precision = min(e.settings.Precision, MaxDisplayPrecision)
+	}
+}
+
+// SetWord turns programmer mode on with words of the given size in bits (8, 16, 32 or 64),
+// signed or unsigned, or off with 0. In programmer mode every value is an integer that wraps
+// around like a machine word: 127 + 1 is -128 in a signed 8-bit word. Turning it on turns
+// exact and high-precision mode off.
+func (e *Engine) SetWord(size int, unsigned bool) error {
+	if size != 0 && !slices.Contains(WordSizes, size) {
+		return NewCalculationError(fmt.Sprintf("word size must be 0 (off), 8, 16, 32 or 64, got %d", size))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.Word = size
+	e.settings.Unsigned = unsigned
+	if size != 0 {
+		e.settings.Exact = false
+		e.settings.Bits = 0
+		e.settings.Precision = min(e.settings.Precision, MaxDisplayPrecision)
+	}
+	return nil
+}
+
+// SetBase sets the base integral results are shown in: 2, 8, 10 or 16. In bases other than
+// 10, results whose parts are both integers (after rounding to the display precision) are
+// written with a 0b, 0o or 0x prefix, like the literals; other results are shown in decimal
+// in the current format.
+func (e *Engine) SetBase(base int) error {
+	if err := validateBase(base); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.Base = base
+	return nil
+}
+
+// SetLocale sets how numbers are written: LocaleEnglish, LocaleSpanish, LocaleGerman, or
+// LocalePlain for the default. Locales with a decimal comma accept 3,5 as well as 3.5 and
+// separate arguments with ';'; results use the locale's decimal mark and digit grouping.
+func (e *Engine) SetLocale(locale Locale) error {
+	if err := validateLocale(locale); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.Locale = locale
+	return nil
+}
+
+// SetTolerance sets the tolerance of the comparisons: a == b holds when |a-b| is at most
+// tolerance * max(1, |a|, |b|), and <= and >= hold for such values as well. 0 compares
+// exactly. Exact values in exact and programmer mode are always compared exactly.
+func (e *Engine) SetTolerance(tolerance float64) error {
+	if !(tolerance >= 0 && tolerance < 1) {
+		return NewCalculationError(fmt.Sprintf("tolerance must be at least 0 and less than 1, got %g", tolerance))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.Tolerance = tolerance
+	return nil
+}
+
+// SetAngleMode sets the unit of the angles used by trigonometric functions.
+func (e *Engine) SetAngleMode(mode AngleMode) error {
+	if mode != AngleRadians && mode != AngleDegrees {
+		return NewCalculationError(fmt.Sprintf("unknown angle mode '%s'; use 'rad' or 'deg'", mode))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.settings.AngleMode = mode
+	return nil
+}
+
+// Limits returns the engine's current resource limits.
+func (e *Engine) Limits() Limits {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.limits
+}
+
+// SetLimits replaces the engine's resource limits; zero fields disable a limit.
+func (e *Engine) SetLimits(limits Limits) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.limits = limits
+}
+
+func validatePrecision(precision int, bits uint) error {
+	if limit := displayPrecisionLimit(bits); precision < 0 || precision > limit {
+		return NewCalculationError(fmt.Sprintf("precision must be an integer between 0 and %d, got %d", limit, precision))
+	}
+	return nil
+}
+
+// RegisterFunction adds a function to this engine only.
+func (e *Engine) RegisterFunction(def FunctionDef) error {
+	return e.env.builtins().RegisterFunction(def)
+}
+
+// RegisterConstant adds a constant to this engine only.
+func (e *Engine) RegisterConstant(def ConstantDef) error {
+	return e.env.builtins().RegisterConstant(def)
+}
+
+// Completions returns the names known to the engine that start with prefix; see Completions.
+func (e *Engine) Completions(prefix string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return Completions(prefix, e.env)
+}
+
+// HelpText is like the package-level HelpText, but also covers the engine's own functions.
+func (e *Engine) HelpText(topic string) (string, bool) {
+	return helpText(e.env.builtins(), topic)
+}
@@ -0,0 +1,95 @@
+// engineering.go
+package toycalc_core
+
+import (
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+// siPrefixes maps the SI prefixes accepted after number literals (4.7k, 100n) to their
+// powers of ten. 'u' and the Greek letter mu are accepted as spellings of the micro sign.
+var siPrefixes = map[rune]int{
+	'f': -15, 'p': -12, 'n': -9, 'µ': -6, 'μ': -6, 'u': -6, 'm': -3,
+	'k': 3, 'M': 6, 'G': 9, 'T': 12,
+}
+
+// siPrefixNames are the prefixes shown by the 'si' format, by power of ten.
+var siPrefixNames = map[int]string{
+	-15: "f", -12: "p", -9: "n", -6: "µ", -3: "m", 0: "", 3: "k", 6: "M", 9: "G", 12: "T",
+}
+
+// isSIPrefix reports whether ch is one of the SI prefixes accepted after number literals.
+func isSIPrefix(ch rune) bool {
+	_, found := siPrefixes[ch]
+	return found
+}
+
+// numberText returns the literal of a NUMBER token in the form strconv.ParseFloat,
+// big.Float.SetString and big.Rat.SetString accept: based literals are converted to decimal,
+// a decimal comma becomes a point and an SI prefix is replaced with an exponent, so "4,7k"
+// becomes "4.7e3" and "0x1F" becomes "31".
+func numberText(literal string) string {
+	if text, ok := basedNumberText(literal); ok {
+		return text
+	}
+	literal = strings.Replace(literal, ",", ".", 1)
+	for prefix, exponent := range siPrefixes {
+		if mantissa, found := strings.CutSuffix(literal, string(prefix)); found {
+			return mantissa + "e" + strconv.Itoa(exponent)
+		}
+	}
+	return literal
+}
+
+// formatEngineering renders c in the 'eng' format (4.7e3) or, if si is set, the 'si' format
+// (4.7k), applied to both parts. Mantissas are between 1 and 1000, rounded to
+// settings.Precision decimal places, without trailing zeros. A part is shown as zero when
+// it is negligible next to the other part. The 'si' format falls back to 'eng' notation for
+// magnitudes without a prefix. Imaginary parts ending in a prefix are written "2.2k i".
+func formatEngineering(c complex128, settings Settings, si bool) string {
+	if cmplx.IsNaN(c) || cmplx.IsInf(c) {
+		decimal := settings
+		decimal.Format = "auto"
+		return formatComplex(c, decimal)
+	}
+	realVal, imagVal := real(c), imag(c)
+	realIsZero := realVal == 0 || math.Abs(realVal) < Epsilon*math.Abs(imagVal)
+	imagIsZero := imagVal == 0 || math.Abs(imagVal) < Epsilon*math.Abs(realVal)
+	if realIsZero && imagIsZero {
+		return "0"
+	}
+	realStr := "0"
+	if !realIsZero {
+		realStr = engineeringText(realVal, settings.Precision, si)
+	}
+	if imagIsZero {
+		return realStr
+	}
+	imagMagStr := engineeringText(math.Abs(imagVal), settings.Precision, si)
+	if last := imagMagStr[len(imagMagStr)-1]; last < '0' || last > '9' {
+		imagMagStr += " "
+	}
+	return joinComplex(realStr, imagMagStr, realIsZero, imagVal < 0, imagMagStr == "1")
+}
+
+// engineeringText renders x != 0 as a mantissa between 1 and 1000 with the given number of
+// decimal places (trailing zeros removed) and an exponent that is a multiple of 3, written
+// as an SI prefix if si is set and one exists.
+func engineeringText(x float64, decimals int, si bool) string {
+	exponent := int(math.Floor(math.Log10(math.Abs(x))/3)) * 3
+	mantissa := roundToDecimalPlaces(x/math.Pow(10, float64(exponent)), decimals)
+	if math.Abs(mantissa) >= 1000 { // Rounding carried into the next group, e.g. 999.9999999999
+		exponent += 3
+		mantissa = roundToDecimalPlaces(x/math.Pow(10, float64(exponent)), decimals)
+	}
+	text := strconv.FormatFloat(mantissa, 'f', -1, 64)
+	if prefix, found := siPrefixNames[exponent]; si && found {
+		return text + prefix
+	}
+	if exponent == 0 {
+		return text
+	}
+	return text + "e" + strconv.Itoa(exponent)
+}
@@ -0,0 +1,179 @@
+// environment.go
+package toycalc_core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxCallDepth bounds how deeply user-defined functions may call each other (or themselves),
+// so that a definition like f(x) = f(x) fails with an error instead of hanging.
+const MaxCallDepth = 256
+
+// UserFunction is a function defined by the user, e.g. f(x, y) = x^2 + y*i.
+// Body holds the parsed right-hand side in RPN; Source keeps its original text for display.
+type UserFunction struct {
+	Name   string
+	Params []string
+	Body   []Token
+	Source string
+}
+
+// Arity returns the number of arguments the function must be called with.
+func (fn *UserFunction) Arity() int {
+	return len(fn.Params)
+}
+
+// String renders the definition the way the user typed it, e.g. "f(x, y) = x^2 + y*i".
+func (fn *UserFunction) String() string {
+	return fmt.Sprintf("%s(%s) = %s", fn.Name, strings.Join(fn.Params, ", "), fn.Source)
+}
+
+// Environment holds the user-defined bindings (variables and functions) that expressions
+// can refer to. The same Environment is handed to the parser (so it can tell variables and
+// functions apart from unknown identifiers) and to the evaluator (so it can look them up).
+// Environments nest: a child scope (such as the frame of a function call) sees the bindings
+// of its parent unless it shadows them.
+// Names are case-insensitive, like the built-in constants and functions.
+// Each Environment also carries the Registry of built-ins its names must not collide with.
+type Environment struct {
+	variables map[string]complex128
+	// precise keeps, beside variables, the values computed in a number system more precise
+	// than complex128 (see Settings.Bits), so that using a variable does not lose digits.
+	precise   map[string]any
+	functions map[string]*UserFunction
+	parent    *Environment
+	registry  *Registry
+}
+
+// NewEnvironment returns an empty environment whose built-ins come from the default registry.
+func NewEnvironment() *Environment {
+	return newEnvironment(defaultRegistry)
+}
+
+func newEnvironment(registry *Registry) *Environment {
+	return &Environment{variables: map[string]complex128{}, precise: map[string]any{}, functions: map[string]*UserFunction{}, registry: registry}
+}
+
+// NewChild returns an empty scope whose lookups fall back to env.
+func (env *Environment) NewChild() *Environment {
+	child := newEnvironment(env.builtins())
+	child.parent = env
+	return child
+}
+
+// builtins returns the registry of functions and constants in scope. It is safe to call on
+// a nil Environment, which sees the default registry.
+func (env *Environment) builtins() *Registry {
+	if env == nil || env.registry == nil {
+		return defaultRegistry
+	}
+	return env.registry
+}
+
+// Set binds name to value in this scope, replacing any function of the same name.
+// Built-in names such as 'pi', 'i' or 'sin' cannot be rebound.
+func (env *Environment) Set(name string, value complex128) error {
+	if env == nil {
+		return newKindError(KindParse, fmt.Sprintf("cannot assign to '%s': no environment available", name))
+	}
+	if registry := env.builtins(); registry.isReserved(name) {
+		return newKindError(KindParse, fmt.Sprintf("cannot assign to built-in %s '%s'", registry.kind(name), name))
+	}
+	env.bind(name, value, nil)
+	return nil
+}
+
+// bind sets name to value in this scope without checking the name, keeping precise (if not
+// nil) as the value to use in the number system it belongs to.
+func (env *Environment) bind(name string, value complex128, precise any) {
+	lowerName := strings.ToLower(name)
+	delete(env.functions, lowerName)
+	env.variables[lowerName] = value
+	if precise != nil {
+		env.precise[lowerName] = precise
+	} else {
+		delete(env.precise, lowerName)
+	}
+}
+
+// DefineFunction binds fn under fn.Name in this scope, replacing any variable of the same name.
+func (env *Environment) DefineFunction(fn *UserFunction) error {
+	if env == nil {
+		return newKindError(KindParse, fmt.Sprintf("cannot define '%s': no environment available", fn.Name))
+	}
+	if registry := env.builtins(); registry.isReserved(fn.Name) {
+		return newKindError(KindParse, fmt.Sprintf("cannot redefine built-in %s '%s'", registry.kind(fn.Name), fn.Name))
+	}
+	lowerName := strings.ToLower(fn.Name)
+	delete(env.variables, lowerName)
+	delete(env.precise, lowerName)
+	env.functions[lowerName] = fn
+	return nil
+}
+
+// lookup finds the nearest scope binding name. Exactly one of the value or the function is
+// meaningful when found is true: fn is nil for variables. owner is the scope holding the binding.
+func (env *Environment) lookup(name string) (value complex128, fn *UserFunction, owner *Environment, found bool) {
+	lowerName := strings.ToLower(name)
+	for scope := env; scope != nil; scope = scope.parent {
+		if value, ok := scope.variables[lowerName]; ok {
+			return value, nil, scope, true
+		}
+		if fn, ok := scope.functions[lowerName]; ok {
+			return 0, fn, scope, true
+		}
+	}
+	return 0, nil, nil, false
+}
+
+// Get returns the value of the variable name, if any, which is NaN for vectors. It is safe to
+// call on a nil Environment.
+func (env *Environment) Get(name string) (complex128, bool) {
+	value, fn, _, found := env.lookup(name)
+	return value, found && fn == nil
+}
+
+// Function returns the user function bound to name, if any. It is safe to call on a nil Environment.
+func (env *Environment) Function(name string) (*UserFunction, bool) {
+	_, fn, _, found := env.lookup(name)
+	return fn, found && fn != nil
+}
+
+// IsVariable reports whether name is bound to a variable. It is safe to call on a nil Environment.
+func (env *Environment) IsVariable(name string) bool {
+	_, found := env.Get(name)
+	return found
+}
+
+// IsFunction reports whether name is bound to a user function. It is safe to call on a nil Environment.
+func (env *Environment) IsFunction(name string) bool {
+	_, found := env.Function(name)
+	return found
+}
+
+// Names returns the variable names bound in this scope in alphabetical order.
+func (env *Environment) Names() []string {
+	if env == nil {
+		return nil
+	}
+	return sortedKeys(env.variables)
+}
+
+// FunctionNames returns the user function names bound in this scope in alphabetical order.
+func (env *Environment) FunctionNames() []string {
+	if env == nil {
+		return nil
+	}
+	return sortedKeys(env.functions)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
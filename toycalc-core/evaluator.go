@@ -2,24 +2,135 @@
 package toycalc_core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/cmplx"
+	"slices"
 	"strconv"
 	"strings" // For ToLower on function names
 )
 
-var OutputFormatMode string = "auto" // "auto", "fixed", "sci" (as before)
-var OutputDisplayPrecision int = 9   // Default number of decimal places to round to for display
-
-// CalculateExpression orchestrates Lex, Parse, EvaluateRPN, and formatComplexOutput
+// CalculateExpression evaluates an expression with the default engine and formats the result.
 func CalculateExpression(expressionString string) (string, error) {
-	tokens, err := Lex(expressionString)
+	return defaultEngine.EvaluateExpression(expressionString)
+}
+
+// CalculateExpressionContext is like CalculateExpression, but stops with ctx's error once ctx
+// is cancelled. The default engine applies DefaultLimits.
+func CalculateExpressionContext(ctx context.Context, expressionString string) (string, error) {
+	return defaultEngine.EvaluateExpressionContext(ctx, expressionString)
+}
+
+// calculation holds what one evaluation request needs besides its input: the cancellation
+// context, the display and angle settings, and the resource limits.
+type calculation struct {
+	ctx      context.Context
+	settings Settings
+	limits   Limits
+}
+
+// lex tokenizes input after checking it against the input size, token and nesting limits.
+// Variables in env, and the parameters of a definition like f(n) = 2n, are not read as SI
+// prefixes: with n = 5, 2n is 10 rather than 2e-9.
+func (c calculation) lex(input string, env *Environment) ([]Token, error) {
+	if err := c.limits.checkInput(input); err != nil {
+		return nil, err
+	}
+	variable := func(name string) bool {
+		_, found := env.Get(name)
+		return found
+	}
+	tokens, err := lexLocale(input, c.settings.Locale, variable)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) >= 2 && tokens[0].Type == IDENT && tokens[1].Type == LPAREN {
+		// The head of a definition has no numbers, so the parameters can be read from the
+		// tokens before the body is lexed again with them bound.
+		if params, _, isDefinition, _ := parseDefinitionHead(tokens, env.builtins()); isDefinition {
+			tokens, err = lexLocale(input, c.settings.Locale, func(name string) bool {
+				return slices.Contains(params, strings.ToLower(name)) || variable(name)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := c.limits.checkTokens(tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// evaluation is the result of a statement: its value as stored in variables, the more
+// precise form kept beside it (nil in the default number system), and its display text.
+type evaluation struct {
+	value   complex128
+	precise any
+	text    string
+}
+
+// evaluate evaluates rpn against env after checking its length, spending from a fresh budget,
+// in the number system selected by the settings.
+func (c calculation) evaluate(rpn []Token, env *Environment) (evaluation, error) {
+	if err := c.limits.checkRPN(rpn); err != nil {
+		return evaluation{}, err
+	}
+	budget := newBudget(c.ctx, c.limits)
+	if c.settings.Word > 0 {
+		return evaluateIn(wordNumbers{size: uint(c.settings.Word), signed: !c.settings.Unsigned}, c.settings, budget, rpn, env)
+	}
+	if c.settings.Exact {
+		return evaluateIn(exactNumbers{}, c.settings, budget, rpn, env)
+	}
+	if c.settings.Bits > 0 {
+		return evaluateBig(c.settings, budget, rpn, env)
+	}
+	return evaluateIn(complexNumbers{}, c.settings, budget, rpn, env)
+}
+
+// evaluateIn evaluates rpn in the given number system and formats the result.
+func evaluateIn[T any](numbers numberSystem[T], settings Settings, budget *Budget, rpn []Token, env *Environment) (evaluation, error) {
+	ev := newEvaluator(numbers, settings, budget)
+	result, err := ev.evaluate(rpn, env, 0)
+	if err != nil {
+		return evaluation{}, err
+	}
+	value, precise := ev.stored(result)
+	var text string
+	switch {
+	case result.boolean:
+		text = formatBoolean(ev.truth(result.value))
+	case result.isVector():
+		elements := make([]string, len(result.elements))
+		for i, element := range result.elements {
+			elements[i] = numbers.format(element, settings)
+		}
+		text = formatVector(elements, settings.Locale)
+	default:
+		text = *ev.display
+		if text == "" {
+			text = numbers.format(result.value, settings)
+		}
+	}
+	return evaluation{
+		value:   value,
+		precise: precise,
+		text:    localizeNumbers(text, settings.Locale),
+	}, nil
+}
+
+// calculateExpression orchestrates Lex, Parse, evaluation and formatting of an expression
+// against env.
+func (c calculation) calculateExpression(expressionString string, env *Environment) (string, error) {
+	tokens, err := c.lex(expressionString, env)
 	if err != nil {
 		return "", err
 	}
 
-	rpnQueue, err := Parse(tokens)
+	rpnQueue, err := ParseWithEnvironment(tokens, env)
 	if err != nil {
 		return "", err
 	}
@@ -30,12 +141,92 @@ func CalculateExpression(expressionString string) (string, error) {
 	// }
 	// fmt.Println()
 
-	resultComplex, err := EvaluateRPN(rpnQueue)
+	result, err := c.evaluate(rpnQueue, env)
+	if err != nil {
+		return "", err
+	}
+
+	return result.text, nil
+}
+
+// CalculateStatement is like CalculateExpression but runs against env, so that variables
+// and functions bound there can be used. Statements of the form `name = expression` store
+// their result in env and return it formatted; definitions like `f(x, y) = x^2 + y*i`
+// store the function and return the definition.
+// It applies DefaultSettings and DefaultLimits.
+func CalculateStatement(statement string, env *Environment) (string, error) {
+	return defaultCalculation().calculateStatement(statement, env)
+}
+
+func defaultCalculation() calculation {
+	return calculation{ctx: context.Background(), settings: DefaultSettings(), limits: DefaultLimits()}
+}
+
+func (c calculation) calculateStatement(statement string, env *Environment) (string, error) {
+	tokens, err := c.lex(statement, env)
+	if err != nil {
+		return "", err
+	}
+
+	parsed, err := ParseStatement(tokens, env)
 	if err != nil {
 		return "", err
 	}
 
-	return formatComplexOutput(resultComplex), nil
+	if parsed.IsFunctionDefinition() {
+		if err := c.limits.checkRPN(parsed.RPN); err != nil {
+			return "", err
+		}
+		function := &UserFunction{
+			Name:   parsed.Target,
+			Params: parsed.Params,
+			Body:   parsed.RPN,
+			Source: strings.TrimSpace(statement[parsed.BodyPosition:]),
+		}
+		if err := env.DefineFunction(function); err != nil {
+			return "", err
+		}
+		return function.String(), nil
+	}
+
+	result, err := c.evaluate(parsed.RPN, env)
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.Target != "" {
+		if err := env.Set(parsed.Target, result.value); err != nil {
+			return "", err
+		}
+		if result.precise != nil {
+			env.bind(parsed.Target, result.value, result.precise)
+		}
+	}
+	return result.text, nil
+}
+
+// CalculateScript evaluates a script line by line against env and returns one formatted
+// result per statement. Blank lines and lines starting with '#' are skipped.
+// Evaluation stops at the first failing line; the error reports its line number.
+// Limits apply to each statement separately.
+func CalculateScript(script string, env *Environment) ([]string, error) {
+	return defaultCalculation().calculateScript(script, env)
+}
+
+func (c calculation) calculateScript(script string, env *Environment) ([]string, error) {
+	var results []string
+	for lineIndex, line := range strings.Split(script, "\n") {
+		statement := strings.TrimSpace(line)
+		if statement == "" || strings.HasPrefix(statement, "#") {
+			continue
+		}
+		result, err := c.calculateStatement(statement, env)
+		if err != nil {
+			return results, fmt.Errorf("line %d: %w", lineIndex+1, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
 }
 
 // Helper to round a float64 to a specific number of decimal places for display
@@ -86,7 +277,34 @@ func roundToDecimalPlaces(val float64, places int) float64 {
 	return math.Round(val*scale) / scale
 }
 
+// roundToSignificantDigits rounds val to the given number of significant digits.
+func roundToSignificantDigits(val float64, digits int) float64 {
+	if val == 0 || math.IsNaN(val) || math.IsInf(val, 0) {
+		return val
+	}
+	// Rounding the decimal text avoids scaling by powers of ten that overflow for tiny values.
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(val, 'g', max(digits, 1), 64), 64)
+	return rounded
+}
+
+// formatComplexOutput formats c with the default settings.
 func formatComplexOutput(c complex128) string {
+	return formatComplex(c, DefaultSettings())
+}
+
+// formatComplex formats c according to the output format and precision in settings.
+func formatComplex(c complex128, settings Settings) string {
+	if text, ok := formatComplexInBase(c, settings); ok {
+		return text
+	}
+	switch settings.Format {
+	case "frac":
+		return formatFraction(c, settings)
+	case "polar":
+		return formatPolar(c, settings)
+	case "eng", "si":
+		return formatEngineering(c, settings, settings.Format == "si")
+	}
 	realRaw := real(c)
 	imagRaw := imag(c)
 
@@ -99,9 +317,13 @@ func formatComplexOutput(c complex128) string {
 		return fmt.Sprintf("%v", c) // ... (Inf formatting as before) ...
 	}
 
-	// 2. Apply display rounding based on global/user setting outputDisplayPrecision
-	realVal := roundToDecimalPlaces(realRaw, OutputDisplayPrecision)
-	imagVal := roundToDecimalPlaces(imagRaw, OutputDisplayPrecision)
+	// 2. Apply display rounding based on the user's display precision
+	round := roundToDecimalPlaces
+	if settings.Significant && settings.Format == "auto" {
+		round = roundToSignificantDigits
+	}
+	realVal := round(realRaw, settings.Precision)
+	imagVal := round(imagRaw, settings.Precision)
 
 	// 3. Determine characteristics based on these "display-ready" values using Epsilon
 	//    Epsilon here is for comparing these already-rounded numbers to perfect zero or integer.
@@ -109,22 +331,32 @@ func formatComplexOutput(c complex128) string {
 	imagIsZero := isEffectivelyZero(imagVal, Epsilon)
 	realIsInt := isEffectivelyInteger(realVal, Epsilon)
 	imagIsInt := isEffectivelyInteger(imagVal, Epsilon)
+	if settings.Significant && settings.Format == "auto" {
+		// Rounding kept tiny parts, so comparing them with Epsilon would turn 1e-12 into 0:
+		// a part is only zero if it is negligible next to the other part. Both parts are
+		// printed with %g, which shows integers without decimals by itself.
+		realIsZero = realVal == 0 || math.Abs(realVal) < Epsilon*math.Abs(imagVal)
+		imagIsZero = imagVal == 0 || math.Abs(imagVal) < Epsilon*math.Abs(realVal)
+		realIsInt, imagIsInt = false, false
+	}
 
-	// 4. Format based on outputFormatMode (auto, fixed, sci) and outputDisplayPrecision
-	var realStr /*imagStr,*/, imagSignStr, imagMagStr string
+	// 4. Format based on settings.Format (auto, fixed, sci) and settings.Precision
+	var realStr /*imagStr,*/, imagMagStr string
 
 	// --- Format Real Part ---
-	switch OutputFormatMode {
+	switch settings.Format {
 	case "fixed":
-		realStr = fmt.Sprintf("%.*f", OutputDisplayPrecision, realVal)
+		realStr = fmt.Sprintf("%.*f", settings.Precision, realVal)
 	case "sci":
-		realStr = fmt.Sprintf("%.*e", OutputDisplayPrecision, realVal)
+		realStr = fmt.Sprintf("%.*e", settings.Precision, realVal)
 	default: // "auto"
 		if realIsZero && imagIsZero {
 			return "0"
 		} // Handle 0+0i early
 		if realIsInt {
 			realStr = fmt.Sprintf("%.0f", realVal)
+		} else if settings.Significant {
+			realStr = fmt.Sprintf("%.*g", settings.Precision, realVal)
 		} else {
 			realStr = fmt.Sprintf("%g", realVal)
 		}
@@ -135,42 +367,44 @@ func formatComplexOutput(c complex128) string {
 		absImagVal := math.Abs(imagVal)
 		isImagMagOne := isEffectivelyZero(absImagVal-1.0, Epsilon)
 
-		switch OutputFormatMode {
+		switch settings.Format {
 		case "fixed":
-			imagMagStr = fmt.Sprintf("%.*f", OutputDisplayPrecision, absImagVal)
+			imagMagStr = fmt.Sprintf("%.*f", settings.Precision, absImagVal)
 		case "sci":
-			imagMagStr = fmt.Sprintf("%.*e", OutputDisplayPrecision, absImagVal)
+			imagMagStr = fmt.Sprintf("%.*e", settings.Precision, absImagVal)
 		default: // "auto"
 			if isImagMagOne {
 				imagMagStr = "" // For "i"
 			} else if imagIsInt {
 				imagMagStr = fmt.Sprintf("%.0f", absImagVal)
+			} else if settings.Significant {
+				imagMagStr = fmt.Sprintf("%.*g", settings.Precision, absImagVal)
 			} else {
 				imagMagStr = fmt.Sprintf("%g", absImagVal)
 			}
 		}
-
-		if imagVal < -Epsilon { // Check against -Epsilon for robust sign
-			imagSignStr = "-"
-		} else {
-			imagSignStr = "+"
-		}
 	}
 
 	// --- Assemble Output ---
 	if imagIsZero {
 		return realStr // Purely real (or 0+0i was handled)
 	}
+	return joinComplex(realStr, imagMagStr, realIsZero, imagVal < 0, isEffectivelyZero(math.Abs(imagVal)-1.0, Epsilon))
+}
 
+// joinComplex assembles a number with a non-zero imaginary part from the text of its real
+// part and of the magnitude of its imaginary part, e.g. "3 - 2i", "3 + i", "2i" or "-i".
+func joinComplex(realStr, imagMagStr string, realIsZero, imagNegative, imagIsOne bool) string {
 	if realIsZero { // Purely imaginary
-		if isEffectivelyZero(math.Abs(imagVal)-1.0, Epsilon) { // Is magnitude 1?
-			return Ternary(imagVal < 0, "-i", "i")
+		if imagIsOne {
+			return Ternary(imagNegative, "-i", "i")
 		}
-		return fmt.Sprintf("%s%si", Ternary(imagVal < 0, "-", ""), imagMagStr)
+		return fmt.Sprintf("%s%si", Ternary(imagNegative, "-", ""), imagMagStr)
 	}
 
 	// Full complex number
-	if isEffectivelyZero(math.Abs(imagVal)-1.0, Epsilon) { // Is magnitude of imag 1?
+	imagSignStr := Ternary(imagNegative, "-", "+")
+	if imagIsOne {
 		return fmt.Sprintf("%s %s i", realStr, imagSignStr)
 	}
 	return fmt.Sprintf("%s %s %si", realStr, imagSignStr, imagMagStr)
@@ -182,7 +416,7 @@ func calculateModulo(a, b complex128, operatorToken Token) (complex128, error) {
 	if b == complex(0, 0) {
 		// Using cmplx.Abs to catch very small numbers that might behave like zero
 		// } else if cmplx.Abs(b) < Epsilon*Epsilon { // Avoid Epsilon itself if b could be Epsilon
-		return complex(math.NaN(), math.NaN()), NewCalculationError(
+		return complex(math.NaN(), math.NaN()), newTokenError(KindDomain, operatorToken,
 			fmt.Sprintf("divisor is zero for modulo operator at position %d", operatorToken.Position),
 		)
 	}
@@ -200,195 +434,347 @@ func calculateModulo(a, b complex128, operatorToken Token) (complex128, error) {
 
 // EvaluateRPN evaluates a token queue in Reverse Polish Notation
 func EvaluateRPN(rpnQueue []Token) (complex128, error) {
-	operandStack := []complex128{}
+	return EvaluateRPNWithEnvironment(rpnQueue, nil)
+}
 
-	for _, token := range rpnQueue {
+// EvaluateRPNWithEnvironment is like EvaluateRPN, but identifiers that are not built-in
+// constants or functions are looked up as variables or user functions in env (which may be nil).
+func EvaluateRPNWithEnvironment(rpnQueue []Token, env *Environment) (complex128, error) {
+	settings := DefaultSettings()
+	ev := evaluator[complex128]{numbers: complexNumbers{}, angleMode: settings.AngleMode, tolerance: settings.Tolerance}
+	result, err := ev.evaluate(rpnQueue, env, 0)
+	if err == nil && result.isVector() {
+		err = newKindError(KindDomain, "the expression is a vector, not a number; use CalculateExpression to format it")
+	}
+	if err != nil {
+		return complex(math.NaN(), math.NaN()), err
+	}
+	return result.value, nil
+}
+
+// evaluator holds the settings that affect evaluation (as opposed to display) while an
+// RPN queue is evaluated, the number system the values live in, and the budget every step
+// is charged to (nil for no limits).
+type evaluator[T any] struct {
+	numbers   numberSystem[T]
+	angleMode AngleMode
+	tolerance float64 // Relative tolerance of '==' and the other comparisons
+	budget    *Budget
+	display   *string // Receives the text of a described call that is the whole expression; may be nil
+}
+
+func newEvaluator[T any](numbers numberSystem[T], settings Settings, budget *Budget) evaluator[T] {
+	return evaluator[T]{numbers: numbers, angleMode: settings.AngleMode, tolerance: settings.Tolerance, budget: budget, display: new(string)}
+}
+
+// stopsEvaluation reports whether err comes from a limit or a cancelled context; such
+// errors are passed on unchanged so that callers can detect them.
+func stopsEvaluation(err error) bool {
+	return errors.Is(err, ErrLimitExceeded) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// atPosition fills in the position of a LimitError raised without one.
+func atPosition(err error, position int) error {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) && limitErr.Position < 0 {
+		limitErr.Position = position
+	}
+	return err
+}
+
+// callBuiltin applies a registered function to args, converting angles from and to degrees
+// when the angle mode asks for it.
+func (ev evaluator[T]) callBuiltin(function FunctionDef, args []T, token Token) (T, error) {
+	var result T
+	var err error
+	if ev.angleMode == AngleDegrees && function.Angles == AngleArgument {
+		args[len(args)-1], err = ev.numbers.degreesToRadians(args[len(args)-1])
+	}
+	if err == nil {
+		result, err = ev.numbers.call(function, args, ev.budget)
+	}
+	if err == nil && ev.angleMode == AngleDegrees && function.Angles == AngleResult {
+		result, err = ev.numbers.radiansToDegrees(result)
+	}
+	if err != nil {
+		return result, builtinError(token, err)
+	}
+	return result, nil
+}
+
+// builtinError turns an error of the built-in function called by token into an error pointing
+// at the call.
+func builtinError(token Token, err error) error {
+	var calcErr *CalculationError
+	if stopsEvaluation(err) {
+		return atPosition(err, token.Position)
+	} else if !errors.As(err, &calcErr) {
+		return newTokenError(KindDomain, token, fmt.Sprintf("function '%s' at position %d: %v", token.Literal, token.Position, err))
+	}
+	return err
+}
+
+// describe stores the display text of result, the result of a call to function that is the
+// whole expression: its description, or the exact value of an integer result that the
+// default number system rounds.
+func (ev evaluator[T]) describe(function FunctionDef, args []operand[T], result operand[T], token Token) error {
+	if function.describe == nil {
+		if result.integer != nil && !result.integer.fitsFloat() {
+			*ev.display = result.integer.String()
+		}
+		return nil
+	}
+	integers, err := ev.integerArguments(args, token)
+	if err != nil {
+		return err
+	}
+	text, err := function.describe(ev.budget, integers)
+	if err != nil {
+		return builtinError(token, err)
+	}
+	*ev.display = text
+	return nil
+}
+
+// callUserFunction evaluates the body of fn with its parameters bound to args in a new scope
+// on top of owner, the environment fn was defined in. depth is the depth of the call itself.
+func (ev evaluator[T]) callUserFunction(fn *UserFunction, owner *Environment, args []operand[T], token Token, depth int) (operand[T], error) {
+	var nothing operand[T]
+	if len(args) != fn.Arity() {
+		return nothing, newTokenError(KindArity, token,
+			fmt.Sprintf("function '%s' expects %d argument(s) but got %d at position %d", token.Literal, fn.Arity(), len(args), token.Position),
+		)
+	}
+	if depth > MaxCallDepth {
+		return nothing, newTokenError(KindLimit, token,
+			fmt.Sprintf("maximum call depth of %d exceeded in function '%s' at position %d (infinite recursion?)", MaxCallDepth, token.Literal, token.Position),
+		)
+	}
+	frame := owner.NewChild()
+	for i, param := range fn.Params {
+		value, precise := ev.stored(args[i])
+		frame.bind(param, value, precise)
+	}
+	result, err := ev.evaluate(fn.Body, frame, depth)
+	if err != nil && depth == 1 {
+		err = atCall(err, token)
+	}
+	return result, err
+}
+
+// atCall points an error raised in the body of a user function at the call token in the
+// input: positions in the body are not positions in the input.
+func atCall(err error, token Token) error {
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		limitErr.Position = token.Position
+		return err
+	}
+	var calcErr *CalculationError
+	if !errors.As(err, &calcErr) || !calcErr.HasSpan() {
+		return err
+	}
+	return newTokenError(calcErr.Kind, token, fmt.Sprintf("%s, in function '%s' called at position %d", calcErr.Message, token.Literal, token.Position))
+}
+
+// variable returns the value of the variable name bound in owner, preferring the value kept
+// at the precision of the number system, if any. Booleans are kept as Go bools, and the
+// exact values of integers that the default number system rounds as gaussianInteger.
+func (ev evaluator[T]) variable(owner *Environment, name string, value complex128) (operand[T], error) {
+	if truth, found := owner.precise[name].(bool); found {
+		return ev.boolean(truth)
+	}
+	if vector, found := owner.precise[name].(storedVector); found {
+		return ev.restore(vector)
+	}
+	if precise, found := owner.precise[name].(T); found {
+		return operand[T]{value: precise}, nil
+	}
+	val, err := ev.numbers.fromComplex(value)
+	if integer, found := owner.precise[name].(gaussianInteger); found && ev.roundsIntegers() {
+		return operand[T]{value: val, integer: &integer}, err
+	}
+	return operand[T]{value: val}, err
+}
+
+// evaluate is the evaluation loop behind EvaluateRPN. depth counts the user function
+// calls currently in progress. On error the returned value is meaningless.
+func (ev evaluator[T]) evaluate(rpnQueue []Token, env *Environment, depth int) (operand[T], error) {
+	var nothing operand[T]
+	operandStack := []operand[T]{}
+	lazy := lazyOperations(rpnQueue, env.builtins())
+
+	for index := 0; index < len(rpnQueue); index++ {
+		token := rpnQueue[index]
+		if err := ev.budget.Spend(1); err != nil {
+			return nothing, atPosition(err, token.Position)
+		}
+		if operation, found := lazy[index]; found {
+			// and, or and if evaluate only the operands that decide their result.
+			result, err := ev.evaluateLazily(operation, rpnQueue[operation.end], env, depth, operation.end == len(rpnQueue)-1)
+			if err != nil {
+				return nothing, err
+			}
+			operandStack = append(operandStack, result)
+			index = operation.end
+			continue
+		}
 		switch token.Type {
 		case NUMBER:
-			// The lexer ensures number literals are in a format ParseFloat can handle (incl. scientific)
-			val, err := strconv.ParseFloat(token.Literal, 64)
+			val, err := ev.numbers.number(token)
+			var calcErr *CalculationError
+			if errors.As(err, &calcErr) { // A valid literal the number system cannot hold
+				return nothing, err
+			}
 			if err != nil {
-				// This error should ideally be caught by the lexer if the number format is truly bad,
-				// but strconv.ParseFloat is the ultimate validator.
-				return complex(math.NaN(), math.NaN()), NewCalculationError(
+				// The lexer should only produce valid literals, but the number system is the
+				// ultimate validator.
+				return nothing, newTokenError(KindLex, token,
 					fmt.Sprintf("invalid number format '%s' at position %d", token.Literal, token.Position),
 				)
 			}
-			operandStack = append(operandStack, complex(val, 0))
+			number := operand[T]{value: val}
+			if ev.roundsIntegers() {
+				number.integer = literalInteger(token.Literal, ev.numbers.toComplex(val))
+			}
+			operandStack = append(operandStack, number)
 
 		case IDENT:
-			var result complex128
-			processed := false // To track if the IDENT was handled
-
 			lowerLiteral := strings.ToLower(token.Literal)
-			switch lowerLiteral {
-			// Constants
-			case "i":
-				result = complex(0, 1)
-				operandStack = append(operandStack, result)
-				processed = true
-			case "pi":
-				result = complex(math.Pi, 0)
-				operandStack = append(operandStack, result)
-				processed = true
-			case "e":
-				result = complex(math.E, 0)
-				operandStack = append(operandStack, result)
-				processed = true
-
-			// Stage 1 & 2 Functions (all unary for now)
-			case "log", "exp", "sin", "cos", "tan", "asin", "acos", "atan",
-				"sinh", "cosh", "tanh", "asinh", "acosh", "atanh",
-				"log10", "log2", "sqrt", "real", "imag", "abs", "phase",
-				"conj", "degtorad", "radtodeg", "floor", "ceil", "round", "trunc":
-				if len(operandStack) < 1 {
-					return complex(math.NaN(), math.NaN()), NewCalculationError(
-						fmt.Sprintf("insufficient operands for function '%s' at position %d (expected 1)",
-							token.Literal, token.Position),
-					)
-				}
-				arg1 := operandStack[len(operandStack)-1]
-				operandStack = operandStack[:len(operandStack)-1] // Pop one argument
-
-				switch lowerLiteral { // Inner switch for function logic
-				case "log":
-					result = cmplx.Log(arg1)
-				case "exp":
-					result = cmplx.Exp(arg1)
-				case "sin":
-					result = cmplx.Sin(arg1)
-				case "cos":
-					result = cmplx.Cos(arg1)
-				case "tan":
-					result = cmplx.Tan(arg1)
-				case "asin":
-					result = cmplx.Asin(arg1)
-				case "acos":
-					result = cmplx.Acos(arg1)
-				case "atan":
-					result = cmplx.Atan(arg1)
-				case "sinh":
-					result = cmplx.Sinh(arg1)
-				case "cosh":
-					result = cmplx.Cosh(arg1)
-				case "tanh":
-					result = cmplx.Tanh(arg1)
-				case "asinh":
-					result = cmplx.Asinh(arg1)
-				case "acosh":
-					result = cmplx.Acosh(arg1)
-				case "atanh":
-					result = cmplx.Atanh(arg1)
-				case "log10":
-					result = cmplx.Log10(arg1)
-				case "log2":
-					result = cmplx.Log(arg1) / cmplx.Log(complex(2, 0))
-				case "sqrt":
-					result = cmplx.Sqrt(arg1)
-				case "real":
-					result = complex(real(arg1), 0.0)
-				case "imag":
-					result = complex(imag(arg1), 0.0)
-				case "abs":
-					result = complex(cmplx.Abs(arg1), 0.0)
-				case "phase":
-					result = complex(cmplx.Phase(arg1), 0.0)
-				case "conj":
-					result = cmplx.Conj(arg1)
-				case "degtorad":
-					result = arg1 * complex(math.Pi/180.0, 0.0)
-				case "radtodeg":
-					result = arg1 * complex(180.0/math.Pi, 0.0)
-				case "floor":
-					result = complex(math.Floor(real(arg1)), math.Floor(imag(arg1)))
-				case "ceil":
-					result = complex(math.Ceil(real(arg1)), math.Ceil(imag(arg1)))
-				case "trunc":
-					result = complex(math.Trunc(real(arg1)), math.Trunc(imag(arg1)))
-				case "round":
-					result = complex(math.Round(real(arg1)), math.Round(imag(arg1)))
+			registry := env.builtins()
+			if constant, found := registry.Constant(lowerLiteral); found {
+				val, err := ev.numbers.constant(constant)
+				if err != nil {
+					return nothing, newTokenError(KindDomain, token, fmt.Sprintf("constant '%s' at position %d: %v", token.Literal, token.Position, err))
 				}
+				operandStack = append(operandStack, operand[T]{value: val, boolean: constant.boolean})
+				continue
+			}
 
-				operandStack = append(operandStack, result)
-				processed = true
-			} // End inner switch for function/constant names
-
-			if !processed { // If IDENT was not a known constant or function
-				return complex(math.NaN(), math.NaN()), NewCalculationError(
+			function, isBuiltin := registry.Function(lowerLiteral)
+			value, userFunction, owner, found := env.lookup(lowerLiteral)
+			if !isBuiltin && !found {
+				return nothing, newUnknownIdentifierError(token,
 					fmt.Sprintf("unknown identifier '%s' encountered during evaluation at position %d", token.Literal, token.Position),
+					env,
 				)
 			}
+			if !isBuiltin && userFunction == nil { // User variable
+				val, err := ev.variable(owner, lowerLiteral, value)
+				if err != nil {
+					return nothing, newTokenError(KindDomain, token, fmt.Sprintf("variable '%s' at position %d: %v", token.Literal, token.Position, err))
+				}
+				operandStack = append(operandStack, val)
+				continue
+			}
 
-		case PLUS, MINUS, ASTERISK, SLASH, PERCENT, CARET, UNARY_MINUS: // Add UNARY_MINUS
-			var op1, op2 complex128 // op1 is not used for unary
-			var numOperandsNeeded int
-
-			if token.Type == UNARY_MINUS {
-				numOperandsNeeded = 1
+			// Function call: pop the arguments counted by the parser.
+			if isBuiltin {
+				if err := registry.checkArity(token); err != nil {
+					return nothing, err
+				}
+			}
+			argCount := max(token.Arity, 1)
+			if len(operandStack) < argCount {
+				return nothing, newTokenError(KindParse, token,
+					fmt.Sprintf("insufficient operands for function '%s' at position %d (expected %d)",
+						token.Literal, token.Position, argCount),
+				)
+			}
+			operands := make([]operand[T], argCount)
+			copy(operands, operandStack[len(operandStack)-argCount:])
+			operandStack = operandStack[:len(operandStack)-argCount] // Pop the arguments
+
+			var result operand[T]
+			var err error
+			if isBuiltin && function.vector != notVector {
+				result, err = ev.callVector(registry, function, operands, token)
+			} else if isBuiltin {
+				result, err = ev.callNumbers(function, operands, token)
+				if err == nil && !result.isVector() && ev.display != nil && depth == 0 && index == len(rpnQueue)-1 {
+					err = ev.describe(function, operands, result, token)
+				}
 			} else {
-				numOperandsNeeded = 2
+				result, err = ev.callUserFunction(userFunction, owner, operands, token, depth+1)
+			}
+			if err != nil {
+				return nothing, err
 			}
+			operandStack = append(operandStack, result)
 
+		case PLUS, MINUS, ASTERISK, SLASH, SLASH_SLASH, PERCENT, CARET, ANGLE, AMPERSAND, PIPE, XOR, SHIFT_LEFT, SHIFT_RIGHT,
+			EQUAL, NOT_EQUAL, LESS, LESS_EQUAL, GREATER, GREATER_EQUAL, AND, OR,
+			UNARY_MINUS, TILDE, NOT, DEGREE, FACTORIAL, DOUBLE_FACTORIAL:
+			numOperandsNeeded := 2
+			if token.Type == UNARY_MINUS || token.Type == TILDE || token.Type == NOT || token.Type == DEGREE || token.Type == FACTORIAL || token.Type == DOUBLE_FACTORIAL {
+				numOperandsNeeded = 1
+			}
 			if len(operandStack) < numOperandsNeeded {
-				return complex(math.NaN(), math.NaN()), NewCalculationError(
+				return nothing, newTokenError(KindParse, token,
 					fmt.Sprintf("insufficient operands for operator '%s' (type %s) at position %d", token.Literal, token.Type, token.Position),
 				)
 			}
 
-			if numOperandsNeeded == 2 {
-				op2 = operandStack[len(operandStack)-1]
-				op1 = operandStack[len(operandStack)-2]
-				operandStack = operandStack[:len(operandStack)-2]
-			} else { // Unary
-				op2 = operandStack[len(operandStack)-1] // Unary op acts on op2
-				operandStack = operandStack[:len(operandStack)-1]
-			}
-
-			var result complex128
+			var result operand[T]
 			var opErr error
-
+			// Arithmetic applies to each element of vectors; logic and comparisons take numbers.
+			operands := operandStack[len(operandStack)-numOperandsNeeded:]
+			operandStack = operandStack[:len(operandStack)-numOperandsNeeded]
+			logical := token.Type == NOT || token.Type == AND || token.Type == OR || isComparison(token.Type)
+			if logical && (operands[0].isVector() || operands[len(operands)-1].isVector()) {
+				return nothing, vectorError(token)
+			}
 			switch token.Type {
-			case PLUS:
-				result = op1 + op2
-			case MINUS:
-				result = op1 - op2
-			case ASTERISK:
-				result = op1 * op2
-			case SLASH:
-				result = op1 / op2
-			case PERCENT:
-				result, opErr = calculateModulo(op1, op2, token)
-				if opErr != nil {
-					return complex(math.NaN(), math.NaN()), opErr
-				}
-			case CARET:
-				result = cmplx.Pow(op1, op2)
-				//fmt.Printf("(%v)^(%v) = %v\n", op1, op2, result)
 			case UNARY_MINUS:
-				// op2 is the single operand for unary minus (e.g., the '4' in '-4')
-				tempRes := -op2 // Perform the negation, e.g., -(4+0i) -> (-4-0i)
-
-				// Normalize signed zeros in the result of this UNARY_MINUS operation.
-				// This ensures that if the user types "-N" (N positive real),
-				// it's treated as complex(-N, +0.0) for subsequent operations
-				// like Pow, aligning with the standard branch cut convention for Log.
-				r := real(tempRes)
-				i := imag(tempRes)
-
-				if r == 0.0 { // This normalizes -0.0 real to +0.0 real
-					r = 0.0 // Assigning 0.0 defaults to +0.0
+				result, opErr = elementwise(operands[0], func(x T) (T, error) { return ev.numbers.negate(x), nil })
+			case TILDE:
+				result, opErr = elementwise(operands[0], func(x T) (T, error) { return ev.numbers.complement(token, x) })
+			case NOT:
+				result, opErr = ev.boolean(!ev.truth(operands[0].value))
+			case FACTORIAL, DOUBLE_FACTORIAL:
+				result, opErr = elementwise(operands[0], func(x T) (T, error) { return ev.numbers.factorial(token, x, ev.budget) })
+			case DEGREE:
+				// 30° is an angle in the angle mode's unit: 30 in degree mode, pi/6 in radians.
+				result = operand[T]{value: operands[0].value, elements: operands[0].elements}
+				if ev.angleMode == AngleRadians {
+					if result, opErr = elementwise(operands[0], ev.numbers.degreesToRadians); opErr != nil {
+						opErr = operatorError(token, opErr)
+					}
 				}
-				if i == 0.0 { // This normalizes -0.0 imag to +0.0 imag
-					i = 0.0 // Assigning 0.0 defaults to +0.0
+			case AND, OR: // Only reached if the queue could not be split into operands; see lazyOperations
+				left, right := ev.truth(operands[0].value), ev.truth(operands[1].value)
+				if token.Type == AND {
+					result, opErr = ev.boolean(left && right)
+				} else {
+					result, opErr = ev.boolean(left || right)
 				}
-				result = complex(r, i)
+			case EQUAL, NOT_EQUAL, LESS, LESS_EQUAL, GREATER, GREATER_EQUAL:
+				var holds bool
+				if holds, opErr = ev.numbers.compare(token, operands[0].value, operands[1].value, ev.tolerance); opErr == nil {
+					result, opErr = ev.boolean(holds)
+				}
+			default:
+				result, opErr = elementwise2(token, operands[0], operands[1], func(op1, op2 T) (T, error) {
+					if token.Type == ANGLE && ev.angleMode == AngleDegrees {
+						// The number systems take phasor angles in radians
+						var err error
+						if op2, err = ev.numbers.degreesToRadians(op2); err != nil {
+							return op2, operatorError(token, err)
+						}
+					}
+					return ev.numbers.operate(token, op1, op2)
+				})
+			}
+			if opErr != nil {
+				return nothing, opErr
 			}
+			ev.trackInteger(token, operands, &result)
 			operandStack = append(operandStack, result)
 
 		default:
 			// This should not be reached if the RPN queue is well-formed by the parser
 			// and contains only known token types for evaluation.
-			return complex(math.NaN(), math.NaN()), NewCalculationError(
+			return nothing, newTokenError(KindParse, token,
 				fmt.Sprintf("unexpected token type '%s' in RPN queue (token: '%s' at pos %d)", token.Type, token.Literal, token.Position),
 			)
 		}
@@ -401,11 +787,11 @@ func EvaluateRPN(rpnQueue []Token) (complex128, error) {
 		// This could happen if the RPN queue was empty (e.g. empty input string,
 		// though Parse should catch this) or an operator consumed all operands
 		// without producing a result (which shouldn't happen with correct logic).
-		return complex(math.NaN(), math.NaN()), NewCalculationError("invalid expression: no result on stack (empty RPN or malformed expression)")
+		return nothing, newKindError(KindParse, "invalid expression: no result on stack (empty RPN or malformed expression)")
 	} else {
 		// More than one value on the stack means the expression was malformed,
 		// typically too many numbers or too few operators.
-		return complex(math.NaN(), math.NaN()), NewCalculationError(
+		return nothing, newKindError(KindParse,
 			fmt.Sprintf("invalid expression: %d values left on stack, expected 1 (check operators and operands)", len(operandStack)),
 		)
 	}
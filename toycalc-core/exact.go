@@ -0,0 +1,455 @@
+// exact.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ApproximateMarker precedes results of exact mode that could not be computed exactly, and
+// results the 'frac' format could not show as fractions.
+const ApproximateMarker = "≈ "
+
+// maxExactBits bounds the size, in bits of numerator plus denominator, of each part of an
+// exact value. Results that would be larger, like 3^100000, are approximated instead.
+const maxExactBits = 1 << 16
+
+// maxExactExponent bounds the decimal exponent of number literals kept exact.
+const maxExactExponent = 4096
+
+// gaussianRational is an exact complex number re + im*i with rational parts.
+type gaussianRational struct {
+	re, im *big.Rat
+}
+
+// exactImpl computes a function exactly in exact mode. It returns false when the result is
+// not a Gaussian rational, in which case the float implementation is used instead.
+type exactImpl func(args []gaussianRational) (gaussianRational, bool)
+
+// exactValue is a value in exact mode: a Gaussian rational or, once an operation could not
+// be carried out exactly, a complex128 approximation. Approximations are contagious.
+type exactValue struct {
+	exact       gaussianRational // The value, unless approximate
+	approximate bool
+	value       complex128 // The value, if approximate
+}
+
+// exactNumbers is the number system of exact mode.
+type exactNumbers struct{}
+
+func approximate(c complex128) exactValue {
+	return exactValue{approximate: true, value: c}
+}
+
+func exactOf(re, im *big.Rat) exactValue {
+	return exactValue{exact: gaussianRational{re, im}}
+}
+
+func (n exactNumbers) number(token Token) (exactValue, error) {
+	text := numberText(token.Literal)
+	if index := strings.IndexAny(text, "eE"); index >= 0 {
+		if exponent, err := strconv.Atoi(text[index+1:]); err != nil || exponent > maxExactExponent || exponent < -maxExactExponent {
+			value, err := complexNumbers{}.number(token)
+			return approximate(value), err
+		}
+	}
+	value, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return exactValue{}, fmt.Errorf("invalid number '%s'", token.Literal)
+	}
+	return exactOf(value, new(big.Rat)), nil
+}
+
+func (n exactNumbers) constant(def ConstantDef) (exactValue, error) {
+	if def.exact {
+		return exactOf(new(big.Rat).SetFloat64(real(def.Value)), new(big.Rat).SetFloat64(imag(def.Value))), nil
+	}
+	return approximate(def.Value), nil
+}
+
+func (n exactNumbers) fromComplex(c complex128) (exactValue, error) { return approximate(c), nil }
+
+func (n exactNumbers) toComplex(x exactValue) complex128 {
+	if x.approximate {
+		return x.value
+	}
+	re, _ := x.exact.re.Float64()
+	im, _ := x.exact.im.Float64()
+	return complex(re, im)
+}
+
+func (n exactNumbers) precise(x exactValue) any { return x }
+
+// limited approximates x if one of its parts has grown beyond maxExactBits.
+func (n exactNumbers) limited(x exactValue) exactValue {
+	if !x.approximate && (ratBits(x.exact.re) > maxExactBits || ratBits(x.exact.im) > maxExactBits) {
+		return approximate(n.toComplex(x))
+	}
+	return x
+}
+
+func (n exactNumbers) operate(op Token, a, b exactValue) (exactValue, error) {
+	if a.approximate || b.approximate {
+		result, err := complexNumbers{}.operate(op, n.toComplex(a), n.toComplex(b))
+		return approximate(result), err
+	}
+	x, y := a.exact, b.exact
+	var result gaussianRational
+	switch op.Type {
+	case PLUS:
+		result = gaussianRational{new(big.Rat).Add(x.re, y.re), new(big.Rat).Add(x.im, y.im)}
+	case MINUS:
+		result = gaussianRational{new(big.Rat).Sub(x.re, y.re), new(big.Rat).Sub(x.im, y.im)}
+	case ASTERISK:
+		result = gaussianMul(x, y)
+	case SLASH:
+		if y.isZero() {
+			return exactValue{}, operatorError(op, errDivisionByZero)
+		}
+		result = gaussianQuo(x, y)
+	case SLASH_SLASH:
+		if y.isZero() {
+			return exactValue{}, operatorError(op, errDivisionByZero)
+		}
+		quotient := gaussianQuo(x, y)
+		result = gaussianRational{ratFloor(quotient.re), ratFloor(quotient.im)}
+	case PERCENT:
+		if y.isZero() {
+			return exactValue{}, newTokenError(KindDomain, op,
+				fmt.Sprintf("divisor is zero for modulo operator at position %d", op.Position),
+			)
+		}
+		// r = a - q*b, where q is the Gaussian integer closest to a/b (see calculateModulo).
+		quotient := gaussianQuo(x, y)
+		nearest := gaussianRational{ratRound(quotient.re), ratRound(quotient.im)}
+		product := gaussianMul(nearest, y)
+		result = gaussianRational{new(big.Rat).Sub(x.re, product.re), new(big.Rat).Sub(x.im, product.im)}
+	case CARET:
+		power, ok, err := gaussianPow(x, y)
+		if err != nil {
+			return exactValue{}, operatorError(op, err)
+		}
+		if !ok {
+			result, err := complexNumbers{}.operate(op, n.toComplex(a), n.toComplex(b))
+			return approximate(result), err
+		}
+		result = power
+	case ANGLE:
+		if !y.isZero() { // Only r∠0 is exact
+			result, err := complexNumbers{}.operate(op, n.toComplex(a), n.toComplex(b))
+			return approximate(result), err
+		}
+		result = x
+	case AMPERSAND, PIPE, XOR, SHIFT_LEFT, SHIFT_RIGHT:
+		if !x.isInteger() {
+			return exactValue{}, bitwiseOperandError(op, n.toComplex(a))
+		}
+		if !y.isInteger() {
+			return exactValue{}, bitwiseOperandError(op, n.toComplex(b))
+		}
+		integer, err := integerOperate(op, x.re.Num(), y.re.Num())
+		if err != nil {
+			return exactValue{}, err
+		}
+		result = gaussianRational{new(big.Rat).SetInt(integer), new(big.Rat)}
+	default:
+		return exactValue{}, newTokenError(KindParse, op, fmt.Sprintf("unexpected operator '%s' at position %d", op.Literal, op.Position))
+	}
+	return n.limited(exactValue{exact: result}), nil
+}
+
+func (n exactNumbers) negate(x exactValue) exactValue {
+	if x.approximate {
+		return approximate(complexNumbers{}.negate(x.value))
+	}
+	return exactOf(new(big.Rat).Neg(x.exact.re), new(big.Rat).Neg(x.exact.im))
+}
+
+// compare compares exact values exactly, ignoring the tolerance; approximations are compared
+// like in the default number system.
+func (n exactNumbers) compare(op Token, a, b exactValue, tolerance float64) (bool, error) {
+	if a.approximate || b.approximate {
+		return complexNumbers{}.compare(op, n.toComplex(a), n.toComplex(b), tolerance)
+	}
+	x, y := a.exact, b.exact
+	equal := x.re.Cmp(y.re) == 0 && x.im.Cmp(y.im) == 0
+	return comparison(op, equal, func() (int, error) {
+		if !x.isReal() {
+			return 0, orderingOperandError(op, n.toComplex(a))
+		}
+		if !y.isReal() {
+			return 0, orderingOperandError(op, n.toComplex(b))
+		}
+		return x.re.Cmp(y.re), nil
+	})
+}
+
+func (n exactNumbers) complement(op Token, x exactValue) (exactValue, error) {
+	if x.approximate {
+		result, err := complexNumbers{}.complement(op, x.value)
+		return approximate(result), err
+	}
+	if !x.exact.isInteger() {
+		return exactValue{}, bitwiseOperandError(op, n.toComplex(x))
+	}
+	integer := new(big.Int).Not(x.exact.re.Num())
+	return exactOf(new(big.Rat).SetInt(integer), new(big.Rat)), nil
+}
+
+// factorial is exact for integers up to maxExactFactorial and approximate otherwise.
+func (n exactNumbers) factorial(op Token, x exactValue, budget *Budget) (exactValue, error) {
+	if !x.approximate && x.exact.isInteger() {
+		integer := x.exact.re.Num()
+		if factorialPole(op, integer) {
+			return exactValue{}, errFactorialPole(op, integer)
+		}
+		if integer.CmpAbs(big.NewInt(maxExactFactorial)) <= 0 {
+			result, err := integerFactorial(op, integer, budget)
+			if err != nil {
+				return exactValue{}, err
+			}
+			return n.limited(exactOf(result, new(big.Rat))), nil
+		}
+	}
+	result, err := complexFactorial(op, n.toComplex(x), budget)
+	return approximate(result), err
+}
+
+func (n exactNumbers) call(function FunctionDef, args []exactValue, budget *Budget) (exactValue, error) {
+	if function.integerImpl != nil {
+		// Arguments that are not Gaussian integers are left to the float implementation,
+		// which reports them.
+		integers := make([]gaussianInteger, 0, len(args))
+		for _, arg := range args {
+			z, ok := rationalGaussian(arg.exact)
+			if arg.approximate || !ok {
+				break
+			}
+			integers = append(integers, z)
+		}
+		if len(integers) == len(args) {
+			result, err := function.integerImpl(budget, integers)
+			if err != nil {
+				return exactValue{}, err
+			}
+			return n.limited(exactValue{exact: result.toRational()}), nil
+		}
+	}
+	if function.exactImpl != nil {
+		exactArgs := make([]gaussianRational, 0, len(args))
+		for _, arg := range args {
+			if arg.approximate {
+				break
+			}
+			exactArgs = append(exactArgs, arg.exact)
+		}
+		if len(exactArgs) == len(args) {
+			if result, ok := function.exactImpl(exactArgs); ok {
+				return n.limited(exactValue{exact: result}), nil
+			}
+		}
+	}
+	floats := make([]complex128, len(args))
+	for i, arg := range args {
+		floats[i] = n.toComplex(arg)
+	}
+	result, err := complexNumbers{}.call(function, floats, budget)
+	return approximate(result), err
+}
+
+func (n exactNumbers) degreesToRadians(x exactValue) (exactValue, error) {
+	result, err := complexNumbers{}.degreesToRadians(n.toComplex(x))
+	return approximate(result), err
+}
+
+func (n exactNumbers) radiansToDegrees(x exactValue) (exactValue, error) {
+	result, err := complexNumbers{}.radiansToDegrees(n.toComplex(x))
+	return approximate(result), err
+}
+
+// format renders exact values as "p/q" or "p/q + r/s i" whatever the format setting, and
+// approximations like formatComplex does, preceded by ApproximateMarker.
+func (n exactNumbers) format(x exactValue, settings Settings) string {
+	if x.approximate {
+		text := formatComplex(x.value, settings)
+		if strings.HasPrefix(text, ApproximateMarker) { // The 'frac' format found no fraction
+			return text
+		}
+		return ApproximateMarker + text
+	}
+	if settings.Base != 0 && settings.Base != 10 && x.exact.re.IsInt() && x.exact.im.IsInt() {
+		return formatIntegersInBase(x.exact.re.Num(), x.exact.im.Num(), settings.Base)
+	}
+	return formatGaussianRational(x.exact)
+}
+
+// formatGaussianRational renders x as "p/q", "p/q i" or "p/q + r/s i"; integer imaginary
+// parts are written without the space, as in "3 + 4i".
+func formatGaussianRational(x gaussianRational) string {
+	realStr := x.re.RatString()
+	if x.im.Sign() == 0 {
+		return realStr
+	}
+	absImag := new(big.Rat).Abs(x.im)
+	imagMagStr := absImag.RatString()
+	if !absImag.IsInt() {
+		imagMagStr += " "
+	}
+	return joinComplex(realStr, imagMagStr, x.re.Sign() == 0, x.im.Sign() < 0, absImag.Cmp(big.NewRat(1, 1)) == 0)
+}
+
+func (x gaussianRational) isZero() bool {
+	return x.re.Sign() == 0 && x.im.Sign() == 0
+}
+
+func (x gaussianRational) isReal() bool {
+	return x.im.Sign() == 0
+}
+
+// isInteger reports whether x is a real integer.
+func (x gaussianRational) isInteger() bool {
+	return x.im.Sign() == 0 && x.re.IsInt()
+}
+
+// ratBits is the size of r in bits.
+func ratBits(r *big.Rat) int {
+	return r.Num().BitLen() + r.Denom().BitLen()
+}
+
+func gaussianMul(x, y gaussianRational) gaussianRational {
+	re := new(big.Rat).Sub(new(big.Rat).Mul(x.re, y.re), new(big.Rat).Mul(x.im, y.im))
+	im := new(big.Rat).Add(new(big.Rat).Mul(x.re, y.im), new(big.Rat).Mul(x.im, y.re))
+	return gaussianRational{re, im}
+}
+
+// gaussianQuo returns x/y; y must not be zero.
+func gaussianQuo(x, y gaussianRational) gaussianRational {
+	if y.isReal() {
+		return gaussianRational{new(big.Rat).Quo(x.re, y.re), new(big.Rat).Quo(x.im, y.re)}
+	}
+	norm := new(big.Rat).Add(new(big.Rat).Mul(y.re, y.re), new(big.Rat).Mul(y.im, y.im))
+	conjugate := gaussianRational{y.re, new(big.Rat).Neg(y.im)}
+	product := gaussianMul(x, conjugate)
+	return gaussianRational{product.re.Quo(product.re, norm), product.im.Quo(product.im, norm)}
+}
+
+// gaussianPow returns x^y when y is an integer and the result stays within maxExactBits.
+// It returns false when the power has to be approximated, and an error for 0 raised to a
+// negative power.
+func gaussianPow(x, y gaussianRational) (gaussianRational, bool, error) {
+	if !y.isReal() || !y.re.IsInt() || !y.re.Num().IsInt64() {
+		return gaussianRational{}, false, nil
+	}
+	exponent := y.re.Num().Int64()
+	magnitude := exponent
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+	if x.isZero() && exponent < 0 {
+		return gaussianRational{}, false, errDivisionByZero
+	}
+	size := int64(max(ratBits(x.re), ratBits(x.im)))
+	if magnitude > maxExactBits || size*magnitude > 2*maxExactBits {
+		return gaussianRational{}, false, nil
+	}
+	result := gaussianRational{big.NewRat(1, 1), new(big.Rat)}
+	base := x
+	for ; magnitude > 0; magnitude >>= 1 {
+		if magnitude&1 == 1 {
+			result = gaussianMul(result, base)
+		}
+		if magnitude > 1 {
+			base = gaussianMul(base, base)
+		}
+	}
+	if exponent < 0 {
+		result = gaussianQuo(gaussianRational{big.NewRat(1, 1), new(big.Rat)}, result)
+	}
+	return result, true, nil
+}
+
+// ratFloor returns the largest integer not greater than r.
+func ratFloor(r *big.Rat) *big.Rat {
+	quotient := new(big.Int).Div(r.Num(), r.Denom()) // Euclidean division floors for positive denominators
+	return new(big.Rat).SetInt(quotient)
+}
+
+func ratCeil(r *big.Rat) *big.Rat {
+	return new(big.Rat).Neg(ratFloor(new(big.Rat).Neg(r)))
+}
+
+func ratTrunc(r *big.Rat) *big.Rat {
+	if r.Sign() < 0 {
+		return ratCeil(r)
+	}
+	return ratFloor(r)
+}
+
+// ratRound rounds r to the nearest integer, halves away from zero like math.Round.
+func ratRound(r *big.Rat) *big.Rat {
+	half := big.NewRat(1, 2)
+	if r.Sign() < 0 {
+		return new(big.Rat).Neg(ratFloor(new(big.Rat).Add(new(big.Rat).Neg(r), half)))
+	}
+	return ratFloor(new(big.Rat).Add(r, half))
+}
+
+// ratSqrt returns the square root of r >= 0 if it is rational.
+func ratSqrt(r *big.Rat) (*big.Rat, bool) {
+	num, den := new(big.Int).Sqrt(r.Num()), new(big.Int).Sqrt(r.Denom())
+	if new(big.Int).Mul(num, num).Cmp(r.Num()) != 0 || new(big.Int).Mul(den, den).Cmp(r.Denom()) != 0 {
+		return nil, false
+	}
+	return new(big.Rat).SetFrac(num, den), true
+}
+
+// Adapters from rational functions to exactImpl.
+
+func exactUnary(f func(x gaussianRational) (gaussianRational, bool)) exactImpl {
+	return func(args []gaussianRational) (gaussianRational, bool) {
+		return f(args[0])
+	}
+}
+
+func exactComponentwise(f func(r *big.Rat) *big.Rat) exactImpl {
+	return exactUnary(func(x gaussianRational) (gaussianRational, bool) {
+		return gaussianRational{f(x.re), f(x.im)}, true
+	})
+}
+
+// exactSqrt is the principal square root of real numbers whose root is a Gaussian rational,
+// like sqrt(9/4) = 3/2 and sqrt(-4) = 2i.
+func exactSqrt(x gaussianRational) (gaussianRational, bool) {
+	if !x.isReal() {
+		return gaussianRational{}, false
+	}
+	root, ok := ratSqrt(new(big.Rat).Abs(x.re))
+	if !ok {
+		return gaussianRational{}, false
+	}
+	if x.re.Sign() < 0 {
+		return gaussianRational{new(big.Rat), root}, true
+	}
+	return gaussianRational{root, new(big.Rat)}, true
+}
+
+// exactAbs is the modulus of x when it is rational, like abs(3+4i) = 5.
+func exactAbs(x gaussianRational) (gaussianRational, bool) {
+	norm := new(big.Rat).Add(new(big.Rat).Mul(x.re, x.re), new(big.Rat).Mul(x.im, x.im))
+	return exactSqrt(gaussianRational{norm, new(big.Rat)})
+}
+
+// exactExtremeByRealPart is extremeByRealPart for Gaussian rationals.
+func exactExtremeByRealPart(sign int) exactImpl {
+	return func(args []gaussianRational) (gaussianRational, bool) {
+		extreme := args[0]
+		for _, arg := range args[1:] {
+			if arg.re.Cmp(extreme.re) == sign {
+				extreme = arg
+			}
+		}
+		return extreme, true
+	}
+}
@@ -0,0 +1,68 @@
+// fraction.go
+package toycalc_core
+
+import (
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// DefaultMaxDenominator is the largest denominator of the 'frac' output format unless
+// changed with SetMaxDenominator.
+const DefaultMaxDenominator = 1000000
+
+// maxContinuedFractionTerms bounds the terms tried by bestRational. Convergent denominators
+// grow at least like the Fibonacci numbers, so any denominator up to 2^63 is reached sooner.
+const maxContinuedFractionTerms = 100
+
+// formatFraction renders c in the 'frac' format: each part as the first continued-fraction
+// convergent within half a unit in the last decimal place of settings.Precision, with a
+// denominator of at most settings.MaxDenominator. If a part has no such convergent, c is
+// shown in 'auto' format preceded by ApproximateMarker.
+func formatFraction(c complex128, settings Settings) string {
+	decimal := settings
+	decimal.Format = "auto"
+	if cmplx.IsNaN(c) || cmplx.IsInf(c) {
+		return formatComplex(c, decimal)
+	}
+	tolerance := 0.5 * math.Pow(10, -float64(settings.Precision))
+	maxDenominator := settings.MaxDenominator
+	if maxDenominator <= 0 {
+		maxDenominator = DefaultMaxDenominator
+	}
+	re, realOK := bestRational(real(c), tolerance, maxDenominator)
+	im, imagOK := bestRational(imag(c), tolerance, maxDenominator)
+	if !realOK || !imagOK {
+		return ApproximateMarker + formatComplex(c, decimal)
+	}
+	return formatGaussianRational(gaussianRational{re, im})
+}
+
+// bestRational returns the first convergent of the continued fraction of x that is within
+// tolerance of x, or false if the convergents' denominators exceed maxDenominator first.
+func bestRational(x, tolerance float64, maxDenominator int64) (*big.Rat, bool) {
+	if x == math.Trunc(x) {
+		return new(big.Rat).SetFloat64(x), true
+	}
+	limit := big.NewInt(maxDenominator)
+	// The two previous convergents, starting with 1/0 and 0/1.
+	previousNum, previousDen := big.NewInt(0), big.NewInt(1)
+	num, den := big.NewInt(1), big.NewInt(0)
+	remainder := x
+	for range maxContinuedFractionTerms {
+		term := math.Floor(remainder)
+		a, _ := big.NewFloat(term).Int(nil)
+		nextNum := new(big.Int).Add(new(big.Int).Mul(a, num), previousNum)
+		nextDen := new(big.Int).Add(new(big.Int).Mul(a, den), previousDen)
+		if nextDen.Cmp(limit) > 0 {
+			return nil, false
+		}
+		previousNum, previousDen, num, den = num, den, nextNum, nextDen
+		convergent := new(big.Rat).SetFrac(num, den)
+		if value, _ := convergent.Float64(); math.Abs(value-x) <= tolerance || remainder == term {
+			return convergent, true
+		}
+		remainder = 1 / (remainder - term)
+	}
+	return nil, false
+}
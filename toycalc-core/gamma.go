@@ -0,0 +1,272 @@
+// gamma.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+)
+
+// lanczosCoefficients are the coefficients of the Lanczos approximation with g = 7 and
+// n = 9, accurate to about 15 significant digits.
+var lanczosCoefficients = [...]float64{
+	0.99999999999980993,
+	676.5203681218851,
+	-1259.1392167224028,
+	771.32342877765313,
+	-176.61502916214059,
+	12.507343278686905,
+	-0.13857109526572012,
+	9.9843695780195716e-6,
+	1.5056327351493116e-7,
+}
+
+const lanczosG = 7
+
+// maxExactFactorial is the largest n whose factorial exact mode computes exactly; 5000!
+// has about 54000 bits, within maxExactBits.
+const maxExactFactorial = 5000
+
+// stirlingCoefficients are B(2k) / (2k(2k-1)) for k = 1..8, the coefficients of the
+// Stirling series for log-gamma; digammaCoefficients are B(2k) / 2k, for digamma.
+var (
+	stirlingCoefficients = [...]float64{
+		1.0 / 12, -1.0 / 360, 1.0 / 1260, -1.0 / 1680, 1.0 / 1188, -691.0 / 360360, 1.0 / 156, -3617.0 / 122400,
+	}
+	digammaCoefficients = [...]float64{
+		1.0 / 12, -1.0 / 120, 1.0 / 252, -1.0 / 240, 1.0 / 132, -691.0 / 32760, 1.0 / 12, -3617.0 / 8160,
+	}
+)
+
+// asymptoticThreshold is the real part above which the Stirling series are accurate to
+// double precision; smaller arguments are shifted up with the recurrence relations.
+const asymptoticThreshold = 10
+
+// gammaPole reports whether z is 0 or a negative integer, where gamma has a pole.
+func gammaPole(z complex128) bool {
+	return imag(z) == 0 && real(z) <= 0 && real(z) == math.Trunc(real(z))
+}
+
+func errGammaPole(z complex128) error {
+	return fmt.Errorf("undefined at the pole %s", formatComplexOutput(z))
+}
+
+// complexGamma returns the gamma function of z: math.Gamma on the real axis, the Lanczos
+// approximation elsewhere, with the reflection formula Γ(z)Γ(1-z) = π/sin(πz) for
+// Re(z) < 1/2. At the poles (0, -1, -2, ...) it returns NaN or ±Inf like math.Gamma.
+func complexGamma(z complex128) complex128 {
+	if imag(z) == 0 {
+		return complex(math.Gamma(real(z)), 0)
+	}
+	if real(z) < 0.5 {
+		return math.Pi / (cmplx.Sin(math.Pi*z) * complexGamma(1-z))
+	}
+	z--
+	sum := complex(lanczosCoefficients[0], 0)
+	for i := 1; i < len(lanczosCoefficients); i++ {
+		sum += complex(lanczosCoefficients[i], 0) / (z + complex(float64(i), 0))
+	}
+	t := z + lanczosG + 0.5
+	return complex(math.Sqrt(2*math.Pi), 0) * cmplx.Pow(t, z+0.5) * cmplx.Exp(-t) * sum
+}
+
+// complexLogGamma returns the principal branch of log-gamma: the continuation of the real
+// ln Γ(x) for x > 0 that is analytic except on the negative real axis, where, as for
+// cmplx.Log, the value is taken from above. It is not log(gamma(z)): lgamma(-0.5) is
+// ln|Γ(-0.5)| - πi, and the imaginary part grows without wrapping around.
+func complexLogGamma(z complex128) complex128 {
+	x, y := real(z), imag(z)
+	switch {
+	case y == 0 && x > 0:
+		value, _ := math.Lgamma(x)
+		return complex(value, 0)
+	case y == 0:
+		// ln Γ(x) = ln Γ(x+n) - ln(x) - ... - ln(x+n-1), where each negative factor adds -πi.
+		value, _ := math.Lgamma(x)
+		return complex(value, -math.Pi*math.Ceil(-x))
+	case y < 0:
+		return cmplx.Conj(complexLogGamma(cmplx.Conj(z)))
+	case x < 0.5:
+		// Reflection, ln Γ(z) = ln π - ln sin(πz) - ln Γ(1-z), with
+		// ln sin(πz) = ln(1 - exp(2πiz)) - ln 2 + πi/2 - πiz, which is analytic for Im(z) > 0
+		// and so stays on the principal branch.
+		logSin := cmplx.Log(1-cmplx.Exp(complex(0, 2*math.Pi)*z)) - math.Ln2 + complex(0, math.Pi/2) - complex(0, math.Pi)*z
+		return complex(math.Log(math.Pi), 0) - logSin - complexLogGamma(1-z)
+	}
+	var shift complex128
+	for real(z) < asymptoticThreshold {
+		shift += cmplx.Log(z)
+		z++
+	}
+	inverse := 1 / z
+	inverseSquared := inverse * inverse
+	series := complex(0, 0)
+	for k := len(stirlingCoefficients) - 1; k >= 0; k-- {
+		series = series*inverseSquared + complex(stirlingCoefficients[k], 0)
+	}
+	return (z-0.5)*cmplx.Log(z) - z + complex(0.5*math.Log(2*math.Pi), 0) + series*inverse - shift
+}
+
+// complexDigamma returns ψ(z), the logarithmic derivative of the gamma function, using
+// ψ(z) = ψ(1-z) - π cot(πz) for Re(z) < 1/2, ψ(z) = ψ(z+1) - 1/z and the asymptotic series.
+func complexDigamma(z complex128) complex128 {
+	if real(z) < 0.5 {
+		return complexDigamma(1-z) - math.Pi*cotPi(z)
+	}
+	var shift complex128
+	for real(z) < asymptoticThreshold {
+		shift += 1 / z
+		z++
+	}
+	inverseSquared := 1 / (z * z)
+	series := complex(0, 0)
+	for k := len(digammaCoefficients) - 1; k >= 0; k-- {
+		series = series*inverseSquared + complex(digammaCoefficients[k], 0)
+	}
+	return cmplx.Log(z) - 1/(2*z) - series*inverseSquared - shift
+}
+
+// cotPi returns cot(πz), which tends to ∓i far from the real axis, where cmplx.Tan overflows.
+func cotPi(z complex128) complex128 {
+	if imag(z) > 20 {
+		return complex(0, -1)
+	} else if imag(z) < -20 {
+		return complex(0, 1)
+	}
+	if imag(z) == 0 {
+		return complex(1/math.Tan(math.Pi*real(z)), 0)
+	}
+	return 1 / cmplx.Tan(math.Pi*z)
+}
+
+// complexBeta returns B(a, b) = Γ(a)Γ(b)/Γ(a+b), computed from log-gamma so that large
+// arguments do not overflow. It is 0 when only a+b is a pole.
+func complexBeta(a, b complex128) (complex128, error) {
+	if gammaPole(a) {
+		return complex(math.NaN(), math.NaN()), errGammaPole(a)
+	} else if gammaPole(b) {
+		return complex(math.NaN(), math.NaN()), errGammaPole(b)
+	} else if gammaPole(a + b) {
+		return 0, nil
+	}
+	if imag(a) == 0 && imag(b) == 0 && real(a) > 0 && real(b) > 0 {
+		la, _ := math.Lgamma(real(a))
+		lb, _ := math.Lgamma(real(b))
+		lab, _ := math.Lgamma(real(a) + real(b))
+		return complex(math.Exp(la+lb-lab), 0), nil
+	}
+	return cmplx.Exp(complexLogGamma(a) + complexLogGamma(b) - complexLogGamma(a+b)), nil
+}
+
+// withoutPoles adapts one of the gamma functions to a FunctionImpl that reports the poles as
+// errors.
+func withoutPoles(f func(complex128) complex128) FunctionImpl {
+	return func(args []complex128) (complex128, error) {
+		if gammaPole(args[0]) {
+			return complex(math.NaN(), math.NaN()), errGammaPole(args[0])
+		}
+		return f(args[0]), nil
+	}
+}
+
+// exactGamma returns (n-1)! for integers 1 <= n <= maxExactFactorial.
+func exactGamma(x gaussianRational) (gaussianRational, bool) {
+	if !x.isInteger() || x.re.Sign() <= 0 || x.re.Num().Cmp(big.NewInt(maxExactFactorial)) > 0 {
+		return gaussianRational{}, false
+	}
+	n := x.re.Num().Int64()
+	return gaussianRational{new(big.Rat).SetInt(new(big.Int).MulRange(1, n-1)), new(big.Rat)}, true
+}
+
+// exactBeta returns (a-1)!(b-1)!/(a+b-1)! for positive integers with a+b <= maxExactFactorial.
+func exactBeta(args []gaussianRational) (gaussianRational, bool) {
+	a, b := args[0], args[1]
+	if !a.isInteger() || !b.isInteger() || a.re.Sign() <= 0 || b.re.Sign() <= 0 {
+		return gaussianRational{}, false
+	}
+	sum := new(big.Int).Add(a.re.Num(), b.re.Num())
+	if sum.Cmp(big.NewInt(maxExactFactorial)) > 0 {
+		return gaussianRational{}, false
+	}
+	m, n := a.re.Num().Int64(), b.re.Num().Int64()
+	numerator := new(big.Int).Mul(new(big.Int).MulRange(1, m-1), new(big.Int).MulRange(1, n-1))
+	result := new(big.Rat).SetFrac(numerator, new(big.Int).MulRange(1, m+n-1))
+	return gaussianRational{result, new(big.Rat)}, true
+}
+
+// errFactorialPole is the error for the factorial of a negative integer, where the gamma
+// function has a pole, and for the double factorial of a negative even integer.
+func errFactorialPole(op Token, n *big.Int) error {
+	return operatorError(op, fmt.Errorf("undefined at the negative integer %s", n))
+}
+
+// factorialPole reports whether op, FACTORIAL or DOUBLE_FACTORIAL, is undefined at the
+// integer n.
+func factorialPole(op Token, n *big.Int) bool {
+	return n.Sign() < 0 && (op.Type == FACTORIAL || n.Bit(0) == 0)
+}
+
+// complexFactorial applies FACTORIAL or DOUBLE_FACTORIAL to z. Integer arguments are
+// multiplied out, so that 20! is exact; others go through the gamma function, with
+// z!! = 2^(z/2) (2/π)^((1 - cos πz)/4) Γ(z/2 + 1), which matches n!! at every integer.
+func complexFactorial(op Token, z complex128, budget *Budget) (complex128, error) {
+	if n, ok := floatInteger(z); ok {
+		if factorialPole(op, n) {
+			return complex(math.NaN(), math.NaN()), errFactorialPole(op, n)
+		}
+		if n.Cmp(big.NewInt(400)) > 0 { // Far beyond 170!, the largest finite factorial
+			return complex(math.Inf(1), 0), nil
+		}
+		product, err := integerFactorial(op, n, budget)
+		if err != nil {
+			return complex(math.NaN(), math.NaN()), err
+		}
+		value, _ := product.Float64()
+		return complex(value, 0), nil
+	}
+	if op.Type == FACTORIAL {
+		return complexGamma(z + 1), nil
+	}
+	exponent := (1 - cmplx.Cos(math.Pi*z)) / 4
+	return cmplx.Pow(2, z/2) * cmplx.Pow(2/math.Pi, exponent) * complexGamma(z/2+1), nil
+}
+
+// integerFactorial returns n! or n!! for an integer n that is not a pole, spending one
+// operation of budget per factor. Negative odd n have double factorials
+// (-2k-1)!! = (-1)^k / (2k-1)!!, so (-1)!! is 1 and (-5)!! is 1/3.
+func integerFactorial(op Token, n *big.Int, budget *Budget) (*big.Rat, error) {
+	if !n.IsInt64() {
+		return nil, operatorError(op, fmt.Errorf("argument %s is too large", n))
+	}
+	value := n.Int64()
+	if err := budget.Spend(int(min(max(value, -value), math.MaxInt32))); err != nil {
+		return nil, operatorError(op, err)
+	}
+	if op.Type == FACTORIAL {
+		return new(big.Rat).SetInt(new(big.Int).MulRange(1, value)), nil
+	}
+	if value < 0 {
+		k := (-value - 1) / 2
+		reciprocal := new(big.Rat).SetFrac(big.NewInt(1), doubleFactorial(2*k-1))
+		if k%2 == 1 {
+			reciprocal.Neg(reciprocal)
+		}
+		return reciprocal, nil
+	}
+	return new(big.Rat).SetInt(doubleFactorial(value)), nil
+}
+
+// doubleFactorial returns n!! for n >= -1: 2^k k! for n = 2k, and (2k+1)! / (2^k k!) for
+// n = 2k+1.
+func doubleFactorial(n int64) *big.Int {
+	if n <= 0 {
+		return big.NewInt(1)
+	}
+	k := n / 2
+	evens := new(big.Int).Lsh(new(big.Int).MulRange(1, k), uint(k))
+	if n%2 == 0 {
+		return evens
+	}
+	return new(big.Int).Quo(new(big.Int).MulRange(1, n), evens)
+}
@@ -3,8 +3,6 @@ package toycalc_core
 
 import (
 	"fmt"
-	"math" // Required for Pi in one example, will use its value directly
-	"math/cmplx"
 	"strings"
 )
 
@@ -14,7 +12,8 @@ var helpTopics = map[string]string{
 	"usage": "Usage:\n" +
 		"  toycalc <expression>\n" +
 		"  toycalc \"<expression with spaces or special characters>\"\n" +
-		"  toycalc help [topic]\n\n" +
+		"  toycalc help [topic]\n" +
+		"  toycalc -f <script file>   (use '-' to read the script from standard input)\n\n" +
 		"If no arguments are provided, toycalc starts in interactive mode (REPL).\n" +
 		"In REPL, type an expression and press Enter, or type 'help [topic]', 'exit', or 'quit'.",
 
@@ -24,10 +23,18 @@ var helpTopics = map[string]string{
 		"Supported features include:\n" + // Changed heading slightly
 		"- Basic arithmetic: +, -, *, /\n" +
 		"- Power: ^\n" +
-		"- Modulo: % (Gaussian integer remainder)\n" +
+		"- Modulo: % (Gaussian integer remainder); floor division: //\n" +
+		"- Bitwise operators on integers: &, |, xor, ~, <<, >> (see 'help set word' for programmer mode)\n" +
 		"- Unary plus (+) and minus (-)\n" +
+		"- Comparisons and logic: ==, !=, <, <=, >, >=, and, or, not, if(cond, a, b) (see 'help ==')\n" +
+		"- Vectors: vec(1, 2, 3+i), with element-wise arithmetic, sum, prod, len, dot, cross, norm and\n" +
+		"  at(v, k) (see 'help vec')\n" +
+		"- Postfix factorial (5!), double factorial (5!!) and degrees (30°)\n" +
 		"- Grouping: (), [], {}\n" +
+		"- Number literals: 3.5, 1.2e-3, 4.7k, 0x1F, 0o17, 0b1010 (see 'help set base')\n" +
 		"- Constants: i, pi, e (see 'help constants')\n" +
+		"- Variables: x = 3+4i, then use x in later expressions (see 'help variables')\n" +
+		"- User-defined functions: f(x, y) = x^2 + y*i (see 'help user functions')\n" +
 		"- A wide range of mathematical functions including logarithmic, exponential, trigonometric,\n" +
 		"  hyperbolic, complex component manipulation, angle conversion, and rounding.\n" +
 		"  (Type 'help functions' for a full list).\n\n" +
@@ -42,6 +49,21 @@ var helpTopics = map[string]string{
 		"                   Example: set format fixed 4  (Output for pi: 3.1416)\n" +
 		"    sci <N>      : Scientific notation with N digits after the decimal point for the significand.\n" +
 		"                   Example: set format sci 6  (Output for pi: 3.141590e+00)\n" +
+		"    eng [N]      : Engineering notation: a mantissa from 1 to 999 with up to N decimals and an\n" +
+		"                   exponent that is a multiple of 3.\n" +
+		"                   Example: set format eng, then 4700  (Output: 4.7e3)\n" +
+		"    si [N]       : Like 'eng', with the exponent written as an SI prefix (f p n µ m k M G T).\n" +
+		"                   Example: set format si, then 4700+2200i  (Output: 4.7k + 2.2k i)\n" +
+		"                   Literals accept the same prefixes: 4.7k, 100n, 2.2u (u for µ), unless\n" +
+		"                   a variable or parameter has that name: with n = 5, 2n is 10.\n" +
+		"    frac [D]     : Fractions, found from the continued fraction of each part: the first convergent\n" +
+		"                   within the display precision with a denominator of at most D (default " + fmt.Sprintf("%d", DefaultMaxDenominator) + ").\n" +
+		"                   Results with no such fraction are shown in 'auto' format, preceded by '" + ApproximateMarker + "'.\n" +
+		"                   Example: set format frac, then 0.5+0.25i  (Output: 1/2 + 1/4 i)\n" +
+		"                   Example: set format frac 100, then pi     (Output: " + ApproximateMarker + "3.141592654)\n" +
+		"    polar [deg|rad] : Phasor notation 'r ∠ θ', with θ in the given unit (default: the angle mode);\n" +
+		"                   degrees are marked with '°'. Phasors can be typed the same way (see 'help ∠').\n" +
+		"                   Example: set format polar deg, then 3+4i  (Output: 5 ∠ 53.130102354°)\n" +
 		"  N is an integer, typically 0-20. This N also updates the general display precision.",
 
 	"set precision": "Command: set precision <N>\n" +
@@ -50,14 +72,93 @@ var helpTopics = map[string]string{
 		"  This also sets the precision used by 'fixed N' and 'sci N' formats directly.\n" +
 		"  N is an integer, typically 0-20.\n" +
 		"    Example: set precision 9 (default for 'auto' pre-rounding)\n" +
-		"    Example: set format fixed 2 (equivalent to 'set format fixed' then 'set precision 2' for fixed mode)",
+		"    Example: set format fixed 2 (equivalent to 'set format fixed' then 'set precision 2' for fixed mode)\n\n" +
+		"Command: set precision sig <N>\n" +
+		"  Rounds each part of 'auto' output to N significant digits, relative to its own magnitude,\n" +
+		"  so tiny values are kept: 1.23e-12 is shown as '1.23e-12' instead of '0'. A part is only\n" +
+		"  hidden when it is negligible next to the other part, as in exp(i*pi) (Result: -1).\n" +
+		"  'set precision N', 'set format fixed N' and 'set format sci N' return to decimal places.\n" +
+		"    Example: set precision sig 6, then pi      (Result: 3.14159)\n" +
+		"    Example: set precision sig 6, then 1234567 (Result: 1.23457e+06)\n\n" +
+		"Command: set precision bits <N|off>\n" +
+		"  Turns on high-precision mode: numbers become complex values with N-bit (64-16384) big.Float\n" +
+		"  parts, and operators and functions are computed to that precision. 'auto' output shows every\n" +
+		"  significant digit, and 'fixed'/'sci' accept as many digits as N bits carry. 'off' returns\n" +
+		"  to complex128 arithmetic.\n" +
+		"    Example: set precision bits 256, then pi (Result: 3.141592653589793238462643383279502884197169399375105820974944592307816406286)",
+	"set angle": "Command: set angle <deg|rad>\n" +
+		"  Sets the unit of angles for trigonometric functions.\n" +
+		"    rad : Default. sin, cos, tan and polar take radians; asin, acos, atan, atan2 and phase return radians.\n" +
+		"    deg : The same functions take and return degrees.\n" +
+		"    Example: set angle deg, then sin(30)   (Result: 0.5)\n" +
+		"    Example: set angle deg, then atan2(1, 1) (Result: 45)",
+	"set base": "Command: set base <2|8|10|16>\n" +
+		"  Shows results whose real and imaginary parts are both integers in the given base, with the\n" +
+		"  same prefixes literals use: 0b (binary), 0o (octal) and 0x (hexadecimal, upper-case digits).\n" +
+		"  Parts are judged integers after rounding to the display precision, so 0.1*10 is 0x1.\n" +
+		"  Results with a part that is not an integer, NaN or infinite are shown in decimal, in the\n" +
+		"  current format. Base 10 is the default.\n" +
+		"  Literals can be typed in any of these bases whatever the setting: 0x1F, 0o17, 0b1010,\n" +
+		"  and hexadecimal fractions like 0x1.8 (1.5).\n" +
+		"    Example: set base 16, then 0xff - 16i  (Result: 0xFF - 0x10i)\n" +
+		"    Example: set base 2, then 0x1F + 0xA*i (Result: 0b11111 + 0b1010i)\n" +
+		"    Example: set base 16, then 1/4         (Result: 0.25)",
+	"set word": "Command: set word <8|16|32|64|off> [signed|unsigned]\n" +
+		"  Turns on programmer mode: every value is an integer of the given number of bits, two's\n" +
+		"  complement unless 'unsigned' is given, and results wrap around like machine words.\n" +
+		"  / and // truncate toward zero and % takes the sign of the dividend, as in C. Numbers that\n" +
+		"  are not integers, like 1.5 or pi, are errors, and so are functions with such results.\n" +
+		"  With 'set base 2', '8' or '16' results are shown as the bits of the word.\n" +
+		"  Turning programmer mode on turns exact and high-precision mode off; 'off' turns it off.\n" +
+		"    Example: set word 8, then 127 + 1                   (Result: -128)\n" +
+		"    Example: set word 8 unsigned, then 0 - 1            (Result: 255)\n" +
+		"    Example: set word 16, then set base 16, then -1     (Result: 0xFFFF)",
+	"set locale": "Command: set locale <en|es|de|off>\n" +
+		"  Sets how numbers are written. Results use the locale's decimal mark and digit grouping;\n" +
+		"  'es' and 'de' also accept a decimal comma in expressions, so arguments are separated with ';'\n" +
+		"  (a comma followed by a space still separates them). The point is still a decimal mark, so\n" +
+		"  3.5 and 1.234 mean what they do in 'en' input; results grouped as 1.234 are not read back.\n" +
+		"    en  : 12,345.5\n" +
+		"    es  : 12.345,5 (numbers of four digits are not grouped: 1234,5)\n" +
+		"    de  : 12.345,5\n" +
+		"    off : Default. 12345.5, with no grouping.\n" +
+		"    Example: set locale es, then max(1,5; 2) * 10000  (Result: 20.000)",
+	"set exact": "Command: set exact <on|off>\n" +
+		"  Turns exact mode on or off. In exact mode numbers are Gaussian rationals (p/q + r/s i):\n" +
+		"  +, -, *, /, % and integer powers give exact results, shown as fractions whatever the format.\n" +
+		"  Results that cannot be exact, like sqrt(2), sin(1) or anything involving pi, are computed\n" +
+		"  in complex128 and shown with a leading '" + ApproximateMarker + "'. Turning exact mode on turns\n" +
+		"  high-precision mode ('set precision bits') and programmer mode ('set word') off.\n" +
+		"    Example: 1/3 + 1/6        (Result: 1/2)\n" +
+		"    Example: (1+2i)/(3-4i)    (Result: -1/5 + 2/5 i)\n" +
+		"    Example: sqrt(2)          (Result: " + ApproximateMarker + "1.414213562)",
+
+	"set tolerance": "Command: set tolerance <t>\n" +
+		"  Sets how close two numbers must be for '==' to hold: |a-b| <= t * max(1, |a|, |b|). It\n" +
+		"  must be at least 0 and less than 1; 0 asks for numbers to be identical. The default is\n" +
+		"  " + fmt.Sprintf("%g", Epsilon) + ". Exact mode and programmer mode always compare exactly.\n" +
+		"    Example: 0.1 + 0.2 == 0.3                         (Result: true)\n" +
+		"    Example: set tolerance 0, then 0.1 + 0.2 == 0.3   (Result: false)",
+
 	"operators": "Supported operators:\n" +
 		"  +  : Addition (binary)\n" +
 		"  -  : Subtraction (binary) / Unary Minus (prefix)\n" +
 		"  * : Multiplication (binary)\n" +
 		"  /  : Division (binary)\n" +
 		"  %  : Modulo (binary)\n" +
-		"  ^  : Power (binary)\n\n" +
+		"  // : Floor division (binary)\n" +
+		"  ^  : Power (binary)\n" +
+		"  ∠  : Phasor, r∠θ (binary; also written 'angle')\n" +
+		"  °  : Degrees (postfix)\n" +
+		"  !, !! : Factorial, double factorial (postfix)\n" +
+		"  &, |, xor : Bitwise and, or, exclusive or (binary, integers only)\n" +
+		"  ~  : Bitwise not (prefix, integers only)\n" +
+		"  <<, >> : Shifts (binary, integers only)\n" +
+		"  ==, !=, <, <=, >, >= : Comparisons (binary; the result is true or false)\n" +
+		"  and, or : Logical and, or (binary)\n" +
+		"  not : Logical not (prefix)\n\n" +
+		"Precedence, from loosest: or, and, not, == != < <= > >=, |, xor, &, << >>, + -, * / // %, ∠,\n" +
+		"unary - ~, ^, ° ! !!.\n" +
 		"See 'help <operator_symbol>' or 'help unary' or 'help modulo' for details.",
 
 	"unary": "Unary Plus and Minus:\n" +
@@ -113,6 +214,105 @@ var helpTopics = map[string]string{
 		"    Example: (-4)^0.5          (Result: 2i)\n" + // Corrected example
 		"    Example: i^2              (Result: -1)",
 
+	"∠": "Operator: ∠ or 'angle' (Phasor)\n" +
+		"  r∠θ is the complex number with magnitude r and angle θ, r*exp(i*θ), like polar(r, θ).\n" +
+		"  θ is in the unit of the angle mode ('help set angle'); write it with '°' to give it in degrees\n" +
+		"  in any mode. ∠ binds tighter than * and /, so phasors can be multiplied and divided directly.\n" +
+		"    Example: 10∠30°                 (Result: 8.660254038 + 5i)\n" +
+		"    Example: 10 angle 90°           (Result: 10i)\n" +
+		"    Example: 10∠30° * 2∠15°         (Result: 14.142135624 + 14.142135624i)\n" +
+		"  With 'set format polar deg' results are shown as phasors too: 10∠30° * 2∠15° is '20 ∠ 45°'.",
+
+	"°": "Operator: ° (Degrees, postfix)\n" +
+		"  x° is the angle x degrees in the unit of the angle mode: x*pi/180 in 'rad' mode, x in 'deg' mode.\n" +
+		"    Example: sin(30°)        (Result: 0.5)\n" +
+		"    Example: 10∠30°          (Result: 8.660254038 + 5i)",
+
+	"!": "Operator: ! (Factorial, postfix)\n" +
+		"  n! is 1*2*...*n for integers n >= 0, and gamma(x+1) for other real and complex numbers.\n" +
+		"  It binds tighter than every other operator, so -3! is -(3!) and 2^3! is 2^6.\n" +
+		"  Negative integers, where the gamma function has poles, are an error.\n" +
+		"    Example: 5!              (Result: 120)\n" +
+		"    Example: 0.5!            (Result: 0.886226925, sqrt(pi)/2)\n" +
+		"    Example: (3!)!           (Result: 720; 3!! is a double factorial)\n" +
+		"  In exact mode integer factorials up to " + fmt.Sprintf("%d", maxExactFactorial) + "! are exact; in high-precision mode only\n" +
+		"  integers have factorials.",
+
+	"!!": "Operator: !! (Double factorial, postfix)\n" +
+		"  n!! is n*(n-2)*(n-4)*..., down to 1 or 2, for integers n >= 0, with 0!! = (-1)!! = 1.\n" +
+		"  Negative odd integers follow (-2k-1)!! = (-1)^k/(2k-1)!!, and other numbers\n" +
+		"  2^(x/2) (2/pi)^((1-cos(pi*x))/4) gamma(x/2+1). Negative even integers are an error.\n" +
+		"    Example: 5!!             (Result: 15)\n" +
+		"    Example: 6!!             (Result: 48)\n" +
+		"    Example: (-5)!!          (Result: 0.333333333)",
+
+	"//": "Operator: // (Floor division)\n" +
+		"  Divides and rounds the quotient down, each part separately for complex numbers.\n" +
+		"  In programmer mode ('help set word') it truncates toward zero like /.\n" +
+		"    Example: 7 // 2           (Result: 3)\n" +
+		"    Example: -7 // 2          (Result: -4)\n" +
+		"  Division by zero results in an error.",
+
+	"&": "Operator: & (Bitwise and)\n" +
+		"  Each bit of the result is 1 where both integers have a 1. Negative numbers behave as\n" +
+		"  two's complement with infinitely many 1 bits on the left, unless a word size is set\n" +
+		"  ('help set word'). Operands that are not real integers are an error.\n" +
+		"    Example: 12 & 10          (Result: 8)\n" +
+		"    Example: -1 & 0xFF        (Result: 255)",
+
+	"|": "Operator: | (Bitwise or)\n" +
+		"  Each bit of the result is 1 where either integer has a 1 (see 'help &' for negative numbers).\n" +
+		"    Example: 12 | 10          (Result: 14)\n" +
+		"    Example: 0x0F | 0xF0      (Result: 255)",
+
+	"xor": "Operator: xor (Bitwise exclusive or)\n" +
+		"  Each bit of the result is 1 where exactly one integer has a 1 (see 'help &' for negative numbers).\n" +
+		"    Example: 12 xor 10        (Result: 6)",
+
+	"~": "Operator: ~ (Bitwise not, prefix)\n" +
+		"  Flips every bit of an integer: ~x is -x-1, or the complement within the word in unsigned\n" +
+		"  programmer mode ('help set word').\n" +
+		"    Example: ~5               (Result: -6)\n" +
+		"    Example: set word 8 unsigned, then ~5  (Result: 250)",
+
+	"<<": "Operator: << (Shift left)\n" +
+		"  x << n moves the bits of the integer x n places to the left, multiplying it by 2^n.\n" +
+		"  In programmer mode bits shifted out of the word are lost.\n" +
+		"    Example: 1 << 10          (Result: 1024)",
+
+	">>": "Operator: >> (Shift right)\n" +
+		"  x >> n moves the bits of the integer x n places to the right, dividing it by 2^n and\n" +
+		"  rounding down; negative numbers keep their sign.\n" +
+		"    Example: 1024 >> 3        (Result: 128)\n" +
+		"    Example: -8 >> 1          (Result: -4)",
+
+	"==": "Operators: ==, !=, <, <=, >, >= (Comparisons)\n" +
+		"  Compare two numbers and give true or false. '==' and '!=' compare complex numbers, which are\n" +
+		"  equal when |a-b| <= t * max(1, |a|, |b|) for the tolerance t ('help set tolerance'), so\n" +
+		"  rounding errors do not matter. <, <=, > and >= compare real numbers only; numbers equal\n" +
+		"  within the tolerance count as equal for them too. Comparisons cannot be chained: write\n" +
+		"  'a < b and b < c' instead of 'a < b < c'. In arithmetic, true is 1 and false is 0.\n" +
+		"    Example: 0.1 + 0.2 == 0.3   (Result: true)\n" +
+		"    Example: 2 != 1 + i         (Result: true)\n" +
+		"    Example: 3 >= 4             (Result: false)\n" +
+		"    Example: (2 > 1) + 1        (Result: 2)",
+
+	"and": "Operator: and (Logical and)\n" +
+		"  True if both operands are true. Any number but 0 counts as true. The right operand is\n" +
+		"  only evaluated if the left one is true.\n" +
+		"    Example: 1 < 2 and 2 < 3    (Result: true)\n" +
+		"    Example: 0 and 1/0          (Result: false)",
+
+	"or": "Operator: or (Logical or)\n" +
+		"  True if either operand is true. Any number but 0 counts as true. The right operand is\n" +
+		"  only evaluated if the left one is false.\n" +
+		"    Example: 1 > 2 or 2 > 1     (Result: true)",
+
+	"not": "Operator: not (Logical not, prefix)\n" +
+		"  True if its operand is false (0), false otherwise. It binds more loosely than comparisons.\n" +
+		"    Example: not 1 == 2         (Result: true)\n" +
+		"    Example: not 0              (Result: true)",
+
 	"grouping": "Grouping Symbols: (), [], {}\n" +
 		"  Parentheses `()`, square brackets `[]`, and curly braces `{}` can all be used\n" +
 		"  interchangeably to group sub-expressions and control the order of operations.\n" +
@@ -120,40 +320,6 @@ var helpTopics = map[string]string{
 		"    Example: (1 + 2) * 3\n" +
 		"    Example: {[ (10 - 2) / 4 ] + 1}^2",
 
-	"functions": "Supported functions (all operate on complex numbers):\n" + // Emphasize complex operation
-		"  Core: real(x), imag(x), abs(x), phase(x), conj(x)\n" +
-		"  Log/Exp: exp(x), log(x) (natural), log10(x), log2(x)\n" +
-		"  Power/Root: sqrt(x) (Note: '^' is the power operator)\n" +
-		"  Trigonometric: sin(x), cos(x), tan(x)\n" +
-		"  Inverse Trig: asin(x), acos(x), atan(x)\n" +
-		"  Hyperbolic: sinh(x), cosh(x), tanh(x)\n" +
-		"  Inverse Hyperbolic: asinh(x), acosh(x), atanh(x)\n" +
-		"  Angle Conversion: degToRad(x), radToDeg(x)\n" +
-		"  Rounding/Truncation: floor(x), ceil(x), round(x), trunc(x)\n\n" +
-		"Type 'help <function_name>' for more details (e.g., 'help sin').",
-
-	"log": "Function: log(x)\n" +
-		"  Calculates the natural logarithm (base e) of the complex number x.\n" +
-		"  Returns the principal value. The imaginary part of the result is in (-π, π].\n" +
-		"    Example: log(exp(2))      (Result: 2)\n" +
-		"    Example: log(-1)           (Result: " + fmt.Sprintf("%gi", math.Pi) + ")\n" + // Corrected output
-		"    Example: log(i)            (Result: " + fmt.Sprintf("%gi", math.Pi/2) + ")\n" + // Corrected output
-		"  log(0) results in " + fmt.Sprintf("%v", cmplx.Log(0)) + ".", // Show actual Inf/NaN output
-
-	"exp": "Function: exp(x)\n" +
-		"  Calculates the exponential function e^x, where e is Euler's number, for the complex number x.\n" +
-		"    Example: exp(0)             (Result: 1)\n" +
-		"    Example: exp(1)             (Result: " + fmt.Sprintf("%g", math.E) + ")\n" +
-		"    Example: exp(log(5))        (Result: 5)\n" +
-		"    Example: exp(i * " + fmt.Sprintf("%g", math.Pi) + ") (Result: -1, Euler's Identity)",
-
-	"i": "Constant: i\n" +
-		"  The imaginary unit, evaluated as complex(0, 1).\n" +
-		"  Must be used with multiplication operator if scaling, e.g., '5*i'.\n" +
-		"    Example: i*i              (Result: -1)\n" +
-		"    Example: 2+3*i            (Result: 2+3i)\n" +
-		"    Example: exp(i*" + fmt.Sprintf("%g", math.Pi/2) + ")    (Result: i)",
-
 	"output": "Output Formatting:\n" +
 		"  Results are displayed as complex numbers. Formatting can be controlled.\n" +
 		"  See 'help set format' and 'help set precision' for details.\n\n" +
@@ -161,169 +327,131 @@ var helpTopics = map[string]string{
 		"  - If imaginary part is negligible, only the real part is displayed.\n" +
 		"  - If real part is negligible, output is like '2i' or '-i'.\n" +
 		"  - Whole numbers formatted without unnecessary decimals (e.g., '5').\n" +
-		"  - Handles 'NaN' and complex 'Inf' representations.",
-
-	"constants": "Supported constants:\n" +
-		"  i  : The imaginary unit, complex(0, 1).\n" +
-		"  pi : The mathematical constant π (Pi), approx. 3.1415926535...\n" +
-		"  e  : Euler's number (base of natural logarithm), approx. 2.7182818284...\n\n" +
-		"Type 'help <constant_name>' for more details (e.g., 'help pi').",
-
-	"pi": "Constant: pi\n" +
-		"  Represents the mathematical constant π (Pi), the ratio of a circle's circumference to its diameter.\n" +
-		"  Value: " + fmt.Sprintf("%.10f...", math.Pi) + "\n" + // Show some precision
-		"    Example: sin(pi/2)    (Result: 1)\n" +
-		"    Example: 2*pi         (Result: " + fmt.Sprintf("%g", 2*math.Pi) + ")",
-
-	"e": "Constant: e\n" +
-		"  Represents Euler's number, the base of the natural logarithm.\n" +
-		"  Value: " + fmt.Sprintf("%.10f...", math.E) + "\n" +
-		"    Example: log(e)         (Result: 1)\n" +
-		"    Example: e^2            (Result: " + fmt.Sprintf("%g", math.E*math.E) + ")",
-	"sin": "Function: sin(x)\n" +
-		"  Calculates the trigonometric sine of the complex number x.\n" +
-		"  x is assumed to be in radians.\n" +
-		"    Example: sin(0)         (Result: 0)\n" +
-		"    Example: sin(" + fmt.Sprintf("%g", math.Pi/2) + ") (Result: 1)\n" +
-		"    Example: sin(i)         (Result: " + fmt.Sprintf("%gi", math.Sinh(1)) + ") (since sin(ix) = i*sinh(x))",
-
-	"cos": "Function: cos(x)\n" +
-		"  Calculates the trigonometric cosine of the complex number x.\n" +
-		"  x is assumed to be in radians.\n" +
-		"    Example: cos(0)         (Result: 1)\n" +
-		"    Example: cos(" + fmt.Sprintf("%g", math.Pi) + ")   (Result: -1)\n" +
-		"    Example: cos(i)         (Result: " + fmt.Sprintf("%g", math.Cosh(1)) + ") (since cos(ix) = cosh(x))",
-
-	"tan": "Function: tan(x)\n" +
-		"  Calculates the trigonometric tangent of the complex number x (sin(x)/cos(x)).\n" +
-		"  x is assumed to be in radians.\n" +
-		"  Result may be Inf or NaN if cos(x) is zero (e.g., at pi/2, 3pi/2).\n" +
-		"    Example: tan(0)         (Result: 0)\n" +
-		"    Example: tan(" + fmt.Sprintf("%g", math.Pi/4) + ") (Result: 1)",
-
-	"asin": "Function: asin(x)\n" +
-		"  Calculates the principal value of the inverse trigonometric sine (arcsine) of x.\n" +
-		"    Example: asin(0)        (Result: 0)\n" +
-		"    Example: asin(1)        (Result: " + fmt.Sprintf("%g", math.Pi/2) + ")",
-
-	"acos": "Function: acos(x)\n" +
-		"  Calculates the principal value of the inverse trigonometric cosine (arccosine) of x.\n" +
-		"    Example: acos(1)        (Result: 0)\n" +
-		"    Example: acos(0)        (Result: " + fmt.Sprintf("%g", math.Pi/2) + ")",
-
-	"atan": "Function: atan(x)\n" +
-		"  Calculates the principal value of the inverse trigonometric tangent (arctangent) of x.\n" +
-		"    Example: atan(0)        (Result: 0)\n" +
-		"    Example: atan(1)        (Result: " + fmt.Sprintf("%g", math.Pi/4) + ")",
-
-	"sinh": "Function: sinh(x)\n" +
-		"  Calculates the hyperbolic sine of the complex number x.\n" +
-		"    Example: sinh(0)        (Result: 0)\n" +
-		"    Example: sin(i*(" + fmt.Sprintf("%g", math.Pi/2) + ")) (Result: i)", // sin(i*x) = i*sinh(x) so sinh(x) = -i*sin(ix)
-
-	"cosh": "Function: cosh(x)\n" +
-		"  Calculates the hyperbolic cosine of the complex number x.\n" +
-		"    Example: cosh(0)        (Result: 1)\n" +
-		"    Example: cos(i)         (Result: " + fmt.Sprintf("%g", math.Cosh(1)) + ")", // cos(ix) = cosh(x)
-
-	"tanh": "Function: tanh(x)\n" +
-		"  Calculates the hyperbolic tangent of the complex number x (sinh(x)/cosh(x)).\n" +
-		"    Example: tanh(0)        (Result: 0)",
-
-	"asinh": "Function: asinh(x)\n  Calculates the principal value of the inverse hyperbolic sine of x.\n    Example: asinh(0) (Result: 0)",
-	"acosh": "Function: acosh(x)\n  Calculates the principal value of the inverse hyperbolic cosine of x.\n    Example: acosh(1) (Result: 0)",
-	"atanh": "Function: atanh(x)\n  Calculates the principal value of the inverse hyperbolic tangent of x.\n    Example: atanh(0) (Result: 0)",
-
-	"log10": "Function: log10(x)\n" +
-		"  Calculates the base-10 logarithm of the complex number x.\n" +
-		"  Returns the principal value.\n" +
-		"    Example: log10(100)     (Result: 2)\n" +
-		"    Example: log10(1)       (Result: 0)",
+		"  - Handles 'NaN' and complex 'Inf' representations.\n" +
+		"  In high-precision mode ('set precision bits N') all significant digits are shown (e.g., 1/3 at\n" +
+		"  128 bits is '0.3333333333333333333333333333333333333').\n" +
+		"  The 'eng' and 'si' formats use exponents that are multiples of 3, e.g. 0.0047 as '4.7e-3' or '4.7m'.\n" +
+		"  The 'frac' format shows results as fractions, e.g. 0.333333333 as '1/3'.\n" +
+		"  The 'polar' format shows results as phasors 'r ∠ θ', e.g. 10i as '10 ∠ 90°' with 'set format polar deg'.\n" +
+		"  In exact mode ('set exact on') results are fractions like '1/2 + 1/4 i', and approximate\n" +
+		"  results are marked with a leading '" + ApproximateMarker + "'.",
+
+	"variables": "Variables:\n" +
+		"  name = expression\n" +
+		"  Evaluates the expression, prints its value and binds it to 'name' for later expressions.\n" +
+		"  Names are case-insensitive, start with a letter or '_' and may contain digits.\n" +
+		"  Built-in names (constants like 'pi', 'i', 'e' and functions like 'sin') cannot be reassigned.\n" +
+		"    Example: x = 3+4i\n" +
+		"    Example: abs(x)         (Result: 5)\n" +
+		"    Example: 2x             (Result: 6 + 8i, implied multiplication)\n" +
+		"  Variables live for the REPL session or, with 'toycalc -f', for the whole script.",
+
+	"user functions": "User-defined functions:\n" +
+		"  name(param1, param2, ...) = expression\n" +
+		"  Defines a function that can then be called like a built-in, including with implied\n" +
+		"  multiplication. It must be called with exactly as many arguments as it has parameters.\n" +
+		"  Other variables and functions used in the body are looked up when the function is called.\n" +
+		"    Example: f(x, y) = x^2 + y*i\n" +
+		"    Example: f(1, 2)        (Result: 1 + 2i)\n" +
+		"    Example: 2f(1, 2)       (Result: 2 + 4i)\n" +
+		"  Functions may call themselves, but calls nested deeper than " + fmt.Sprintf("%d", MaxCallDepth) + " levels are\n" +
+		"  stopped with an error, so a definition like f(x) = f(x) cannot hang the calculator.\n" +
+		"  Use if() to stop the recursion, as it only evaluates the chosen branch:\n" +
+		"    Example: fact(n) = if(n <= 1, 1, n*fact(n-1))\n" +
+		"    Example: fact(5)        (Result: 120)",
+}
 
-	"log2": "Function: log2(x)\n" +
-		"  Calculates the base-2 logarithm of the complex number x.\n" +
-		"  Returns the principal value.\n" +
-		"    Example: log2(8)        (Result: 3)\n" +
-		"    Example: log2(1)        (Result: 0)",
-
-	"sqrt": "Function: sqrt(x)\n" +
-		"  Calculates the principal value of the square root of the complex number x.\n" +
-		"  Equivalent to x^0.5.\n" +
-		"    Example: sqrt(4)        (Result: 2)\n" +
-		"    Example: sqrt(-1)       (Result: i)\n" + // Output format will show 'i'
-		"    Example: sqrt(2i)       (Result: 1+1i)", // sqrt(2i) = 1+i
-	"real": "Function: real(x)\n" +
-		"  Returns the real part of the complex number x, as a complex number with a zero imaginary part.\n" +
-		"    Example: real(3+4*i)    (Result: 3)\n" +
-		"    Example: real(5)        (Result: 5)\n" +
-		"    Example: real(2*i)      (Result: 0)",
-
-	"imag": "Function: imag(x)\n" +
-		"  Returns the imaginary part of the complex number x, as a complex number with a zero imaginary part.\n" +
-		"  Note: This returns the coefficient of 'i'. For the complex number 'i' itself, use the constant 'i'.\n" +
-		"    Example: imag(3+4*i)    (Result: 4)\n" +
-		"    Example: imag(5)        (Result: 0)\n" +
-		"    Example: imag(2*i)      (Result: 2)",
-
-	"abs": "Function: abs(x)\n" +
-		"  Calculates the absolute value (or modulus/magnitude) of the complex number x.\n" +
-		"  This is a non-negative real number, returned as complex(abs_value, 0).\n" +
-		"    Example: abs(3+4*i)    (Result: 5)\n" +
-		"    Example: abs(-5)       (Result: 5)\n" +
-		"    Example: abs(i)        (Result: 1)",
-
-	"phase": "Function: phase(x)\n" +
-		"  Calculates the argument (or phase/angle) of the complex number x.\n" +
-		"  The result is in radians, in the interval (-π, π].\n" +
-		"  Returned as complex(angle_value, 0).\n" +
-		"    Example: phase(1+i)    (Result: " + fmt.Sprintf("%g", math.Pi/4) + ")\n" +
-		"    Example: phase(-1)     (Result: " + fmt.Sprintf("%g", math.Pi) + ")\n" +
-		"    Example: phase(i)      (Result: " + fmt.Sprintf("%g", math.Pi/2) + ")\n" +
-		"    Example: phase(0)      (Result: 0)",
-
-	"conj": "Function: conj(x)\n" +
-		"  Calculates the complex conjugate of x.\n" +
-		"  If x = a+bi, conj(x) = a-bi.\n" +
-		"    Example: conj(3+4*i)    (Result: 3-4i)\n" +
-		"    Example: conj(5)        (Result: 5)\n" +
-		"    Example: conj(2*i)      (Result: -2i)",
-
-	"degtorad": "Function: degToRad(x)\n" +
-		"  Converts the complex number x from degrees to radians.\n" +
-		"  The entire complex number (both real and imaginary parts) is scaled by π/180.\n" +
-		"    Example: degToRad(180)          (Result: " + fmt.Sprintf("%g", math.Pi) + ")\n" +
-		"    Example: degToRad(90+180*i)  (Result: " + fmt.Sprintf("%g+%gi", math.Pi/2, math.Pi) + ")",
-
-	"radtodeg": "Function: radToDeg(x)\n" +
-		"  Converts the complex number x from radians to degrees.\n" +
-		"  The entire complex number (both real and imaginary parts) is scaled by 180/π.\n" +
-		"    Example: radToDeg(pi)           (Result: 180)\n" +
-		"    Example: radToDeg(pi/2 + i)     (Result: " + fmt.Sprintf("%g+%gi", 90.0, 180.0/math.Pi) + ")",
-
-	"floor": "Function: floor(x)\n" +
-		"  Computes the floor of the complex number x component-wise.\n" +
-		"  Result: complex(math.Floor(real(x)), math.Floor(imag(x)))\n" +
-		"    Example: floor(3.7+2.3*i)   (Result: 3+2i)\n" +
-		"    Example: floor(-3.7-2.3*i)  (Result: -4-3i)",
-
-	"ceil": "Function: ceil(x)\n" +
-		"  Computes the ceiling of the complex number x component-wise.\n" +
-		"  Result: complex(math.Ceil(real(x)), math.Ceil(imag(x)))\n" +
-		"    Example: ceil(3.2+2.8*i)    (Result: 4+3i)\n" +
-		"    Example: ceil(-3.2-2.8*i)   (Result: -3-2i)",
-
-	"round": "Function: round(x)\n" +
-		"  Rounds the complex number x to the nearest integer component-wise.\n" +
-		"  Uses Go's math.Round (rounds half to even).\n" +
-		"  Result: complex(math.Round(real(x)), math.Round(imag(x)))\n" +
-		"    Example: round(3.5+2.5*i)   (Result: 4+2i)\n" +
-		"    Example: round(3.7+2.3*i)   (Result: 4+2i)",
-
-	"trunc": "Function: trunc(x)\n" +
-		"  Truncates the complex number x towards zero component-wise.\n" +
-		"  Result: complex(math.Trunc(real(x)), math.Trunc(imag(x)))\n" +
-		"    Example: trunc(3.7+2.3*i)   (Result: 3+2i)\n" +
-		"    Example: trunc(-3.7-2.3*i)  (Result: -3-2i)",
+// helpTopicOrder lists the fixed topics in the order 'help' shows them. The functions and
+// constants in the registry are listed after these.
+var helpTopicOrder = []string{
+	"usage", "general", "operators", "unary", "+", "-", "*", "/", "//", "%", "^", "∠", "°", "!", "!!",
+	"&", "|", "xor", "~", "<<", ">>", "==", "and", "or", "not", "grouping",
+	"functions", "constants", "variables", "user functions", "output",
+}
+
+// functionsHelp builds the 'functions' topic from the registry, grouped by category.
+func functionsHelp(r *Registry) string {
+	var sb strings.Builder
+	sb.WriteString("Supported functions (all operate on complex numbers):\n")
+	functions := r.Functions()
+	for _, category := range r.Categories() {
+		var signatures []string
+		for _, def := range functions {
+			if def.Category == category {
+				signatures = append(signatures, def.Signature)
+			}
+		}
+		sb.WriteString(fmt.Sprintf("  %s: %s\n", category, strings.Join(signatures, ", ")))
+	}
+	sb.WriteString("  Your own: see 'help user functions'\n\n")
+	sb.WriteString("Type 'help <function_name>' for more details (e.g., 'help sin').")
+	return sb.String()
+}
+
+// constantsHelp builds the 'constants' topic from the registry.
+func constantsHelp(r *Registry) string {
+	var sb strings.Builder
+	sb.WriteString("Supported constants:\n")
+	for _, def := range r.Constants() {
+		sb.WriteString(fmt.Sprintf("  %-3s: %s\n", def.Name, strings.TrimSpace(constantSummary(def))))
+	}
+	sb.WriteString("\nType 'help <constant_name>' for more details (e.g., 'help pi').")
+	return sb.String()
+}
+
+// constantSummary is the first line of a constant's help after its "Constant: name" heading.
+func constantSummary(def ConstantDef) string {
+	lines := strings.Split(def.Help, "\n")
+	if len(lines) > 1 {
+		return lines[1]
+	}
+	return formatComplexOutput(def.Value)
+}
+
+// HelpText returns the help for topic: one of the fixed topics, or the help text of a
+// registered function or constant.
+func HelpText(topic string) (string, bool) {
+	return helpText(defaultRegistry, topic)
+}
+
+func helpText(r *Registry, topic string) (string, bool) {
+	topic = strings.ToLower(strings.TrimSpace(topic))
+	switch topic {
+	case "functions":
+		return functionsHelp(r), true
+	case "constants":
+		return constantsHelp(r), true
+	case "!=", "<", "<=", ">", ">=": // The comparisons share a topic
+		topic = "=="
+	}
+	if content, found := helpTopics[topic]; found {
+		return content, true
+	}
+	if def, found := r.Function(topic); found {
+		return def.Help, true
+	}
+	if def, found := r.Constant(topic); found {
+		return def.Help, true
+	}
+	return "", false
+}
+
+// helpTopicList returns every topic name: the fixed topics, then constants, then functions
+// by category.
+func helpTopicList() []string {
+	topics := append([]string{}, helpTopicOrder...)
+	for _, def := range defaultRegistry.Constants() {
+		topics = append(topics, def.Name)
+	}
+	functions := defaultRegistry.Functions()
+	for _, category := range defaultRegistry.Categories() {
+		for _, def := range functions {
+			if def.Category == category {
+				topics = append(topics, def.Name)
+			}
+		}
+	}
+	return topics
 }
 
 // displayHelp shows help information.
@@ -331,27 +459,17 @@ var helpTopics = map[string]string{
 // If topic is specified, it shows help for that topic.
 func DisplayHelp(topic string) {
 	topic = strings.ToLower(strings.TrimSpace(topic))
-	availableTopics := []string{
-		"usage", "general", "operators", "unary", "+", "-", "*", "/", "%", "^", "grouping",
-		"functions", "constants", "output", "i", "pi", "e",
-		"log", "exp", "sin", "cos", "tan", "asin", "acos", "atan",
-		"sinh", "cosh", "tanh", "asinh", "acosh", "atanh",
-		"log10", "log2", "sqrt",
-		"real", "imag", "abs", "phase", "conj",
-		"degtorad", "radtodeg",
-		"floor", "ceil", "round", "trunc",
-	} // Ensure all helpTopics keys are listable here if desired for discoverability
 
 	if topic == "" {
 		fmt.Println(helpTopics["general"])
 		fmt.Println("\nAvailable topics (type 'help <topic>'):")
 		// Simple way to list topics, can be formatted better if many topics
-		topicsStr := strings.Join(availableTopics, ", ")
+		topicsStr := strings.Join(helpTopicList(), ", ")
 		fmt.Println("  " + topicsStr)
 		return
 	}
 
-	content, found := helpTopics[topic]
+	content, found := HelpText(topic)
 	if found {
 		fmt.Println(content)
 	} else {
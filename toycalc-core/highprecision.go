@@ -0,0 +1,495 @@
+// highprecision.go
+package toycalc_core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Range of Settings.Bits accepted by SetPrecisionBits (besides 0, which turns high-precision mode off).
+const (
+	MinPrecisionBits = 64
+	MaxPrecisionBits = 16384
+)
+
+// bigGuardBits are carried beyond Settings.Bits while computing a function or operator, so
+// that the rounded result is accurate to the last bit or two.
+const bigGuardBits = 32
+
+// bigImpl computes a function in high-precision mode to the working precision prec.
+// Errors are recorded in m.
+type bigImpl func(m *bigMath, prec uint, args []bigComplex) bigComplex
+
+// bigDisplayDigits is the number of significant decimal digits shown for results computed
+// with the given number of bits.
+func bigDisplayDigits(bits uint) int {
+	return max(int(float64(bits)*math.Log10(2))-1, 1)
+}
+
+// displayPrecisionLimit is the largest precision accepted by SetFormat and SetPrecision.
+// High-precision mode can show as many decimals as its bits carry.
+func displayPrecisionLimit(bits uint) int {
+	if bits == 0 {
+		return MaxDisplayPrecision
+	}
+	return max(MaxDisplayPrecision, bigDisplayDigits(bits))
+}
+
+// bigNumbers is the number system of high-precision mode: complex numbers whose components
+// are big.Float values with the given number of bits.
+type bigNumbers struct {
+	bits   uint
+	budget *Budget
+}
+
+func newBigNumbers(bits uint, budget *Budget) bigNumbers {
+	return bigNumbers{bits: bits, budget: budget}
+}
+
+// evaluateBig is evaluateIn for high-precision mode. Operations big.Float cannot represent,
+// such as infinity minus infinity, are reported as domain errors.
+func evaluateBig(settings Settings, budget *Budget, rpn []Token, env *Environment) (result evaluation, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isNaN := r.(big.ErrNaN); !isNaN {
+				panic(r)
+			}
+			result, err = evaluation{}, newKindError(KindDomain, "result is undefined in high-precision mode (not a number)")
+		}
+	}()
+	return evaluateIn(newBigNumbers(settings.Bits, budget), settings, budget, rpn, env)
+}
+
+func (n bigNumbers) workingPrecision() uint {
+	return n.bits + bigGuardBits
+}
+
+func (n bigNumbers) number(token Token) (bigComplex, error) {
+	value, ok := newFloat(n.bits).SetString(numberText(token.Literal))
+	if !ok {
+		return bigComplex{}, fmt.Errorf("invalid number '%s'", token.Literal)
+	}
+	return bigReal(value, n.bits), nil
+}
+
+func (n bigNumbers) constant(def ConstantDef) (bigComplex, error) {
+	if def.bigValue != nil {
+		return bigReal(def.bigValue(n.bits), n.bits), nil
+	}
+	return n.fromComplex(def.Value)
+}
+
+func (n bigNumbers) fromComplex(c complex128) (bigComplex, error) {
+	return bigComplex{newFloat(n.bits).SetFloat64(real(c)), newFloat(n.bits).SetFloat64(imag(c))}, nil
+}
+
+func (n bigNumbers) toComplex(x bigComplex) complex128 {
+	re, _ := x.re.Float64()
+	im, _ := x.im.Float64()
+	return complex(re, im)
+}
+
+func (n bigNumbers) precise(x bigComplex) any { return x }
+
+// operatorError turns an error recorded while applying op into an error pointing at op.
+func operatorError(op Token, err error) error {
+	if stopsEvaluation(err) {
+		return atPosition(err, op.Position)
+	}
+	return newTokenError(KindDomain, op, fmt.Sprintf("%v for operator '%s' at position %d", err, op.Literal, op.Position))
+}
+
+func (n bigNumbers) operate(op Token, a, b bigComplex) (bigComplex, error) {
+	m := &bigMath{budget: n.budget}
+	wp := n.workingPrecision()
+	var result bigComplex
+	switch op.Type {
+	case PLUS:
+		result = cadd(a, b, n.bits)
+	case MINUS:
+		result = csub(a, b, n.bits)
+	case ASTERISK:
+		result = cmul(a, b, wp)
+	case SLASH:
+		result = m.cquo(a, b, wp)
+	case SLASH_SLASH:
+		if b.isZero() {
+			return bigComplex{}, operatorError(op, errDivisionByZero)
+		}
+		quotient := m.cquo(a, b, wp)
+		result = bigComplex{floor(quotient.re, wp), floor(quotient.im, wp)}
+	case PERCENT:
+		if b.isZero() {
+			return bigComplex{}, newTokenError(KindDomain, op,
+				fmt.Sprintf("divisor is zero for modulo operator at position %d", op.Position),
+			)
+		}
+		// r = a - x*b, where x is the Gaussian integer closest to a/b (see calculateModulo).
+		quotient := m.cquo(a, b, wp)
+		nearest := bigComplex{roundHalfAway(quotient.re, wp), roundHalfAway(quotient.im, wp)}
+		result = csub(a, cmul(nearest, b, wp), wp)
+	case CARET:
+		result = m.cpow(a, b, wp)
+	case ANGLE:
+		result = m.cpolar(a, b, wp)
+	case AMPERSAND, PIPE, XOR, SHIFT_LEFT, SHIFT_RIGHT:
+		x, err := n.integer(op, a)
+		if err != nil {
+			return bigComplex{}, err
+		}
+		y, err := n.integer(op, b)
+		if err != nil {
+			return bigComplex{}, err
+		}
+		integer, err := integerOperate(op, x, y)
+		if err != nil {
+			return bigComplex{}, err
+		}
+		result = bigReal(newFloat(n.bits).SetInt(integer), n.bits)
+	default:
+		return bigComplex{}, newTokenError(KindParse, op, fmt.Sprintf("unexpected operator '%s' at position %d", op.Literal, op.Position))
+	}
+	if m.err != nil {
+		return bigComplex{}, operatorError(op, m.err)
+	}
+	return result.round(n.bits), nil
+}
+
+// negate negates x, normalizing signed zeros like the default number system does.
+func (n bigNumbers) negate(x bigComplex) bigComplex {
+	result := cneg(x, n.bits)
+	if result.re.Sign() == 0 {
+		result.re = newFloat(n.bits)
+	}
+	if result.im.Sign() == 0 {
+		result.im = newFloat(n.bits)
+	}
+	return result
+}
+
+// compare treats a and b as equal when |a-b| <= tolerance * max(1, |a|, |b|), like the default
+// number system does.
+func (n bigNumbers) compare(op Token, a, b bigComplex, tolerance float64) (bool, error) {
+	equal := a.re.Cmp(b.re) == 0 && a.im.Cmp(b.im) == 0
+	if !equal && !a.isInf() && !b.isInf() {
+		wp := n.workingPrecision()
+		bound := newFloat(wp).SetInt64(1)
+		if magnitude := cabs(a, wp); magnitude.Cmp(bound) > 0 {
+			bound = magnitude
+		}
+		if magnitude := cabs(b, wp); magnitude.Cmp(bound) > 0 {
+			bound = magnitude
+		}
+		bound = mul(bound, newFloat(wp).SetFloat64(tolerance), wp)
+		equal = cabs(csub(a, b, wp), wp).Cmp(bound) <= 0
+	}
+	return comparison(op, equal, func() (int, error) {
+		if a.im.Sign() != 0 {
+			return 0, orderingOperandError(op, n.toComplex(a))
+		}
+		if b.im.Sign() != 0 {
+			return 0, orderingOperandError(op, n.toComplex(b))
+		}
+		return a.re.Cmp(b.re), nil
+	})
+}
+
+func (n bigNumbers) complement(op Token, x bigComplex) (bigComplex, error) {
+	integer, err := n.integer(op, x)
+	if err != nil {
+		return bigComplex{}, err
+	}
+	return bigReal(newFloat(n.bits).SetInt(integer.Not(integer)), n.bits), nil
+}
+
+// integer returns x, an operand of a bitwise operator, as an integer. Integers wider than
+// the precision have already been rounded, so their lowest bits are zero.
+func (n bigNumbers) integer(op Token, x bigComplex) (*big.Int, error) {
+	if x.im.Sign() != 0 || x.re.IsInf() || !x.re.IsInt() {
+		return nil, bitwiseOperandError(op, n.toComplex(x))
+	}
+	integer, _ := x.re.Int(nil)
+	return integer, nil
+}
+
+// factorial multiplies out the factorials of integers; those of other numbers, which need
+// the gamma function, are not available.
+func (n bigNumbers) factorial(op Token, x bigComplex, budget *Budget) (bigComplex, error) {
+	if x.im.Sign() != 0 || x.re.IsInf() || !x.re.IsInt() {
+		return bigComplex{}, operatorError(op, errors.New("factorials of non-integers are not available in high-precision mode"))
+	}
+	integer, _ := x.re.Int(nil)
+	if factorialPole(op, integer) {
+		return bigComplex{}, errFactorialPole(op, integer)
+	}
+	result, err := integerFactorial(op, integer, budget)
+	if err != nil {
+		return bigComplex{}, err
+	}
+	return bigReal(newFloat(n.bits).SetRat(result), n.bits), nil
+}
+
+func (n bigNumbers) call(function FunctionDef, args []bigComplex, budget *Budget) (bigComplex, error) {
+	if function.integerImpl != nil {
+		return function.integerImpl.callBig(budget, n.bits, args)
+	}
+	if function.bigImpl == nil {
+		return bigComplex{}, errors.New("not available in high-precision mode")
+	}
+	m := &bigMath{budget: budget}
+	result := function.bigImpl(m, n.workingPrecision(), args)
+	if m.err != nil {
+		return bigComplex{}, m.err
+	}
+	return result.round(n.bits), nil
+}
+
+func (n bigNumbers) degreesToRadians(x bigComplex) (bigComplex, error) {
+	return cscale(x, radiansPerDegree(n.workingPrecision()), n.bits), nil
+}
+
+func (n bigNumbers) radiansToDegrees(x bigComplex) (bigComplex, error) {
+	return cscale(x, degreesPerRadian(n.workingPrecision()), n.bits), nil
+}
+
+// format renders x like formatComplex renders complex128 values. In 'auto' mode every
+// significant digit the precision carries is shown, or settings.Precision of them in
+// significant-digit mode. A component smaller than the rounding noise of the precision next
+// to the other component is shown as zero, so that exp(i*pi) is -1; on its own, even 1e-100
+// keeps its digits.
+func (n bigNumbers) format(x bigComplex, settings Settings) string {
+	if settings.Base != 0 && settings.Base != 10 {
+		re, realIsInt := n.nearestInteger(x.re)
+		im, imagIsInt := n.nearestInteger(x.im)
+		if realIsInt && imagIsInt {
+			return formatIntegersInBase(re, im, settings.Base)
+		}
+	}
+	switch settings.Format {
+	case "auto", "fixed", "sci":
+	default:
+		return formatComplex(n.toComplex(x), settings)
+	}
+	if x.re.IsInf() || x.im.IsInf() {
+		return formatComplex(n.toComplex(x), settings)
+	}
+	noise := int(n.bits) - 20
+	negligible := func(component, other *big.Float) bool {
+		if component.Sign() == 0 {
+			return true
+		}
+		return other.Sign() != 0 && component.MantExp(nil) < other.MantExp(nil)-noise
+	}
+	realIsZero := negligible(x.re, x.im)
+	imagIsZero := negligible(x.im, x.re)
+
+	text := func(component *big.Float) string {
+		switch settings.Format {
+		case "fixed":
+			return bigText(component, 'f', settings.Precision, n.bits)
+		case "sci":
+			return bigText(component, 'e', settings.Precision, n.bits)
+		}
+		if settings.Significant {
+			return bigText(component, 'g', min(settings.Precision, bigDisplayDigits(n.bits)), n.bits)
+		}
+		return bigText(component, 'g', bigDisplayDigits(n.bits), n.bits)
+	}
+	realStr := "0"
+	if !realIsZero || settings.Format != "auto" {
+		realPart := x.re
+		if realIsZero {
+			realPart = newFloat(n.bits)
+		}
+		realStr = text(realPart)
+	}
+	if imagIsZero {
+		return realStr
+	}
+	absImag := newFloat(n.bits).Abs(x.im)
+	imagMagStr := text(absImag)
+	imagIsOne := imagMagStr == text(intFloat(1, n.bits))
+	return joinComplex(realStr, imagMagStr, realIsZero, x.im.Sign() < 0, imagIsOne)
+}
+
+// bigTextExponent is the binary exponent beyond which bigText scales a number before writing
+// it in decimal: big.Float.Text takes time growing with the exponent, seconds for exp(10^7).
+const bigTextExponent = 1 << 16
+
+// bigText returns x.Text(format, digits) for the formats 'e', 'f' and 'g'. Numbers of huge
+// magnitude are scaled to [1, 10) with a few multiplications and written with their decimal
+// exponent; in format 'f' they are written like format 'e', or as zero if they are tiny.
+func bigText(x *big.Float, format byte, digits int, bits uint) string {
+	exponent := x.MantExp(nil)
+	if x.Sign() == 0 || x.IsInf() || (exponent > -bigTextExponent && exponent < bigTextExponent) {
+		return x.Text(format, digits)
+	}
+	if format == 'f' && exponent < 0 {
+		return Ternary(x.Sign() < 0, "-", "") + newFloat(bits).Text('f', digits)
+	}
+
+	// x is m·2^exponent with 0.5 <= m < 1, so its decimal exponent is about exponent·log10(2).
+	prec := bits + 64
+	decimal := int(math.Floor(float64(exponent) * math.Log10(2)))
+	power, ten := intFloat(1, prec), intFloat(10, prec)
+	for k := max(decimal, -decimal); k > 0; k >>= 1 {
+		if k&1 == 1 {
+			power.Mul(power, ten)
+		}
+		if k > 1 {
+			ten.Mul(ten, ten)
+		}
+	}
+	scaled := newFloat(prec)
+	if decimal > 0 {
+		scaled.Quo(x, power)
+	} else {
+		scaled.Mul(x, power)
+	}
+	ten = intFloat(10, prec)
+	for newFloat(prec).Abs(scaled).Cmp(ten) >= 0 {
+		scaled.Quo(scaled, ten)
+		decimal++
+	}
+	for newFloat(prec).Abs(scaled).Cmp(intFloat(1, prec)) < 0 {
+		scaled.Mul(scaled, ten)
+		decimal--
+	}
+
+	// Rounding may carry into the exponent of the scaled text, as in 9.99 to 1.0e+01.
+	var mantissa string
+	switch format {
+	case 'g':
+		mantissa = scaled.Text('e', max(digits, 1)-1)
+	default:
+		mantissa = scaled.Text('e', digits)
+	}
+	mantissa, carried, _ := strings.Cut(mantissa, "e")
+	shift, _ := strconv.Atoi(carried)
+	if format == 'g' && strings.Contains(mantissa, ".") {
+		mantissa = strings.TrimSuffix(strings.TrimRight(mantissa, "0"), ".")
+	}
+	return fmt.Sprintf("%se%+03d", mantissa, decimal+shift)
+}
+
+// nearestInteger returns the integer closest to x and whether x differs from it by no more
+// than the rounding noise of the precision next to x, as format judges negligible parts.
+// Numbers of huge magnitude are not taken as integers, which would have too many digits to
+// write.
+func (n bigNumbers) nearestInteger(x *big.Float) (*big.Int, bool) {
+	if x.IsInf() || x.MantExp(nil) >= bigTextExponent {
+		return nil, false
+	}
+	half := big.NewFloat(0.5)
+	if x.Sign() < 0 {
+		half.Neg(half)
+	}
+	nearest, _ := newFloat(n.bits).Add(x, half).Int(nil)
+	difference := newFloat(n.bits).Sub(x, newFloat(n.bits).SetInt(nearest))
+	noise := int(n.bits) - 20
+	if difference.Sign() == 0 || difference.MantExp(nil) < x.MantExp(nil)-noise {
+		return nearest, true
+	}
+	return nil, false
+}
+
+// Adapters from the functions in bigmath.go to bigImpl.
+
+func bigUnary(f func(m *bigMath, z bigComplex, prec uint) bigComplex) bigImpl {
+	return func(m *bigMath, prec uint, args []bigComplex) bigComplex {
+		return f(m, args[0], prec)
+	}
+}
+
+func bigBinary(f func(m *bigMath, a, b bigComplex, prec uint) bigComplex) bigImpl {
+	return func(m *bigMath, prec uint, args []bigComplex) bigComplex {
+		return f(m, args[0], args[1], prec)
+	}
+}
+
+// bigPure adapts a function that cannot fail and needs no budget.
+func bigPure(f func(z bigComplex, prec uint) bigComplex) bigImpl {
+	return bigUnary(func(_ *bigMath, z bigComplex, prec uint) bigComplex { return f(z, prec) })
+}
+
+func bigComponentwise(f func(x *big.Float, prec uint) *big.Float) bigImpl {
+	return bigPure(func(z bigComplex, prec uint) bigComplex {
+		return bigComplex{f(z.re, prec), f(z.im, prec)}
+	})
+}
+
+// bigE returns Euler's number to prec bits.
+func bigE(prec uint) *big.Float {
+	return (&bigMath{}).exp(intFloat(1, prec), prec)
+}
+
+// clogBase returns log(x) / log(base).
+func (m *bigMath) clogBase(x, base bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	return m.cquo(m.clog(x, wp), m.clog(base, wp), prec)
+}
+
+// cnthRoot is nthRoot for bigComplex values.
+func (m *bigMath) cnthRoot(x, n bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	if x.isReal() && n.isReal() && x.re.Sign() < 0 && n.re.IsInt() {
+		if odd, _ := n.re.Int(nil); odd.Bit(0) == 1 {
+			exponent := m.cquo(bigOne(wp), n, wp)
+			return cneg(m.cpow(cneg(x, wp), exponent, wp), prec)
+		}
+	}
+	return m.cpow(x, m.cquo(bigOne(wp), n, wp), prec)
+}
+
+// chypot is complexHypot for bigComplex values.
+func chypot(a, b bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	return csqrt(cadd(cmul(a, a, wp), cmul(b, b, wp), wp), prec)
+}
+
+// catan2 is complexAtan2 for bigComplex values.
+func (m *bigMath) catan2(y, x bigComplex, prec uint) bigComplex {
+	if y.isReal() && x.isReal() {
+		return bigReal(m.atan2(y.re, x.re, prec), prec)
+	}
+	wp := prec + 32
+	i := bigI(wp)
+	ratio := m.cquo(cadd(x, cmul(i, y, wp), wp), csqrt(cadd(cmul(x, x, wp), cmul(y, y, wp), wp), wp), wp)
+	return cmul(cneg(i, wp), m.clog(ratio, wp), prec)
+}
+
+// cpolar returns r*exp(i*theta).
+func (m *bigMath) cpolar(r, theta bigComplex, prec uint) bigComplex {
+	wp := prec + 16
+	return cmul(r, m.cexp(cmul(bigI(wp), theta, wp), wp), prec)
+}
+
+// bigExtremeByRealPart is extremeByRealPart for bigComplex values.
+func bigExtremeByRealPart(sign int) bigImpl {
+	return func(m *bigMath, prec uint, args []bigComplex) bigComplex {
+		extreme := args[0]
+		for _, arg := range args[1:] {
+			if arg.re.Cmp(extreme.re) == sign {
+				extreme = arg
+			}
+		}
+		return extreme
+	}
+}
+
+// bigScale returns the function multiplying its argument by factor(prec).
+func bigScale(factor func(prec uint) *big.Float) bigImpl {
+	return bigPure(func(z bigComplex, prec uint) bigComplex { return cscale(z, factor(prec), prec) })
+}
+
+func degreesPerRadian(prec uint) *big.Float {
+	return quo(intFloat(180, prec), bigPi(prec), prec)
+}
+
+func radiansPerDegree(prec uint) *big.Float {
+	return quo(bigPi(prec), intFloat(180, prec), prec)
+}
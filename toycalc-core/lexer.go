@@ -12,6 +12,10 @@ type Lexer struct {
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
 	ch           rune // current char under examination
+	decimalComma bool // the locale writes decimals with a comma and separates arguments with ';'
+	// bound reports whether a name is a variable or parameter, which a letter after a number
+	// is read as rather than as an SI prefix; nil if there are none.
+	bound func(name string) bool
 }
 
 func NewLexer(input string) *Lexer {
@@ -34,13 +38,13 @@ func (l *Lexer) readChar() {
 }
 
 // peekChar looks ahead in the input without consuming the character.
-/*func (l *Lexer) peekChar() rune {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	}
 	r, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
 	return r
-}*/
+}
 
 func (l *Lexer) NextToken() Token {
 	var tok Token
@@ -57,11 +61,55 @@ func (l *Lexer) NextToken() Token {
 	case '*':
 		tok = Token{Type: ASTERISK, Literal: "*", Position: tokenStartPosition}
 	case '/':
-		tok = Token{Type: SLASH, Literal: "/", Position: tokenStartPosition}
+		if l.peekChar() == '/' {
+			l.readChar()
+			tok = Token{Type: SLASH_SLASH, Literal: "//", Position: tokenStartPosition}
+		} else {
+			tok = Token{Type: SLASH, Literal: "/", Position: tokenStartPosition}
+		}
 	case '%':
 		tok = Token{Type: PERCENT, Literal: "%", Position: tokenStartPosition}
 	case '^':
 		tok = Token{Type: CARET, Literal: "^", Position: tokenStartPosition}
+	case '∠':
+		tok = Token{Type: ANGLE, Literal: "∠", Position: tokenStartPosition}
+	case '°':
+		tok = Token{Type: DEGREE, Literal: "°", Position: tokenStartPosition}
+	case '!':
+		if l.peekChar() == '!' { // 5!! is a double factorial; write (5!)! for the factorial of 5!
+			l.readChar()
+			tok = Token{Type: DOUBLE_FACTORIAL, Literal: "!!", Position: tokenStartPosition}
+		} else if l.peekChar() == '=' { // Write 3! == 6 for a comparison with a factorial
+			l.readChar()
+			tok = Token{Type: NOT_EQUAL, Literal: "!=", Position: tokenStartPosition}
+		} else {
+			tok = Token{Type: FACTORIAL, Literal: "!", Position: tokenStartPosition}
+		}
+	case '&':
+		tok = Token{Type: AMPERSAND, Literal: "&", Position: tokenStartPosition}
+	case '|':
+		tok = Token{Type: PIPE, Literal: "|", Position: tokenStartPosition}
+	case '~':
+		tok = Token{Type: TILDE, Literal: "~", Position: tokenStartPosition}
+	case '<', '>':
+		switch {
+		case l.peekChar() == l.ch: // Shifts
+			tok = Token{Type: SHIFT_LEFT, Literal: "<<", Position: tokenStartPosition}
+			if l.ch == '>' {
+				tok = Token{Type: SHIFT_RIGHT, Literal: ">>", Position: tokenStartPosition}
+			}
+			l.readChar()
+		case l.peekChar() == '=':
+			tok = Token{Type: LESS_EQUAL, Literal: "<=", Position: tokenStartPosition}
+			if l.ch == '>' {
+				tok = Token{Type: GREATER_EQUAL, Literal: ">=", Position: tokenStartPosition}
+			}
+			l.readChar()
+		case l.ch == '<':
+			tok = Token{Type: LESS, Literal: "<", Position: tokenStartPosition}
+		default:
+			tok = Token{Type: GREATER, Literal: ">", Position: tokenStartPosition}
+		}
 	case '(':
 		tok = Token{Type: LPAREN, Literal: "(", Position: tokenStartPosition}
 	case ')':
@@ -76,12 +124,30 @@ func (l *Lexer) NextToken() Token {
 		tok = Token{Type: RBRACE, Literal: "}", Position: tokenStartPosition}
 	case ',':
 		tok = Token{Type: COMMA, Literal: ",", Position: tokenStartPosition}
+	case ';':
+		if l.decimalComma {
+			tok = Token{Type: COMMA, Literal: ";", Position: tokenStartPosition}
+		} else {
+			tok = Token{Type: ILLEGAL, Literal: ";", Position: tokenStartPosition}
+		}
+	case '=':
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = Token{Type: EQUAL, Literal: "==", Position: tokenStartPosition}
+		} else {
+			tok = Token{Type: ASSIGN, Literal: "=", Position: tokenStartPosition}
+		}
 	case 0: // EOF
 		tok = Token{Type: EOF, Literal: "", Position: tokenStartPosition}
 	default:
 		if isLetter(l.ch) {
 			literal := l.readIdentifier() // readIdentifier consumes chars & updates l.ch, l.position
 			tok = Token{Type: IDENT, Literal: literal, Position: tokenStartPosition}
+			if strings.EqualFold(literal, "angle") { // The spelled-out phasor operator: 10 angle 30
+				tok.Type = ANGLE
+			} else if keyword, found := keywordOperators[strings.ToLower(literal)]; found {
+				tok.Type = keyword
+			}
 			return tok // Return directly; readIdentifier already advanced past the token
 		} else if isDigit(l.ch) {
 			literal := l.readNumber() // readNumber consumes chars & updates l.ch, l.position
@@ -101,6 +167,14 @@ func (l *Lexer) NextToken() Token {
 	return tok
 }
 
+// keywordOperators are the operators spelled as words. They cannot be used as names.
+var keywordOperators = map[string]TokenType{
+	"xor": XOR,
+	"and": AND,
+	"or":  OR,
+	"not": NOT,
+}
+
 func (l *Lexer) skipWhitespace() {
 	for unicode.IsSpace(l.ch) {
 		l.readChar()
@@ -118,11 +192,19 @@ func (l *Lexer) readIdentifier() string {
 }
 
 // readNumber reads in a number (integer or float) and advances the lexer's position.
+// If the locale writes decimals with a comma, a comma followed by a digit is the decimal
+// mark as well as the point, so 3,5 and 3.5 are the same number but f(1, 2) has two arguments.
+// A number without an exponent may end in an SI prefix, as in 4.7k or 100n, if the prefix
+// is not the start of a longer name or of a call and is not bound as a variable or parameter:
+// 2min and 2f(x) are still products, and so is 2n when n is a variable.
 func (l *Lexer) readNumber() string {
+	if literal, ok := l.readBasedNumber(); ok {
+		return literal
+	}
 	position := l.position
 	hasDecimal := false
-	for isDigit(l.ch) || (l.ch == '.' && !hasDecimal) {
-		if l.ch == '.' {
+	for isDigit(l.ch) || (l.isDecimalMark() && !hasDecimal) {
+		if !isDigit(l.ch) {
 			hasDecimal = true
 		}
 		l.readChar()
@@ -141,10 +223,24 @@ func (l *Lexer) readNumber() string {
 		for isDigit(l.ch) {
 			l.readChar()
 		}
+		return l.input[position:l.position]
+	}
+	if next := l.peekChar(); isSIPrefix(l.ch) && !isLetter(next) && !isDigit(next) && next != '(' && !l.isBound(string(l.ch)) {
+		l.readChar() // consume the prefix
 	}
 	return l.input[position:l.position]
 }
 
+// isBound reports whether name is a variable or parameter.
+func (l *Lexer) isBound(name string) bool {
+	return l.bound != nil && l.bound(name)
+}
+
+// isDecimalMark reports whether the current character is a decimal mark.
+func (l *Lexer) isDecimalMark() bool {
+	return l.ch == '.' || (l.decimalComma && l.ch == ',' && isDigit(l.peekChar()))
+}
+
 // Helper functions for character types
 func isLetter(ch rune) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
@@ -157,7 +253,16 @@ func isDigit(ch rune) bool {
 
 // Lex function (adjust error message for new Token.Position)
 func Lex(input string) ([]Token, error) {
+	return lexLocale(input, LocalePlain, nil)
+}
+
+// lexLocale is like Lex, but reads numbers and argument separators as the locale writes them,
+// and reads a letter after a number as a name rather than an SI prefix if bound reports it
+// (bound may be nil).
+func lexLocale(input string, locale Locale, bound func(name string) bool) ([]Token, error) {
 	l := NewLexer(input)
+	l.decimalComma = locale.decimalComma()
+	l.bound = bound
 	var tokens []Token
 	for {
 		tok := l.NextToken()
@@ -166,7 +271,7 @@ func Lex(input string) ([]Token, error) {
 			break
 		}
 		if tok.Type == ILLEGAL {
-			return tokens, NewCalculationError(fmt.Sprintf("illegal character '%s' found at position %d", tok.Literal, tok.Position))
+			return tokens, newTokenError(KindLex, tok, fmt.Sprintf("illegal character '%s' found at position %d", tok.Literal, tok.Position))
 		}
 	}
 	return tokens, nil
@@ -0,0 +1,157 @@
+// limits.go
+package toycalc_core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Limits bounds the resources a single statement may use. A zero field means no limit.
+type Limits struct {
+	MaxInputBytes int // Length of the statement text in bytes
+	MaxTokens     int // Number of tokens produced by the lexer
+	MaxParenDepth int // Nesting depth of (), [] and {}
+	MaxRPNLength  int // Number of tokens in the parsed RPN queue
+	MaxOperations int // Evaluation steps, including those of user functions and iterative numeric routines
+}
+
+// DefaultLimits returns limits that are generous for interactive use but keep hostile
+// input (e.g. on a public web server) from exhausting memory or CPU.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxInputBytes: 4096,
+		MaxTokens:     1024,
+		MaxParenDepth: 64,
+		MaxRPNLength:  1024,
+		MaxOperations: 1000000,
+	}
+}
+
+// Names of the limits, as reported in LimitError.Limit.
+const (
+	LimitInputBytes = "input bytes"
+	LimitTokens     = "tokens"
+	LimitParenDepth = "parenthesis depth"
+	LimitRPNLength  = "RPN length"
+	LimitOperations = "operations"
+)
+
+// ErrLimitExceeded matches every LimitError with errors.Is.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError reports that a statement exceeded one of its Limits.
+type LimitError struct {
+	Limit    string // One of the Limit* names
+	Max      int    // The configured maximum
+	Position int    // Position in the input where the limit was reached, or -1 if not applicable
+}
+
+func (e *LimitError) Error() string {
+	if e.Position < 0 {
+		return fmt.Sprintf("Calculation error: limit exceeded: more than %d %s", e.Max, e.Limit)
+	}
+	return fmt.Sprintf("Calculation error: limit exceeded: more than %d %s at position %d", e.Max, e.Limit, e.Position)
+}
+
+// Is makes errors.Is(err, ErrLimitExceeded) true for every LimitError.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// Unwrap exposes the error as a *CalculationError of kind KindLimit, so that it can be
+// handled (and displayed) like every other calculation error.
+func (e *LimitError) Unwrap() error {
+	calcErr := &CalculationError{Message: strings.TrimPrefix(e.Error(), "Calculation error: "), Kind: KindLimit}
+	if e.Position >= 0 {
+		calcErr.Start, calcErr.End = e.Position, e.Position+1
+	}
+	return calcErr
+}
+
+// checkInput enforces MaxInputBytes.
+func (l Limits) checkInput(input string) error {
+	if l.MaxInputBytes > 0 && len(input) > l.MaxInputBytes {
+		return &LimitError{Limit: LimitInputBytes, Max: l.MaxInputBytes, Position: l.MaxInputBytes}
+	}
+	return nil
+}
+
+// checkTokens enforces MaxTokens and MaxParenDepth on the output of Lex.
+func (l Limits) checkTokens(tokens []Token) error {
+	depth := 0
+	count := 0
+	for _, token := range tokens {
+		if token.Type == EOF {
+			break
+		}
+		count++
+		if l.MaxTokens > 0 && count > l.MaxTokens {
+			return &LimitError{Limit: LimitTokens, Max: l.MaxTokens, Position: token.Position}
+		}
+		switch token.Type {
+		case LPAREN, LBRACKET, LBRACE:
+			depth++
+			if l.MaxParenDepth > 0 && depth > l.MaxParenDepth {
+				return &LimitError{Limit: LimitParenDepth, Max: l.MaxParenDepth, Position: token.Position}
+			}
+		case RPAREN, RBRACKET, RBRACE:
+			depth--
+		}
+	}
+	return nil
+}
+
+// checkRPN enforces MaxRPNLength on the output of the parser.
+func (l Limits) checkRPN(rpn []Token) error {
+	if l.MaxRPNLength > 0 && len(rpn) > l.MaxRPNLength {
+		return &LimitError{Limit: LimitRPNLength, Max: l.MaxRPNLength, Position: rpn[l.MaxRPNLength].Position}
+	}
+	return nil
+}
+
+// Budget tracks the operations spent by one evaluation and watches its context.
+// Functions registered with a BudgetedImpl receive it and must call Spend in their loops,
+// so that long computations stop at the operation limit or when the context is cancelled.
+// A nil *Budget places no limits.
+type Budget struct {
+	ctx           context.Context
+	maxOperations int
+	operations    int
+}
+
+// budgetCheckInterval is how many operations may pass between checks of the context.
+const budgetCheckInterval = 256
+
+func newBudget(ctx context.Context, limits Limits) *Budget {
+	return &Budget{ctx: ctx, maxOperations: limits.MaxOperations}
+}
+
+// Spend records n operations. It returns a *LimitError once the operation limit is
+// exceeded, or the context's error (wrapped) once it is cancelled.
+func (b *Budget) Spend(n int) error {
+	if b == nil {
+		return nil
+	}
+	before := b.operations
+	b.operations += n
+	if b.maxOperations > 0 && b.operations > b.maxOperations {
+		return &LimitError{Limit: LimitOperations, Max: b.maxOperations, Position: -1}
+	}
+	if before/budgetCheckInterval != b.operations/budgetCheckInterval || before == 0 {
+		return b.Err()
+	}
+	return nil
+}
+
+// Err returns a non-nil error if the evaluation's context has been cancelled.
+func (b *Budget) Err() error {
+	if b == nil || b.ctx == nil {
+		return nil
+	}
+	if err := b.ctx.Err(); err != nil {
+		return fmt.Errorf("calculation stopped: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// locale.go
+package toycalc_core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Locale selects how numbers are written in expressions and results.
+type Locale string
+
+const (
+	LocalePlain   Locale = ""   // Default: 1234.5 in and out, ',' between arguments
+	LocaleEnglish Locale = "en" // Results like 1,234.5
+	LocaleSpanish Locale = "es" // 1234,5 accepted, ';' between arguments; results like 12.345,5 (but 1234,5)
+	LocaleGerman  Locale = "de" // 1234,5 accepted, ';' between arguments; results like 1.234,5
+)
+
+// localeFormat describes how a locale writes numbers.
+type localeFormat struct {
+	decimalMark    byte
+	groupSeparator byte // 0 for no grouping
+	// minimumGroupingDigits is how many digits must precede the first separator:
+	// with 2, 1234 is left alone and 12345 becomes 12.345.
+	minimumGroupingDigits int
+}
+
+var localeFormats = map[Locale]localeFormat{
+	LocalePlain:   {decimalMark: '.'},
+	LocaleEnglish: {decimalMark: '.', groupSeparator: ',', minimumGroupingDigits: 1},
+	LocaleSpanish: {decimalMark: ',', groupSeparator: '.', minimumGroupingDigits: 2},
+	LocaleGerman:  {decimalMark: ',', groupSeparator: '.', minimumGroupingDigits: 1},
+}
+
+// decimalComma reports whether the locale writes decimals with a comma, in which case
+// expressions separate arguments with ';'.
+func (l Locale) decimalComma() bool {
+	return localeFormats[l].decimalMark == ','
+}
+
+func validateLocale(locale Locale) error {
+	if _, found := localeFormats[locale]; !found {
+		return NewCalculationError(fmt.Sprintf("unknown locale '%s'; use 'en', 'es' or 'de'", locale))
+	}
+	return nil
+}
+
+// localizeNumbers rewrites the numbers in a formatted result with the locale's decimal
+// mark and digit grouping; exponents, based numbers like 0x1F and everything that is not a
+// number are kept.
+func localizeNumbers(text string, locale Locale) string {
+	format := localeFormats[locale]
+	if format.decimalMark == '.' && format.groupSeparator == 0 {
+		return text
+	}
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		if !isDigit(rune(text[i])) {
+			sb.WriteByte(text[i])
+			i++
+			continue
+		}
+		start := i
+		if text[i] == '0' && i+1 < len(text) && basePrefixes[rune(text[i+1])] != 0 { // 0x1F, 0b1010
+			for i += 2; i < len(text) && isHexDigit(rune(text[i])); i++ {
+			}
+			sb.WriteString(text[start:i])
+			continue
+		}
+		for i < len(text) && isDigit(rune(text[i])) {
+			i++
+		}
+		writeGrouped(&sb, text[start:i], format)
+		if i+1 < len(text) && text[i] == '.' && isDigit(rune(text[i+1])) {
+			sb.WriteByte(format.decimalMark)
+			i++
+			for i < len(text) && isDigit(rune(text[i])) {
+				sb.WriteByte(text[i])
+				i++
+			}
+		}
+		// Copy an exponent such as e+06 or e-3 unchanged.
+		if i < len(text) && (text[i] == 'e' || text[i] == 'E') {
+			j := i + 1
+			if j < len(text) && (text[j] == '+' || text[j] == '-') {
+				j++
+			}
+			if j < len(text) && isDigit(rune(text[j])) {
+				for j < len(text) && isDigit(rune(text[j])) {
+					j++
+				}
+				sb.WriteString(text[i:j])
+				i = j
+			}
+		}
+	}
+	return sb.String()
+}
+
+// writeGrouped writes the integer digits with the locale's group separator every three
+// digits, if there are enough of them.
+func writeGrouped(sb *strings.Builder, digits string, format localeFormat) {
+	if format.groupSeparator == 0 || len(digits) < 3+format.minimumGroupingDigits {
+		sb.WriteString(digits)
+		return
+	}
+	first := len(digits) % 3
+	if first == 0 {
+		first = 3
+	}
+	sb.WriteString(digits[:first])
+	for i := first; i < len(digits); i += 3 {
+		sb.WriteByte(format.groupSeparator)
+		sb.WriteString(digits[i : i+3])
+	}
+}
@@ -0,0 +1,148 @@
+// logic.go
+package toycalc_core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// operand is a value on the evaluation stack: a number or a vector. Comparisons and logical
+// operators produce booleans: the numbers 1 and 0, marked so that they are displayed as true
+// and false. Arithmetic on a boolean uses its number and produces a plain number.
+type operand[T any] struct {
+	value    T
+	boolean  bool
+	elements []T // The elements of a vector, whose value is unused; nil for numbers
+
+	// integer is the exact value of an integer computed in the default number system, whose
+	// value may have been rounded, for the functions on integers; see trackInteger.
+	integer *gaussianInteger
+}
+
+// formatBoolean renders the result of a comparison or logical operator.
+func formatBoolean(b bool) string {
+	return Ternary(b, "true", "false")
+}
+
+// boolean returns b as an operand.
+func (ev evaluator[T]) boolean(b bool) (operand[T], error) {
+	number := complex128(0)
+	if b {
+		number = 1
+	}
+	value, err := ev.numbers.fromComplex(number)
+	return operand[T]{value: value, boolean: true}, err
+}
+
+// truth reports whether x counts as true, which every number but 0 does. Every number system
+// holds 0, and testing for equality cannot fail.
+func (ev evaluator[T]) truth(x T) bool {
+	zero, _ := ev.numbers.fromComplex(0)
+	isZero, _ := ev.numbers.compare(Token{Type: EQUAL, Literal: "=="}, x, zero, 0)
+	return !isZero
+}
+
+// lazyOperation is a call to if, or an and or or operator, whose operands are evaluated only
+// when they decide the result.
+type lazyOperation struct {
+	end      int       // Index of the operator or the call in the RPN queue
+	operands [][]Token // The RPN of each operand
+}
+
+// lazyOperations finds the lazy operations in rpn, keyed by the index their subexpression
+// starts at. Where several start at the same index, the outermost one is kept; the others
+// are found again when its operands are evaluated. It returns nil if rpn is malformed, which
+// the evaluation loop reports.
+func lazyOperations(rpn []Token, registry *Registry) map[int]lazyOperation {
+	var lazy map[int]lazyOperation
+	starts := make([]int, len(rpn)) // starts[i] is where the subexpression ending at i starts
+	stack := []int{}                // Indices of the subexpressions evaluated so far
+	for index, token := range rpn {
+		count := 2
+		switch token.Type {
+		case NUMBER:
+			count = 0
+		case UNARY_MINUS, TILDE, NOT, DEGREE, FACTORIAL, DOUBLE_FACTORIAL:
+			count = 1
+		case IDENT:
+			count = token.Arity
+		}
+		if len(stack) < count {
+			return nil
+		}
+		starts[index] = index
+		if count > 0 {
+			starts[index] = starts[stack[len(stack)-count]]
+		}
+		operands := append([]int(nil), stack[len(stack)-count:]...)
+		stack = append(stack[:len(stack)-count], index)
+
+		if !isLazy(token, registry) {
+			continue
+		}
+		operation := lazyOperation{end: index, operands: make([][]Token, count)}
+		for i, end := range operands {
+			operation.operands[i] = rpn[starts[end] : end+1]
+		}
+		if previous, found := lazy[starts[index]]; !found || previous.end < index {
+			if lazy == nil {
+				lazy = map[int]lazyOperation{}
+			}
+			lazy[starts[index]] = operation
+		}
+	}
+	return lazy
+}
+
+// isLazy reports whether token is an and or or operator or a call to the if function.
+func isLazy(token Token, registry *Registry) bool {
+	if token.Type == AND || token.Type == OR {
+		return true
+	}
+	if token.Type != IDENT || token.Arity != 3 || !strings.EqualFold(token.Literal, "if") {
+		return false
+	}
+	_, found := registry.Function("if")
+	return found
+}
+
+// evaluateLazily evaluates a lazy operation. The chosen branch of an if keeps the display of
+// a described call when the operation is the whole expression (last).
+func (ev evaluator[T]) evaluateLazily(operation lazyOperation, token Token, env *Environment, depth int, last bool) (operand[T], error) {
+	quiet := ev
+	quiet.display = nil
+	condition, err := quiet.evaluate(operation.operands[0], env, depth)
+	if err != nil {
+		return operand[T]{}, err
+	}
+	if condition.isVector() && token.Type == IDENT {
+		return operand[T]{}, newTokenError(KindDomain, token, fmt.Sprintf("function '%s' at position %d needs a number as its condition, not a vector", token.Literal, token.Position))
+	} else if condition.isVector() {
+		return operand[T]{}, vectorError(token)
+	}
+	truth := ev.truth(condition.value)
+
+	switch token.Type {
+	case AND, OR:
+		if truth == (token.Type == OR) { // false and x, true or x
+			return ev.boolean(truth)
+		}
+		other, err := quiet.evaluate(operation.operands[1], env, depth)
+		if err != nil {
+			return operand[T]{}, err
+		}
+		if other.isVector() {
+			return operand[T]{}, vectorError(token)
+		}
+		return ev.boolean(ev.truth(other.value))
+	}
+
+	branch := quiet
+	if last {
+		branch = ev
+	}
+	if truth {
+		return branch.evaluate(operation.operands[1], env, depth)
+	}
+	return branch.evaluate(operation.operands[2], env, depth)
+}
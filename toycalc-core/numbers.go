@@ -0,0 +1,201 @@
+// numbers.go
+package toycalc_core
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strconv"
+)
+
+// numberSystem is the arithmetic an evaluation is carried out in: complex128 by default,
+// complex numbers with big.Float components in high-precision mode, Gaussian rationals in
+// exact mode, or fixed-size integers in programmer mode. T is the type of its values.
+// The evaluator handles everything else (the stack, variables, user functions, arity, angle
+// mode and errors) the same way for every number system. Methods that cannot fail in every
+// number system return an error without a position, which the evaluator adds.
+type numberSystem[T any] interface {
+	number(token Token) (T, error)                                  // The value of a NUMBER literal
+	constant(def ConstantDef) (T, error)                            // The value of a registered constant
+	fromComplex(c complex128) (T, error)                            // Converts a variable stored as complex128
+	toComplex(x T) complex128                                       // Converts a value for storage as complex128
+	precise(x T) any                                                // What to keep beside toComplex(x) in variables, or nil
+	operate(op Token, a, b T) (T, error)                            // Applies a binary operator
+	negate(x T) T                                                   // Applies UNARY_MINUS
+	complement(op Token, x T) (T, error)                            // Applies TILDE
+	compare(op Token, a, b T, tolerance float64) (bool, error)      // Applies a comparison operator
+	factorial(op Token, x T, budget *Budget) (T, error)             // Applies FACTORIAL and DOUBLE_FACTORIAL
+	call(function FunctionDef, args []T, budget *Budget) (T, error) // Applies a registered function
+	degreesToRadians(x T) (T, error)                                // Converts an angle argument in degree mode
+	radiansToDegrees(x T) (T, error)                                // Converts an angle result in degree mode
+	format(x T, settings Settings) string                           // Renders a result for display
+}
+
+// complexNumbers is the default number system: complex128 arithmetic.
+type complexNumbers struct{}
+
+func (complexNumbers) number(token Token) (complex128, error) {
+	// The lexer ensures number literals are in a format ParseFloat can handle (incl. scientific)
+	val, err := strconv.ParseFloat(numberText(token.Literal), 64)
+	if err != nil {
+		return complex(math.NaN(), math.NaN()), err
+	}
+	return complex(val, 0), nil
+}
+
+func (complexNumbers) constant(def ConstantDef) (complex128, error) { return def.Value, nil }
+func (complexNumbers) fromComplex(c complex128) (complex128, error) { return c, nil }
+func (complexNumbers) toComplex(x complex128) complex128            { return x }
+func (complexNumbers) precise(complex128) any                       { return nil }
+
+func (complexNumbers) operate(op Token, a, b complex128) (complex128, error) {
+	switch op.Type {
+	case PLUS:
+		return a + b, nil
+	case MINUS:
+		return a - b, nil
+	case ASTERISK:
+		return a * b, nil
+	case SLASH:
+		return a / b, nil
+	case SLASH_SLASH:
+		if b == 0 {
+			return complex(math.NaN(), math.NaN()), operatorError(op, errDivisionByZero)
+		}
+		q := a / b
+		return complex(math.Floor(real(q)), math.Floor(imag(q))), nil
+	case PERCENT:
+		return calculateModulo(a, b, op)
+	case CARET:
+		return cmplx.Pow(a, b), nil
+	case ANGLE:
+		return a * cmplx.Exp(complex(0, 1)*b), nil // Like polar(a, b)
+	case AMPERSAND, PIPE, XOR, SHIFT_LEFT, SHIFT_RIGHT:
+		x, ok := floatInteger(a)
+		if !ok {
+			return complex(math.NaN(), math.NaN()), bitwiseOperandError(op, a)
+		}
+		y, ok := floatInteger(b)
+		if !ok {
+			return complex(math.NaN(), math.NaN()), bitwiseOperandError(op, b)
+		}
+		result, err := integerOperate(op, x, y)
+		if err != nil {
+			return complex(math.NaN(), math.NaN()), err
+		}
+		value, _ := new(big.Float).SetInt(result).Float64()
+		return complex(value, 0), nil
+	}
+	return complex(math.NaN(), math.NaN()), newTokenError(KindParse, op,
+		fmt.Sprintf("unexpected operator '%s' at position %d", op.Literal, op.Position),
+	)
+}
+
+func (complexNumbers) negate(x complex128) complex128 {
+	tempRes := -x // Perform the negation, e.g., -(4+0i) -> (-4-0i)
+
+	// Normalize signed zeros in the result of this UNARY_MINUS operation.
+	// This ensures that if the user types "-N" (N positive real),
+	// it's treated as complex(-N, +0.0) for subsequent operations
+	// like Pow, aligning with the standard branch cut convention for Log.
+	r := real(tempRes)
+	i := imag(tempRes)
+
+	if r == 0.0 { // This normalizes -0.0 real to +0.0 real
+		r = 0.0 // Assigning 0.0 defaults to +0.0
+	}
+	if i == 0.0 { // This normalizes -0.0 imag to +0.0 imag
+		i = 0.0 // Assigning 0.0 defaults to +0.0
+	}
+	return complex(r, i)
+}
+
+func (complexNumbers) complement(op Token, x complex128) (complex128, error) {
+	integer, ok := floatInteger(x)
+	if !ok {
+		return complex(math.NaN(), math.NaN()), bitwiseOperandError(op, x)
+	}
+	value, _ := new(big.Float).SetInt(integer.Not(integer)).Float64()
+	return complex(value, 0), nil
+}
+
+// compare treats a and b as equal when |a-b| <= tolerance * max(1, |a|, |b|).
+func (complexNumbers) compare(op Token, a, b complex128, tolerance float64) (bool, error) {
+	equal := a == b
+	if !equal && !cmplx.IsInf(a) && !cmplx.IsInf(b) {
+		equal = cmplx.Abs(a-b) <= tolerance*max(1, cmplx.Abs(a), cmplx.Abs(b))
+	}
+	return comparison(op, equal, func() (int, error) {
+		if imag(a) != 0 {
+			return 0, orderingOperandError(op, a)
+		}
+		if imag(b) != 0 {
+			return 0, orderingOperandError(op, b)
+		}
+		return cmp.Compare(real(a), real(b)), nil
+	})
+}
+
+// comparison applies the comparison operator op to two operands, given whether they are equal
+// within the tolerance. order, which orders real operands and rejects complex ones, is only
+// called for <, <=, > and >=. Operands equal within the tolerance are equal for those too, so
+// 0.1 + 0.2 <= 0.3 holds just like 0.1 + 0.2 == 0.3.
+func comparison(op Token, equal bool, order func() (int, error)) (bool, error) {
+	switch op.Type {
+	case EQUAL:
+		return equal, nil
+	case NOT_EQUAL:
+		return !equal, nil
+	}
+	sign, err := order()
+	if err != nil {
+		return false, err
+	}
+	if equal {
+		sign = 0
+	}
+	switch op.Type {
+	case LESS:
+		return sign < 0, nil
+	case LESS_EQUAL:
+		return sign <= 0, nil
+	case GREATER:
+		return sign > 0, nil
+	case GREATER_EQUAL:
+		return sign >= 0, nil
+	}
+	return false, newTokenError(KindParse, op, fmt.Sprintf("unexpected operator '%s' at position %d", op.Literal, op.Position))
+}
+
+// orderingOperandError reports a complex operand of <, <=, > or >=.
+func orderingOperandError(op Token, c complex128) error {
+	return newTokenError(KindDomain, op, fmt.Sprintf("operator '%s' at position %d compares real numbers only, got the complex value %s", op.Literal, op.Position, formatComplexOutput(c)))
+}
+
+func (complexNumbers) factorial(op Token, x complex128, budget *Budget) (complex128, error) {
+	return complexFactorial(op, x, budget)
+}
+
+func (complexNumbers) call(function FunctionDef, args []complex128, budget *Budget) (complex128, error) {
+	if function.integerImpl != nil {
+		return function.integerImpl.callFloat(budget, args)
+	}
+	if function.Budgeted != nil {
+		return function.Budgeted(budget, args)
+	}
+	return function.Impl(args)
+}
+
+func (complexNumbers) degreesToRadians(x complex128) (complex128, error) {
+	return x * (math.Pi / 180), nil
+}
+
+func (complexNumbers) radiansToDegrees(x complex128) (complex128, error) {
+	return x * (180 / math.Pi), nil
+}
+
+func (complexNumbers) format(x complex128, settings Settings) string {
+	return formatComplex(x, settings)
+}
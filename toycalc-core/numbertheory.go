@@ -0,0 +1,811 @@
+// numbertheory.go
+package toycalc_core
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// maxFloatInteger is 2^53: above it complex128 cannot hold every integer, so the default
+// number system keeps larger integers exactly beside their floats (see operand.integer) and
+// the functions on integers refuse floats beyond it, which may be a neighbour of the number
+// that was meant.
+const maxFloatInteger = 1 << 53
+
+// trialDivisionLimit is the largest prime factor factorInteger looks for by trial division;
+// larger factors are found with Pollard's rho method.
+const trialDivisionLimit = 1000
+
+// gaussianInteger is a complex number re + im*i with integer parts. The functions below work
+// in Z when all their arguments are real, and in Z[i] otherwise.
+type gaussianInteger struct {
+	re, im *big.Int
+}
+
+// integerImpl computes a function on Gaussian integers. Every number system converts its
+// arguments for it, so that integers keep every digit in exact and high-precision mode.
+type integerImpl func(budget *Budget, args []gaussianInteger) (gaussianInteger, error)
+
+// describeImpl renders the result of a call that is the whole expression, like the
+// factorization shown by factor(360), when that says more than its value.
+type describeImpl func(budget *Budget, args []gaussianInteger) (string, error)
+
+// primePower is a factor p^e of a factorization.
+type primePower struct {
+	p gaussianInteger
+	e int
+}
+
+var (
+	errNotInteger      = errors.New("the arguments must be integers or Gaussian integers")
+	errZeroFactorized  = errors.New("undefined for 0")
+	errTooLargeInteger = errors.New("integers beyond 2^53 computed in floating point may have been rounded; use exact mode ('set exact on')")
+)
+
+func gaussianOf(re, im int64) gaussianInteger {
+	return gaussianInteger{big.NewInt(re), big.NewInt(im)}
+}
+
+func realInteger(x *big.Int) gaussianInteger {
+	return gaussianInteger{x, new(big.Int)}
+}
+
+func (z gaussianInteger) isReal() bool { return z.im.Sign() == 0 }
+func (z gaussianInteger) isZero() bool { return z.re.Sign() == 0 && z.im.Sign() == 0 }
+
+func (z gaussianInteger) equals(w gaussianInteger) bool {
+	return z.re.Cmp(w.re) == 0 && z.im.Cmp(w.im) == 0
+}
+
+func (z gaussianInteger) add(w gaussianInteger) gaussianInteger {
+	return gaussianInteger{new(big.Int).Add(z.re, w.re), new(big.Int).Add(z.im, w.im)}
+}
+
+func (z gaussianInteger) sub(w gaussianInteger) gaussianInteger {
+	return gaussianInteger{new(big.Int).Sub(z.re, w.re), new(big.Int).Sub(z.im, w.im)}
+}
+
+func (z gaussianInteger) mul(w gaussianInteger) gaussianInteger {
+	re := new(big.Int).Mul(z.re, w.re)
+	re.Sub(re, new(big.Int).Mul(z.im, w.im))
+	im := new(big.Int).Mul(z.re, w.im)
+	im.Add(im, new(big.Int).Mul(z.im, w.re))
+	return gaussianInteger{re, im}
+}
+
+func (z gaussianInteger) conj() gaussianInteger {
+	return gaussianInteger{z.re, new(big.Int).Neg(z.im)}
+}
+
+// norm is re² + im², the number of residues modulo z.
+func (z gaussianInteger) norm() *big.Int {
+	n := new(big.Int).Mul(z.re, z.re)
+	return n.Add(n, new(big.Int).Mul(z.im, z.im))
+}
+
+// quoRem divides z by w != 0 with the quotient rounded to the nearest Gaussian integer, as
+// the '%' operator does, so that N(remainder) <= N(w)/2.
+func (z gaussianInteger) quoRem(w gaussianInteger) (gaussianInteger, gaussianInteger) {
+	numerator := z.mul(w.conj())
+	n := w.norm()
+	q := gaussianInteger{roundedQuotient(numerator.re, n), roundedQuotient(numerator.im, n)}
+	return q, z.sub(q.mul(w))
+}
+
+// roundedQuotient is x/n rounded to the nearest integer, for n > 0.
+func roundedQuotient(x, n *big.Int) *big.Int {
+	twice := new(big.Int).Lsh(x, 1)
+	twice.Add(twice, n)
+	return twice.Div(twice, new(big.Int).Lsh(n, 1)) // Euclidean division floors for n > 0
+}
+
+// exactQuo returns z/w if w divides z.
+func (z gaussianInteger) exactQuo(w gaussianInteger) (gaussianInteger, bool) {
+	numerator := z.mul(w.conj())
+	n := w.norm()
+	re, reRem := new(big.Int).QuoRem(numerator.re, n, new(big.Int))
+	im, imRem := new(big.Int).QuoRem(numerator.im, n, new(big.Int))
+	return gaussianInteger{re, im}, reRem.Sign() == 0 && imRem.Sign() == 0
+}
+
+// normalized returns the associate of z in the first quadrant (re > 0, im >= 0), which for
+// real integers is their absolute value, and the unit u with z = u * normalized.
+func (z gaussianInteger) normalized() (gaussianInteger, gaussianInteger) {
+	unit := gaussianOf(1, 0)
+	if z.isZero() {
+		return z, unit
+	}
+	for z.re.Sign() <= 0 || z.im.Sign() < 0 {
+		z = z.mul(gaussianOf(0, -1))
+		unit = unit.mul(gaussianOf(0, 1))
+	}
+	return z, unit
+}
+
+// String formats z compactly, like "2-i" or "-3i".
+func (z gaussianInteger) String() string {
+	switch {
+	case z.isReal():
+		return z.re.String()
+	case z.re.Sign() == 0:
+		return imaginaryText(z.im)
+	case z.im.Sign() < 0:
+		return z.re.String() + imaginaryText(z.im)
+	}
+	return z.re.String() + "+" + imaginaryText(z.im)
+}
+
+func imaginaryText(im *big.Int) string {
+	switch {
+	case im.IsInt64() && im.Int64() == 1:
+		return "i"
+	case im.IsInt64() && im.Int64() == -1:
+		return "-i"
+	}
+	return im.String() + "i"
+}
+
+// operand formats z as an operand of '*', in parentheses unless it is a natural number.
+func (z gaussianInteger) operand() string {
+	if z.isReal() && z.re.Sign() >= 0 {
+		return z.String()
+	}
+	return "(" + z.String() + ")"
+}
+
+// Conversions from the values of the number systems. Each fails with errNotInteger for
+// values that are not Gaussian integers, and with errTooLargeInteger for values that may
+// have been rounded to an integer.
+
+func floatGaussian(c complex128) (gaussianInteger, error) {
+	re, im := real(c), imag(c)
+	if math.IsInf(re, 0) || math.IsNaN(re) || re != math.Trunc(re) || math.IsInf(im, 0) || math.IsNaN(im) || im != math.Trunc(im) {
+		return gaussianInteger{}, fmt.Errorf("%w, got %s", errNotInteger, formatComplexOutput(c))
+	}
+	if math.Abs(re) > maxFloatInteger || math.Abs(im) > maxFloatInteger {
+		return gaussianInteger{}, errTooLargeInteger
+	}
+	z := gaussianInteger{new(big.Int), new(big.Int)}
+	big.NewFloat(re).Int(z.re)
+	big.NewFloat(im).Int(z.im)
+	return z, nil
+}
+
+// fitsFloat reports whether complex128 holds z exactly, as it does every Gaussian integer
+// with parts up to 2^53.
+func (z gaussianInteger) fitsFloat() bool {
+	return z.re.CmpAbs(big.NewInt(maxFloatInteger)) <= 0 && z.im.CmpAbs(big.NewInt(maxFloatInteger)) <= 0
+}
+
+// toComplex is the complex128 nearest to z.
+func (z gaussianInteger) toComplex() complex128 {
+	re, _ := new(big.Float).SetInt(z.re).Float64()
+	im, _ := new(big.Float).SetInt(z.im).Float64()
+	return complex(re, im)
+}
+
+func rationalGaussian(x gaussianRational) (gaussianInteger, bool) {
+	if !x.re.IsInt() || !x.im.IsInt() {
+		return gaussianInteger{}, false
+	}
+	return gaussianInteger{new(big.Int).Set(x.re.Num()), new(big.Int).Set(x.im.Num())}, true
+}
+
+func (z gaussianInteger) toRational() gaussianRational {
+	return gaussianRational{new(big.Rat).SetInt(z.re), new(big.Rat).SetInt(z.im)}
+}
+
+// bigGaussian converts a value of high-precision mode, where integers with more than prec
+// bits may have been rounded.
+func bigGaussian(z bigComplex, prec uint) (gaussianInteger, error) {
+	if z.re.IsInf() || z.im.IsInf() || !z.re.IsInt() || !z.im.IsInt() {
+		return gaussianInteger{}, fmt.Errorf("%w, got %s", errNotInteger, formatComplexOutput(bigNumbers{}.toComplex(z)))
+	}
+	re, _ := z.re.Int(nil)
+	im, _ := z.im.Int(nil)
+	if uint(re.BitLen()) > prec || uint(im.BitLen()) > prec {
+		return gaussianInteger{}, fmt.Errorf("integers beyond 2^%d cannot be represented exactly at this precision", prec)
+	}
+	return gaussianInteger{re, im}, nil
+}
+
+// integerArgument converts a value of any number system, given as its complex128 value and
+// its precise form (see numberSystem.precise).
+func integerArgument(value complex128, precise any) (gaussianInteger, error) {
+	switch p := precise.(type) {
+	case exactValue:
+		if !p.approximate {
+			if z, ok := rationalGaussian(p.exact); ok {
+				return z, nil
+			}
+		}
+	case *big.Int:
+		return realInteger(p), nil
+	case bigComplex:
+		return bigGaussian(p, p.re.Prec())
+	}
+	return floatGaussian(value)
+}
+
+// callFloat applies f to complex128 arguments, for the default number system. The evaluator
+// calls f through callIntegers instead, which keeps results beyond 2^53 exactly.
+func (f integerImpl) callFloat(budget *Budget, args []complex128) (complex128, error) {
+	integers := make([]gaussianInteger, len(args))
+	for i, arg := range args {
+		var err error
+		if integers[i], err = floatGaussian(arg); err != nil {
+			return complex(math.NaN(), math.NaN()), err
+		}
+	}
+	result, err := f(budget, integers)
+	if err != nil {
+		return complex(math.NaN(), math.NaN()), err
+	}
+	return result.toComplex(), nil
+}
+
+// callBig applies f to the arguments of high-precision mode, whose values have bits bits.
+func (f integerImpl) callBig(budget *Budget, bits uint, args []bigComplex) (bigComplex, error) {
+	integers := make([]gaussianInteger, len(args))
+	for i, arg := range args {
+		var err error
+		if integers[i], err = bigGaussian(arg, bits); err != nil {
+			return bigComplex{}, err
+		}
+	}
+	result, err := f(budget, integers)
+	if err != nil {
+		return bigComplex{}, err
+	}
+	if uint(result.re.BitLen()) > bits || uint(result.im.BitLen()) > bits {
+		return bigComplex{}, fmt.Errorf("the result is beyond 2^%d and cannot be represented exactly at this precision", bits)
+	}
+	return bigComplex{newFloat(bits).SetInt(result.re), newFloat(bits).SetInt(result.im)}, nil
+}
+
+// gaussianGCD is the greatest common divisor of a and b in the first quadrant, computed with
+// the Euclidean algorithm. For real integers it is the usual non-negative one.
+func gaussianGCD(budget *Budget, a, b gaussianInteger) (gaussianInteger, error) {
+	for !b.isZero() {
+		if err := budget.Spend(1); err != nil {
+			return gaussianInteger{}, err
+		}
+		_, r := a.quoRem(b)
+		a, b = b, r
+	}
+	g, _ := a.normalized()
+	return g, nil
+}
+
+// gaussianLCM is a b / gcd(a, b) in the first quadrant, and 0 if a or b is.
+func gaussianLCM(budget *Budget, a, b gaussianInteger) (gaussianInteger, error) {
+	if a.isZero() || b.isZero() {
+		return realInteger(new(big.Int)), nil
+	}
+	g, err := gaussianGCD(budget, a, b)
+	if err != nil {
+		return gaussianInteger{}, err
+	}
+	quotient, _ := a.exactQuo(g)
+	l, _ := quotient.mul(b).normalized()
+	return l, nil
+}
+
+// foldIntegers adapts a binary operation to an integerImpl of any number of arguments.
+func foldIntegers(f func(budget *Budget, a, b gaussianInteger) (gaussianInteger, error)) integerImpl {
+	return func(budget *Budget, args []gaussianInteger) (gaussianInteger, error) {
+		result, _ := args[0].normalized()
+		for _, arg := range args[1:] {
+			var err error
+			if result, err = f(budget, result, arg); err != nil {
+				return gaussianInteger{}, err
+			}
+		}
+		return result, nil
+	}
+}
+
+// extendedGCD returns g = gcd(a, b) with s and t such that g = s a + t b. For real integers
+// the coefficients are the smallest ones, as math/big computes them.
+func extendedGCD(budget *Budget, a, b gaussianInteger) (g, s, t gaussianInteger, err error) {
+	if a.isReal() && b.isReal() {
+		x, y := new(big.Int), new(big.Int)
+		d := new(big.Int).GCD(x, y, a.re, b.re)
+		return realInteger(d), realInteger(x), realInteger(y), nil
+	}
+	// Invariants: r0 = s0 a + t0 b and r1 = s1 a + t1 b.
+	r0, s0, t0 := a, gaussianOf(1, 0), gaussianOf(0, 0)
+	r1, s1, t1 := b, gaussianOf(0, 0), gaussianOf(1, 0)
+	for !r1.isZero() {
+		if err := budget.Spend(1); err != nil {
+			return g, s, t, err
+		}
+		q, r := r0.quoRem(r1)
+		r0, r1 = r1, r
+		s0, s1 = s1, s0.sub(q.mul(s1))
+		t0, t1 = t1, t0.sub(q.mul(t1))
+	}
+	g, unit := r0.normalized()
+	inverse := unit.conj() // Units are inverted by conjugation
+	return g, s0.mul(inverse), t0.mul(inverse), nil
+}
+
+func egcdValue(budget *Budget, args []gaussianInteger) (gaussianInteger, error) {
+	g, _, _, err := extendedGCD(budget, args[0], args[1])
+	return g, err
+}
+
+func egcdText(budget *Budget, args []gaussianInteger) (string, error) {
+	g, s, t, err := extendedGCD(budget, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s = %s*%s + %s*%s", g, s.operand(), args[0].operand(), t.operand(), args[1].operand()), nil
+}
+
+// isPrime reports whether n is prime in Z (up to sign) or, when gaussian, in Z[i]. Below
+// 2^64 the answer is certain; above, it is the Baillie-PSW test of math/big, to which no
+// counterexample is known.
+func isPrime(budget *Budget, n gaussianInteger, gaussian bool) (bool, error) {
+	if err := budget.Spend(n.re.BitLen() + n.im.BitLen()); err != nil {
+		return false, err
+	}
+	if !gaussian {
+		return new(big.Int).Abs(n.re).ProbablyPrime(20), nil
+	}
+	if n.re.Sign() != 0 && n.im.Sign() != 0 {
+		return n.norm().ProbablyPrime(20), nil
+	}
+	// Rational primes stay prime in Z[i] when they are 3 mod 4.
+	m := new(big.Int).Abs(n.re)
+	m.Add(m, new(big.Int).Abs(n.im))
+	return m.Bit(0) == 1 && m.Bit(1) == 1 && m.ProbablyPrime(20), nil
+}
+
+func isPrimeValue(budget *Budget, args []gaussianInteger) (gaussianInteger, error) {
+	prime, err := isPrime(budget, args[0], !args[0].isReal())
+	if err != nil || !prime {
+		return gaussianOf(0, 0), err
+	}
+	return gaussianOf(1, 0), nil
+}
+
+// factorInteger returns the prime factors of n > 0 in increasing order, with trial division
+// for the small ones and Pollard's rho method for the rest.
+func factorInteger(budget *Budget, n *big.Int) ([]primePower, error) {
+	var primes []*big.Int
+	n = new(big.Int).Set(n)
+	p, q, r := new(big.Int), new(big.Int), new(big.Int)
+	for d := int64(2); d <= trialDivisionLimit && n.Cmp(big.NewInt(d*d)) >= 0; d++ {
+		p.SetInt64(d)
+		for {
+			if err := budget.Spend(1); err != nil {
+				return nil, err
+			}
+			if q.QuoRem(n, p, r); r.Sign() != 0 {
+				break
+			}
+			primes = append(primes, big.NewInt(d))
+			n.Set(q)
+		}
+	}
+	pending := []*big.Int{n}
+	for len(pending) > 0 {
+		m := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if m.Cmp(big.NewInt(1)) == 0 {
+			continue
+		}
+		if m.ProbablyPrime(20) {
+			primes = append(primes, m)
+			continue
+		}
+		d, err := pollardRho(budget, m)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, d, new(big.Int).Quo(m, d))
+	}
+	sort.Slice(primes, func(i, j int) bool { return primes[i].Cmp(primes[j]) < 0 })
+	var factors []primePower
+	for _, prime := range primes {
+		if last := len(factors) - 1; last >= 0 && factors[last].p.re.Cmp(prime) == 0 {
+			factors[last].e++
+		} else {
+			factors = append(factors, primePower{realInteger(prime), 1})
+		}
+	}
+	return factors, nil
+}
+
+// pollardRho returns a non-trivial factor of the composite n, iterating x² + c modulo n with
+// Floyd's cycle detection and trying the next c when a cycle closes without one.
+func pollardRho(budget *Budget, n *big.Int) (*big.Int, error) {
+	one := big.NewInt(1)
+	for c := int64(1); ; c++ {
+		step := func(x *big.Int) {
+			x.Mul(x, x)
+			x.Add(x, big.NewInt(c))
+			x.Mod(x, n)
+		}
+		x, y, d := big.NewInt(2), big.NewInt(2), big.NewInt(1)
+		difference := new(big.Int)
+		for d.Cmp(one) == 0 {
+			if err := budget.Spend(1); err != nil {
+				return nil, err
+			}
+			step(x)
+			step(y)
+			step(y)
+			d.GCD(nil, nil, difference.Abs(difference.Sub(x, y)), n)
+		}
+		if d.Cmp(n) != 0 {
+			return d, nil
+		}
+	}
+}
+
+// splitPrime returns the Gaussian prime a+bi with a > b > 0 and a² + b² = p, for a prime
+// p = 1 mod 4: the gcd of p and k+i, where k² = -1 modulo p.
+func splitPrime(budget *Budget, p *big.Int) (gaussianInteger, error) {
+	exponent := new(big.Int).Rsh(p, 2) // (p-1)/4
+	k := new(big.Int)
+	for c := int64(2); ; c++ {
+		if err := budget.Spend(1); err != nil {
+			return gaussianInteger{}, err
+		}
+		if big.Jacobi(big.NewInt(c), p) == -1 { // c^((p-1)/2) = -1
+			k.Exp(big.NewInt(c), exponent, p)
+			break
+		}
+	}
+	g, err := gaussianGCD(budget, realInteger(p), gaussianInteger{k, big.NewInt(1)})
+	if err != nil {
+		return gaussianInteger{}, err
+	}
+	a, b := new(big.Int).Abs(g.re), new(big.Int).Abs(g.im)
+	if a.Cmp(b) < 0 {
+		a, b = b, a
+	}
+	return gaussianInteger{a, b}, nil
+}
+
+// normPrimes returns the rational primes dividing the norm of n != 0, in increasing order.
+// It factors the gcd g of the parts of n and N(n)/g² apart rather than N(n), in which every
+// factor of a real n is squared.
+func normPrimes(budget *Budget, n gaussianInteger) ([]*big.Int, error) {
+	g := new(big.Int).GCD(nil, nil, new(big.Int).Abs(n.re), new(big.Int).Abs(n.im))
+	rest := new(big.Int).Quo(n.norm(), new(big.Int).Mul(g, g))
+	var primes []*big.Int
+	for _, m := range []*big.Int{g, rest} {
+		factors, err := factorInteger(budget, m)
+		if err != nil {
+			return nil, err
+		}
+		for _, factor := range factors {
+			primes = append(primes, factor.p.re)
+		}
+	}
+	sort.Slice(primes, func(i, j int) bool { return primes[i].Cmp(primes[j]) < 0 })
+	var distinct []*big.Int
+	for _, p := range primes {
+		if len(distinct) == 0 || distinct[len(distinct)-1].Cmp(p) != 0 {
+			distinct = append(distinct, p)
+		}
+	}
+	return distinct, nil
+}
+
+// factorGaussian returns the unit u and the prime factors of n != 0 in Z[i], ordered by the
+// rational prime below them, so that n = u * product. The factors are 1+i, the rational
+// primes that are 3 mod 4, and the conjugate pairs a±bi with a > b > 0, as in
+// 5 = (2+i)(2-i).
+func factorGaussian(budget *Budget, n gaussianInteger) (gaussianInteger, []primePower, error) {
+	primes, err := normPrimes(budget, n)
+	if err != nil {
+		return gaussianInteger{}, nil, err
+	}
+	var factors []primePower
+	for _, p := range primes {
+		var candidates []gaussianInteger
+		switch {
+		case p.Cmp(big.NewInt(2)) == 0:
+			candidates = []gaussianInteger{gaussianOf(1, 1)}
+		case p.Bit(1) == 1: // 3 mod 4
+			candidates = []gaussianInteger{realInteger(p)}
+		default:
+			pi, err := splitPrime(budget, p)
+			if err != nil {
+				return gaussianInteger{}, nil, err
+			}
+			candidates = []gaussianInteger{pi, pi.conj()}
+		}
+		for _, candidate := range candidates {
+			e := 0
+			for {
+				quotient, divides := n.exactQuo(candidate)
+				if !divides {
+					break
+				}
+				n = quotient
+				e++
+			}
+			if e > 0 {
+				factors = append(factors, primePower{candidate, e})
+			}
+		}
+	}
+	return n, factors, nil
+}
+
+func factorValue(_ *Budget, args []gaussianInteger) (gaussianInteger, error) {
+	return args[0], nil
+}
+
+// factorText shows the factorization of n, like "2^3 * 3^2 * 5" in Z and "-i(1+i)^2" in Z[i].
+// Integers with a prime factor that splits in Z[i] show both, as in "5 = (2+i)(2-i)".
+func factorText(budget *Budget, args []gaussianInteger) (string, error) {
+	n := args[0]
+	if n.isZero() {
+		return "0", nil
+	}
+	if !n.isReal() {
+		return gaussianFactorText(budget, n)
+	}
+	factors, err := factorInteger(budget, new(big.Int).Abs(n.re))
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if n.re.Sign() < 0 {
+		sb.WriteString("-")
+	}
+	if len(factors) == 0 {
+		sb.WriteString("1")
+	}
+	splits := false
+	for i, factor := range factors {
+		if i > 0 {
+			sb.WriteString(" * ")
+		}
+		sb.WriteString(factor.p.String())
+		if factor.e > 1 {
+			fmt.Fprintf(&sb, "^%d", factor.e)
+		}
+		splits = splits || factor.p.re.Bit(1) == 0 || factor.p.re.Cmp(big.NewInt(2)) == 0 // 2 and the primes 1 mod 4
+	}
+	if splits {
+		text, err := gaussianFactorText(budget, n)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(" = " + text)
+	}
+	return sb.String(), nil
+}
+
+// gaussianFactorText shows the factorization of n != 0 in Z[i], like "-i(1+i)^2".
+func gaussianFactorText(budget *Budget, n gaussianInteger) (string, error) {
+	unit, factors, err := factorGaussian(budget, n)
+	if err != nil {
+		return "", err
+	}
+	if len(factors) == 0 {
+		return unit.String(), nil
+	}
+	var sb strings.Builder
+	if !unit.equals(gaussianOf(1, 0)) {
+		sb.WriteString(strings.TrimSuffix(unit.String(), "1"))
+	}
+	for _, factor := range factors {
+		sb.WriteString("(" + factor.p.String() + ")")
+		if factor.e > 1 {
+			fmt.Fprintf(&sb, "^%d", factor.e)
+		}
+	}
+	return sb.String(), nil
+}
+
+// totient counts the units modulo n: Euler's φ in Z and its analogue N(n) Π (1 - 1/N(p))
+// in Z[i].
+func totient(budget *Budget, args []gaussianInteger) (gaussianInteger, error) {
+	n := args[0]
+	if n.isZero() {
+		return gaussianInteger{}, errZeroFactorized
+	}
+	gaussian := !n.isReal()
+	var factors []primePower
+	var err error
+	if gaussian {
+		_, factors, err = factorGaussian(budget, n)
+	} else {
+		factors, err = factorInteger(budget, new(big.Int).Abs(n.re))
+	}
+	if err != nil {
+		return gaussianInteger{}, err
+	}
+	result := big.NewInt(1)
+	for _, factor := range factors {
+		norm := factor.p.norm()
+		if !gaussian {
+			norm = factor.p.re
+		}
+		result.Mul(result, new(big.Int).Exp(norm, big.NewInt(int64(factor.e-1)), nil))
+		result.Mul(result, new(big.Int).Sub(norm, big.NewInt(1)))
+	}
+	return realInteger(result), nil
+}
+
+// powmod is a^b modulo m. In Z the result is in [0, |m|); in Z[i] it is the remainder of
+// the '%' operator. Negative exponents use the inverse of a modulo m.
+func powmod(budget *Budget, args []gaussianInteger) (gaussianInteger, error) {
+	a, b, m := args[0], args[1], args[2]
+	if !b.isReal() {
+		return gaussianInteger{}, fmt.Errorf("the exponent must be a real integer, got %s", b)
+	}
+	if m.isZero() {
+		return gaussianInteger{}, errors.New("the modulus must not be 0")
+	}
+	if err := budget.Spend(b.re.BitLen()); err != nil {
+		return gaussianInteger{}, err
+	}
+	exponent := new(big.Int).Abs(b.re)
+	if a.isReal() && m.isReal() {
+		modulus := new(big.Int).Abs(m.re)
+		base := new(big.Int).Mod(a.re, modulus)
+		if b.re.Sign() < 0 {
+			if modulus.Cmp(big.NewInt(1)) == 0 {
+				return realInteger(new(big.Int)), nil
+			}
+			if base.ModInverse(base, modulus) == nil {
+				return gaussianInteger{}, fmt.Errorf("%s has no inverse modulo %s", a, m)
+			}
+		}
+		return realInteger(new(big.Int).Exp(base, exponent, modulus)), nil
+	}
+	_, base := a.quoRem(m)
+	if b.re.Sign() < 0 {
+		g, s, _, err := extendedGCD(budget, base, m)
+		if err != nil {
+			return gaussianInteger{}, err
+		}
+		if !g.equals(gaussianOf(1, 0)) {
+			return gaussianInteger{}, fmt.Errorf("%s has no inverse modulo %s", a, m)
+		}
+		_, base = s.quoRem(m)
+	}
+	result := gaussianOf(1, 0)
+	for i := exponent.BitLen() - 1; i >= 0; i-- {
+		_, result = result.mul(result).quoRem(m)
+		if exponent.Bit(i) == 1 {
+			_, result = result.mul(base).quoRem(m)
+		}
+	}
+	_, result = result.quoRem(m) // For exponent 0
+	return result, nil
+}
+
+// pow is z^e for e >= 0.
+func (z gaussianInteger) pow(e int64) gaussianInteger {
+	result := gaussianOf(1, 0)
+	for ; e > 0; e >>= 1 {
+		if e&1 == 1 {
+			result = result.mul(z)
+		}
+		if e > 1 {
+			z = z.mul(z)
+		}
+	}
+	return result
+}
+
+// The default number system holds integers exactly only up to 2^53. So that the functions on
+// integers see every digit of larger ones, the evaluator keeps the exact value of integer
+// literals, of +, -, * and ^ on integers, and of the results of these functions beside
+// their floats, in operand.integer.
+
+// roundsIntegers reports whether ev works in the default number system, the only one that
+// keeps no precise form of its values.
+func (ev evaluator[T]) roundsIntegers() bool {
+	var zero T
+	return ev.numbers.precise(zero) == nil
+}
+
+// literalInteger is the exact value of a number literal whose float value is an integer
+// beyond 2^53, or nil.
+func literalInteger(literal string, value complex128) *gaussianInteger {
+	if math.Abs(real(value)) <= maxFloatInteger || math.IsInf(real(value), 0) {
+		return nil
+	}
+	r, ok := new(big.Rat).SetString(numberText(literal))
+	if !ok || !r.IsInt() {
+		return nil
+	}
+	z := realInteger(new(big.Int).Set(r.Num()))
+	return &z
+}
+
+// integerOf converts x for the functions on integers, preferring its exact value.
+func (ev evaluator[T]) integerOf(x operand[T]) (gaussianInteger, error) {
+	if x.integer != nil {
+		return *x.integer, nil
+	}
+	return integerArgument(ev.numbers.toComplex(x.value), ev.numbers.precise(x.value))
+}
+
+// integerArguments converts the arguments of a call to a function on integers.
+func (ev evaluator[T]) integerArguments(args []operand[T], token Token) ([]gaussianInteger, error) {
+	integers := make([]gaussianInteger, len(args))
+	for i, arg := range args {
+		var err error
+		if integers[i], err = ev.integerOf(arg); err != nil {
+			return nil, builtinError(token, err)
+		}
+	}
+	return integers, nil
+}
+
+// callIntegers applies a function on integers in the default number system and keeps the
+// exact value of its result.
+func (ev evaluator[T]) callIntegers(function FunctionDef, args []operand[T], token Token) (operand[T], error) {
+	integers, err := ev.integerArguments(args, token)
+	if err != nil {
+		return operand[T]{}, err
+	}
+	result, err := function.integerImpl(ev.budget, integers)
+	if err != nil {
+		return operand[T]{}, builtinError(token, err)
+	}
+	if function.predicate {
+		return ev.boolean(!result.isZero())
+	}
+	value, err := ev.numbers.fromComplex(result.toComplex())
+	return operand[T]{value: value, integer: &result}, err
+}
+
+// trackInteger keeps the exact value of result, the result of op on operands in the default
+// number system, when op is +, -, *, ^ or unary minus on integers and its float result may
+// have been rounded: when an operand was rounded, or the result is beyond 2^53. Powers are
+// kept up to maxExactBits bits, like in exact mode.
+func (ev evaluator[T]) trackInteger(op Token, operands []operand[T], result *operand[T]) {
+	if !ev.roundsIntegers() || result.isVector() || result.boolean {
+		return
+	}
+	rounded := false
+	integers := make([]gaussianInteger, len(operands))
+	for i, x := range operands {
+		var err error
+		if integers[i], err = ev.integerOf(x); err != nil {
+			return
+		}
+		rounded = rounded || x.integer != nil
+	}
+	if value := ev.numbers.toComplex(result.value); !rounded && math.Abs(real(value)) < maxFloatInteger && math.Abs(imag(value)) < maxFloatInteger {
+		return
+	}
+	var z gaussianInteger
+	switch op.Type {
+	case UNARY_MINUS:
+		z = gaussianOf(0, 0).sub(integers[0])
+	case PLUS:
+		z = integers[0].add(integers[1])
+	case MINUS:
+		z = integers[0].sub(integers[1])
+	case ASTERISK:
+		z = integers[0].mul(integers[1])
+	case CARET:
+		base, exponent := integers[0], integers[1]
+		bits := max(base.re.BitLen(), base.im.BitLen()) + 1
+		if !exponent.isReal() || exponent.re.Sign() < 0 || !exponent.re.IsInt64() || exponent.re.Int64() > int64(maxExactBits/bits) {
+			return
+		}
+		z = base.pow(exponent.re.Int64())
+	default:
+		return
+	}
+	result.integer = &z
+}
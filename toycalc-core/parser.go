@@ -7,26 +7,100 @@ import (
 	// "strconv"
 )
 
-// Constants for known identifiers (used for implied multiplication logic and IDENT handling)
-// These maps should be kept in sync with what the evaluator can handle.
-var (
-	knownConstants = map[string]bool{
-		"i":  true,
-		"pi": true,
-		"e":  true,
+// checkArity verifies that a call to a function registered in r passes the right number of
+// arguments. The error points at the call site (the position of the function name).
+func (r *Registry) checkArity(functionToken Token) error {
+	def, found := r.Function(functionToken.Literal)
+	if !found {
+		return nil
 	}
-	knownFunctions = map[string]bool{ // Stage 1 & 2 functions
-		"log": true, "exp": true,
-		"sin": true, "cos": true, "tan": true,
-		"asin": true, "acos": true, "atan": true,
-		"sinh": true, "cosh": true, "tanh": true,
-		"asinh": true, "acosh": true, "atanh": true,
-		"log10": true, "log2": true, "sqrt": true,
-		"real": true, "imag": true, "abs": true, "phase": true, "conj": true,
-		"degtorad": true, "radtodeg": true,
-		"floor": true, "ceil": true, "round": true, "trunc": true,
+	got := max(functionToken.Arity, 1)
+	if def.Arity == Variadic || got <= def.Arity && got >= def.Arity-def.Optional {
+		return nil
 	}
-)
+	if def.Optional > 0 {
+		return newTokenError(KindArity, functionToken,
+			fmt.Sprintf("function '%s' expects %d to %d arguments but got %d at position %d", functionToken.Literal, def.Arity-def.Optional, def.Arity, got, functionToken.Position),
+		)
+	}
+	return newTokenError(KindArity, functionToken,
+		fmt.Sprintf("function '%s' expects %d argument(s) but got %d at position %d", functionToken.Literal, def.Arity, got, functionToken.Position),
+	)
+}
+
+// operatorPrecedence ranks the operators: higher binds tighter.
+// The logical operators bind loosest, 'or' below 'and' below 'not', and the comparisons
+// just above them, as in Python, so that not x < 1 or y == 2 is (not (x < 1)) or (y == 2).
+// The bitwise operators bind looser than arithmetic, '|' loosest, so that
+// x & 0xF0 >> 4 is x & (0xF0 >> 4) and 1 << n - 1 is 1 << (n - 1).
+// The phasor operator binds tighter than '*' and '/', so that 10∠30 * 2 is 20∠30, and
+// the postfix operators '°', '!' and '!!' tightest of all, so that 2^30° is 2^(30°) and
+// -3! is -(3!).
+var operatorPrecedence = map[TokenType]int{
+	OR:               1,
+	AND:              2,
+	NOT:              3,
+	EQUAL:            4,
+	NOT_EQUAL:        4,
+	LESS:             4,
+	LESS_EQUAL:       4,
+	GREATER:          4,
+	GREATER_EQUAL:    4,
+	PIPE:             5,
+	XOR:              6,
+	AMPERSAND:        7,
+	SHIFT_LEFT:       8,
+	SHIFT_RIGHT:      8,
+	PLUS:             9,
+	MINUS:            9,
+	ASTERISK:         10,
+	SLASH:            10,
+	SLASH_SLASH:      10,
+	PERCENT:          10,
+	ANGLE:            11,
+	CARET:            13,
+	UNARY_MINUS:      12,
+	TILDE:            12,
+	DEGREE:           14,
+	FACTORIAL:        14,
+	DOUBLE_FACTORIAL: 14,
+}
+
+// maxPrecedence is above every operator; operands (numbers, names, calls) bind this tightly.
+const maxPrecedence = 20
+
+var operatorLeftAssociative = map[TokenType]bool{
+	OR:            true,
+	AND:           true,
+	EQUAL:         true,
+	NOT_EQUAL:     true,
+	LESS:          true,
+	LESS_EQUAL:    true,
+	GREATER:       true,
+	GREATER_EQUAL: true,
+	PIPE:          true,
+	XOR:           true,
+	AMPERSAND:     true,
+	SHIFT_LEFT:    true,
+	SHIFT_RIGHT:   true,
+	PLUS:          true,
+	MINUS:         true,
+	ASTERISK:      true,
+	SLASH:         true,
+	SLASH_SLASH:   true,
+	PERCENT:       true,
+	ANGLE:         true,
+	CARET:         false,
+}
+
+// isComparison reports whether tokenType is one of the comparison operators.
+func isComparison(tokenType TokenType) bool {
+	switch tokenType {
+	case EQUAL, NOT_EQUAL, LESS, LESS_EQUAL, GREATER, GREATER_EQUAL:
+		return true
+	}
+	return false
+}
 
 type Parser struct {
 	tokens       []Token
@@ -34,39 +108,32 @@ type Parser struct {
 	// currentToken is now managed internally by nextToken/peekToken, not a struct field directly always up-to-date
 	// This avoids confusion as nextToken() now effectively means "consume and advance".
 
-	outputQueue   []Token
+	// output holds the subtrees built so far. Where the classic shunting-yard algorithm
+	// appends a token to its RPN output, emit combines the operands on top of output into
+	// a node instead, so that the finished parse leaves exactly one tree behind.
+	output        []Node
 	operatorStack []Token
 
+	// implicitAt records the positions of the '*' tokens inserted for implied multiplication.
+	implicitAt map[int]bool
+
 	precedence      map[TokenType]int
 	leftAssociative map[TokenType]bool
 
 	// Tracks if the previous token suggests that the next token should be an operand (or a prefix unary operator)
 	// This is true at the start, after '(', '[', '{', ',', or after another operator.
 	expectOperand bool
+
+	// env holds user variables; identifiers bound there are parsed like constants. May be nil.
+	env *Environment
 }
 
 func NewParser(tokens []Token) *Parser {
 	p := &Parser{
-		tokens: tokens, // Including EOF
-		precedence: map[TokenType]int{
-			// We might introduce UNARY_MINUS here with higher precedence if we take that path
-			PLUS:        2,
-			MINUS:       2,
-			ASTERISK:    3,
-			SLASH:       3,
-			PERCENT:     3,
-			CARET:       5,
-			UNARY_MINUS: 4,
-		},
-		leftAssociative: map[TokenType]bool{
-			PLUS:     true,
-			MINUS:    true,
-			ASTERISK: true,
-			SLASH:    true,
-			PERCENT:  true,
-			CARET:    false,
-		},
-		expectOperand: true, // At the start of an expression, we expect an operand or unary prefix
+		tokens:          tokens, // Including EOF
+		precedence:      operatorPrecedence,
+		leftAssociative: operatorLeftAssociative,
+		expectOperand:   true, // At the start of an expression, we expect an operand or unary prefix
 	}
 	return p
 }
@@ -116,7 +183,9 @@ func (p *Parser) peekOperator() (Token, bool) {
 // Type check helpers (isOperator, isFunction, isLeftParen, isRightParen, getMatchingLeftParen) - same as before
 func isOperator(tokenType TokenType) bool { // Checks for binary operators for Shunting-Yard logic
 	switch tokenType {
-	case PLUS, MINUS, ASTERISK, SLASH, PERCENT, CARET, UNARY_MINUS:
+	case PLUS, MINUS, ASTERISK, SLASH, PERCENT, CARET, UNARY_MINUS, ANGLE,
+		SLASH_SLASH, AMPERSAND, PIPE, XOR, TILDE, SHIFT_LEFT, SHIFT_RIGHT,
+		EQUAL, NOT_EQUAL, LESS, LESS_EQUAL, GREATER, GREATER_EQUAL, AND, OR, NOT:
 		return true
 	}
 	return false
@@ -156,7 +225,18 @@ func getMatchingLeftParen(rightParenType TokenType) TokenType {
 
 // ParseToRPN converts infix token stream to RPN (postfix)
 func (p *Parser) ParseToRPN() ([]Token, error) {
-	p.outputQueue = []Token{}
+	tree, err := p.ParseTree()
+	if err != nil {
+		return nil, err
+	}
+	return ToRPN(tree), nil
+}
+
+// ParseTree converts the infix token stream to an expression tree, using the shunting-yard
+// algorithm with emit building nodes in place of RPN output.
+func (p *Parser) ParseTree() (Node, error) {
+	p.output = []Node{}
+	p.implicitAt = map[int]bool{}
 	p.operatorStack = []Token{}
 	p.currentIndex = 0
 	p.expectOperand = true // Reset at the start of parsing
@@ -174,10 +254,12 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 			case IDENT:
 				// An IDENT can start an operand if it's a constant or a function call
 				lowerLiteral := strings.ToLower(currentToken.Literal)
-				if _, isConst := knownConstants[lowerLiteral]; isConst {
+				if p.env.builtins().IsConstant(lowerLiteral) {
 					isOperandStarter = true
-				} else if _, isFunc := knownFunctions[lowerLiteral]; isFunc {
+				} else if p.env.builtins().IsFunction(lowerLiteral) {
 					isOperandStarter = true // e.g. (1+2)log(x)
+				} else if p.env.IsVariable(lowerLiteral) || p.env.IsFunction(lowerLiteral) {
+					isOperandStarter = true // e.g. 2x or 2f(1,2)
 				}
 			}
 
@@ -186,6 +268,7 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 			// then insert an implicit multiplication.
 			if isOperandStarter && currentToken.Type != PLUS && currentToken.Type != MINUS {
 				implicitAsterisk := Token{Type: ASTERISK, Literal: "*", Position: currentToken.Position} // Use pos of the token that implies mult
+				p.implicitAt[implicitAsterisk.Position] = true
 
 				// Process this virtual ASTERISK token using Shunting-Yard logic
 				op1Implicit := implicitAsterisk
@@ -197,7 +280,9 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 					if (p.leftAssociative[op1Implicit.Type] && p.precedence[op1Implicit.Type] <= p.precedence[op2Stack.Type]) ||
 						(!p.leftAssociative[op1Implicit.Type] && p.precedence[op1Implicit.Type] < p.precedence[op2Stack.Type]) {
 						poppedOp, _ := p.popOperator()
-						p.outputQueue = append(p.outputQueue, poppedOp)
+						if err := p.emit(poppedOp); err != nil {
+							return nil, err
+						}
 					} else {
 						break
 					}
@@ -221,11 +306,13 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 			if !p.expectOperand {
 				// If we were not expecting an operand, it means an operator was missing
 				// e.g., "2 3" or ") 3" or "x 3" (if x is a var/constant)
-				return nil, NewCalculationError(
+				return nil, newTokenError(KindParse, currentToken,
 					fmt.Sprintf("unexpected number '%s' at position %d; an operator may be missing", currentToken.Literal, currentToken.Position),
 				)
 			}
-			p.outputQueue = append(p.outputQueue, currentToken)
+			if err := p.emit(currentToken); err != nil {
+				return nil, err
+			}
 			p.expectOperand = false // After an operand, we expect an operator or closing paren
 
 		case IDENT:
@@ -233,32 +320,38 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 			isKnownFunction := false // We'll use this to differentiate known functions from unknown idents
 
 			lowerLiteral := strings.ToLower(currentToken.Literal)
-			switch lowerLiteral {
-			case "i", "pi", "e": // Added "pi" and "e"
+			switch {
+			case p.env.builtins().IsConstant(lowerLiteral):
 				isConstant = true
-			case "log", "exp", // Stage 1 functions
-				"sin", "cos", "tan", "asin", "acos", "atan", // Stage 2 trig
-				"sinh", "cosh", "tanh", "asinh", "acosh", "atanh", // Stage 2 hyperbolic
-				"log10", "log2", "sqrt", "real", "imag", "abs", "phase",
-				"conj", "degtorad", "radtodeg", "floor", "ceil", "round", "trunc": // Stage 2 other
+			case p.env.builtins().IsFunction(lowerLiteral):
 				isKnownFunction = true
+			default:
+				// User functions are called like built-ins; user variables behave exactly like constants.
+				isKnownFunction = p.env.IsFunction(lowerLiteral)
+				isConstant = p.env.IsVariable(lowerLiteral)
 			}
 
 			if isConstant {
 				if !p.expectOperand {
-					return nil, NewCalculationError(
+					return nil, newTokenError(KindParse, currentToken,
 						fmt.Sprintf("unexpected constant '%s' at position %d; an operator may be missing", currentToken.Literal, currentToken.Position),
 					)
 				}
-				p.outputQueue = append(p.outputQueue, currentToken) // Token is {IDENT, "pi", pos}, etc.
-				p.expectOperand = false                             // After an operand/constant, we expect an operator
+				if err := p.emit(currentToken); err != nil {
+					return nil, err
+				}
+				p.expectOperand = false // After an operand/constant, we expect an operator
 			} else if isKnownFunction {
-				p.pushOperator(currentToken) // Function name goes to operator stack
+				// Function name goes to operator stack. Each COMMA inside its parentheses
+				// increments Arity, so the evaluator knows how many arguments to pop.
+				currentToken.Arity = 1
+				p.pushOperator(currentToken)
 				// expectOperand state is managed by LPAREN that should follow a function
 			} else {
 				// Unknown identifier
-				return nil, NewCalculationError(
+				return nil, newUnknownIdentifierError(currentToken,
 					fmt.Sprintf("unknown identifier or function '%s' at position %d", currentToken.Literal, currentToken.Position),
+					p.env,
 				)
 			}
 		case PLUS, MINUS:
@@ -288,7 +381,9 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 				if (p.leftAssociative[operatorToken.Type] && p.precedence[op2.Type] >= p.precedence[operatorToken.Type]) ||
 					(!p.leftAssociative[operatorToken.Type] && p.precedence[op2.Type] > p.precedence[operatorToken.Type]) {
 					poppedOp, _ := p.popOperator()
-					p.outputQueue = append(p.outputQueue, poppedOp)
+					if err := p.emit(poppedOp); err != nil {
+						return nil, err
+					}
 				} else {
 					break
 				}
@@ -296,10 +391,11 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 			p.pushOperator(operatorToken)
 			p.expectOperand = true // After any operator (unary or binary), we expect an operand
 
-		case ASTERISK, SLASH, PERCENT, CARET: // These are always binary in this context
+		case ASTERISK, SLASH, PERCENT, CARET, ANGLE, SLASH_SLASH, AMPERSAND, PIPE, XOR, SHIFT_LEFT, SHIFT_RIGHT,
+			EQUAL, NOT_EQUAL, LESS, LESS_EQUAL, GREATER, GREATER_EQUAL, AND, OR: // These are always binary in this context
 			if p.expectOperand {
 				// This means an operator like '*' appeared where an operand was expected, e.g., "* 5" or "( * 5)"
-				return nil, NewCalculationError(fmt.Sprintf("unexpected operator '%s' at position %d; operand expected", currentToken.Literal, currentToken.Position))
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("unexpected operator '%s' at position %d; operand expected", currentToken.Literal, currentToken.Position))
 			}
 			op1 := currentToken
 			for {
@@ -307,10 +403,16 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 				if !ok || isLeftParen(op2.Type) {
 					break
 				}
+				if isComparison(op1.Type) && isComparison(op2.Type) {
+					// a < b < c would compare the boolean a < b with c.
+					return nil, newTokenError(KindParse, op1, fmt.Sprintf("comparisons cannot be chained at position %d; write a %s b and b %s c", op1.Position, op2.Literal, op1.Literal))
+				}
 				if (p.precedence[op2.Type] > p.precedence[op1.Type]) ||
 					(p.precedence[op2.Type] == p.precedence[op1.Type] && p.leftAssociative[op1.Type]) {
 					p.popOperator()
-					p.outputQueue = append(p.outputQueue, op2)
+					if err := p.emit(op2); err != nil {
+						return nil, err
+					}
 				} else {
 					break
 				}
@@ -318,9 +420,31 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 			p.pushOperator(op1)
 			p.expectOperand = true // After a binary operator, we expect an operand
 
+		case TILDE, NOT:
+			if !p.expectOperand {
+				example := currentToken.Literal + "5"
+				if currentToken.Type == NOT {
+					example = "not x"
+				}
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("unexpected '%s' at position %d; it must precede a value, as in %s", currentToken.Literal, currentToken.Position, example))
+			}
+			// Like unary minus, a prefix operator is right-associative: operators already on the
+			// stack stay there until its operand is complete.
+			p.pushOperator(currentToken)
+
+		case DEGREE, FACTORIAL, DOUBLE_FACTORIAL:
+			if p.expectOperand {
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("unexpected '%s' at position %d; it must follow a value, as in 30%s", currentToken.Literal, currentToken.Position, currentToken.Literal))
+			}
+			// A postfix operator binding tighter than any other applies to the operand just
+			// completed, so it is emitted at once. The next token is still an operator.
+			if err := p.emit(currentToken); err != nil {
+				return nil, err
+			}
+
 		case COMMA:
 			if p.expectOperand { // Comma should not appear where an operand is expected right before it
-				return nil, NewCalculationError(fmt.Sprintf("unexpected comma at position %d; operand expected before comma", currentToken.Position))
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("unexpected comma at position %d; operand expected before comma", currentToken.Position))
 			}
 			foundLeftParen := false
 			for len(p.operatorStack) > 0 {
@@ -330,11 +454,18 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 					break
 				}
 				poppedOp, _ := p.popOperator()
-				p.outputQueue = append(p.outputQueue, poppedOp)
+				if err := p.emit(poppedOp); err != nil {
+					return nil, err
+				}
 			}
 			if !foundLeftParen {
-				return nil, NewCalculationError(fmt.Sprintf("mismatched comma or parentheses at position %d", currentToken.Position))
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("mismatched comma or parentheses at position %d", currentToken.Position))
+			}
+			// The comma separates arguments of the function whose '(' is on top of the stack.
+			if len(p.operatorStack) < 2 || !isFunction(p.operatorStack[len(p.operatorStack)-2].Type) {
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("unexpected comma at position %d; commas can only separate function arguments", currentToken.Position))
 			}
+			p.operatorStack[len(p.operatorStack)-2].Arity++
 			p.expectOperand = true // After a comma, we expect another argument (operand)
 
 		case LPAREN, LBRACKET, LBRACE:
@@ -346,7 +477,7 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 			} else if !p.expectOperand {
 				// We have something like "5(" or ")(" which implies multiplication.
 				// This is for Stage 4 (implied multiplication). For now, it's an error.
-				return nil, NewCalculationError(fmt.Sprintf("unexpected parenthesis '%s' at position %d; operator expected or implied multiplication not supported", currentToken.Literal, currentToken.Position))
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("unexpected parenthesis '%s' at position %d; operator expected or implied multiplication not supported", currentToken.Literal, currentToken.Position))
 			}
 			p.pushOperator(currentToken)
 			p.expectOperand = true // After '(', we expect an operand (or unary operator)
@@ -362,12 +493,12 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 				// If the parser encounters something like `(`, `EOF` without an operand for `log(`, this check is too late.
 				// The check `if p.expectOperand` implies nothing was pushed to outputQueue since last operator/LPAREN/comma
 			}*/
-			if p.expectOperand && (len(p.outputQueue) == 0 || isOperator(p.outputQueue[len(p.outputQueue)-1].Type) || isLeftParen(p.outputQueue[len(p.outputQueue)-1].Type) || p.outputQueue[len(p.outputQueue)-1].Type == COMMA) {
-				// This means something like `()` or `(,)` or `(*)` which is an error if an operand was expected
+			if p.expectOperand {
+				// This means something like `()`, `f(1,)` or `(2+)` which is an error if an operand was expected
 				// but the part before `)` is not a valid operand.
 				// Example: `log()` - `log` is on opStack, `(` is on opStack. `)` comes. `expectOperand` is true.
 				// This situation would mean no argument was provided for the function.
-				return nil, NewCalculationError(fmt.Sprintf("missing operand before closing parenthesis '%s' at position %d", currentToken.Literal, currentToken.Position))
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("missing operand before closing parenthesis '%s' at position %d", currentToken.Literal, currentToken.Position))
 			}
 
 			expectedLeftParen := getMatchingLeftParen(currentToken.Type)
@@ -380,20 +511,35 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 					break
 				}
 				poppedOp, _ := p.popOperator()
-				p.outputQueue = append(p.outputQueue, poppedOp)
+				if err := p.emit(poppedOp); err != nil {
+					return nil, err
+				}
 			}
 			if !foundMatchingParen {
-				return nil, NewCalculationError(fmt.Sprintf("mismatched parentheses/brackets/braces for '%s' at position %d", currentToken.Literal, currentToken.Position))
+				return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("mismatched parentheses/brackets/braces for '%s' at position %d", currentToken.Literal, currentToken.Position))
 			}
 			// If token at top of stack is a function name, pop it to output.
 			if op, ok := p.peekOperator(); ok && isFunction(op.Type) {
 				poppedFunc, _ := p.popOperator()
-				p.outputQueue = append(p.outputQueue, poppedFunc)
+				if registry := p.env.builtins(); registry.isReserved(poppedFunc.Literal) {
+					if err := registry.checkArity(poppedFunc); err != nil {
+						return nil, err
+					}
+				}
+				if err := p.emit(poppedFunc); err != nil {
+					return nil, err
+				}
+				// The call extends to its closing parenthesis.
+				p.output[len(p.output)-1].(*CallNode).span.End = currentToken.Position + len(currentToken.Literal)
 			}
 			p.expectOperand = false // After ')', we expect an operator
 
+		case ASSIGN:
+			// Assignments are split off by ParseStatement; any '=' left here is misplaced.
+			return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("unexpected '=' at position %d; assignments must have the form 'name = expression'", currentToken.Position))
+
 		default: // Should be unreachable if lexer is correct
-			return nil, NewCalculationError(fmt.Sprintf("parser encountered unexpected token '%s' (type %s) at position %d", currentToken.Literal, currentToken.Type, currentToken.Position))
+			return nil, newTokenError(KindParse, currentToken, fmt.Sprintf("parser encountered unexpected token '%s' (type %s) at position %d", currentToken.Literal, currentToken.Type, currentToken.Position))
 		}
 		currentToken = p.consumeToken() // Consume current token and advance to the next
 	}
@@ -402,27 +548,217 @@ func (p *Parser) ParseToRPN() ([]Token, error) {
 	for len(p.operatorStack) > 0 {
 		op, _ := p.popOperator()
 		if isLeftParen(op.Type) {
-			return nil, NewCalculationError(fmt.Sprintf("mismatched parentheses/brackets/braces at end (unclosed '%s' at pos %d)", op.Literal, op.Position))
+			return nil, newTokenError(KindParse, op, fmt.Sprintf("mismatched parentheses/brackets/braces at end (unclosed '%s' at pos %d)", op.Literal, op.Position))
 		}
-		p.outputQueue = append(p.outputQueue, op)
+		if err := p.emit(op); err != nil {
+			return nil, err
+		}
+	}
+
+	// Final check: exactly one tree must be left.
+	if len(p.output) == 0 {
+		return nil, newKindError(KindParse, "parsed expression resulted in empty RPN queue (invalid expression structure)")
+	}
+	if len(p.output) > 1 {
+		return nil, newKindError(KindParse,
+			fmt.Sprintf("invalid expression: %d values left on stack, expected 1 (check operators and operands)", len(p.output)),
+		)
 	}
+	return p.output[0], nil
+}
 
-	// Final check: if the output queue is empty, it means no valid expression was formed.
-	if len(p.outputQueue) == 0 && (p.currentIndex > 0 && p.tokens[0].Type != EOF) { // Make sure it wasn't just an empty input that Parse() should have caught
-		return nil, NewCalculationError("parsed expression resulted in empty RPN queue (invalid expression structure)")
+// emit adds token to the output: operands become leaves, and operators and functions take
+// their operands from the top of the output to become inner nodes.
+func (p *Parser) emit(token Token) error {
+	operandCount := 0
+	switch token.Type {
+	case NUMBER:
+		p.output = append(p.output, &NumberNode{Token: token})
+		return nil
+	case UNARY_MINUS, TILDE, NOT, DEGREE, FACTORIAL, DOUBLE_FACTORIAL:
+		operandCount = 1
+	case IDENT:
+		if token.Arity == 0 { // Constants and variables; functions are pushed with Arity >= 1
+			p.output = append(p.output, &IdentNode{Token: token})
+			return nil
+		}
+		operandCount = token.Arity
+	default:
+		operandCount = 2
 	}
 
-	return p.outputQueue, nil
+	if len(p.output) < operandCount {
+		if token.Type == IDENT {
+			return newTokenError(KindParse, token,
+				fmt.Sprintf("insufficient operands for function '%s' at position %d (expected %d)", token.Literal, token.Position, operandCount),
+			)
+		}
+		return newTokenError(KindParse, token,
+			fmt.Sprintf("insufficient operands for operator '%s' (type %s) at position %d", token.Literal, token.Type, token.Position),
+		)
+	}
+	operands := make([]Node, operandCount)
+	copy(operands, p.output[len(p.output)-operandCount:])
+	p.output = p.output[:len(p.output)-operandCount]
+
+	var node Node
+	switch token.Type {
+	case UNARY_MINUS, TILDE, NOT, DEGREE, FACTORIAL, DOUBLE_FACTORIAL:
+		node = &UnaryNode{Op: token, Operand: operands[0]}
+	case IDENT:
+		span := Span{Start: token.Position, End: operands[len(operands)-1].Span().End}
+		node = &CallNode{Name: token, Args: operands, span: span}
+	default:
+		node = &BinaryNode{Op: token, Left: operands[0], Right: operands[1], Implicit: token.Type == ASTERISK && p.implicitAt[token.Position]}
+	}
+	p.output = append(p.output, node)
+	return nil
 }
 
 // Main Parse function (entry point) - from before
 func Parse(tokens []Token) ([]Token, error) {
+	return ParseWithEnvironment(tokens, nil)
+}
+
+// ParseWithEnvironment is like Parse, but identifiers bound in env are accepted as variables.
+func ParseWithEnvironment(tokens []Token, env *Environment) ([]Token, error) {
+	tree, err := ParseASTWithEnvironment(tokens, env)
+	if err != nil {
+		return nil, err
+	}
+	return ToRPN(tree), nil
+}
+
+// ParseAST parses tokens into an expression tree whose nodes carry their source spans.
+// ToRPN turns the tree into the queue Parse returns.
+func ParseAST(tokens []Token) (Node, error) {
+	return ParseASTWithEnvironment(tokens, nil)
+}
+
+// ParseASTWithEnvironment is like ParseAST, but identifiers bound in env are accepted as
+// variables and user functions.
+func ParseASTWithEnvironment(tokens []Token, env *Environment) (Node, error) {
 	if len(tokens) == 0 {
-		return nil, NewCalculationError("no tokens provided to parse (empty token slice)")
+		return nil, newKindError(KindParse, "no tokens provided to parse (empty token slice)")
 	}
 	if len(tokens) == 1 && tokens[0].Type == EOF {
-		return nil, NewCalculationError("no expression provided to parse (only EOF token found)")
+		return nil, newKindError(KindParse, "no expression provided to parse (only EOF token found)")
 	}
 	parser := NewParser(tokens)
-	return parser.ParseToRPN()
+	parser.env = env
+	return parser.ParseTree()
+}
+
+// Statement is the result of ParseStatement. Target is the (lowercased) name being bound
+// by an assignment or function definition, or "" for a plain expression. Params is non-nil
+// only for function definitions. RPN is the parsed expression (the right-hand side, if any)
+// and BodyPosition is the offset where that expression starts in the input.
+type Statement struct {
+	Target       string
+	Params       []string
+	RPN          []Token
+	BodyPosition int
+}
+
+// IsFunctionDefinition reports whether the statement defines a function, e.g. f(x, y) = x*y.
+func (s Statement) IsFunctionDefinition() bool {
+	return s.Params != nil
+}
+
+// ParseStatement parses one of:
+//
+//	expression
+//	name = expression
+//	name(param1, param2, ...) = expression
+//
+// The body of a function definition is parsed in a child scope of env in which the
+// parameters are variables and the function itself is already defined (so it may recurse).
+func ParseStatement(tokens []Token, env *Environment) (Statement, error) {
+	if len(tokens) >= 2 && tokens[0].Type == IDENT && (tokens[1].Type == ASSIGN || tokens[1].Type == LPAREN) {
+		name := tokens[0]
+		registry := env.builtins()
+		params, assignIndex, isDefinition, err := parseDefinitionHead(tokens, registry)
+		if err != nil {
+			return Statement{}, err
+		}
+		if isDefinition {
+			if registry.isReserved(name.Literal) {
+				return Statement{}, newTokenError(KindParse, name, fmt.Sprintf("cannot assign to built-in %s '%s' at position %d", registry.kind(name.Literal), name.Literal, name.Position))
+			}
+			if tokens[assignIndex+1].Type == EOF {
+				return Statement{}, newTokenError(KindParse, tokens[assignIndex], fmt.Sprintf("missing expression after '=' at position %d", tokens[assignIndex].Position))
+			}
+			scope := env
+			if params != nil {
+				scope = env.NewChild()
+				_ = scope.DefineFunction(&UserFunction{Name: name.Literal, Params: params})
+				for _, param := range params {
+					_ = scope.Set(param, 0)
+				}
+			}
+			rpn, err := ParseWithEnvironment(tokens[assignIndex+1:], scope)
+			if err != nil {
+				return Statement{}, err
+			}
+			return Statement{
+				Target:       strings.ToLower(name.Literal),
+				Params:       params,
+				RPN:          rpn,
+				BodyPosition: tokens[assignIndex+1].Position,
+			}, nil
+		}
+	}
+	rpn, err := ParseWithEnvironment(tokens, env)
+	if err != nil {
+		return Statement{}, err
+	}
+	return Statement{RPN: rpn}, nil
+}
+
+// parseDefinitionHead recognizes the left-hand side of `name = ...` (params is nil) or
+// `name(p1, p2) = ...` (params lists the lowercased parameter names). assignIndex is the index
+// of the '=' token. isDefinition is false when the tokens merely start like a definition,
+// e.g. the call in `f(2) + 1`, and should be parsed as an expression instead.
+// Parameters may not shadow the built-ins in registry.
+func parseDefinitionHead(tokens []Token, registry *Registry) (params []string, assignIndex int, isDefinition bool, err error) {
+	if tokens[1].Type == ASSIGN {
+		return nil, 1, true, nil
+	}
+	// tokens[0] is IDENT and tokens[1] is LPAREN: look for IDENT {COMMA IDENT} RPAREN ASSIGN.
+	index := 2
+	for {
+		if index+1 >= len(tokens) || tokens[index].Type != IDENT {
+			return nil, 0, false, nil
+		}
+		params = append(params, strings.ToLower(tokens[index].Literal))
+		if tokens[index+1].Type == COMMA {
+			index += 2
+			continue
+		}
+		if tokens[index+1].Type != RPAREN {
+			return nil, 0, false, nil
+		}
+		index += 2
+		break
+	}
+	if index >= len(tokens) || tokens[index].Type != ASSIGN {
+		return nil, 0, false, nil
+	}
+
+	// It is a definition; validate the parameter list.
+	seen := map[string]bool{}
+	for i, param := range params {
+		paramToken := tokens[2+2*i]
+		if registry.isReserved(param) {
+			return nil, 0, false, newTokenError(KindParse, paramToken, fmt.Sprintf("cannot use built-in %s '%s' as a parameter name at position %d", registry.kind(param), paramToken.Literal, paramToken.Position))
+		}
+		if param == strings.ToLower(tokens[0].Literal) {
+			return nil, 0, false, newTokenError(KindParse, paramToken, fmt.Sprintf("parameter '%s' at position %d has the same name as the function", paramToken.Literal, paramToken.Position))
+		}
+		if seen[param] {
+			return nil, 0, false, newTokenError(KindParse, paramToken, fmt.Sprintf("duplicate parameter '%s' at position %d", paramToken.Literal, paramToken.Position))
+		}
+		seen[param] = true
+	}
+	return params, index, true, nil
 }
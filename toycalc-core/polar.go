@@ -0,0 +1,31 @@
+// polar.go
+package toycalc_core
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// formatPolar renders c in the 'polar' format, "r ∠ θ", with θ in settings.PolarUnit (the
+// angle mode if empty) and degrees marked with '°', e.g. "10 ∠ 30°". Both numbers are
+// formatted like the 'auto' format does.
+func formatPolar(c complex128, settings Settings) string {
+	decimal := settings
+	decimal.Format = "auto"
+	if cmplx.IsNaN(c) || cmplx.IsInf(c) {
+		return formatComplex(c, decimal)
+	}
+	r, theta := cmplx.Polar(c)
+	magnitude := formatComplex(complex(r, 0), decimal)
+	if magnitude == "0" {
+		return magnitude
+	}
+	unit, suffix := settings.PolarUnit, ""
+	if unit == "" {
+		unit = settings.AngleMode
+	}
+	if unit == AngleDegrees {
+		theta, suffix = theta*(180/math.Pi), "°"
+	}
+	return magnitude + " ∠ " + formatComplex(complex(theta, 0), decimal) + suffix
+}
@@ -0,0 +1,273 @@
+// programmer.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// WordSizes are the word sizes accepted by SetWord, in bits.
+var WordSizes = []int{8, 16, 32, 64}
+
+// errAngleInProgrammerMode rejects the degree sign, phasors and the angle conversions of
+// trigonometric functions in degree mode, none of which have integer results.
+var errAngleInProgrammerMode = fmt.Errorf("angles are not available in programmer mode")
+
+// isBitwise reports whether op is one of the operators that only apply to integers.
+func isBitwise(op TokenType) bool {
+	switch op {
+	case AMPERSAND, PIPE, XOR, TILDE, SHIFT_LEFT, SHIFT_RIGHT:
+		return true
+	}
+	return false
+}
+
+// bitwiseOperandError explains why c, an operand of op, is not an integer.
+func bitwiseOperandError(op Token, c complex128) error {
+	if imag(c) != 0 {
+		return newTokenError(KindDomain, op, fmt.Sprintf("operator '%s' at position %d needs real integers, got the complex value %s", op.Literal, op.Position, formatComplexOutput(c)))
+	}
+	return newTokenError(KindDomain, op, fmt.Sprintf("operator '%s' at position %d needs integers, got %s", op.Literal, op.Position, formatComplexOutput(c)))
+}
+
+// floatInteger returns c as an integer, or false if c is not a real integer.
+func floatInteger(c complex128) (*big.Int, bool) {
+	x := real(c)
+	if imag(c) != 0 || math.IsInf(x, 0) || math.IsNaN(x) || x != math.Trunc(x) {
+		return nil, false
+	}
+	integer, _ := big.NewFloat(x).Int(nil)
+	return integer, true
+}
+
+// integerOperate applies a bitwise operator to integers of unlimited size, with negative
+// numbers behaving as infinitely sign-extended two's complement, as in Python: -1 & 0xFF is
+// 255 and -8 >> 1 is -4.
+func integerOperate(op Token, a, b *big.Int) (*big.Int, error) {
+	switch op.Type {
+	case AMPERSAND:
+		return new(big.Int).And(a, b), nil
+	case PIPE:
+		return new(big.Int).Or(a, b), nil
+	case XOR:
+		return new(big.Int).Xor(a, b), nil
+	case SHIFT_LEFT, SHIFT_RIGHT:
+		if b.Sign() < 0 || b.Cmp(big.NewInt(maxExactBits)) > 0 {
+			return nil, newTokenError(KindDomain, op, fmt.Sprintf("shift count must be between 0 and %d, got %s for operator '%s' at position %d", maxExactBits, b, op.Literal, op.Position))
+		}
+		if op.Type == SHIFT_LEFT {
+			return new(big.Int).Lsh(a, uint(b.Uint64())), nil
+		}
+		return new(big.Int).Rsh(a, uint(b.Uint64())), nil
+	}
+	return nil, newTokenError(KindParse, op, fmt.Sprintf("unexpected operator '%s' at position %d", op.Literal, op.Position))
+}
+
+// wordNumbers is the number system of programmer mode: integers of size bits, two's
+// complement if signed, that wrap around like machine words. Division truncates toward
+// zero and % takes the sign of the dividend, as in C and Go.
+type wordNumbers struct {
+	size   uint
+	signed bool
+}
+
+// notInteger is the error for values programmer mode cannot hold.
+func notInteger(c complex128) error {
+	return fmt.Errorf("%s is not an integer, as programmer mode requires", formatComplexOutput(c))
+}
+
+// wrap reduces x to the range of the word: [0, 2^size) if unsigned, [-2^(size-1), 2^(size-1))
+// if signed. Values from variables set with another word size are converted the same way.
+func (n wordNumbers) wrap(x *big.Int) *big.Int {
+	result := n.pattern(x)
+	if n.signed && result.Bit(int(n.size)-1) == 1 {
+		result.Sub(result, n.modulus())
+	}
+	return result
+}
+
+// pattern returns the bits of x's word as a non-negative number.
+func (n wordNumbers) pattern(x *big.Int) *big.Int {
+	return new(big.Int).Mod(x, n.modulus())
+}
+
+func (n wordNumbers) modulus() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), n.size)
+}
+
+// fromExact converts a result of exactNumbers, which parses literals and computes functions
+// for programmer mode.
+func (n wordNumbers) fromExact(x exactValue) (*big.Int, error) {
+	if x.approximate {
+		integer, ok := floatInteger(x.value)
+		if !ok {
+			return nil, notInteger(x.value)
+		}
+		return n.wrap(integer), nil
+	}
+	if x.exact.im.Sign() != 0 || !x.exact.re.IsInt() {
+		return nil, notInteger(exactNumbers{}.toComplex(x))
+	}
+	return n.wrap(x.exact.re.Num()), nil
+}
+
+func (n wordNumbers) number(token Token) (*big.Int, error) {
+	value, err := exactNumbers{}.number(token)
+	if err != nil {
+		return nil, err
+	}
+	integer, err := n.fromExact(value)
+	if err != nil {
+		return nil, newTokenError(KindDomain, token, fmt.Sprintf("number '%s' at position %d: %v", token.Literal, token.Position, err))
+	}
+	return integer, nil
+}
+
+func (n wordNumbers) constant(def ConstantDef) (*big.Int, error) {
+	integer, ok := floatInteger(def.Value)
+	if !ok {
+		return nil, notInteger(def.Value)
+	}
+	return n.wrap(integer), nil
+}
+
+func (n wordNumbers) fromComplex(c complex128) (*big.Int, error) {
+	integer, ok := floatInteger(c)
+	if !ok {
+		return nil, notInteger(c)
+	}
+	return n.wrap(integer), nil
+}
+
+func (n wordNumbers) toComplex(x *big.Int) complex128 {
+	value, _ := new(big.Float).SetInt(x).Float64()
+	return complex(value, 0)
+}
+
+func (n wordNumbers) precise(x *big.Int) any { return x }
+
+func (n wordNumbers) operate(op Token, a, b *big.Int) (*big.Int, error) {
+	a, b = n.wrap(a), n.wrap(b)
+	switch op.Type {
+	case PLUS:
+		return n.wrap(new(big.Int).Add(a, b)), nil
+	case MINUS:
+		return n.wrap(new(big.Int).Sub(a, b)), nil
+	case ASTERISK:
+		return n.wrap(new(big.Int).Mul(a, b)), nil
+	case SLASH, SLASH_SLASH:
+		if b.Sign() == 0 {
+			return nil, operatorError(op, errDivisionByZero)
+		}
+		return n.wrap(new(big.Int).Quo(a, b)), nil
+	case PERCENT:
+		if b.Sign() == 0 {
+			return nil, newTokenError(KindDomain, op,
+				fmt.Sprintf("divisor is zero for modulo operator at position %d", op.Position),
+			)
+		}
+		return n.wrap(new(big.Int).Rem(a, b)), nil
+	case CARET:
+		if b.Sign() < 0 {
+			return nil, operatorError(op, fmt.Errorf("negative exponent %s has no integer result", b))
+		}
+		return n.wrap(new(big.Int).Exp(n.pattern(a), b, n.modulus())), nil
+	case SHIFT_LEFT, SHIFT_RIGHT:
+		// Shifting by the word size or more shifts every bit out.
+		if b.Sign() >= 0 && b.Cmp(big.NewInt(int64(n.size))) > 0 {
+			b = big.NewInt(int64(n.size))
+		}
+		result, err := integerOperate(op, a, b)
+		if err != nil {
+			return nil, err
+		}
+		return n.wrap(result), nil
+	case AMPERSAND, PIPE, XOR:
+		result, err := integerOperate(op, a, b)
+		if err != nil {
+			return nil, err
+		}
+		return n.wrap(result), nil
+	case ANGLE:
+		return nil, operatorError(op, errAngleInProgrammerMode)
+	}
+	return nil, newTokenError(KindParse, op, fmt.Sprintf("unexpected operator '%s' at position %d", op.Literal, op.Position))
+}
+
+func (n wordNumbers) negate(x *big.Int) *big.Int {
+	return n.wrap(new(big.Int).Neg(x))
+}
+
+func (n wordNumbers) complement(_ Token, x *big.Int) (*big.Int, error) {
+	return n.wrap(new(big.Int).Not(x)), nil
+}
+
+// compare compares the integers of a and b's words exactly; the tolerance does not apply to them.
+func (n wordNumbers) compare(op Token, a, b *big.Int, _ float64) (bool, error) {
+	sign := n.wrap(a).Cmp(n.wrap(b))
+	return comparison(op, sign == 0, func() (int, error) { return sign, nil })
+}
+
+// factorial multiplies modulo the word size, stopping once the product is 0, as it is for
+// every n! with n >= 2*size.
+func (n wordNumbers) factorial(op Token, x *big.Int, budget *Budget) (*big.Int, error) {
+	x = n.wrap(x)
+	if factorialPole(op, x) {
+		return nil, errFactorialPole(op, x)
+	}
+	if x.Sign() < 0 { // (-1)!! = 1 and (-3)!! = -1; the others are fractions
+		result, err := integerFactorial(op, x, budget)
+		if err != nil {
+			return nil, err
+		}
+		if !result.IsInt() {
+			value, _ := result.Float64()
+			return nil, operatorError(op, notInteger(complex(value, 0)))
+		}
+		return n.wrap(result.Num()), nil
+	}
+	step := big.NewInt(1)
+	if op.Type == DOUBLE_FACTORIAL {
+		step = big.NewInt(2)
+	}
+	result := big.NewInt(1)
+	for factor := new(big.Int).Set(x); factor.Sign() > 0 && result.Sign() != 0; factor.Sub(factor, step) {
+		if err := budget.Spend(1); err != nil {
+			return nil, operatorError(op, err)
+		}
+		result = n.pattern(result.Mul(result, factor))
+	}
+	return n.wrap(result), nil
+}
+
+// call computes functions in exact mode, so that functions like abs, min and max keep every
+// bit of 64-bit words; the result must be an integer.
+func (n wordNumbers) call(function FunctionDef, args []*big.Int, budget *Budget) (*big.Int, error) {
+	exactArgs := make([]exactValue, len(args))
+	for i, arg := range args {
+		exactArgs[i] = exactOf(new(big.Rat).SetInt(n.wrap(arg)), new(big.Rat))
+	}
+	result, err := exactNumbers{}.call(function, exactArgs, budget)
+	if err != nil {
+		return nil, err
+	}
+	return n.fromExact(result)
+}
+
+func (n wordNumbers) degreesToRadians(*big.Int) (*big.Int, error) {
+	return nil, errAngleInProgrammerMode
+}
+
+func (n wordNumbers) radiansToDegrees(*big.Int) (*big.Int, error) {
+	return nil, errAngleInProgrammerMode
+}
+
+// format shows x in decimal with its sign or, in bases 2, 8 and 16, as the bits of the
+// word, so that -1 in an 8-bit word is 0xFF.
+func (n wordNumbers) format(x *big.Int, settings Settings) string {
+	if settings.Base == 0 || settings.Base == 10 {
+		return n.wrap(x).String()
+	}
+	return formatIntegersInBase(n.pattern(x), new(big.Int), settings.Base)
+}
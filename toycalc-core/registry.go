@@ -0,0 +1,385 @@
+// registry.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Variadic is the Arity of functions that accept one or more arguments, like min and max.
+const Variadic = -1
+
+// FunctionImpl computes a function from its evaluated arguments. len(args) always matches
+// the Arity of the FunctionDef, less at most Optional trailing arguments (or is at least 1
+// for Variadic functions).
+type FunctionImpl func(args []complex128) (complex128, error)
+
+// BudgetedImpl is the signature of implementations that iterate (series, root finding, ...).
+// They must call budget.Spend in their loops so that they honor the operation limit and
+// cancellation of the evaluation; budget may be nil, which places no limits.
+type BudgetedImpl func(budget *Budget, args []complex128) (complex128, error)
+
+// AngleUsage tells the evaluator which value of a function is an angle, so that it can be
+// converted when an Engine works in degrees. Impl always works in radians.
+type AngleUsage int
+
+const (
+	NoAngles      AngleUsage = iota // Neither the arguments nor the result are angles
+	AngleArgument                   // The last argument is an angle, as in sin(x) or polar(r, theta)
+	AngleResult                     // The result is an angle, as in asin(x) or phase(x)
+)
+
+// FunctionDef describes a function callable from expressions.
+type FunctionDef struct {
+	Name      string       // Name used in expressions; matched case-insensitively
+	Signature string       // How calls are shown in 'help functions', e.g. "atan2(y, x)"; defaults to "name(x)"
+	Arity     int          // Number of arguments, or Variadic
+	Optional  int          // Number of trailing arguments that may be left out
+	Impl      FunctionImpl // The implementation
+	Budgeted  BudgetedImpl // Used instead of Impl by implementations that iterate
+	Category  string       // Heading under which 'help functions' lists it; defaults to "Other"
+	Help      string       // Text shown by 'help <name>'; defaults to the signature
+	Angles    AngleUsage   // Which value, if any, is affected by the angle mode
+
+	bigImpl     bigImpl        // Implementation for high-precision mode; functions without one are unavailable there
+	exactImpl   exactImpl      // Exact implementation for exact mode; without one, or when it fails, results are approximate
+	integerImpl integerImpl    // Implementation on Gaussian integers, used in every mode instead of the others
+	describe    describeImpl   // Display text of a call that is the whole expression, instead of its value
+	vector      vectorFunction // Set for the functions on whole vectors, which the evaluator implements
+	predicate   bool           // Results are 1 or 0, shown as true or false like a comparison
+}
+
+// ConstantDef describes a named constant usable in expressions, like pi.
+type ConstantDef struct {
+	Name  string     // Name used in expressions; matched case-insensitively
+	Value complex128 // The value of the constant
+	Help  string     // Text shown by 'help <name>'
+
+	bigValue func(prec uint) *big.Float // The value to prec bits in high-precision mode; nil uses Value
+	exact    bool                       // Value is exact, so exact mode does not mark it approximate
+	boolean  bool                       // Value is 1 or 0, shown as true or false like a comparison
+}
+
+// Registry is the single source of truth for the functions and constants known to the
+// parser, the evaluator, the help system and completion. It is safe for concurrent use.
+type Registry struct {
+	mu        sync.RWMutex
+	functions map[string]FunctionDef
+	constants map[string]ConstantDef
+}
+
+// defaultRegistry holds the built-ins plus whatever embedders add with RegisterFunction and
+// RegisterConstant.
+var defaultRegistry = NewRegistry()
+
+// NewRegistry returns a registry holding the built-in functions and constants.
+func NewRegistry() *Registry {
+	r := &Registry{functions: map[string]FunctionDef{}, constants: map[string]ConstantDef{}}
+	for _, def := range builtinConstants {
+		if err := r.RegisterConstant(def); err != nil {
+			panic(err) // The built-in tables are static; a failure here is a programming error.
+		}
+	}
+	for _, def := range builtinFunctions {
+		if err := r.RegisterFunction(def); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// RegisterFunction adds a function to the default registry, making it available to every
+// expression evaluated afterwards, e.g.
+//
+//	toycalc_core.RegisterFunction(toycalc_core.FunctionDef{
+//		Name: "sq", Arity: 1, Category: "Domain",
+//		Impl: func(args []complex128) (complex128, error) { return args[0] * args[0], nil },
+//	})
+func RegisterFunction(def FunctionDef) error {
+	return defaultRegistry.RegisterFunction(def)
+}
+
+// RegisterConstant adds a constant to the default registry.
+func RegisterConstant(def ConstantDef) error {
+	return defaultRegistry.RegisterConstant(def)
+}
+
+// validateName checks that name can be written as an identifier in an expression and is not
+// already taken by a function or constant. r.mu must be held.
+func (r *Registry) validateName(name string) error {
+	if name == "" {
+		return NewCalculationError("cannot register a function or constant with an empty name")
+	}
+	for i, ch := range name {
+		if !isLetter(ch) && (i == 0 || !isDigit(ch)) {
+			return NewCalculationError(fmt.Sprintf("cannot register '%s': names must start with a letter or '_' and contain only letters, digits and '_'", name))
+		}
+	}
+	if _, found := r.functions[name]; found {
+		return NewCalculationError(fmt.Sprintf("cannot register '%s': a function with that name already exists", name))
+	}
+	if _, found := r.constants[name]; found {
+		return NewCalculationError(fmt.Sprintf("cannot register '%s': a constant with that name already exists", name))
+	}
+	return nil
+}
+
+// RegisterFunction adds a function to r. Names are stored lowercased.
+func (r *Registry) RegisterFunction(def FunctionDef) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	def.Name = strings.ToLower(def.Name)
+	if err := r.validateName(def.Name); err != nil {
+		return err
+	}
+	if def.Impl == nil && def.Budgeted == nil && def.integerImpl == nil && def.vector == notVector {
+		return NewCalculationError(fmt.Sprintf("cannot register '%s': missing implementation", def.Name))
+	}
+	if def.Impl != nil && def.Budgeted != nil {
+		return NewCalculationError(fmt.Sprintf("cannot register '%s': set either Impl or Budgeted, not both", def.Name))
+	}
+	if def.Arity < 1 && def.Arity != Variadic {
+		return NewCalculationError(fmt.Sprintf("cannot register '%s': arity must be at least 1 or Variadic", def.Name))
+	}
+	if def.Optional < 0 || def.Optional > 0 && (def.Arity == Variadic || def.Optional >= def.Arity) {
+		return NewCalculationError(fmt.Sprintf("cannot register '%s': optional arguments must leave at least 1 required one", def.Name))
+	}
+	if def.Signature == "" {
+		def.Signature = def.Name + "(x)"
+	}
+	if def.Category == "" {
+		def.Category = "Other"
+	}
+	if def.Help == "" {
+		def.Help = "Function: " + def.Signature
+	}
+	r.functions[def.Name] = def
+	return nil
+}
+
+// RegisterConstant adds a constant to r. Names are stored lowercased.
+func (r *Registry) RegisterConstant(def ConstantDef) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	def.Name = strings.ToLower(def.Name)
+	if err := r.validateName(def.Name); err != nil {
+		return err
+	}
+	if def.Help == "" {
+		def.Help = "Constant: " + def.Name
+	}
+	r.constants[def.Name] = def
+	return nil
+}
+
+// Function looks up a function by name (case-insensitively).
+func (r *Registry) Function(name string) (FunctionDef, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	def, found := r.functions[strings.ToLower(name)]
+	return def, found
+}
+
+// Constant looks up a constant by name (case-insensitively).
+func (r *Registry) Constant(name string) (ConstantDef, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	def, found := r.constants[strings.ToLower(name)]
+	return def, found
+}
+
+// IsFunction reports whether name is a registered function.
+func (r *Registry) IsFunction(name string) bool {
+	_, found := r.Function(name)
+	return found
+}
+
+// IsConstant reports whether name is a registered constant.
+func (r *Registry) IsConstant(name string) bool {
+	_, found := r.Constant(name)
+	return found
+}
+
+// isReserved reports whether name belongs to a registered constant or function, so that
+// variables, user functions and parameters cannot take it.
+func (r *Registry) isReserved(name string) bool {
+	return r.IsConstant(name) || r.IsFunction(name)
+}
+
+// kind describes a reserved name for error messages ("constant" or "function").
+func (r *Registry) kind(name string) string {
+	if r.IsConstant(name) {
+		return "constant"
+	}
+	return "function"
+}
+
+// Clone returns a copy of r that can be extended without affecting r.
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	clone := &Registry{functions: make(map[string]FunctionDef, len(r.functions)), constants: make(map[string]ConstantDef, len(r.constants))}
+	for name, def := range r.functions {
+		clone.functions[name] = def
+	}
+	for name, def := range r.constants {
+		clone.constants[name] = def
+	}
+	return clone
+}
+
+// Functions returns all registered functions sorted by name.
+func (r *Registry) Functions() []FunctionDef {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	defs := make([]FunctionDef, 0, len(r.functions))
+	for _, name := range sortedKeys(r.functions) {
+		defs = append(defs, r.functions[name])
+	}
+	return defs
+}
+
+// Constants returns all registered constants sorted by name.
+func (r *Registry) Constants() []ConstantDef {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	defs := make([]ConstantDef, 0, len(r.constants))
+	for _, name := range sortedKeys(r.constants) {
+		defs = append(defs, r.constants[name])
+	}
+	return defs
+}
+
+// Categories returns the categories of the registered functions: the built-in ones first,
+// in their usual order, then any others alphabetically.
+func (r *Registry) Categories() []string {
+	present := map[string]bool{}
+	for _, def := range r.Functions() {
+		present[def.Category] = true
+	}
+	var categories []string
+	for _, category := range categoryOrder {
+		if present[category] {
+			categories = append(categories, category)
+			delete(present, category)
+		}
+	}
+	extra := sortedKeys(present)
+	return append(categories, extra...)
+}
+
+// knownNames returns every name an expression evaluated in env can refer to: the functions
+// and constants of its registry and the variables and functions of env and its parents.
+// The value reports whether the name is a function. env may be nil.
+func knownNames(env *Environment) map[string]bool {
+	names := map[string]bool{}
+	registry := env.builtins()
+	for _, def := range registry.Functions() {
+		names[def.Name] = true
+	}
+	for _, def := range registry.Constants() {
+		names[def.Name] = false
+	}
+	for scope := env; scope != nil; scope = scope.parent {
+		for _, name := range scope.Names() {
+			if _, shadowed := names[name]; !shadowed {
+				names[name] = false
+			}
+		}
+		for _, name := range scope.FunctionNames() {
+			if _, shadowed := names[name]; !shadowed {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+// Completions returns the names of the functions and constants in env's registry and the
+// variables and functions of env that start with prefix (case-insensitively), sorted.
+// Function names end with "(". env may be nil.
+func Completions(prefix string, env *Environment) []string {
+	lowerPrefix := strings.ToLower(prefix)
+	var completions []string
+	for name, isFunction := range knownNames(env) {
+		if !strings.HasPrefix(name, lowerPrefix) {
+			continue
+		}
+		if isFunction {
+			name += "("
+		}
+		completions = append(completions, name)
+	}
+	sort.Strings(completions)
+	return completions
+}
+
+// MaxSuggestions is the largest number of names Suggestions returns.
+const MaxSuggestions = 3
+
+// Suggestions returns up to MaxSuggestions known names (see Completions) that look like a
+// misspelling of name, closest first: names within a small edit distance, such as "sin" for
+// "sine", and names that name is a prefix of, such as "sqrt" for "sqr". env may be nil.
+func Suggestions(name string, env *Environment) []string {
+	lowerName := strings.ToLower(name)
+	maxDistance := max(1, len(lowerName)/3)
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for known := range knownNames(env) {
+		if known == lowerName {
+			continue
+		}
+		distance := editDistance(lowerName, known)
+		closeEnough := distance <= maxDistance && distance < len(lowerName) // Never suggest 'e' for 'x'
+		if closeEnough || (len(lowerName) >= 2 && strings.HasPrefix(known, lowerName)) {
+			candidates = append(candidates, candidate{known, distance})
+		}
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		if candidates[a].distance != candidates[b].distance {
+			return candidates[a].distance < candidates[b].distance
+		}
+		return candidates[a].name < candidates[b].name
+	})
+	var suggestions []string
+	for _, c := range candidates[:min(len(candidates), MaxSuggestions)] {
+		suggestions = append(suggestions, c.name)
+	}
+	return suggestions
+}
+
+// editDistance is the number of single-character insertions, deletions, substitutions and
+// transpositions of adjacent characters needed to turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// rows[i][j] is the distance between ra[:i] and rb[:j]; only the last three rows are kept.
+	prevPrev := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prevPrev[j-2]+1)
+			}
+		}
+		prevPrev, prev, curr = prev, curr, prevPrev
+	}
+	return prev[len(rb)]
+}
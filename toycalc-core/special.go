@@ -0,0 +1,295 @@
+// special.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// maxSpecialIterations bounds the iterative routines below; they spend one operation of
+// the budget per iteration as well.
+const maxSpecialIterations = 1000
+
+// maxErfSeries bounds |z| for erfSeries, whose terms grow to about e^(|z|²) and overflow
+// beyond it.
+const maxErfSeries = 26
+
+// complexErf returns the error function of z: math.Erf on the real axis, i erfi(Im(z)) on
+// the imaginary axis, the Maclaurin series near it, where it does not suffer cancellation,
+// and 1 - erfc(z) elsewhere. erf(-z) = -erf(z).
+func complexErf(z complex128, budget *Budget) (complex128, error) {
+	if imag(z) == 0 {
+		return complex(math.Erf(real(z)), 0), nil
+	}
+	if real(z) == 0 {
+		y, err := realErfi(imag(z), budget)
+		return complex(0, y), err
+	}
+	if real(z) < 0 {
+		result, err := complexErf(-z, budget)
+		return -result, err
+	}
+	if real(z) < 1.5 && cmplx.Abs(z) < maxErfSeries {
+		return erfSeries(z, budget)
+	}
+	complement, err := erfcContinuedFraction(z, budget)
+	return 1 - complement, err
+}
+
+// complexErfc returns 1 - erf(z) without the cancellation of that difference for large
+// Re(z). erfc(-z) = 2 - erfc(z).
+func complexErfc(z complex128, budget *Budget) (complex128, error) {
+	if imag(z) == 0 {
+		return complex(math.Erfc(real(z)), 0), nil
+	}
+	if real(z) == 0 {
+		y, err := realErfi(imag(z), budget)
+		return complex(1, -y), err
+	}
+	if real(z) < 0 {
+		result, err := complexErfc(-z, budget)
+		return 2 - result, err
+	}
+	if real(z) < 1.5 && cmplx.Abs(z) < maxErfSeries {
+		result, err := erfSeries(z, budget)
+		return 1 - result, err
+	}
+	return erfcContinuedFraction(z, budget)
+}
+
+// erfSeries sums erf(z) = 2/√π Σ (-1)^n z^(2n+1) / (n! (2n+1)). Its terms grow to about
+// e^(|z|²) while the result is about e^(Im(z)²-Re(z)²), so the digits lost to cancellation
+// are those of e^(2 Re(z)²): few for the Re(z) < 1.5 it is used for.
+func erfSeries(z complex128, budget *Budget) (complex128, error) {
+	z2 := z * z
+	term := z // z^(2n+1) (-1)^n / n!
+	sum := z
+	for n := 1; ; n++ {
+		if err := budget.Spend(1); err != nil {
+			return 0, err
+		}
+		term *= -z2 / complex(float64(n), 0)
+		contribution := term / complex(float64(2*n+1), 0)
+		sum += contribution
+		if cmplx.Abs(contribution) <= 1e-17*cmplx.Abs(sum) || cmplx.IsInf(sum) {
+			break
+		}
+	}
+	return sum * complex(2/math.Sqrt(math.Pi), 0), nil
+}
+
+// erfcContinuedFraction evaluates erfc(z) = e^(-z²)/√π · 1/(z + (1/2)/(z + 1/(z + (3/2)/(z + ...))))
+// with the modified Lentz algorithm, for Re(z) > 0.
+func erfcContinuedFraction(z complex128, budget *Budget) (complex128, error) {
+	const tiny = 1e-300
+	f := z
+	c, d := z, complex(0, 0)
+	for n := 1; n <= maxSpecialIterations; n++ {
+		if err := budget.Spend(1); err != nil {
+			return 0, err
+		}
+		a := complex(float64(n)/2, 0)
+		d = z + a*d
+		if d == 0 {
+			d = tiny
+		}
+		c = z + a/c
+		if c == 0 {
+			c = tiny
+		}
+		d = 1 / d
+		delta := c * d
+		f *= delta
+		if cmplx.Abs(delta-1) < 1e-16 {
+			break
+		}
+	}
+	return expTimes(-z*z, 1/(complex(math.Sqrt(math.Pi), 0)*f)), nil
+}
+
+// realErfi returns erfi(x) for real x. erf(ix) = i erfi(x) is purely imaginary, so the
+// series has no cancellation there; for |x| >= 6 the asymptotic expansion
+// erfi(x) ~ e^(x²)/(x√π) Σ (2k-1)!!/(2x²)^k takes over, before the terms of the series
+// overflow, and gives ±Inf once the result does.
+func realErfi(x float64, budget *Budget) (float64, error) {
+	if math.Abs(x) < 6 {
+		result, err := erfSeries(complex(0, x), budget)
+		return imag(result), err
+	}
+	x2 := x * x
+	term, sum := 1.0, 1.0
+	for k := 1; k <= maxSpecialIterations; k++ {
+		if err := budget.Spend(1); err != nil {
+			return 0, err
+		}
+		next := term * float64(2*k-1) / (2 * x2)
+		if next < 1e-17 || next >= term { // The expansion diverges past its smallest term
+			break
+		}
+		term = next
+		sum += term
+	}
+	return math.Copysign(math.Exp(x2-math.Log(math.Abs(x)*math.SqrtPi))*sum, x), nil
+}
+
+// complexErfi returns the imaginary error function, erfi(z) = -i erf(iz).
+func complexErfi(z complex128, budget *Budget) (complex128, error) {
+	if imag(z) == 0 {
+		result, err := realErfi(real(z), budget)
+		return complex(result, 0), err
+	}
+	result, err := complexErf(complex(0, 1)*z, budget)
+	return complex(0, -1) * result, err
+}
+
+// complexLambertW returns W_k(z), the branch k of the inverse of w e^w, with the branch
+// cuts of Corless et al.: W_0 is real on [-1/e, ∞) and W_-1 on [-1/e, 0). It starts from
+// the series at the branch point -1/e or from the asymptotic expansion and refines with
+// Halley's method.
+func complexLambertW(z complex128, k int, budget *Budget) (complex128, error) {
+	if z == 0 {
+		if k == 0 {
+			return 0, nil
+		}
+		return complex(math.Inf(-1), 0), nil
+	}
+	w := lambertWGuess(z, k)
+	for range maxSpecialIterations {
+		if err := budget.Spend(1); err != nil {
+			return 0, err
+		}
+		ew := cmplx.Exp(w)
+		f := w*ew - z
+		step := f / (ew*(w+1) - (w+2)*f/(2*w+2))
+		if cmplx.IsNaN(step) || cmplx.IsInf(step) {
+			break
+		}
+		w -= step
+		if cmplx.Abs(step) <= 1e-15*cmplx.Abs(w) {
+			break
+		}
+	}
+	if imag(z) == 0 && (k == 0 && real(z) >= -1/math.E || k == -1 && real(z) >= -1/math.E && real(z) < 0) {
+		w = complex(real(w), 0) // Real on these intervals; drop the rounding residue
+	}
+	return w, nil
+}
+
+// lambertWGuess is the starting point of the Halley iteration of complexLambertW.
+func lambertWGuess(z complex128, k int) complex128 {
+	nearBranchPoint := cmplx.Abs(z+1/math.E) < 0.3
+	p := cmplx.Sqrt(2 * (math.E*z + 1))
+	switch {
+	case k == 0 && nearBranchPoint:
+		return -1 + p - p*p/3 + 11.0/72*p*p*p
+	case (k == -1 && imag(z) >= 0 || k == 1 && imag(z) < 0) && nearBranchPoint:
+		return -1 - p - p*p/3 - 11.0/72*p*p*p
+	case k == 0 && cmplx.Abs(z) <= 10 && real(z) > -0.5:
+		return cmplx.Log(1 + z)
+	}
+	l1 := cmplx.Log(z) + complex(0, 2*math.Pi*float64(k))
+	l2 := cmplx.Log(l1)
+	return l1 - l2 + l2/l1
+}
+
+// lambertW is the FunctionImpl of lambertw(z [, k]): k must be an integer, and is 0, the
+// principal branch, if left out.
+func lambertW(budget *Budget, args []complex128) (complex128, error) {
+	if len(args) == 1 {
+		return complexLambertW(args[0], 0, budget)
+	}
+	branch, ok := floatInteger(args[1])
+	if !ok || !branch.IsInt64() {
+		return complex(math.NaN(), math.NaN()), fmt.Errorf("the branch index must be an integer, got %s", formatComplexOutput(args[1]))
+	}
+	return complexLambertW(args[0], int(branch.Int64()), budget)
+}
+
+// eulerMaclaurinCoefficients are B(2j) / (2j)! for j = 1..10.
+var eulerMaclaurinCoefficients = [...]float64{
+	1.0 / 6 / 2,
+	-1.0 / 30 / 24,
+	1.0 / 42 / 720,
+	-1.0 / 30 / 40320,
+	5.0 / 66 / 3628800,
+	-691.0 / 2730 / 479001600,
+	7.0 / 6 / 87178291200,
+	-3617.0 / 510 / 20922789888000,
+	43867.0 / 798 / 6402373705728000,
+	-174611.0 / 330 / 2432902008176640000,
+}
+
+// complexZeta returns the Riemann zeta function, continued analytically to every s except
+// the pole at 1: Euler-Maclaurin summation for Re(s) >= 1/2 and the functional equation
+// ζ(s) = 2^s π^(s-1) sin(πs/2) Γ(1-s) ζ(1-s) for Re(s) < 1/2. The trivial zeros at the
+// negative even integers are exact.
+func complexZeta(s complex128, budget *Budget) (complex128, error) {
+	if s == 1 {
+		return complex(math.NaN(), math.NaN()), fmt.Errorf("undefined at the pole 1")
+	}
+	if s == 0 {
+		return -0.5, nil
+	}
+	if imag(s) == 0 && real(s) < 0 && math.Mod(real(s), 2) == 0 {
+		return 0, nil
+	}
+	if real(s) >= 0.5 {
+		return zetaEulerMaclaurin(s, budget)
+	}
+	if imag(s) < 0 {
+		result, err := complexZeta(cmplx.Conj(s), budget)
+		return cmplx.Conj(result), err
+	}
+	reflected, err := zetaEulerMaclaurin(1-s, budget)
+	if err != nil {
+		return 0, err
+	}
+	if imag(s) == 0 {
+		x := real(s)
+		logGamma, _ := math.Lgamma(1 - x)
+		factor := math.Exp(x*math.Ln2+(x-1)*math.Log(math.Pi)+logGamma) * math.Sin(math.Pi*math.Mod(x, 4)/2)
+		return complex(factor*real(reflected), 0), nil
+	}
+	// The factors are combined as logarithms, since for large Im(s) sin(πs/2) overflows while
+	// the product does not. As in complexLogGamma, ln sin(πs/2) = ln(1 - exp(πis)) - ln 2 +
+	// πi/2 - πis/2.
+	logSin := cmplx.Log(1-cmplx.Exp(complex(0, math.Pi)*s)) - math.Ln2 + complex(0, math.Pi/2) - complex(0, math.Pi/2)*s
+	logFactor := s*math.Ln2 + (s-1)*complex(math.Log(math.Pi), 0) + logSin + complexLogGamma(1-s)
+	return cmplx.Exp(logFactor) * reflected, nil
+}
+
+// zetaEulerMaclaurin sums ζ(s) = Σ_{k<N} k^-s + N^(1-s)/(s-1) + N^-s/2 + Σ_j B(2j)/(2j)!
+// s(s+1)...(s+2j-2) N^(-s-2j+1), with N large enough next to |s| for the correction terms
+// to fall below double precision.
+func zetaEulerMaclaurin(s complex128, budget *Budget) (complex128, error) {
+	if real(s) >= 50 { // The terms past 3^-s are below double precision
+		return 1 + cmplx.Exp(-s*math.Ln2) + cmplx.Exp(-s*complex(math.Log(3), 0)), nil
+	}
+	n := 20 + int(math.Ceil(cmplx.Abs(s)))
+	if err := budget.Spend(n); err != nil {
+		return 0, err
+	}
+	sum := complex(0, 0)
+	for k := n - 1; k >= 1; k-- { // Smallest terms first
+		sum += cmplx.Exp(-s * complex(math.Log(float64(k)), 0))
+	}
+	logN := complex(math.Log(float64(n)), 0)
+	powerN := cmplx.Exp(-s * logN) // N^-s
+	nf := complex(float64(n), 0)
+	sum += powerN*nf/(s-1) + powerN/2
+	// factor is s(s+1)...(s+2j-2) N^(-s-2j+1).
+	factor := s * powerN / nf
+	for j, coefficient := range eulerMaclaurinCoefficients {
+		sum += complex(coefficient, 0) * factor
+		factor *= (s + complex(float64(2*j+1), 0)) * (s + complex(float64(2*j+2), 0)) / (nf * nf)
+	}
+	return sum, nil
+}
+
+// iterative adapts one of the one-argument functions above to a BudgetedImpl.
+func iterative(f func(complex128, *Budget) (complex128, error)) BudgetedImpl {
+	return func(budget *Budget, args []complex128) (complex128, error) {
+		return f(args[0], budget)
+	}
+}
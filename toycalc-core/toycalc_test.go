@@ -2,12 +2,16 @@
 package toycalc_core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/cmplx"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // --- Test Helper Functions (ensure these are present and correct) ---
@@ -586,6 +590,18 @@ func TestParserToRPN(t *testing.T) {
 					simpleActualRPN[i] = Token{Type: tok.Type, Literal: tok.Literal}
 				}
 				compareTokenSlices(t, simpleExpectedRPN, simpleActualRPN, "RPN output for "+tc.input)
+
+				// The same RPN must be derivable from the tree returned by ParseAST.
+				tree, astErr := ParseAST(tokens)
+				if astErr != nil {
+					t.Fatalf("ParseAST failed unexpectedly: %v", astErr)
+				}
+				treeRPN := ToRPN(tree)
+				simpleTreeRPN := make([]Token, len(treeRPN))
+				for i, tok := range treeRPN {
+					simpleTreeRPN[i] = Token{Type: tok.Type, Literal: tok.Literal}
+				}
+				compareTokenSlices(t, simpleExpectedRPN, simpleTreeRPN, "RPN from AST for "+tc.input)
 			}
 		})
 	}
@@ -996,6 +1012,48 @@ func runRPNEvalTests(t *testing.T, testCases []rpnEvalTestCase) {
 	}
 }
 
+// engineTestCase is an expression and the result an engine must display for it.
+type engineTestCase struct {
+	name     string
+	engine   *Engine // The engine behind CalculateExpression if nil
+	input    string
+	expected string
+}
+
+// runEngineCases evaluates each case in a subtest, named after its input if it has no name.
+func runEngineCases(t *testing.T, testCases []engineTestCase) {
+	for _, tc := range testCases {
+		t.Run(Ternary(tc.name == "", tc.input, tc.name), func(t *testing.T) {
+			result, err := tc.evaluate()
+			if err != nil {
+				t.Fatalf("EvaluateExpression(%q) failed unexpectedly: %v", tc.input, err)
+			}
+			if result != tc.expected {
+				t.Errorf("EvaluateExpression(%q): expected %q, got %q", tc.input, tc.expected, result)
+			}
+		})
+	}
+}
+
+// evaluate evaluates the input of tc with its engine.
+func (tc engineTestCase) evaluate() (string, error) {
+	if tc.engine == nil {
+		return defaultEngine.EvaluateExpression(tc.input)
+	}
+	return tc.engine.EvaluateExpression(tc.input)
+}
+
+// runEngineErrorCases evaluates each case in a subtest, which must fail with an error
+// containing the expected text.
+func runEngineErrorCases(t *testing.T, testCases []engineTestCase) {
+	for _, tc := range testCases {
+		t.Run(Ternary(tc.name == "", tc.input, tc.name), func(t *testing.T) {
+			_, err := tc.evaluate()
+			checkError(t, tc.expected, err)
+		})
+	}
+}
+
 func TestStage2FunctionsEvaluator(t *testing.T) {
 	/*z1 := complex(3, 4) // |z1|=5, phase(z1) approx 0.927
 	z2 := complex(-1, 2)
@@ -1123,3 +1181,1732 @@ func TestCalculateExpressionEndToEnd_Stage2(t *testing.T) {
 		})
 	}
 }
+
+// --- Variables and Assignment ---
+
+func TestCalculateStatementVariables(t *testing.T) {
+	env := NewEnvironment()
+	steps := []struct {
+		input                  string
+		expectedOutput         string
+		expectedErrorSubstring string
+	}{
+		{"x = 3+4i", "3 + 4i", ""},
+		{"abs(x)", "5", ""},
+		{"2x", "6 + 8i", ""},
+		{"X * conj(x)", "25", ""}, // Names are case-insensitive
+		{"y = x - 3", "4i", ""},
+		{"y/x", "0.64 + 0.48i", ""},
+		{"x = 1", "1", ""}, // Rebinding is allowed
+		{"x + y", "1 + 4i", ""},
+		{"pi = 3", "", "cannot assign to built-in constant 'pi' at position 0"},
+		{"i = 2", "", "cannot assign to built-in constant 'i'"},
+		{"sin = 1", "", "cannot assign to built-in function 'sin'"},
+		{"z = ", "", "missing expression after '='"},
+		{"1 = 2", "", "unexpected '=' at position 2"},
+		{"z + 1", "", "unknown identifier or function 'z'"},
+	}
+
+	for _, step := range steps {
+		actualOutput, err := CalculateStatement(step.input, env)
+		checkError(t, step.expectedErrorSubstring, err)
+		if step.expectedErrorSubstring == "" && err == nil && actualOutput != step.expectedOutput {
+			t.Errorf("Input '%s': Expected output '%s', but got '%s'", step.input, step.expectedOutput, actualOutput)
+		}
+	}
+
+	if err := env.Set("e", 1); err == nil {
+		t.Errorf("Expected Environment.Set to reject the built-in constant 'e'")
+	}
+	if names := env.Names(); !reflect.DeepEqual(names, []string{"x", "y"}) {
+		t.Errorf("Expected variables [x y], got %v", names)
+	}
+}
+
+func TestCalculateScript(t *testing.T) {
+	script := "# circuit values\nr = 100\n\nxl = 50i\nz = r + xl\nabs(z)^2\n"
+	results, err := CalculateScript(script, NewEnvironment())
+	checkError(t, "", err)
+	expected := []string{"100", "50i", "100 + 50i", "12500"}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Expected script results %v, got %v", expected, results)
+	}
+
+	_, err = CalculateScript("a = 1\nb = a +\n", NewEnvironment())
+	checkError(t, "line 2:", err)
+}
+
+// --- User-Defined Functions ---
+
+func TestCalculateStatementUserFunctions(t *testing.T) {
+	env := NewEnvironment()
+	steps := []struct {
+		input                  string
+		expectedOutput         string
+		expectedErrorSubstring string
+	}{
+		{"f(x, y) = x^2 + y*i", "f(x, y) = x^2 + y*i", ""},
+		{"f(1, 2)", "1 + 2i", ""},
+		{"2f(1,2)", "2 + 4i", ""},
+		{"f(3, 0) + f(0, 1)", "9 + i", ""},
+		{"F(1, 2)", "1 + 2i", ""},
+		{"f(2) + 1", "", "function 'f' expects 2 argument(s) but got 1 at position 0"},
+		{"f(1, 2, 3)", "", "function 'f' expects 2 argument(s) but got 3"},
+		{"f(1,)", "", "missing operand before closing parenthesis"},
+		{"a = 2", "2", ""},
+		{"g(t) = a*t", "g(t) = a*t", ""},
+		{"g(3)", "6", ""},
+		{"a = 5", "5", ""},
+		{"g(3)", "15", ""}, // Free variables are looked up at call time
+		{"x = 100", "100", ""},
+		{"h(x) = g(x) + x", "h(x) = g(x) + x", ""},
+		{"h(1)", "6", ""}, // Parameter x shadows the global x
+		{"loop(x) = loop(x)", "loop(x) = loop(x)", ""},
+		{"loop(1)", "", "maximum call depth of 256 exceeded in function 'loop'"},
+		{"sin(x) = 1", "", "cannot assign to built-in function 'sin'"},
+		{"p(pi) = 1", "", "cannot use built-in constant 'pi' as a parameter name"},
+		{"p(x, x) = 1", "", "duplicate parameter 'x' at position 5"},
+		{"p(x) = x + undefined", "", "unknown identifier or function 'undefined'"},
+		{"(1, 2)", "", "commas can only separate function arguments"},
+	}
+
+	for _, step := range steps {
+		actualOutput, err := CalculateStatement(step.input, env)
+		checkError(t, step.expectedErrorSubstring, err)
+		if step.expectedErrorSubstring == "" && err == nil && actualOutput != step.expectedOutput {
+			t.Errorf("Input '%s': Expected output '%s', but got '%s'", step.input, step.expectedOutput, actualOutput)
+		}
+	}
+
+	if names := env.FunctionNames(); !reflect.DeepEqual(names, []string{"f", "g", "h", "loop"}) {
+		t.Errorf("Expected functions [f g h loop], got %v", names)
+	}
+}
+
+// --- Multi-Argument Functions ---
+
+func TestMultiArgumentFunctionsEvaluator(t *testing.T) {
+	testCases := []rpnEvalTestCase{
+		{name: "atan2(1, 1)", input: "atan2(1, 1)", expectedResult: complex(math.Pi/4, 0)},
+		{name: "atan2(1, -1)", input: "atan2(1, -1)", expectedResult: complex(3*math.Pi/4, 0)},
+		{name: "atan2(-1, -1)", input: "atan2(-1, -1)", expectedResult: complex(-3*math.Pi/4, 0)},
+		{name: "atan2 complex", input: "atan2(i, 1)", expectedResult: -complex(0, 1) * cmplx.Log((1+complex(0, 1)*complex(0, 1))/cmplx.Sqrt(1+complex(0, 1)*complex(0, 1))), skipStrCompare: true},
+		{name: "logb(81, 3)", input: "logb(81, 3)", expectedResult: complex(4, 0)},
+		{name: "logb(-8, 2)", input: "logb(-8, 2)", expectedResult: cmplx.Log(-8) / cmplx.Log(2)},
+		{name: "root(27, 3)", input: "root(27, 3)", expectedResult: complex(3, 0)},
+		{name: "root(-8, 3) real root", input: "root(-8, 3)", expectedResult: complex(-2, 0)},
+		{name: "root(-4, 2) principal", input: "root(-4, 2)", expectedResult: complex(0, 2)},
+		{name: "root(16i, 4)", input: "root(16i, 4)", expectedResult: cmplx.Pow(complex(0, 16), 0.25)},
+		{name: "hypot(3, 4)", input: "hypot(3, 4)", expectedResult: complex(5, 0)},
+		{name: "hypot(1e200, 1e200)", input: "hypot(1e200, 1e200)", expectedResult: complex(math.Hypot(1e200, 1e200), 0)},
+		{name: "hypot(i, 1)", input: "hypot(i, 1)", expectedResult: complex(0, 0)},
+		{name: "polar(2, pi/2)", input: "polar(2, pi/2)", expectedResult: complex(0, 2)},
+		{name: "polar(1, pi)", input: "polar(1, pi)", expectedResult: complex(-1, 0)},
+		{name: "min(3, -1, 2)", input: "min(3, -1, 2)", expectedResult: complex(-1, 0)},
+		{name: "max(3, -1, 2)", input: "max(3, -1, 2)", expectedResult: complex(3, 0)},
+		{name: "min single", input: "min(7)", expectedResult: complex(7, 0)},
+		{name: "max keeps imag part", input: "max(2+i, 1-i)", expectedResult: complex(2, 1)},
+		{name: "nested calls", input: "max(hypot(3, 4), root(8, 3), atan2(0, -1))", expectedResult: complex(5, 0)},
+		{name: "implied mult", input: "2hypot(3, 4)", expectedResult: complex(10, 0)},
+
+		// Arity errors point at the call site
+		{name: "too few args", input: "1 + atan2(1)", expectedError: "function 'atan2' expects 2 argument(s) but got 1 at position 4"},
+		{name: "too many args", input: "hypot(1, 2, 3)", expectedError: "function 'hypot' expects 2 argument(s) but got 3 at position 0"},
+		{name: "unary with two args", input: "sin(1, 2)", expectedError: "function 'sin' expects 1 argument(s) but got 2 at position 0"},
+	}
+	runRPNEvalTests(t, testCases)
+}
+
+func TestParserFunctionArity(t *testing.T) {
+	tokens, err := Lex("max(1, atan2(2, 3), 4) + sin(5)")
+	if err != nil {
+		t.Fatalf("Lexing failed unexpectedly: %v", err)
+	}
+	rpn, err := Parse(tokens)
+	if err != nil {
+		t.Fatalf("Parsing failed unexpectedly: %v", err)
+	}
+	arities := map[string]int{}
+	for _, tok := range rpn {
+		if tok.Type == IDENT {
+			arities[tok.Literal] = tok.Arity
+		}
+	}
+	expected := map[string]int{"max": 3, "atan2": 2, "sin": 1}
+	if !reflect.DeepEqual(arities, expected) {
+		t.Errorf("Expected function arities %v, got %v", expected, arities)
+	}
+}
+
+// --- Function Registry ---
+
+func TestRegistryRegistration(t *testing.T) {
+	r := NewRegistry()
+	square := func(args []complex128) (complex128, error) { return args[0] * args[0], nil }
+
+	errorCases := []struct {
+		name          string
+		register      func() error
+		expectedError string
+	}{
+		{"duplicate builtin", func() error { return r.RegisterFunction(FunctionDef{Name: "Sin", Arity: 1, Impl: square}) }, "cannot register 'sin': a function with that name already exists"},
+		{"clashes with constant", func() error { return r.RegisterFunction(FunctionDef{Name: "pi", Arity: 1, Impl: square}) }, "cannot register 'pi': a constant with that name already exists"},
+		{"invalid name", func() error { return r.RegisterFunction(FunctionDef{Name: "2x", Arity: 1, Impl: square}) }, "cannot register '2x': names must start with a letter or '_' and contain only letters, digits and '_'"},
+		{"missing impl", func() error { return r.RegisterFunction(FunctionDef{Name: "sq", Arity: 1}) }, "cannot register 'sq': missing implementation"},
+		{"bad arity", func() error { return r.RegisterFunction(FunctionDef{Name: "sq", Arity: 0, Impl: square}) }, "cannot register 'sq': arity must be at least 1 or Variadic"},
+		{"empty constant name", func() error { return r.RegisterConstant(ConstantDef{Value: 1}) }, "cannot register a function or constant with an empty name"},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.register()
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("Expected error containing '%s', got %v", tc.expectedError, err)
+			}
+		})
+	}
+
+	if err := r.RegisterFunction(FunctionDef{Name: "Sq", Arity: 1, Impl: square}); err != nil {
+		t.Fatalf("RegisterFunction failed unexpectedly: %v", err)
+	}
+	def, found := r.Function("SQ")
+	if !found {
+		t.Fatalf("Expected 'sq' to be registered")
+	}
+	if def.Signature != "sq(x)" || def.Category != "Other" || def.Help != "Function: sq(x)" {
+		t.Errorf("Expected defaults for signature, category and help, got %+v", def)
+	}
+	if categories := r.Categories(); categories[len(categories)-1] != "Other" {
+		t.Errorf("Expected 'Other' to be listed after the built-in categories, got %v", categories)
+	}
+	if defaultRegistry.IsFunction("sq") {
+		t.Errorf("Registering in a new registry must not affect the default registry")
+	}
+}
+
+func TestRegisteredFunctionsAndConstants(t *testing.T) {
+	err := RegisterFunction(FunctionDef{
+		Name: "testcube", Signature: "testCube(x)", Arity: 1, Category: "Testing",
+		Help: "Function: testCube(x)\n  Cubes x.",
+		Impl: func(args []complex128) (complex128, error) { return args[0] * args[0] * args[0], nil },
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunction failed unexpectedly: %v", err)
+	}
+	err = RegisterFunction(FunctionDef{
+		Name: "testfail", Arity: 1, Category: "Testing",
+		Impl: func(args []complex128) (complex128, error) { return 0, errors.New("always fails") },
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunction failed unexpectedly: %v", err)
+	}
+	if err := RegisterConstant(ConstantDef{Name: "testc", Value: complex(299792458, 0)}); err != nil {
+		t.Fatalf("RegisterConstant failed unexpectedly: %v", err)
+	}
+
+	testCases := []rpnEvalTestCase{
+		{name: "registered function", input: "testcube(2)", expectedResult: complex(8, 0)},
+		{name: "case-insensitive call", input: "TestCube(i)", expectedResult: complex(0, -1)},
+		{name: "implied mult", input: "2testcube(3)", expectedResult: complex(54, 0)},
+		{name: "registered constant", input: "testc/2", expectedResult: complex(149896229, 0)},
+		{name: "arity checked", input: "testcube(1, 2)", expectedError: "function 'testcube' expects 1 argument(s) but got 2 at position 0"},
+		{name: "impl error", input: "1 + testfail(1)", expectedError: "function 'testfail' at position 4: always fails"},
+	}
+	runRPNEvalTests(t, testCases)
+
+	env := NewEnvironment()
+	if err := env.Set("testcube", 1); err == nil {
+		t.Errorf("Expected assigning to a registered function to fail")
+	}
+
+	if help, found := HelpText("TESTCUBE"); !found || !strings.Contains(help, "Cubes x.") {
+		t.Errorf("Expected help for registered function, got %q (found=%v)", help, found)
+	}
+	if help, _ := HelpText("functions"); !strings.Contains(help, "Testing: testCube(x), testfail(x)") {
+		t.Errorf("Expected 'help functions' to list the Testing category, got:\n%s", help)
+	}
+	if help, _ := HelpText("constants"); !strings.Contains(help, "testc") {
+		t.Errorf("Expected 'help constants' to list testc, got:\n%s", help)
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	env := NewEnvironment()
+	if _, err := CalculateStatement("asinval = 1", env); err != nil {
+		t.Fatalf("Assignment failed unexpectedly: %v", err)
+	}
+	if _, err := CalculateStatement("asq(x) = x^2", env); err != nil {
+		t.Fatalf("Definition failed unexpectedly: %v", err)
+	}
+
+	testCases := []struct {
+		prefix   string
+		env      *Environment
+		expected []string
+	}{
+		{"as", nil, []string{"asin(", "asinh("}},
+		{"AS", env, []string{"asin(", "asinh(", "asinval", "asq("}},
+		{"p", nil, []string{"phase(", "pi", "polar(", "powmod(", "prod("}},
+		{"zzz", env, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			got := Completions(tc.prefix, tc.env)
+			if len(got) == 0 && len(tc.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Completions(%q): expected %v, got %v", tc.prefix, tc.expected, got)
+			}
+		})
+	}
+}
+
+// --- Engine ---
+
+func TestEngineSettingsAreIndependent(t *testing.T) {
+	fixed := NewEngine()
+	if err := fixed.SetFormat("fixed", 3); err != nil {
+		t.Fatalf("SetFormat failed unexpectedly: %v", err)
+	}
+	degrees := NewEngine()
+	if err := degrees.SetAngleMode(AngleDegrees); err != nil {
+		t.Fatalf("SetAngleMode failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"fixed pi", fixed, "pi", "3.142"},
+		{"fixed complex", fixed, "1/3 + 2i", "0.333 + 2.000i"},
+		{"fixed still radians", fixed, "sin(pi/2)", "1.000"},
+		{"degrees sin", degrees, "sin(30)", "0.5"},
+		{"degrees cos", degrees, "cos(180)", "-1"},
+		{"degrees atan2", degrees, "atan2(1, 1)", "45"},
+		{"degrees asin", degrees, "asin(1)", "90"},
+		{"degrees phase", degrees, "phase(i)", "90"},
+		{"degrees polar", degrees, "polar(2, 90)", "2i"},
+		{"degrees hyperbolic unaffected", degrees, "sinh(0)", "0"},
+		{"default unaffected", defaultEngine, "pi", "3.141592654"},
+	}
+	runEngineCases(t, testCases)
+	if result, _ := CalculateExpression("pi"); result != "3.141592654" {
+		t.Errorf("CalculateExpression must use the default settings, got %q", result)
+	}
+}
+
+func TestEngineSettingErrors(t *testing.T) {
+	engine := NewEngine()
+	checkError(t, "unknown format mode 'hex'", engine.SetFormat("hex", 2))
+	checkError(t, "precision must be an integer between 0 and 20, got -1", engine.SetFormat("fixed", -1))
+	checkError(t, "precision must be an integer between 0 and 20, got 21", engine.SetPrecision(21))
+	checkError(t, "unknown angle mode 'grad'", engine.SetAngleMode("grad"))
+	if settings := engine.Settings(); settings != DefaultSettings() {
+		t.Errorf("Failed settings changes must leave the engine unchanged, got %+v", settings)
+	}
+}
+
+func TestEngineVariablesAndFunctions(t *testing.T) {
+	engine := NewEngine()
+	other := NewEngine()
+	for _, statement := range []string{"x = 3+4i", "f(z) = z*conj(z)"} {
+		if _, err := engine.Evaluate(statement); err != nil {
+			t.Fatalf("Evaluate(%q) failed unexpectedly: %v", statement, err)
+		}
+	}
+	if result, err := engine.Evaluate("f(x)"); err != nil || result != "25" {
+		t.Errorf("Expected f(x) = 25, got %q (err: %v)", result, err)
+	}
+	if _, err := other.Evaluate("x"); err == nil {
+		t.Errorf("Variables of one engine must not be visible to another")
+	}
+	if _, err := engine.EvaluateExpression("y = 2"); err == nil {
+		t.Errorf("EvaluateExpression must reject assignments")
+	}
+
+	err := engine.RegisterFunction(FunctionDef{
+		Name: "twice", Arity: 1,
+		Impl: func(args []complex128) (complex128, error) { return 2 * args[0], nil },
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunction failed unexpectedly: %v", err)
+	}
+	if result, err := engine.Evaluate("twice(x)"); err != nil || result != "6 + 8i" {
+		t.Errorf("Expected twice(x) = 6 + 8i, got %q (err: %v)", result, err)
+	}
+	if _, err := engine.Evaluate("twice = 1"); err == nil {
+		t.Errorf("Expected assigning to an engine function to fail")
+	}
+	if _, err := other.Evaluate("twice(1)"); err == nil {
+		t.Errorf("Functions registered on one engine must not be visible to another")
+	}
+	if _, err := CalculateExpression("twice(1)"); err == nil {
+		t.Errorf("Functions registered on an engine must not be visible to the default engine")
+	}
+	if completions := engine.Completions("tw"); !reflect.DeepEqual(completions, []string{"twice("}) {
+		t.Errorf("Expected completions [twice(], got %v", completions)
+	}
+	if _, found := engine.HelpText("twice"); !found {
+		t.Errorf("Expected help for the engine function")
+	}
+}
+
+func TestEngineConcurrentUse(t *testing.T) {
+	engine := NewEngine()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				_ = engine.SetFormat("fixed", i)
+			} else {
+				_ = engine.SetAngleMode(AngleDegrees)
+			}
+			if _, err := engine.Evaluate(fmt.Sprintf("v%d = %d", i, i)); err != nil {
+				t.Errorf("Evaluate failed unexpectedly: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if names := engine.env.Names(); len(names) != 8 {
+		t.Errorf("Expected 8 variables, got %v", names)
+	}
+}
+
+// --- Limits and Cancellation ---
+
+func TestEngineLimits(t *testing.T) {
+	testCases := []struct {
+		name          string
+		limits        Limits
+		input         string
+		expectedLimit string
+		expectedError string
+	}{
+		{"input bytes", Limits{MaxInputBytes: 8}, "123456789", LimitInputBytes, "more than 8 input bytes at position 8"},
+		{"tokens", Limits{MaxTokens: 4}, "1 + 2 + 3", LimitTokens, "more than 4 tokens at position 8"},
+		{"paren depth", Limits{MaxParenDepth: 2}, "(([1]))", LimitParenDepth, "more than 2 parenthesis depth at position 2"},
+		{"paren depth in calls", Limits{MaxParenDepth: 1}, "sin(cos(1))", LimitParenDepth, "at position 7"},
+		{"rpn length", Limits{MaxRPNLength: 4}, "1 + 2 + 3", LimitRPNLength, "more than 4 RPN length at position 6"},
+		{"operations", Limits{MaxOperations: 4}, "1 + 2 + 3", LimitOperations, "more than 4 operations at position 6"},
+		{"operations in user functions", Limits{MaxOperations: 50}, "g(1)", LimitOperations, "more than 50 operations"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			engine := NewEngine()
+			for _, definition := range []string{"f(x) = x*x + x*x", "g(x) = f(x) + f(x) + f(x) + f(x) + f(x) + f(x)"} {
+				if _, err := engine.Evaluate(definition); err != nil {
+					t.Fatalf("Evaluate(%q) failed unexpectedly: %v", definition, err)
+				}
+			}
+			engine.SetLimits(tc.limits)
+			_, err := engine.Evaluate(tc.input)
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("Expected a *LimitError, got %v", err)
+			}
+			if limitErr.Limit != tc.expectedLimit {
+				t.Errorf("Expected limit %q, got %q", tc.expectedLimit, limitErr.Limit)
+			}
+			if !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("Expected errors.Is(err, ErrLimitExceeded)")
+			}
+			checkError(t, tc.expectedError, err)
+
+			engine.SetLimits(Limits{})
+			if _, err := engine.Evaluate(tc.input); err != nil {
+				t.Errorf("Expected %q to succeed without limits, got %v", tc.input, err)
+			}
+		})
+	}
+
+	if _, err := CalculateExpression(strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100)); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected CalculateExpression to apply the default limits, got %v", err)
+	}
+}
+
+func TestEvaluationCancellation(t *testing.T) {
+	engine := NewEngine()
+	engine.SetLimits(Limits{})
+	err := engine.RegisterFunction(FunctionDef{
+		Name: "spin", Arity: 1,
+		Budgeted: func(budget *Budget, args []complex128) (complex128, error) {
+			for {
+				if err := budget.Spend(1); err != nil {
+					return 0, err
+				}
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunction failed unexpectedly: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = engine.EvaluateContext(ctx, "1 + spin(1)")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to stop the iterative function, got %v", err)
+	}
+
+	engine.SetLimits(Limits{MaxOperations: 1000})
+	_, err = engine.Evaluate("1 + spin(1)")
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitOperations || limitErr.Position != 4 {
+		t.Errorf("Expected an operations limit error at position 4, got %v", err)
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, err := CalculateExpressionContext(cancelled, "2 + 3"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled context to stop evaluation, got %v", err)
+	}
+	both := FunctionDef{
+		Name: "both", Arity: 1,
+		Impl:     func([]complex128) (complex128, error) { return 0, nil },
+		Budgeted: func(*Budget, []complex128) (complex128, error) { return 0, nil },
+	}
+	if err := NewRegistry().RegisterFunction(both); err == nil {
+		t.Errorf("Expected registering both Impl and Budgeted to fail")
+	}
+}
+
+// --- Expression Tree ---
+
+func TestParseAST(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		expectedString string
+		expectedSpan   Span
+	}{
+		{"number", "3.25", "3.25", Span{0, 4}},
+		{"constant", " pi ", "pi", Span{1, 3}},
+		{"precedence", "1 + 2 * 3", "1 + 2 * 3", Span{0, 9}},
+		{"parentheses kept where needed", "(1 + 2) * 3", "(1 + 2) * 3", Span{1, 11}},
+		{"redundant parentheses dropped", "((2 * 3)) + 1", "2 * 3 + 1", Span{2, 13}},
+		{"left associative", "8 - (2 - 1)", "8 - (2 - 1)", Span{0, 10}},
+		{"right associative power", "(2^3)^2", "(2 ^ 3) ^ 2", Span{1, 7}},
+		{"unary minus", "-(1 + i)", "-(1 + i)", Span{0, 7}},
+		{"unary binds looser than power", "-2^2", "-2 ^ 2", Span{0, 4}},
+		{"call span includes parenthesis", "atan2(1, 2 )", "atan2(1, 2)", Span{0, 12}},
+		{"implied multiplication", "2(x + 1)", "2(x + 1)", Span{0, 7}},
+		{"implied multiplication of names", "2pi", "2 * pi", Span{0, 3}},
+		{"nested calls", "max(sin(1), 2)^2", "max(sin(1), 2) ^ 2", Span{0, 16}},
+	}
+	env := NewEnvironment()
+	_ = env.Set("x", 1)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, err := Lex(tc.input)
+			if err != nil {
+				t.Fatalf("Lexing failed unexpectedly: %v", err)
+			}
+			tree, err := ParseASTWithEnvironment(tokens, env)
+			if err != nil {
+				t.Fatalf("ParseAST failed unexpectedly: %v", err)
+			}
+			if tree.String() != tc.expectedString {
+				t.Errorf("String(): expected %q, got %q", tc.expectedString, tree.String())
+			}
+			if tree.Span() != tc.expectedSpan {
+				t.Errorf("Span(): expected %+v, got %+v", tc.expectedSpan, tree.Span())
+			}
+		})
+	}
+}
+
+func TestParseASTNodes(t *testing.T) {
+	tokens, err := Lex("2x - hypot(3, 4)")
+	if err != nil {
+		t.Fatalf("Lexing failed unexpectedly: %v", err)
+	}
+	env := NewEnvironment()
+	_ = env.Set("x", 1)
+	tree, err := ParseASTWithEnvironment(tokens, env)
+	if err != nil {
+		t.Fatalf("ParseAST failed unexpectedly: %v", err)
+	}
+
+	minus, ok := tree.(*BinaryNode)
+	if !ok || minus.Op.Type != MINUS || minus.Implicit {
+		t.Fatalf("Expected an explicit binary '-' at the root, got %#v", tree)
+	}
+	product, ok := minus.Left.(*BinaryNode)
+	if !ok || product.Op.Type != ASTERISK || !product.Implicit {
+		t.Fatalf("Expected an implicit '*' on the left, got %#v", minus.Left)
+	}
+	if number, ok := product.Left.(*NumberNode); !ok || number.Token.Literal != "2" {
+		t.Errorf("Expected the number 2, got %#v", product.Left)
+	}
+	if ident, ok := product.Right.(*IdentNode); !ok || ident.Span() != (Span{1, 2}) {
+		t.Errorf("Expected the identifier x at 1-2, got %#v", product.Right)
+	}
+	call, ok := minus.Right.(*CallNode)
+	if !ok || call.Name.Literal != "hypot" || len(call.Args) != 2 {
+		t.Fatalf("Expected a call to hypot with 2 arguments, got %#v", minus.Right)
+	}
+	if span := call.Args[1].Span(); span != (Span{14, 15}) {
+		t.Errorf("Expected the second argument at 14-15, got %+v", span)
+	}
+
+	result, err := EvaluateRPNWithEnvironment(ToRPN(tree), env)
+	if err != nil {
+		t.Fatalf("Evaluating the RPN derived from the tree failed: %v", err)
+	}
+	compareComplex(t, complex(-3, 0), result, "2x - hypot(3, 4)")
+
+	for _, input := range []string{"1 +", "(1 + 2", "sin()"} {
+		tokens, _ := Lex(input)
+		if _, err := ParseAST(tokens); err == nil {
+			t.Errorf("Expected ParseAST(%q) to fail", input)
+		}
+	}
+}
+
+func TestStructuredErrors(t *testing.T) {
+	testCases := []struct {
+		input    string
+		kind     ErrorKind
+		sentinel error
+		start    int
+		end      int
+		literal  string
+	}{
+		{"2 $ 3", KindLex, ErrLex, 2, 3, "$"},
+		{"1 + * 2", KindParse, ErrParse, 4, 5, "*"},
+		{"foo + 1", KindParse, ErrParse, 0, 3, "foo"},
+		{"1 + (2", KindParse, ErrParse, 4, 5, "("},
+		{"atan2(1)", KindArity, ErrArity, 0, 5, "atan2"},
+		{"5 % 0", KindDomain, ErrDomain, 2, 3, "%"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := CalculateExpression(tc.input)
+			var calcErr *CalculationError
+			if !errors.As(err, &calcErr) {
+				t.Fatalf("Expected a *CalculationError, got %T: %v", err, err)
+			}
+			if calcErr.Kind != tc.kind || calcErr.Start != tc.start || calcErr.End != tc.end || calcErr.Literal != tc.literal {
+				t.Errorf("Expected %s error at %d-%d on %q, got %s at %d-%d on %q",
+					tc.kind, tc.start, tc.end, tc.literal, calcErr.Kind, calcErr.Start, calcErr.End, calcErr.Literal)
+			}
+			if !calcErr.HasSpan() {
+				t.Errorf("Expected the error to have a span")
+			}
+			if !errors.Is(err, tc.sentinel) {
+				t.Errorf("Expected errors.Is(err, %v) to be true", tc.sentinel)
+			}
+			for _, other := range []error{ErrLex, ErrParse, ErrDomain, ErrArity, ErrLimitExceeded} {
+				if other != tc.sentinel && errors.Is(err, other) {
+					t.Errorf("Expected errors.Is(err, %v) to be false", other)
+				}
+			}
+		})
+	}
+
+	engine := NewEngine()
+	engine.SetLimits(Limits{MaxTokens: 3})
+	_, err := engine.Evaluate("1 + 2 + 3")
+	var calcErr *CalculationError
+	if !errors.As(err, &calcErr) || calcErr.Kind != KindLimit || calcErr.Start != 6 || calcErr.End != 7 {
+		t.Errorf("Expected a limit error at 6-7, got %#v (%v)", calcErr, err)
+	}
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected errors.Is(err, ErrLimitExceeded) to be true, got %v", err)
+	}
+
+	// Errors raised in the body of a user function point at the call in the input.
+	engine.SetLimits(DefaultLimits())
+	for _, definition := range []string{"h(x) = 1 + x % 0", "g(x) = g(x)", "k(x) = 2 * h(x)"} {
+		if _, err := engine.Evaluate(definition); err != nil {
+			t.Fatalf("Evaluate(%q) failed unexpectedly: %v", definition, err)
+		}
+	}
+	callCases := []struct {
+		input    string
+		kind     ErrorKind
+		start    int
+		contains string
+	}{
+		{"h(5) * 2", KindDomain, 0, "in function 'h' called at position 0"},
+		{"3 + g(1)", KindLimit, 4, "maximum call depth"},
+		{"1 - k(2)", KindDomain, 4, "in function 'k' called at position 4"},
+	}
+	for _, tc := range callCases {
+		_, err := engine.Evaluate(tc.input)
+		if !errors.As(err, &calcErr) || calcErr.Kind != tc.kind || calcErr.Start != tc.start || calcErr.End != tc.start+1 {
+			t.Errorf("Evaluate(%q): expected a %s error at %d-%d, got %#v (%v)", tc.input, tc.kind, tc.start, tc.start+1, calcErr, err)
+		}
+		checkError(t, tc.contains, err)
+	}
+
+	if !errors.As(NewCalculationError("plain"), &calcErr) || calcErr.HasSpan() || calcErr.Kind != "" {
+		t.Errorf("Expected NewCalculationError to carry no kind or span, got %#v", calcErr)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	env := NewEnvironment()
+	if _, err := CalculateStatement("radius = 2", env); err != nil {
+		t.Fatalf("Assignment failed unexpectedly: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		env      *Environment
+		expected []string
+	}{
+		{"sine", nil, []string{"sin", "sinh"}},
+		{"sqr", nil, []string{"sqrt"}},
+		{"SQR", nil, []string{"sqrt"}},
+		{"lgo", nil, []string{"log"}},
+		{"pii", nil, []string{"pi"}},
+		{"radus", env, []string{"radius"}},
+		{"x", nil, nil},
+		{"unknown", nil, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Suggestions(tc.name, tc.env)
+			if len(got) == 0 && len(tc.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Suggestions(%q): expected %v, got %v", tc.name, tc.expected, got)
+			}
+		})
+	}
+
+	if got := Suggestions("co", nil); len(got) != MaxSuggestions {
+		t.Errorf("Expected at most %d suggestions for 'co', got %v", MaxSuggestions, got)
+	}
+
+	_, err := CalculateExpression("sine(1)")
+	var calcErr *CalculationError
+	if !errors.As(err, &calcErr) || !reflect.DeepEqual(calcErr.Suggestions, []string{"sin", "sinh"}) {
+		t.Errorf("Expected the error for 'sine(1)' to suggest sin and sinh, got %#v", calcErr)
+	}
+	_, err = CalculateStatement("2 * radus", env)
+	if !errors.As(err, &calcErr) || !reflect.DeepEqual(calcErr.Suggestions, []string{"radius"}) {
+		t.Errorf("Expected the error for 'radus' to suggest radius, got %#v", calcErr)
+	}
+}
+
+func TestHighPrecisionMode(t *testing.T) {
+	engine := NewEngine()
+	if err := engine.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	wide := NewEngine()
+	if err := wide.SetPrecisionBits(256); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	if err := wide.SetFormat("fixed", 60); err != nil {
+		t.Fatalf("SetFormat failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"pi", engine, "pi", "3.141592653589793238462643383279502884"},
+		{"e", engine, "e", "2.718281828459045235360287471352662498"},
+		{"third", engine, "1/3", "0.3333333333333333333333333333333333333"},
+		{"sqrt", engine, "sqrt(2)", "1.414213562373095048801688724209698079"},
+		{"exact power", engine, "2^100", "1267650600228229401496703205376"},
+		{"large literal", engine, "12345678901234567890123", "12345678901234567890123"},
+		{"complex power", engine, "(1+i)^(1/3)", "1.084215081491351181879666008261083204 + 0.2905145555072514445038131886249290737i"},
+		{"sin pi", engine, "sin(pi)", "0"},
+		{"cos half pi", engine, "cos(pi/2)", "0"},
+		{"tiny literal", engine, "1e-100", "1e-100"},
+		{"tiny sine", engine, "sin(1e-100)", "1e-100"},
+		{"tiny exponential", engine, "exp(-1000)", "5.075958897549456765291809479574336919e-435"},
+		{"tiny imaginary part", engine, "1 + 1e-100i", "1"},
+		{"euler identity", engine, "exp(i*pi)", "-1"},
+		{"log", engine, "log(-1)", "3.141592653589793238462643383279502884i"},
+		{"asin branch cut", engine, "asin(2)", "1.570796326794896619231321691639751442 + 1.316957896924816708625046347307968444i"},
+		{"modulo", engine, "(5+3i) % (2+i)", "-1"},
+		{"odd root", engine, "root(-8, 3)", "-2"},
+		{"round", engine, "round(-2.5)", "-3"},
+		{"fixed digits", wide, "pi", "3.141592653589793238462643383279502884197169399375105820974945"},
+	}
+	runEngineCases(t, testCases)
+
+	// Variables and user function arguments keep the full precision.
+	for _, statement := range []string{"x = sqrt(2)", "f(z) = z^2"} {
+		if _, err := engine.Evaluate(statement); err != nil {
+			t.Fatalf("Evaluate(%q) failed unexpectedly: %v", statement, err)
+		}
+	}
+	for _, input := range []string{"x^2", "f(x)"} {
+		if result, err := engine.Evaluate(input); err != nil || result != "2" {
+			t.Errorf("Expected %s = 2, got %q (err: %v)", input, result, err)
+		}
+	}
+
+	// Results of huge magnitude are written without converting every digit.
+	huge := NewEngine()
+	if err := huge.SetPrecisionBits(256); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	hugeCases := []struct {
+		format    string
+		precision int
+		input     string
+		expected  string
+	}{
+		{"auto", 6, "exp(10^7)", "6.59223e+4342944"},
+		{"auto", 6, "exp(10^8)", "1.54998e+43429448"},
+		{"auto", 6, "-2^(10^9)", "-4.61298e+301029995"},
+		{"sci", 3, "2^(10^9) * (1 - i)", "4.613e+301029995 - 4.613e+301029995i"},
+		{"fixed", 2, "2^(10^9)", "4.61e+301029995"},
+		{"fixed", 2, "-2^(-10^9)", "-0.00"},
+	}
+	for _, tc := range hugeCases {
+		if tc.format == "auto" {
+			if err := huge.SetSignificantDigits(tc.precision); err != nil {
+				t.Fatalf("SetSignificantDigits failed unexpectedly: %v", err)
+			}
+		} else if err := huge.SetFormat(tc.format, tc.precision); err != nil {
+			t.Fatalf("SetFormat failed unexpectedly: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		result, err := huge.EvaluateExpressionContext(ctx, tc.input)
+		cancel()
+		if err != nil {
+			t.Errorf("EvaluateExpression(%q) failed unexpectedly: %v", tc.input, err)
+		} else if result != tc.expected {
+			t.Errorf("EvaluateExpression(%q): expected %q, got %q", tc.input, tc.expected, result)
+		}
+	}
+
+	_, err := engine.Evaluate("1/0")
+	checkError(t, "division by zero", err)
+	err = engine.RegisterFunction(FunctionDef{
+		Name: "twice", Arity: 1,
+		Impl: func(args []complex128) (complex128, error) { return 2 * args[0], nil },
+	})
+	if err != nil {
+		t.Fatalf("RegisterFunction failed unexpectedly: %v", err)
+	}
+	_, err = engine.Evaluate("twice(1)")
+	checkError(t, "not available in high-precision mode", err)
+
+	checkError(t, "precision bits must be 0 (off) or between 64 and 16384, got 8", engine.SetPrecisionBits(8))
+	if err := engine.SetPrecisionBits(0); err != nil {
+		t.Fatalf("SetPrecisionBits(0) failed unexpectedly: %v", err)
+	}
+	if result, _ := engine.Evaluate("pi"); result != "3.141592654" {
+		t.Errorf("Expected complex128 results after turning high-precision mode off, got %q", result)
+	}
+	if err := wide.SetPrecisionBits(0); err != nil || wide.Settings().Precision != MaxDisplayPrecision {
+		t.Errorf("Turning high-precision mode off must clamp the display precision, got %+v (err: %v)", wide.Settings(), err)
+	}
+}
+
+func TestExactMode(t *testing.T) {
+	engine := NewEngine()
+	engine.SetExact(true)
+
+	testCases := []engineTestCase{
+		{engine: engine, input: "1/3 + 1/6", expected: "1/2"},
+		{engine: engine, input: "0.1 + 0.2", expected: "3/10"},
+		{engine: engine, input: "(1+2i)/(3-4i)", expected: "-1/5 + 2/5 i"},
+		{engine: engine, input: "1 - i/2", expected: "1 - 1/2 i"},
+		{engine: engine, input: "-i/2", expected: "-1/2 i"},
+		{engine: engine, input: "(1+i)^10", expected: "32i"},
+		{engine: engine, input: "2^(-3)", expected: "1/8"},
+		{engine: engine, input: "(1/2 + i/3)^(-2)", expected: "180/169 - 432/169 i"},
+		{engine: engine, input: "(5+3i) % (2+i)", expected: "-1"},
+		{engine: engine, input: "(7/2) % 1", expected: "-1/2"},
+		{engine: engine, input: "sqrt(9/4)", expected: "3/2"},
+		{engine: engine, input: "sqrt(-4)", expected: "2i"},
+		{engine: engine, input: "abs(3+4i)", expected: "5"},
+		{engine: engine, input: "round(-5/2) + floor(-1/3)", expected: "-4"},
+		{engine: engine, input: "max(1/2, 1/3)", expected: "1/2"},
+		{engine: engine, input: "sqrt(2)", expected: "≈ 1.414213562"},
+		{engine: engine, input: "sin(1)", expected: "≈ 0.841470985"},
+		{engine: engine, input: "2*pi", expected: "≈ 6.283185307"},
+		{engine: engine, input: "2^0.5 * 0", expected: "≈ 0"},
+	}
+	runEngineCases(t, testCases)
+
+	if _, err := engine.Evaluate("x = 1/3"); err != nil {
+		t.Fatalf("Assignment failed unexpectedly: %v", err)
+	}
+	if result, err := engine.Evaluate("3x"); err != nil || result != "1" {
+		t.Errorf("Variables must stay exact, expected 3x = 1, got %q (err: %v)", result, err)
+	}
+	_, err := engine.Evaluate("1/0")
+	checkError(t, "division by zero for operator '/' at position 1", err)
+	_, err = engine.Evaluate("0^(-1)")
+	checkError(t, "division by zero for operator '^'", err)
+
+	if err := engine.SetPrecisionBits(128); err != nil || engine.Settings().Exact {
+		t.Errorf("High-precision mode must turn exact mode off, got %+v (err: %v)", engine.Settings(), err)
+	}
+	engine.SetExact(true)
+	if settings := engine.Settings(); settings.Bits != 0 {
+		t.Errorf("Exact mode must turn high-precision mode off, got %+v", settings)
+	}
+}
+
+func TestFractionFormat(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Format = "frac"
+	coarse := settings
+	coarse.MaxDenominator = 1000
+
+	testCases := []struct {
+		name     string
+		settings Settings
+		input    complex128
+		expected string
+	}{
+		{"third", settings, complex(0.333333333, 0), "1/3"},
+		{"complex", settings, complex(0.5, 0.25), "1/2 + 1/4 i"},
+		{"negative parts", settings, complex(-2.0/7, -1.0/9), "-2/7 - 1/9 i"},
+		{"pure imaginary", settings, complex(0, -1.0/3), "-1/3 i"},
+		{"integers", settings, complex(3, 4), "3 + 4i"},
+		{"large integer", settings, complex(1e20, 0), "100000000000000000000"},
+		{"rounding noise", settings, complex(0.1+0.2, 1e-17), "3/10"},
+		{"pi", settings, complex(math.Pi, 0), "104348/33215"},
+		{"pi with small denominators", coarse, complex(math.Pi, 0), ApproximateMarker + "3.141592654"},
+		{"not a number", settings, complex(math.NaN(), 0), "NaN"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatComplex(tc.input, tc.settings); got != tc.expected {
+				t.Errorf("formatComplex(%v): expected %q, got %q", tc.input, tc.expected, got)
+			}
+		})
+	}
+
+	engine := NewEngine()
+	if err := engine.SetFormat("frac", 3); err != nil {
+		t.Fatalf("SetFormat failed unexpectedly: %v", err)
+	}
+	if result, err := engine.Evaluate("pi"); err != nil || result != "333/106" {
+		t.Errorf("Expected pi = 333/106 within 3 decimals, got %q (err: %v)", result, err)
+	}
+	checkError(t, "maximum denominator must be a positive integer, got 0", engine.SetMaxDenominator(0))
+}
+
+func TestPhasors(t *testing.T) {
+	tokens, err := Lex("10∠30° angle")
+	if err != nil {
+		t.Fatalf("Lex failed unexpectedly: %v", err)
+	}
+	expectedTokens := []Token{
+		{Type: NUMBER, Literal: "10", Position: 0},
+		{Type: ANGLE, Literal: "∠", Position: 2},
+		{Type: NUMBER, Literal: "30", Position: 5},
+		{Type: DEGREE, Literal: "°", Position: 7},
+		{Type: ANGLE, Literal: "angle", Position: 10},
+		{Type: EOF, Literal: "", Position: 15},
+	}
+	if !reflect.DeepEqual(tokens, expectedTokens) {
+		t.Errorf("Lex: expected %v, got %v", expectedTokens, tokens)
+	}
+
+	tokens, _ = Lex("10∠-30° * 2")
+	tree, err := ParseAST(tokens)
+	if err != nil {
+		t.Fatalf("ParseAST failed unexpectedly: %v", err)
+	}
+	if got := tree.String(); got != "10 ∠ -30° * 2" {
+		t.Errorf("Expected the tree (10 ∠ -(30°)) * 2 to print as %q, got %q", "10 ∠ -30° * 2", got)
+	}
+	if span := tree.(*BinaryNode).Left.(*BinaryNode).Right.Span(); span != (Span{Start: 5, End: 10}) {
+		t.Errorf("Expected the span of -30° to be {5 10}, got %v", span)
+	}
+
+	degrees := NewEngine()
+	if err := degrees.SetAngleMode(AngleDegrees); err != nil {
+		t.Fatalf("SetAngleMode failed unexpectedly: %v", err)
+	}
+	phasors := NewEngine()
+	if err := phasors.SetFormat("polar", 9); err != nil {
+		t.Fatalf("SetFormat failed unexpectedly: %v", err)
+	}
+	if err := phasors.SetPolarUnit(AngleDegrees); err != nil {
+		t.Fatalf("SetPolarUnit failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"phasor", defaultEngine, "10∠30°", "8.660254038 + 5i"},
+		{"spelled out", degrees, "10 angle 90", "10i"},
+		{"radians", defaultEngine, "2∠pi", "-2"},
+		{"degree sign in degree mode", degrees, "sin(30°)", "0.5"},
+		{"degree sign in radian mode", defaultEngine, "sin(30°)", "0.5"},
+		{"binds tighter than *", defaultEngine, "10∠90° * 2", "20i"},
+		{"quotient", phasors, "10∠30° / 5∠10°", "2 ∠ 20°"},
+		{"sum", phasors, "10∠0° + 10∠90°", "14.142135624 ∠ 45°"},
+		{"negative angle", phasors, "3-3i", "4.242640687 ∠ -45°"},
+		{"zero", phasors, "0∠30°", "0"},
+		{"same as exp", defaultEngine, "abs(10∠30° - 10*exp(i*pi/6))", "0"},
+	}
+	runEngineCases(t, testCases)
+
+	if err := phasors.SetPolarUnit(""); err != nil {
+		t.Fatalf("SetPolarUnit failed unexpectedly: %v", err)
+	}
+	if result, _ := phasors.Evaluate("10i"); result != "10 ∠ 1.570796327" {
+		t.Errorf("Expected polar angles to follow the angle mode, got %q", result)
+	}
+	checkError(t, "unknown angle unit 'grad'", phasors.SetPolarUnit("grad"))
+	_, err = CalculateExpression("° 30")
+	checkError(t, "unexpected '°' at position 0", err)
+}
+
+func TestEngineeringFormats(t *testing.T) {
+	tokens, err := Lex("4.7k*2min(1,2)")
+	if err != nil {
+		t.Fatalf("Lex failed unexpectedly: %v", err)
+	}
+	expectedTokens := []Token{
+		{Type: NUMBER, Literal: "4.7k", Position: 0},
+		{Type: ASTERISK, Literal: "*", Position: 4},
+		{Type: NUMBER, Literal: "2", Position: 5},
+		{Type: IDENT, Literal: "min", Position: 6},
+		{Type: LPAREN, Literal: "(", Position: 9},
+		{Type: NUMBER, Literal: "1", Position: 10},
+		{Type: COMMA, Literal: ",", Position: 11},
+		{Type: NUMBER, Literal: "2", Position: 12},
+		{Type: RPAREN, Literal: ")", Position: 13},
+		{Type: EOF, Literal: "", Position: 14},
+	}
+	if !reflect.DeepEqual(tokens, expectedTokens) {
+		t.Errorf("Lex: expected %v, got %v", expectedTokens, tokens)
+	}
+	if tokens, _ := Lex("2f(x)"); len(tokens) < 2 || tokens[1] != (Token{Type: IDENT, Literal: "f", Position: 1}) {
+		t.Errorf("Lex: expected the prefix-like name in 2f(x) to be a call, got %v", tokens)
+	}
+
+	// Variables and parameters named like a prefix are multiplied, not read as prefixes.
+	env := NewEnvironment()
+	script := "2n\nn = 5\n2n\nm = 3\n2m\nf(n) = 2n\nf(5)\ng(k) = 3k\ng(2)"
+	results, err := CalculateScript(script, env)
+	if err != nil {
+		t.Fatalf("CalculateScript failed unexpectedly: %v", err)
+	}
+	expected := []string{"2e-09", "5", "10", "3", "6", "f(n) = 2n", "10", "g(k) = 3k", "6"}
+	if strings.Join(results, "|") != strings.Join(expected, "|") {
+		t.Errorf("CalculateScript: expected %q, got %q", expected, results)
+	}
+
+	eng := NewEngine()
+	if err := eng.SetFormat("eng", 9); err != nil {
+		t.Fatalf("SetFormat failed unexpectedly: %v", err)
+	}
+	si := NewEngine()
+	if err := si.SetFormat("si", 3); err != nil {
+		t.Fatalf("SetFormat failed unexpectedly: %v", err)
+	}
+	precise := NewEngine()
+	if err := precise.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"literal", defaultEngine, "4.7k + 100n", "4700.0000001"},
+		{"micro", defaultEngine, "2.2u + 2.2µ", "4.4e-06"},
+		{"eng", eng, "4700", "4.7e3"},
+		{"eng small", eng, "-0.0047", "-4.7e-3"},
+		{"eng complex", eng, "4.7k + 2.2k*i", "4.7e3 + 2.2e3i"},
+		{"si", si, "4.7k + 2.2k*i", "4.7k + 2.2k i"},
+		{"si imaginary", si, "100n*i", "100n i"},
+		{"si rounding carry", si, "999.9999", "1k"},
+		{"si fraction", si, "1/3", "333.333m"},
+		{"si unit range", si, "12.5", "12.5"},
+		{"si beyond prefixes", si, "1e18", "1e18"},
+		{"high precision literal", precise, "4.7k", "4700"},
+	}
+	runEngineCases(t, testCases)
+}
+
+func TestSignificantDigits(t *testing.T) {
+	sig := NewEngine()
+	if err := sig.SetSignificantDigits(6); err != nil {
+		t.Fatalf("SetSignificantDigits failed unexpectedly: %v", err)
+	}
+	precise := NewEngine()
+	if err := precise.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	if err := precise.SetSignificantDigits(30); err != nil {
+		t.Fatalf("SetSignificantDigits failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"decimal places lose tiny values", defaultEngine, "1.23e-12", "0"},
+		{"tiny value", sig, "1.23e-12", "1.23e-12"},
+		{"tiny imaginary", sig, "1.23e-12*i", "1.23e-12i"},
+		{"parts rounded separately", sig, "1e-20 + 1e-12*i", "1e-20 + 1e-12i"},
+		{"negligible part", sig, "exp(i*pi)", "-1"},
+		{"rounded", sig, "pi", "3.14159"},
+		{"integer", sig, "100", "100"},
+		{"large", sig, "1234567", "1.23457e+06"},
+		{"rounds to one", sig, "3 + 0.9999999i", "3 + i"},
+		{"zero", sig, "0", "0"},
+		{"high precision", precise, "1/3", "0.333333333333333333333333333333"},
+	}
+	runEngineCases(t, testCases)
+
+	if err := sig.SetSignificantDigits(0); err == nil {
+		t.Error("Expected an error for 0 significant digits")
+	}
+	if err := sig.SetPrecision(9); err != nil || sig.Settings().Significant {
+		t.Errorf("Expected SetPrecision to return to decimal places, got %+v (error %v)", sig.Settings(), err)
+	}
+}
+
+func TestLocales(t *testing.T) {
+	tokens, err := lexLocale("max(1,5; 2, x)", LocaleSpanish, nil)
+	if err != nil {
+		t.Fatalf("lexLocale failed unexpectedly: %v", err)
+	}
+	expectedTokens := []Token{
+		{Type: IDENT, Literal: "max", Position: 0},
+		{Type: LPAREN, Literal: "(", Position: 3},
+		{Type: NUMBER, Literal: "1,5", Position: 4},
+		{Type: COMMA, Literal: ";", Position: 7},
+		{Type: NUMBER, Literal: "2", Position: 9},
+		{Type: COMMA, Literal: ",", Position: 10},
+		{Type: IDENT, Literal: "x", Position: 12},
+		{Type: RPAREN, Literal: ")", Position: 13},
+		{Type: EOF, Literal: "", Position: 14},
+	}
+	if !reflect.DeepEqual(tokens, expectedTokens) {
+		t.Errorf("lexLocale: expected %v, got %v", expectedTokens, tokens)
+	}
+	if _, err := Lex("max(1; 2)"); !errors.Is(err, ErrLex) {
+		t.Errorf("Expected ';' to be illegal without a locale, got %v", err)
+	}
+
+	engines := map[Locale]*Engine{}
+	for _, locale := range []Locale{LocaleEnglish, LocaleSpanish, LocaleGerman} {
+		engines[locale] = NewEngine()
+		if err := engines[locale].SetLocale(locale); err != nil {
+			t.Fatalf("SetLocale(%q) failed unexpectedly: %v", locale, err)
+		}
+	}
+
+	testCases := []engineTestCase{
+		{"english grouping", engines[LocaleEnglish], "1234567 + 0.5i", "1,234,567 + 0.5i"},
+		{"english small", engines[LocaleEnglish], "1234", "1,234"},
+		{"english complex", engines[LocaleEnglish], "1/4 + 2000i", "0.25 + 2,000i"},
+		{"spanish decimal comma", engines[LocaleSpanish], "3,5 * 2", "7"},
+		{"spanish decimal point", engines[LocaleSpanish], "3.5 / 2", "1,75"},
+		{"spanish point before three digits", engines[LocaleSpanish], "1.234 * 2", "2,468"},
+		{"spanish arguments", engines[LocaleSpanish], "max(1,5; 2) * 10000", "20.000"},
+		{"spanish four digits", engines[LocaleSpanish], "1234,5", "1234,5"},
+		{"spanish SI literal", engines[LocaleSpanish], "4,7k", "4700"},
+		{"german grouping", engines[LocaleGerman], "1234,5", "1.234,5"},
+		{"german exponent", engines[LocaleGerman], "1,5e-5", "1,5e-05"},
+		{"german decimal point", engines[LocaleGerman], "1.500 + 1", "2,5"},
+		{"german comma and space", engines[LocaleGerman], "root(8, 3)", "2"},
+	}
+	runEngineCases(t, testCases)
+
+	if err := NewEngine().SetLocale("fr"); err == nil {
+		t.Error("Expected an error for an unknown locale")
+	}
+}
+
+func TestBases(t *testing.T) {
+	tokens, err := Lex("0x1.8p 0b 0o17i")
+	if err != nil {
+		t.Fatalf("Lex failed unexpectedly: %v", err)
+	}
+	expectedTokens := []Token{
+		{Type: NUMBER, Literal: "0x1.8", Position: 0},
+		{Type: IDENT, Literal: "p", Position: 5},
+		{Type: NUMBER, Literal: "0", Position: 7},
+		{Type: IDENT, Literal: "b", Position: 8},
+		{Type: NUMBER, Literal: "0o17", Position: 10},
+		{Type: IDENT, Literal: "i", Position: 14},
+		{Type: EOF, Literal: "", Position: 15},
+	}
+	if !reflect.DeepEqual(tokens, expectedTokens) {
+		t.Errorf("Lex: expected %v, got %v", expectedTokens, tokens)
+	}
+
+	hex := NewEngine()
+	if err := hex.SetBase(16); err != nil {
+		t.Fatalf("SetBase failed unexpectedly: %v", err)
+	}
+	binary := NewEngine()
+	if err := binary.SetBase(2); err != nil {
+		t.Fatalf("SetBase failed unexpectedly: %v", err)
+	}
+	exactHex := NewEngine()
+	exactHex.SetExact(true)
+	if err := exactHex.SetBase(16); err != nil {
+		t.Fatalf("SetBase failed unexpectedly: %v", err)
+	}
+	preciseOctal := NewEngine()
+	if err := preciseOctal.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	if err := preciseOctal.SetBase(8); err != nil {
+		t.Fatalf("SetBase failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"hex literal", defaultEngine, "0x1F", "31"},
+		{"octal and binary literals", defaultEngine, "0o17 + 0b1010", "25"},
+		{"hex fraction", defaultEngine, "0x1.8", "1.5"},
+		{"upper-case prefix", defaultEngine, "0XfF", "255"},
+		{"hex output", hex, "0xff - 16i", "0xFF - 0x10i"},
+		{"hex imaginary ending in a letter", hex, "0x1F*i", "0x1F i"},
+		{"negative", hex, "-255", "-0xFF"},
+		{"unit imaginary", hex, "1 - i", "0x1 - i"},
+		{"rounded to an integer", hex, "0.1*10", "0x1"},
+		{"beyond 64 bits", hex, "2^64", "0x10000000000000000"},
+		{"above 2^53", hex, "1e20", "0x56BC75E2D63100000"},
+		{"negative above 2^53", hex, "-2^60 - 2^55", "-0x1080000000000000"},
+		{"fallback", hex, "1/4 + 2i", "0.25 + 2i"},
+		{"binary output", binary, "0x1F + 0xA*i", "0b11111 + 0b1010i"},
+		{"exact mode", exactHex, "2^70 + 0x1.8*2", "0x400000000000000003"},
+		{"exact fallback", exactHex, "1/3", "1/3"},
+		{"high precision", preciseOctal, "8^30 - 1", "0o777777777777777777777777777777"},
+	}
+	runEngineCases(t, testCases)
+
+	if _, err := CalculateExpression("0b102"); err == nil {
+		t.Error("Expected an error for the binary literal 0b102")
+	}
+	if err := hex.SetBase(3); err == nil {
+		t.Error("Expected an error for base 3")
+	}
+}
+
+func TestProgrammerMode(t *testing.T) {
+	tokens, err := Lex("a<<2//b>>~1 xor 3|4&5")
+	if err != nil {
+		t.Fatalf("Lex failed unexpectedly: %v", err)
+	}
+	var types []TokenType
+	for _, token := range tokens {
+		types = append(types, token.Type)
+	}
+	expectedTypes := []TokenType{IDENT, SHIFT_LEFT, NUMBER, SLASH_SLASH, IDENT, SHIFT_RIGHT, TILDE, NUMBER, XOR, NUMBER, PIPE, NUMBER, AMPERSAND, NUMBER, EOF}
+	if !reflect.DeepEqual(types, expectedTypes) {
+		t.Errorf("Lex: expected %v, got %v", expectedTypes, types)
+	}
+
+	signed8 := NewEngine()
+	if err := signed8.SetWord(8, false); err != nil {
+		t.Fatalf("SetWord failed unexpectedly: %v", err)
+	}
+	unsigned8 := NewEngine()
+	if err := unsigned8.SetWord(8, true); err != nil {
+		t.Fatalf("SetWord failed unexpectedly: %v", err)
+	}
+	hex16 := NewEngine()
+	if err := hex16.SetWord(16, false); err != nil {
+		t.Fatalf("SetWord failed unexpectedly: %v", err)
+	}
+	if err := hex16.SetBase(16); err != nil {
+		t.Fatalf("SetBase failed unexpectedly: %v", err)
+	}
+	unsigned64 := NewEngine()
+	if err := unsigned64.SetWord(64, true); err != nil {
+		t.Fatalf("SetWord failed unexpectedly: %v", err)
+	}
+	exact := NewEngine()
+	exact.SetExact(true)
+	precise := NewEngine()
+	if err := precise.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"and", defaultEngine, "12 & 10", "8"},
+		{"or", defaultEngine, "12 | 10", "14"},
+		{"xor", defaultEngine, "12 xor 10", "6"},
+		{"not", defaultEngine, "~5", "-6"},
+		{"shift left", defaultEngine, "1 << 10", "1024"},
+		{"arithmetic shift right", defaultEngine, "-8 >> 1", "-4"},
+		{"negative and mask", defaultEngine, "-1 & 0xFF", "255"},
+		{"floor division", defaultEngine, "-7 // 2", "-4"},
+		{"complex floor division", defaultEngine, "(7+5i) // 2", "3 + 2i"},
+		{"bitwise below arithmetic", defaultEngine, "1 + 2 << 3", "24"},
+		{"and above or", defaultEngine, "1 | 2 & 3", "3"},
+		{"exact mode", exact, "(1 << 100) | 1", "1267650600228229401496703205377"},
+		{"exact floor division", exact, "(7/2) // (1/3)", "10"},
+		{"high precision", precise, "~(1 << 70)", "-1180591620717411303425"},
+		{"signed wrap-around", signed8, "127 + 1", "-128"},
+		{"signed literal", signed8, "255", "-1"},
+		{"truncating division", signed8, "-7 / 2", "-3"},
+		{"C remainder", signed8, "-7 % 2", "-1"},
+		{"power", signed8, "3^5", "-13"},
+		{"shift out", signed8, "1 << 8", "0"},
+		{"sign-preserving shift", signed8, "-128 >> 3", "-16"},
+		{"function", signed8, "max(abs(-5), sqrt(16))", "5"},
+		{"unsigned wrap-around", unsigned8, "0 - 1", "255"},
+		{"unsigned not", unsigned8, "~5", "250"},
+		{"unsigned shift", unsigned8, "0xF0 >> 4", "15"},
+		{"word bits in hex", hex16, "-1", "0xFFFF"},
+		{"word bits in hex, positive", hex16, "0x7FFF + 1", "0x8000"},
+		{"64 bits", unsigned64, "(1 << 64) - 1", "18446744073709551615"},
+	}
+	runEngineCases(t, testCases)
+
+	errorCases := []engineTestCase{
+		{input: "1.5 & 1", expected: "needs integers, got 1.5"},
+		{input: "(1+i) | 2", expected: "needs real integers, got the complex value 1 + i"},
+		{input: "~0.5", expected: "operator '~' at position 0 needs integers"},
+		{input: "1 << -1", expected: "shift count must be between 0"},
+		{input: "7 // 0", expected: "division by zero"},
+		{input: "5 ~ 3", expected: "it must precede a value"},
+		{engine: exact, input: "1/2 xor 1", expected: "needs integers, got 0.5"},
+		{engine: signed8, input: "1.5", expected: "is not an integer"},
+		{engine: signed8, input: "pi", expected: "constant 'pi' at position 0"},
+		{engine: signed8, input: "sqrt(2)", expected: "function 'sqrt' at position 0"},
+		{engine: signed8, input: "30°", expected: "angles are not available in programmer mode"},
+		{engine: signed8, input: "1 / 0", expected: "division by zero"},
+		{engine: signed8, input: "2^(0-1)", expected: "negative exponent"},
+	}
+	runEngineErrorCases(t, errorCases)
+
+	if err := signed8.SetWord(12, false); err == nil {
+		t.Error("Expected an error for a 12-bit word")
+	}
+	signed8.SetExact(true)
+	if settings := signed8.Settings(); settings.Word != 0 || !settings.Exact {
+		t.Errorf("SetExact(true) should turn programmer mode off, got %+v", settings)
+	}
+}
+
+func TestFactorials(t *testing.T) {
+	tokens, err := Lex("5!!+3!")
+	if err != nil {
+		t.Fatalf("Lex failed unexpectedly: %v", err)
+	}
+	expectedTokens := []Token{
+		{Type: NUMBER, Literal: "5", Position: 0},
+		{Type: DOUBLE_FACTORIAL, Literal: "!!", Position: 1},
+		{Type: PLUS, Literal: "+", Position: 3},
+		{Type: NUMBER, Literal: "3", Position: 4},
+		{Type: FACTORIAL, Literal: "!", Position: 5},
+		{Type: EOF, Literal: "", Position: 6},
+	}
+	if !reflect.DeepEqual(tokens, expectedTokens) {
+		t.Errorf("Lex: expected %v, got %v", expectedTokens, tokens)
+	}
+	tokens, _ = Lex("(3!)! + -3! + 2^3!")
+	tree, err := NewParser(tokens).ParseTree()
+	if err != nil {
+		t.Fatalf("ParseTree failed unexpectedly: %v", err)
+	}
+	if got, want := tree.String(), "3! ! + -3! + 2 ^ 3!"; got != want {
+		t.Errorf("String: expected %q, got %q", want, got)
+	}
+
+	exact := NewEngine()
+	exact.SetExact(true)
+	precise := NewEngine()
+	if err := precise.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	word := NewEngine()
+	if err := word.SetWord(8, true); err != nil {
+		t.Fatalf("SetWord failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"factorial", defaultEngine, "5!", "120"},
+		{"zero", defaultEngine, "0!", "1"},
+		{"double factorial, odd", defaultEngine, "5!!", "15"},
+		{"double factorial, even", defaultEngine, "6!!", "48"},
+		{"binds above power", defaultEngine, "2^3!", "64"},
+		{"binds above unary minus", defaultEngine, "-3!", "-6"},
+		{"factorial of a factorial", defaultEngine, "(3!)!", "720"},
+		{"exact in float", defaultEngine, "20!", "2432902008176640000"},
+		{"half", defaultEngine, "0.5! - sqrt(pi)/2", "0"},
+		{"complex", defaultEngine, "i!", "0.498015668 - 0.154949828i"},
+		{"negative odd double factorial", defaultEngine, "(-5)!!", "0.333333333"},
+		{"non-integer double factorial", defaultEngine, "1.5!!", "1.380662682"},
+		{"overflow", defaultEngine, "200!", "(+Inf+0i)"},
+		{"degrees", defaultEngine, "sin(30°)", "0.5"},
+		{"exact", exact, "25!", "15511210043330985984000000"},
+		{"exact negative odd double factorial", exact, "(-5)!!", "1/3"},
+		{"exact fallback", exact, "0.5!", ApproximateMarker + "0.886226925"},
+		{"high precision", precise, "30! / 29!", "30"},
+		{"word", word, "5!", "120"},
+		{"word wrap-around", word, "6!", "208"},
+		{"word zero", word, "100!", "0"},
+	}
+	runEngineCases(t, testCases)
+
+	errorCases := []engineTestCase{
+		{input: "(-3)!", expected: "undefined at the negative integer -3 for operator '!' at position 4"},
+		{input: "(-2)!!", expected: "undefined at the negative integer -2"},
+		{input: "!5", expected: "it must follow a value"},
+		{engine: precise, input: "0.5!", expected: "not available in high-precision mode"},
+		{engine: precise, input: "1e7!", expected: "limit exceeded"},
+	}
+	runEngineErrorCases(t, errorCases)
+}
+
+func TestGammaFunctions(t *testing.T) {
+	exact := NewEngine()
+	exact.SetExact(true)
+
+	// Reference values from Abramowitz & Stegun and mpmath, to the default 9 decimals.
+	testCases := []engineTestCase{
+		{input: "gamma(5)", expected: "24"},
+		{input: "gamma(0.5)", expected: "1.772453851"},
+		{input: "gamma(-0.5)", expected: "-3.544907702"},
+		{input: "gamma(1+i)", expected: "0.498015668 - 0.154949828i"},
+		{input: "gamma(-1.5+2i) * gamma(2.5-2i) * sin(pi*(-1.5+2i)) / pi", expected: "1"},
+		{input: "lgamma(100)", expected: "359.13420537"},
+		{input: "lgamma(-0.5)", expected: "1.265512123 - 3.141592654i"},
+		{input: "lgamma(1+i)", expected: "-0.650923199 - 0.30164032i"},
+		{input: "lgamma(1-i)", expected: "-0.650923199 + 0.30164032i"},
+		{input: "lgamma(-30.5+2i) - lgamma(-29.5+2i) + log(-30.5+2i)", expected: "0"},
+		{input: "exp(lgamma(3+4i)) / gamma(3+4i)", expected: "1"},
+		{input: "digamma(1)", expected: "-0.577215665"},
+		{input: "digamma(0.5)", expected: "-1.963510026"},
+		{input: "digamma(i)", expected: "0.094650321 + 2.076674047i"},
+		{input: "digamma(-3.5+0.5i) - digamma(-2.5+0.5i) + 1/(-3.5+0.5i)", expected: "0"},
+		{input: "beta(2, 3)", expected: "0.083333333"},
+		{input: "beta(0.5, 0.5)", expected: "3.141592654"},
+		{input: "beta(1+i, 2)", expected: "0.1 - 0.3i"},
+		{input: "beta(0.5, -1.5)", expected: "0"},
+		{engine: exact, input: "gamma(21)", expected: "2432902008176640000"},
+		{engine: exact, input: "beta(2, 3)", expected: "1/12"},
+		{engine: exact, input: "gamma(0.5)", expected: ApproximateMarker + "1.772453851"},
+	}
+	runEngineCases(t, testCases)
+
+	for _, input := range []string{"gamma(0)", "lgamma(-2)", "digamma(-1)", "beta(-1, 2)"} {
+		_, err := CalculateExpression(input)
+		if err == nil || !strings.Contains(err.Error(), "undefined at the pole") {
+			t.Errorf("CalculateExpression(%q): expected a pole error, got %v", input, err)
+		}
+	}
+}
+
+func TestSpecialFunctions(t *testing.T) {
+	// Reference values from Abramowitz & Stegun and mpmath, to the default 9 decimals, and
+	// identities that hold across the complex plane.
+	testCases := []engineTestCase{
+		{input: "erf(1)", expected: "0.842700793"},
+		{input: "erf(1+i)", expected: "1.316151282 + 0.190453469i"},
+		{input: "erf(-1-i)", expected: "-1.316151282 - 0.190453469i"},
+		{input: "erf(3+2i) + erfc(3+2i)", expected: "1"},
+		{input: "erf(3-2i) - conj(erf(3+2i))", expected: "0"},
+		{input: "erfc(1)", expected: "0.157299207"},
+		{input: "erfc(-1)", expected: "1.842700793"},
+		{input: "erfc(0.5+0.5i) + erfc(-0.5-0.5i)", expected: "2"},
+		{input: "erfi(1)", expected: "1.650425759"},
+		{input: "erfi(i)", expected: "0.842700793i"},
+		{input: "erfi(1+i) + i*erf(i*(1+i))", expected: "0"},
+		{input: "erfi(6.1) / 1.355935690682231e15", expected: "1"},
+		{input: "erfi(26) / 8.314637164730988e291", expected: "1"},
+		{input: "erf(1e6i)", expected: "(0+Infi)"},
+		{input: "erf(-30i)", expected: "(0-Infi)"},
+		{input: "erfc(1e6i)", expected: "(1-Infi)"},
+		{input: "erfi(-1e6)", expected: "(-Inf+0i)"},
+		{input: "erfc(1e6+i)", expected: "0"},
+		{input: "lambertw(1, 0)", expected: "0.56714329"},
+		{input: "lambertw(1)", expected: "0.56714329"},
+		{input: "lambertw(i)", expected: "0.374699021 + 0.576412723i"},
+		{input: "lambertw(-1/e, 0)", expected: "-1"},
+		{input: "lambertw(-0.1, 0)", expected: "-0.111832559"},
+		{input: "lambertw(-0.1, -1)", expected: "-3.577152064"},
+		{input: "lambertw(1, 1)", expected: "-1.53391332 + 4.375185153i"},
+		{input: "lambertw(i, 0)", expected: "0.374699021 + 0.576412723i"},
+		{input: "lambertw(-1, -1)", expected: "-0.318131505 - 1.337235701i"},
+		{input: "lambertw(2+3i, -2) * exp(lambertw(2+3i, -2))", expected: "2 + 3i"},
+		{input: "lambertw(0, 0)", expected: "0"},
+		{input: "zeta(2)", expected: "1.644934067"},
+		{input: "zeta(3)", expected: "1.202056903"},
+		{input: "zeta(0.5)", expected: "-1.460354509"},
+		{input: "zeta(0)", expected: "-0.5"},
+		{input: "zeta(-1)", expected: "-0.083333333"},
+		{input: "zeta(-2)", expected: "0"},
+		{input: "zeta(i)", expected: "0.003300224 - 0.418155449i"},
+		{input: "zeta(-i)", expected: "0.003300224 + 0.418155449i"},
+		{input: "zeta(0.5+14.134725141734693i)", expected: "0"},
+		{input: "zeta(100)", expected: "1"},
+	}
+	runEngineCases(t, testCases)
+
+	errorCases := []engineTestCase{
+		{input: "zeta(1)", expected: "undefined at the pole 1"},
+		{input: "lambertw(1, 0.5)", expected: "branch index must be an integer"},
+		{input: "lambertw(1, 0, 2)", expected: "expects 1 to 2 arguments"},
+	}
+	runEngineErrorCases(t, errorCases)
+}
+
+func TestBesselFunctions(t *testing.T) {
+	// Reference values from Abramowitz & Stegun, and the closed forms of the half-integer
+	// orders, e.g. J_1/2(z) = sqrt(2/(pi z)) sin(z), which hold for complex z.
+	testCases := []engineTestCase{
+		{input: "besselj(0, 1)", expected: "0.765197687"},
+		{input: "besselj(1, 1)", expected: "0.440050586"},
+		{input: "besselj(0, 10)", expected: "-0.245935764"},
+		{input: "besselj(0, 0)", expected: "1"},
+		{input: "besselj(2, 0)", expected: "0"},
+		{input: "besselj(3, -2)", expected: "-0.128943249"},
+		{input: "besselj(-3, 2)", expected: "-0.128943249"},
+		{input: "besselj(1, 2+i)", expected: "0.790623393 - 0.079932694i"},
+		{input: "besselj(0.5, 2+3i) / (sqrt(2/(pi*(2+3i))) * sin(2+3i))", expected: "1"},
+		{input: "besselj(-0.5, -2+3i) / (sqrt(2/(pi*(-2+3i))) * cos(-2+3i))", expected: "1"},
+		{input: "bessely(0, 1)", expected: "0.088256964"},
+		{input: "bessely(1, 10)", expected: "0.249015424"},
+		{input: "bessely(0.5, 2)", expected: "0.23478571"},
+		{input: "bessely(1.5, 3i) / (-sqrt(2/(pi*3i)) * (cos(3i)/(3i) + sin(3i)))", expected: "1"},
+		{input: "besselj(2.5, 4-i)*bessely(1.5, 4-i) - besselj(1.5, 4-i)*bessely(2.5, 4-i) - 2/(pi*(4-i))", expected: "0"},
+		{input: "besseli(0, 1)", expected: "1.266065878"},
+		{input: "besseli(1, 10)", expected: "2670.988303701"},
+		{input: "besseli(0.5, -1+2i) / (sqrt(2/(pi*(-1+2i))) * sinh(-1+2i))", expected: "1"},
+		{input: "besselk(0, 1)", expected: "0.421024438"},
+		{input: "besselk(0.5, 1)", expected: "0.461068504"},
+		{input: "besselk(0.5, 20) * exp(20)", expected: "0.280249561"},
+		{input: "besselk(-1.5, 3-4i) / (sqrt(pi/(2*(3-4i))) * exp(-(3-4i)) * (1 + 1/(3-4i)))", expected: "1"},
+		{input: "bessely(1e4, 3)", expected: "(-Inf+0i)"},
+		{input: "bessely(-10001, 3)", expected: "(+Inf+0i)"},
+		{input: "bessely(1e4, -3)", expected: "(-Inf+0i)"},
+		{input: "bessely(-1e4-0.5, 3)", expected: "0"},
+		{input: "besselk(1e4, 3)", expected: "(+Inf+0i)"},
+		{input: "besselk(50, 1e-5)", expected: "(+Inf+0i)"},
+		{input: "besselj(1e4, 3)", expected: "0"},
+		{input: "besseli(1e4, 3)", expected: "0"},
+		{input: "airyai(0)", expected: "0.355028054"},
+		{input: "airyai(1)", expected: "0.135292416"},
+		{input: "airyai(-10)", expected: "0.040241238"},
+		{input: "airyai(i)", expected: "0.331493305 - 0.317449859i"},
+		{input: "airybi(1)", expected: "1.207423595"},
+		{input: "airybi(-10)", expected: "-0.31467983"},
+		{input: "airybi(10) / 455641153.548", expected: "1"},
+		{input: "airyai(2+2i) + exp(2*pi*i/3)*airyai((2+2i)*exp(2*pi*i/3)) + exp(-2*pi*i/3)*airyai((2+2i)*exp(-2*pi*i/3))", expected: "0"},
+	}
+	runEngineCases(t, testCases)
+
+	errorCases := []engineTestCase{
+		{input: "bessely(0, 0)", expected: "undefined at z = 0"},
+		{input: "besselk(2, 0)", expected: "undefined at z = 0"},
+		{input: "besselj(-0.5, 0)", expected: "undefined at z = 0"},
+		{input: "besselj(i, 1)", expected: "the order must be a real number"},
+		{input: "besselj(1)", expected: "expects 2 argument(s)"},
+	}
+	runEngineErrorCases(t, errorCases)
+}
+
+func TestNumberTheory(t *testing.T) {
+	exact := NewEngine()
+	exact.SetExact(true)
+	precise := NewEngine()
+	if err := precise.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	word := NewEngine()
+	if err := word.SetWord(16, false); err != nil {
+		t.Fatalf("SetWord failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"gcd", NewEngine(), "gcd(12, 18, 8)", "2"},
+		{"gaussian gcd", NewEngine(), "gcd(5, 3+i)", "1 + 2i"},
+		{"gcd with zero", NewEngine(), "gcd(0, -4)", "4"},
+		{"lcm", NewEngine(), "lcm(4, 6, 10)", "60"},
+		{"gaussian lcm", NewEngine(), "lcm(1+i, 1-i)", "1 + i"},
+		{"egcd", NewEngine(), "egcd(240, 46)", "2 = (-9)*240 + 47*46"},
+		{"gaussian egcd", NewEngine(), "egcd(3+i, 2)", "1+i = (-1)*(3+i) + (2+i)*2"},
+		{"egcd value", NewEngine(), "egcd(240, 46) * 10", "20"},
+		{"prime", NewEngine(), "isprime(97)", "true"},
+		{"composite", NewEngine(), "isprime(91)", "false"},
+		{"one", NewEngine(), "isprime(1)", "false"},
+		{"gaussian composite", NewEngine(), "isprime(1+3i)", "false"},
+		{"inert prime", NewEngine(), "isprime(3i)", "true"},
+		{"gaussian prime", NewEngine(), "isprime(2+i)", "true"},
+		{"factor", NewEngine(), "factor(360)", "2^3 * 3^2 * 5 = i(1+i)^6(3)^2(2+i)(2-i)"},
+		{"negative factor", NewEngine(), "factor(-12)", "-2^2 * 3 = (1+i)^4(3)"},
+		{"large factors", NewEngine(), "factor(600851475143)", "71 * 839 * 1471 * 6857 = (71)(839)(1471)(61+56i)(61-56i)"},
+		{"factor of 1", NewEngine(), "factor(1)", "1"},
+		{"inert factors", NewEngine(), "factor(63)", "3^2 * 7"},
+		{"split factor", NewEngine(), "factor(5)", "5 = (2+i)(2-i)"},
+		{"ramified factor", NewEngine(), "factor(2)", "2 = -i(1+i)^2"},
+		{"complex factor", NewEngine(), "factor(3+4i)", "(2+i)^2"},
+		{"factor value", NewEngine(), "2 * factor(6)", "12"},
+		{"powmod", NewEngine(), "powmod(2, 100, 1000000007)", "976371285"},
+		{"powmod inverse", NewEngine(), "powmod(3, -1, 7)", "5"},
+		{"gaussian powmod", NewEngine(), "powmod(2+i, 10, 3)", "i"},
+		{"totient", NewEngine(), "totient(36)", "12"},
+		{"gaussian totient", NewEngine(), "totient(2+i)", "4"},
+		{"large literal", NewEngine(), "factor(18446744073709551617)", "274177 * 67280421310721 = (516+89i)(516-89i)(8083111+1394180i)(8083111-1394180i)"},
+		{"large prime", NewEngine(), "isprime(2^61 - 1)", "true"},
+		{"large result", NewEngine(), "lcm(2^30, 3^30)", "221073919720733357899776"},
+		{"rounded operands", NewEngine(), "gcd(2^53 + 1, 2^53)", "1"},
+		{"large powmod", NewEngine(), "powmod(3, 2^100, 10^30 + 57)", "887638991292560464018113014970"},
+		{"exact prime", exact, "isprime(2^61 - 1)", "true"},
+		{"exact factor", exact, "factor(2^64 + 1)", "274177 * 67280421310721 = (516+89i)(516-89i)(8083111+1394180i)(8083111-1394180i)"},
+		{"exact gcd", exact, "gcd(2^100, 6^50)", "1125899906842624"},
+		{"exact powmod", exact, "powmod(3, 2^100, 10^30 + 57)", "887638991292560464018113014970"},
+		{"exact totient", exact, "totient(10^20)", "40000000000000000000"},
+		{"high precision", precise, "factor(2^64 + 1)", "274177 * 67280421310721 = (516+89i)(516-89i)(8083111+1394180i)(8083111-1394180i)"},
+		{"programmer mode", word, "totient(100)", "40"},
+	}
+	runEngineCases(t, testCases)
+
+	// Variables and parameters keep the exact value of integers beyond 2^53.
+	results, err := NewEngine().EvaluateScript("n = 2^61 - 1\nisprime(n)\nf(x) = gcd(x, 2^61)\nf(n)")
+	if err != nil {
+		t.Fatalf("EvaluateScript failed unexpectedly: %v", err)
+	}
+	if results[1] != "true" || results[3] != "1" {
+		t.Errorf("expected isprime(n) true and f(n) 1, got %q", results)
+	}
+
+	errorCases := []engineTestCase{
+		{input: "gcd(1.5, 2)", expected: "must be integers or Gaussian integers"},
+		{input: "isprime(floor(2^61 / 3))", expected: "use exact mode"},
+		{input: "powmod(2, 3, 0)", expected: "modulus must not be 0"},
+		{input: "powmod(2, -1, 4)", expected: "2 has no inverse modulo 4"},
+		{input: "totient(0)", expected: "undefined for 0"},
+		{input: "factor(5, i)", expected: "expects 1 argument"},
+	}
+	runEngineErrorCases(t, errorCases)
+}
+
+func TestComparisons(t *testing.T) {
+	exact := NewEngine()
+	exact.SetExact(true)
+	precise := NewEngine()
+	if err := precise.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	word := NewEngine()
+	if err := word.SetWord(8, false); err != nil {
+		t.Fatalf("SetWord failed unexpectedly: %v", err)
+	}
+	strict := NewEngine()
+	if err := strict.SetTolerance(0); err != nil {
+		t.Fatalf("SetTolerance failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"less", NewEngine(), "1 < 2", "true"},
+		{"greater equal", NewEngine(), "1 >= 2", "false"},
+		{"not equal", NewEngine(), "2 != 1 + i", "true"},
+		{"complex equal", NewEngine(), "(1+i)^2 == 2i", "true"},
+		{"tolerance", NewEngine(), "0.1 + 0.2 == 0.3", "true"},
+		{"ordering within tolerance", NewEngine(), "0.3 < 0.1 + 0.2", "false"},
+		{"zero tolerance", strict, "0.1 + 0.2 == 0.3", "false"},
+		{"factorial is not !=", NewEngine(), "3! != 6", "false"},
+		{"precedence", NewEngine(), "1 + 1 == 2 and 2 << 1 > 3", "true"},
+		{"true literal", NewEngine(), "true", "true"},
+		{"false literal", NewEngine(), "not false", "true"},
+		{"literals count as numbers", NewEngine(), "true + true", "2"},
+		{"predicate", NewEngine(), "isprime(7) == true", "true"},
+		{"predicate in a condition", NewEngine(), "if(isprime(8), 1, 2)", "2"},
+		{"exact literal", exact, "false or 1/2 > 1/3", "true"},
+		{"programmer literal", word, "true and 3 > 2", "true"},
+		{"not", NewEngine(), "not 1 == 2", "true"},
+		{"or", NewEngine(), "0 or 2", "true"},
+		{"and short-circuits", NewEngine(), "0 and 1/0", "false"},
+		{"or short-circuits", NewEngine(), "1 or 1/0", "true"},
+		{"boolean arithmetic", NewEngine(), "(2 > 1) + 1", "2"},
+		{"if", NewEngine(), "if(2 > 1, 10, 20)", "10"},
+		{"if else", NewEngine(), "if(0, 10, 20)", "20"},
+		{"if is lazy", NewEngine(), "if(1, 5, 1/0)", "5"},
+		{"if keeps booleans", NewEngine(), "if(1, 1 < 2, 0)", "true"},
+		{"exact", exact, "1/3 == 0.3333333333333333", "false"},
+		{"exact ordering", exact, "1/3 < 1/2", "true"},
+		{"high precision", precise, "sqrt(2)^2 == 2", "true"},
+		{"programmer mode", word, "200 < 100", "true"},
+	}
+	runEngineCases(t, testCases)
+
+	env := NewEnvironment()
+	script := "f(n) = if(n <= 1, 1, n*f(n-1))\nf(10)\nb = f(3) > 5\nb\nnot b\ng(x) = if(x, 1, 2)\ng(b)"
+	results, err := CalculateScript(script, env)
+	if err != nil {
+		t.Fatalf("CalculateScript failed unexpectedly: %v", err)
+	}
+	expected := []string{"f(n) = if(n <= 1, 1, n*f(n-1))", "3628800", "true", "true", "false", "g(x) = if(x, 1, 2)", "1"}
+	if strings.Join(results, "|") != strings.Join(expected, "|") {
+		t.Errorf("CalculateScript: expected %q, got %q", expected, results)
+	}
+
+	errorCases := []engineTestCase{
+		{input: "i < 1", expected: "compares real numbers only, got the complex value i"},
+		{input: "1 < 2 < 3", expected: "comparisons cannot be chained"},
+		{input: "1 == 2 != 3", expected: "comparisons cannot be chained"},
+		{input: "if(1, 2)", expected: "expects 3 argument(s)"},
+	}
+	runEngineErrorCases(t, errorCases)
+	if err := NewEngine().SetTolerance(1); err == nil {
+		t.Errorf("SetTolerance(1): expected an error")
+	}
+}
+
+func TestVectors(t *testing.T) {
+	exact := NewEngine()
+	exact.SetExact(true)
+	precise := NewEngine()
+	if err := precise.SetPrecisionBits(128); err != nil {
+		t.Fatalf("SetPrecisionBits failed unexpectedly: %v", err)
+	}
+	german := NewEngine()
+	if err := german.SetLocale(LocaleGerman); err != nil {
+		t.Fatalf("SetLocale failed unexpectedly: %v", err)
+	}
+
+	testCases := []engineTestCase{
+		{"vec", NewEngine(), "vec(1, 2, 3+i)", "vec(1, 2, 3 + i)"},
+		{"nested", NewEngine(), "vec(vec(1, 2), 3)", "vec(1, 2, 3)"},
+		{"addition", NewEngine(), "vec(1, 2) + vec(10, 20)", "vec(11, 22)"},
+		{"scalar", NewEngine(), "2 * vec(1, 2) - 1", "vec(1, 3)"},
+		{"division", NewEngine(), "1 / vec(2, 4)", "vec(0.5, 0.25)"},
+		{"negation", NewEngine(), "-vec(1, 2)", "vec(-1, -2)"},
+		{"factorial", NewEngine(), "vec(3, 4)!", "vec(6, 24)"},
+		{"unary function", NewEngine(), "sqrt(vec(4, -9))", "vec(2, 3i)"},
+		{"variadic function", NewEngine(), "max(vec(3, 7), 5)", "7"},
+		{"sum", NewEngine(), "sum(vec(1, 2, 3))", "6"},
+		{"sum of numbers", NewEngine(), "sum(1, 2, 3)", "6"},
+		{"prod", NewEngine(), "prod(vec(1, 2, 3, 4))", "24"},
+		{"len", NewEngine(), "len(vec(1, 2, 3))", "3"},
+		{"dot", NewEngine(), "dot(vec(1, 2, 3), vec(4, 5, 6))", "32"},
+		{"complex dot", NewEngine(), "dot(vec(1+i, 2), vec(1+i, 2)) - norm(vec(1+i, 2))^2", "0"},
+		{"conjugated dot", NewEngine(), "dot(vec(i, 1), vec(1, i))", "0"},
+		{"exact complex dot", exact, "dot(vec(1/2 + i, 1), vec(i, 1))", "2 + 1/2 i"},
+		{"cross", NewEngine(), "cross(vec(1, 0, 0), vec(0, 1, 0))", "vec(0, 0, 1)"},
+		{"norm", NewEngine(), "norm(vec(3, 4i))", "5"},
+		{"at", NewEngine(), "at(vec(10, 20, 30), 2)", "20"},
+		{"if", NewEngine(), "if(1, vec(1, 2), 0)", "vec(1, 2)"},
+		{"exact", exact, "vec(1/3, 1/6) * 3", "vec(1, 1/2)"},
+		{"exact sum", exact, "sum(vec(1/3, 1/6))", "1/2"},
+		{"high precision", precise, "norm(vec(3, 4))", "5"},
+		{"locale", german, "vec(1,5; 2)", "vec(1,5; 2)"},
+	}
+	runEngineCases(t, testCases)
+
+	env := NewEnvironment()
+	script := "v = vec(1, 2, 3)\nv * v\nf(x) = x^2 + 1\nf(v)\nat(v, 3)"
+	results, err := CalculateScript(script, env)
+	if err != nil {
+		t.Fatalf("CalculateScript failed unexpectedly: %v", err)
+	}
+	expected := []string{"vec(1, 2, 3)", "vec(1, 4, 9)", "f(x) = x^2 + 1", "vec(2, 5, 10)", "3"}
+	if strings.Join(results, "|") != strings.Join(expected, "|") {
+		t.Errorf("CalculateScript: expected %q, got %q", expected, results)
+	}
+
+	errorCases := []engineTestCase{
+		{input: "vec(1, 2) + vec(1, 2, 3)", expected: "needs vectors of the same length, got 2 and 3 elements"},
+		{input: "dot(vec(1, 2), vec(1))", expected: "needs vectors of the same length"},
+		{input: "cross(vec(1, 2), vec(3, 4))", expected: "needs vectors of 3 elements"},
+		{input: "at(vec(1, 2), 3)", expected: "index 3 is not between 1 and 2"},
+		{input: "atan2(vec(1, 2), 1)", expected: "function 'atan2' at position 0 takes numbers, not vectors"},
+		{input: "vec(1, 2) < 3", expected: "operator '<' at position 10 takes numbers, not vectors"},
+		{input: "if(vec(1, 2), 1, 2)", expected: "needs a number as its condition"},
+		{input: "gcd(vec(1, 2, 3), 2)", expected: "function 'gcd' at position 0 takes numbers, not vectors"},
+		{input: "factor(vec(4, 6))", expected: "function 'factor' at position 0 takes numbers, not vectors"},
+		{input: "isprime(vec(2, 3))", expected: "takes numbers, not vectors"},
+	}
+	runEngineErrorCases(t, errorCases)
+}
@@ -0,0 +1,288 @@
+// vector.go
+package toycalc_core
+
+import (
+	"fmt"
+	"math/big"
+	"math/cmplx"
+	"strings"
+)
+
+// vectorFunction identifies the built-ins that take whole vectors, like sum(v) or dot(a, b).
+// The evaluator implements them in every number system (see evaluator.callVector).
+type vectorFunction int
+
+const (
+	notVector     vectorFunction = iota
+	vectorMake                   // vec(x1, x2, ...)
+	vectorSum                    // sum(x1, x2, ...)
+	vectorProduct                // prod(x1, x2, ...)
+	vectorLength                 // len(v)
+	vectorDot                    // dot(a, b)
+	vectorCross                  // cross(a, b)
+	vectorNorm                   // norm(v)
+	vectorAt                     // at(v, k)
+)
+
+// isVector reports whether x is a vector rather than a number.
+func (x operand[T]) isVector() bool {
+	return x.elements != nil
+}
+
+// items returns the elements of a vector, or a number as the only element.
+func (x operand[T]) items() []T {
+	if x.isVector() {
+		return x.elements
+	}
+	return []T{x.value}
+}
+
+// storedVector is how a vector is kept in variables, whatever the number system: like a
+// number, each element is kept as complex128 and in its more precise form, if any.
+type storedVector struct {
+	values  []complex128
+	precise []any
+}
+
+// stored returns what a variable set to x holds: its value as complex128, which is NaN for
+// vectors, and the form to keep beside it (see Environment.bind).
+func (ev evaluator[T]) stored(x operand[T]) (complex128, any) {
+	switch {
+	case x.isVector():
+		vector := storedVector{values: make([]complex128, len(x.elements)), precise: make([]any, len(x.elements))}
+		for i, element := range x.elements {
+			vector.values[i] = ev.numbers.toComplex(element)
+			vector.precise[i] = ev.numbers.precise(element)
+		}
+		return cmplx.NaN(), vector
+	case x.boolean:
+		return ev.numbers.toComplex(x.value), ev.truth(x.value)
+	}
+	if x.integer != nil && ev.roundsIntegers() {
+		return ev.numbers.toComplex(x.value), *x.integer
+	}
+	return ev.numbers.toComplex(x.value), ev.numbers.precise(x.value)
+}
+
+// restore turns a vector kept in a variable back into an operand.
+func (ev evaluator[T]) restore(vector storedVector) (operand[T], error) {
+	elements := make([]T, len(vector.values))
+	for i, value := range vector.values {
+		if precise, found := vector.precise[i].(T); found {
+			elements[i] = precise
+			continue
+		}
+		var err error
+		if elements[i], err = ev.numbers.fromComplex(value); err != nil {
+			return operand[T]{}, err
+		}
+	}
+	return operand[T]{elements: elements}, nil
+}
+
+// formatVector renders the formatted elements of a vector the way it is written, e.g.
+// "vec(1, 2, 3 + i)", separating them with ';' in locales with a decimal comma.
+func formatVector(elements []string, locale Locale) string {
+	return "vec(" + strings.Join(elements, Ternary(locale.decimalComma(), "; ", ", ")) + ")"
+}
+
+// vectorError reports a vector given to an operator or function that only takes numbers.
+func vectorError(token Token) error {
+	what := Ternary(token.Type == IDENT, "function", "operator")
+	return newTokenError(KindDomain, token, fmt.Sprintf("%s '%s' at position %d takes numbers, not vectors", what, token.Literal, token.Position))
+}
+
+// lengthError reports vectors whose lengths do not fit the operator or function token.
+func lengthError(token Token, a, b int) error {
+	what := Ternary(token.Type == IDENT, "function", "operator")
+	return newTokenError(KindDomain, token, fmt.Sprintf("%s '%s' at position %d needs vectors of the same length, got %d and %d elements", what, token.Literal, token.Position, a, b))
+}
+
+// elementwise applies f to the number x, or to each element of the vector x.
+func elementwise[T any](x operand[T], f func(T) (T, error)) (operand[T], error) {
+	if !x.isVector() {
+		value, err := f(x.value)
+		return operand[T]{value: value}, err
+	}
+	elements := make([]T, len(x.elements))
+	for i, element := range x.elements {
+		var err error
+		if elements[i], err = f(element); err != nil {
+			return operand[T]{}, err
+		}
+	}
+	return operand[T]{elements: elements}, nil
+}
+
+// elementwise2 applies the binary operator token's f to a and b: to the elements of two
+// vectors of the same length pairwise, or to a number and each element of a vector.
+func elementwise2[T any](token Token, a, b operand[T], f func(T, T) (T, error)) (operand[T], error) {
+	if !a.isVector() && !b.isVector() {
+		value, err := f(a.value, b.value)
+		return operand[T]{value: value}, err
+	}
+	x, y := a.items(), b.items()
+	if a.isVector() && b.isVector() && len(x) != len(y) {
+		return operand[T]{}, lengthError(token, len(x), len(y))
+	}
+	elements := make([]T, max(len(x), len(y)))
+	for i := range elements {
+		var err error
+		if elements[i], err = f(x[min(i, len(x)-1)], y[min(i, len(y)-1)]); err != nil {
+			return operand[T]{}, err
+		}
+	}
+	return operand[T]{elements: elements}, nil
+}
+
+// flatten returns the numbers in args, with vectors replaced by their elements.
+func flatten[T any](args []operand[T]) []T {
+	var numbers []T
+	for _, arg := range args {
+		numbers = append(numbers, arg.items()...)
+	}
+	return numbers
+}
+
+// arithmetic returns the token of the operator op applied on behalf of the function token.
+func arithmetic(op TokenType, token Token) Token {
+	return Token{Type: op, Literal: string(op), Position: token.Position}
+}
+
+// fold combines numbers with the operator op, from left to right.
+func (ev evaluator[T]) fold(op Token, numbers []T) (operand[T], error) {
+	result := numbers[0]
+	for _, x := range numbers[1:] {
+		var err error
+		if result, err = ev.numbers.operate(op, result, x); err != nil {
+			return operand[T]{}, err
+		}
+	}
+	return operand[T]{value: result}, nil
+}
+
+// callNumbers applies a function on numbers to args. Variadic functions take the elements
+// of vectors as arguments, and other functions apply to each element of a single vector
+// argument; vectors are not accepted otherwise, nor by the number-theory functions, for
+// which gcd(vec(4, 6), 2) would read as gcd(4, 6, 2).
+func (ev evaluator[T]) callNumbers(function FunctionDef, args []operand[T], token Token) (operand[T], error) {
+	if function.integerImpl != nil {
+		for _, arg := range args {
+			if arg.isVector() {
+				return operand[T]{}, vectorError(token)
+			}
+		}
+		if ev.roundsIntegers() {
+			return ev.callIntegers(function, args, token)
+		}
+	}
+	if function.Arity == Variadic {
+		value, err := ev.callBuiltin(function, flatten(args), token)
+		return operand[T]{value: value}, err
+	}
+	if len(args) == 1 && args[0].isVector() {
+		return elementwise(args[0], func(x T) (T, error) {
+			return ev.callBuiltin(function, []T{x}, token)
+		})
+	}
+	numbers := make([]T, len(args))
+	for i, arg := range args {
+		if arg.isVector() {
+			return operand[T]{}, vectorError(token)
+		}
+		numbers[i] = arg.value
+	}
+	value, err := ev.callBuiltin(function, numbers, token)
+	if err == nil && function.predicate {
+		return ev.boolean(ev.truth(value))
+	}
+	return operand[T]{value: value}, err
+}
+
+// callVector applies one of the built-ins on whole vectors. A number counts as a vector of
+// one element.
+func (ev evaluator[T]) callVector(registry *Registry, function FunctionDef, args []operand[T], token Token) (operand[T], error) {
+	switch function.vector {
+	case vectorMake:
+		return operand[T]{elements: flatten(args)}, nil
+	case vectorSum:
+		return ev.fold(arithmetic(PLUS, token), flatten(args))
+	case vectorProduct:
+		return ev.fold(arithmetic(ASTERISK, token), flatten(args))
+	case vectorLength:
+		value, err := ev.numbers.fromComplex(complex(float64(len(args[0].items())), 0))
+		return operand[T]{value: value}, err
+	case vectorDot:
+		// conj(a1)*b1 + conj(a2)*b2 + ..., so that dot(v, v) is norm(v)^2 for complex v too.
+		if len(args[0].items()) != len(args[1].items()) {
+			return operand[T]{}, lengthError(token, len(args[0].items()), len(args[1].items()))
+		}
+		conj, _ := registry.Function("conj")
+		products, err := elementwise2(token, args[0], args[1], func(a, b T) (T, error) {
+			conjugate, err := ev.callBuiltin(conj, []T{a}, token)
+			if err != nil {
+				return conjugate, err
+			}
+			return ev.numbers.operate(arithmetic(ASTERISK, token), conjugate, b)
+		})
+		if err != nil {
+			return operand[T]{}, err
+		}
+		return ev.fold(arithmetic(PLUS, token), products.items())
+	case vectorCross:
+		a, b := args[0].items(), args[1].items()
+		if len(a) != 3 || len(b) != 3 {
+			return operand[T]{}, newTokenError(KindDomain, token, fmt.Sprintf("function '%s' at position %d needs vectors of 3 elements, got %d and %d", token.Literal, token.Position, len(a), len(b)))
+		}
+		multiply := func(x, y T) (T, error) { return ev.numbers.operate(arithmetic(ASTERISK, token), x, y) }
+		elements := make([]T, 3)
+		for i := range elements {
+			// The i-th element is a[j]*b[k] - a[k]*b[j].
+			j, k := (i+1)%3, (i+2)%3
+			p, err := multiply(a[j], b[k])
+			if err != nil {
+				return operand[T]{}, err
+			}
+			q, err := multiply(a[k], b[j])
+			if err != nil {
+				return operand[T]{}, err
+			}
+			if elements[i], err = ev.numbers.operate(arithmetic(MINUS, token), p, q); err != nil {
+				return operand[T]{}, err
+			}
+		}
+		return operand[T]{elements: elements}, nil
+	case vectorNorm:
+		// sqrt(|x1|^2 + |x2|^2 + ...), so complex elements count with their magnitude.
+		abs, _ := registry.Function("abs")
+		sqrt, _ := registry.Function("sqrt")
+		squares, err := elementwise(operand[T]{elements: args[0].items()}, func(x T) (T, error) {
+			magnitude, err := ev.callBuiltin(abs, []T{x}, token)
+			if err != nil {
+				return magnitude, err
+			}
+			return ev.numbers.operate(arithmetic(ASTERISK, token), magnitude, magnitude)
+		})
+		if err != nil {
+			return operand[T]{}, err
+		}
+		sum, err := ev.fold(arithmetic(PLUS, token), squares.elements)
+		if err != nil {
+			return operand[T]{}, err
+		}
+		value, err := ev.callBuiltin(sqrt, []T{sum.value}, token)
+		return operand[T]{value: value}, err
+	case vectorAt:
+		elements := args[0].items()
+		if args[1].isVector() {
+			return operand[T]{}, vectorError(token)
+		}
+		index := ev.numbers.toComplex(args[1].value)
+		position, ok := floatInteger(index)
+		if !ok || position.Sign() < 1 || position.Cmp(big.NewInt(int64(len(elements)))) > 0 {
+			return operand[T]{}, newTokenError(KindDomain, token, fmt.Sprintf("function '%s' at position %d: index %s is not between 1 and %d", token.Literal, token.Position, formatComplexOutput(index), len(elements)))
+		}
+		return operand[T]{value: elements[position.Int64()-1]}, nil
+	}
+	return operand[T]{}, newTokenError(KindParse, token, fmt.Sprintf("unexpected vector function '%s' at position %d", token.Literal, token.Position))
+}
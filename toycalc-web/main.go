@@ -1,27 +1,111 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	toycalc_core "github.com/vladimirck/toycalc/toycalc-core"
 )
 
+// evaluationTimeout limita el tiempo de cálculo de cada expresión; junto con los límites
+// por defecto del motor (tamaño de la entrada, anidamiento, operaciones) evita que una
+// expresión maliciosa acapare el servidor.
+const evaluationTimeout = 2 * time.Second
+
 //go:embed templates
 var templateFiles embed.FS
 
 // PageData contiene los datos que se pasarán a la plantilla HTML.
 type PageData struct {
 	Expression        string
+	Format            string
+	Formats           []FormatOption
+	Locale            toycalc_core.Locale
 	Result            string
+	Error             *ErrorView
 	GoogleAnalyticsID string
 }
 
+// FormatOption es un formato de salida que se puede elegir en la página.
+type FormatOption struct {
+	Value string
+	Label string
+}
+
+// formatOptions son los formatos de salida que ofrece la página: los que no necesitan un
+// número de decimales.
+var formatOptions = []FormatOption{
+	{Value: "auto", Label: "Automático"},
+	{Value: "eng", Label: "Ingeniería (4.7e3)"},
+	{Value: "si", Label: "Prefijos SI (4.7k)"},
+	{Value: "frac", Label: "Fracciones"},
+	{Value: "polar", Label: "Polar (r ∠ θ)"},
+}
+
+// ErrorView describe un error de cálculo para la plantilla: el mensaje y la expresión
+// dividida en tres partes, de modo que la parte errónea (Span) pueda resaltarse.
+type ErrorView struct {
+	Message     string
+	Before      string
+	Span        string
+	After       string
+	Suggestions []Suggestion
+}
+
+// Suggestion es un nombre conocido que el usuario quizá quiso escribir, junto con el enlace
+// que calcula la expresión corregida.
+type Suggestion struct {
+	Name string
+	URL  string
+}
+
+// newErrorView prepara err para mostrarlo. Si el error señala una parte de la expresión,
+// esa parte se separa para resaltarla; si apunta al final de la entrada, se resalta un
+// espacio tras la expresión.
+func newErrorView(expression, format string, err error) *ErrorView {
+	view := &ErrorView{Message: err.Error()}
+	var calcErr *toycalc_core.CalculationError
+	if !errors.As(err, &calcErr) {
+		return view
+	}
+	view.Message = calcErr.Message
+	if !calcErr.HasSpan() || calcErr.Start > len(expression) {
+		return view
+	}
+	end := min(calcErr.End, len(expression))
+	view.Before = expression[:calcErr.Start]
+	view.Span = expression[calcErr.Start:end]
+	view.After = expression[end:]
+	for _, name := range calcErr.Suggestions {
+		corrected := view.Before + name + view.After
+		view.Suggestions = append(view.Suggestions, Suggestion{Name: name, URL: calculatorURL(corrected, format)})
+	}
+	if view.Span == "" {
+		view.Span = " "
+	}
+	return view
+}
+
+// calculatorURL es el enlace que calcula expression mostrando el resultado con format.
+func calculatorURL(expression, format string) string {
+	query := url.Values{"expression": {expression}}
+	if format != "" && format != "auto" {
+		query.Set("format", format)
+	}
+	return "/?" + query.Encode()
+}
+
 func main() {
 	// El manejador de rutas sigue usando el mux por defecto de Go.
 	http.HandleFunc("/", handleCalculator)
@@ -41,6 +125,48 @@ func main() {
 	log.Fatal(server.ListenAndServe())
 }
 
+// setFormat aplica a engine el formato de salida pedido; una cadena vacía deja el formato
+// por defecto.
+func setFormat(engine *toycalc_core.Engine, format string) error {
+	if format == "" {
+		return nil
+	}
+	return engine.SetFormat(format, engine.Settings().Precision)
+}
+
+// setLocale aplica a engine el idioma preferido de la cabecera Accept-Language que el motor
+// admite (por ejemplo, "es-MX,es;q=0.9,en;q=0.8" elige 'es'). Si no admite ninguno, se
+// queda el formato sencillo.
+func setLocale(engine *toycalc_core.Engine, acceptLanguage string) {
+	type language struct {
+		locale  toycalc_core.Locale
+		quality float64
+	}
+	var languages []language
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		// Solo cuenta el idioma principal: 'es-MX' y 'es' usan el mismo formato.
+		primary, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if primary != "" && quality > 0 {
+			languages = append(languages, language{toycalc_core.Locale(primary), quality})
+		}
+	}
+	sort.SliceStable(languages, func(a, b int) bool { return languages[a].quality > languages[b].quality })
+	for _, language := range languages {
+		if engine.SetLocale(language.locale) == nil {
+			return
+		}
+	}
+}
+
 // handleCalculator se encarga de las peticiones a la página.
 func handleCalculator(w http.ResponseWriter, r *http.Request) {
 	// Parsea la plantilla HTML. Es importante manejar el error.
@@ -53,19 +179,34 @@ func handleCalculator(w http.ResponseWriter, r *http.Request) {
 
 	// Obtiene la expresión del formulario enviado (parámetro GET 'expression').
 	expression := r.URL.Query().Get("expression")
+	format := r.URL.Query().Get("format") // Formato de salida elegido (parámetro GET 'format')
 	gaID := os.Getenv("GA_ID")
 
+	// Cada petición usa un motor propio, de modo que las peticiones concurrentes no
+	// comparten configuración. Los números se escriben según el idioma del navegador.
+	engine := toycalc_core.NewEngine()
+	setLocale(engine, r.Header.Get("Accept-Language"))
+
 	data := PageData{
 		Expression:        expression,
+		Format:            format,
+		Formats:           formatOptions,
+		Locale:            engine.Settings().Locale,
 		GoogleAnalyticsID: gaID,
 	}
 
 	// Si hay una expresión, la calcula.
 	if expression != "" {
-		result, err := toycalc_core.CalculateExpression(expression)
+		ctx, cancel := context.WithTimeout(r.Context(), evaluationTimeout)
+		defer cancel()
+		var result string
+		err := setFormat(engine, format)
+		if err == nil {
+			result, err = engine.EvaluateExpressionContext(ctx, expression)
+		}
 		if err != nil {
-			// Si hay un error en el cálculo, lo muestra como resultado.
-			data.Result = "Error: " + err.Error()
+			// Si hay un error en el cálculo, lo muestra resaltando la parte errónea.
+			data.Error = newErrorView(expression, format, err)
 		} else {
 			// Si el cálculo es exitoso, muestra el resultado.
 			data.Result = data.Expression + " = " + result
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestSpanishBrowser comprueba que un navegador en español, que elige el idioma 'es', sigue
+// pudiendo escribir el punto decimal además de la coma.
+func TestSpanishBrowser(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+	}{
+		{"3.5", "3.5 = 3,5"},
+		{"3,5 * 2", "3,5 * 2 = 7"},
+		{"1.234 * 2", "1.234 * 2 = 2,468"},
+	}
+	for _, tc := range testCases {
+		request := httptest.NewRequest(http.MethodGet, "/?"+url.Values{"expression": {tc.expression}}.Encode(), nil)
+		request.Header.Set("Accept-Language", "es")
+		response := httptest.NewRecorder()
+		handleCalculator(response, request)
+
+		body := response.Body.String()
+		if response.Code != http.StatusOK || strings.Contains(body, "Error:") {
+			t.Errorf("%q: expected a result, got status %d and body %s", tc.expression, response.Code, body)
+		} else if !strings.Contains(body, tc.expected) {
+			t.Errorf("%q: expected %q in the page", tc.expression, tc.expected)
+		}
+	}
+}